@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/LeeFred3042U/kitcat/internal/core"
@@ -88,46 +90,90 @@ var commands = map[string]CommandFunc{
 			os.Exit(1)
 		}
 
-		if len(args) < 2 {
-			fmt.Println("Usage: kitcat commit <-m | -am | --amend> <message>")
+		var opts core.CommitOptions
+		var messages []string
+		var messageFile string
+		isAmend := false
+		// Words after -m up to the next option belong to its message, so an unquoted
+		// message still works
+		inMessage := false
+		for i := 0; i < len(args); i++ {
+			flag, value, hasValue := strings.Cut(args[i], "=")
+			takeValue := func() string {
+				if hasValue {
+					return value
+				}
+				if i+1 >= len(args) {
+					fmt.Printf("Error: %s requires a value\n", flag)
+					os.Exit(2)
+				}
+				i++
+				return args[i]
+			}
+			switch flag {
+			case "-m", "-am":
+				opts.All = opts.All || flag == "-am"
+				if i+1 >= len(args) {
+					fmt.Printf("Error: %s requires a message\n", flag)
+					os.Exit(2)
+				}
+				i++
+				messages = append(messages, args[i])
+				inMessage = true
+				continue
+			case "-a", "--all":
+				opts.All = true
+			case "-F", "--file":
+				messageFile = takeValue()
+			case "--amend":
+				isAmend = true
+			case "--allow-empty":
+				opts.AllowEmpty = true
+			case "-n", "--no-verify":
+				opts.NoVerify = true
+			case "--author":
+				opts.Author = takeValue()
+			case "--date":
+				opts.Date = takeValue()
+			default:
+				if !inMessage {
+					fmt.Printf("Error: unknown argument %s\n", args[i])
+					fmt.Println("Usage: kitcat commit [-a] [--amend] [--allow-empty] [--no-verify] [--author <author>] [--date <date>] [-m <message>... | -F <file>]")
+					os.Exit(2)
+				}
+				messages[len(messages)-1] += " " + args[i]
+				continue
+			}
+			inMessage = false
+		}
+		if messageFile != "" && len(messages) > 0 {
+			fmt.Println("Error: options -m and -F cannot be used together")
 			os.Exit(2)
 		}
 
-		var isAmend bool
-		var message string
-
-		switch args[0] {
-		// Checks for amending
-		case "--amend":
-			if len(args) < 3 || args[1] != "-m" {
-				fmt.Println("Usage: kitcat commit --amend -m <message>")
-				os.Exit(2)
+		// Each -m is a paragraph; without -m or -F the editor opens
+		message := strings.Join(messages, "\n\n")
+		switch {
+		case messageFile == "-":
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				fmt.Println("Error: could not read the message from standard input:", err)
+				os.Exit(1)
 			}
-			isAmend = true
-			message = strings.Join(args[2:], " ")
-		// Normal commit flow
-		case "-am":
-			message = strings.Join(args[1:], " ")
-			newCommit, summary, err := core.CommitAll(message)
+			message = string(data)
+		case messageFile != "":
+			data, err := os.ReadFile(messageFile)
 			if err != nil {
-				if err.Error() == "nothing to commit, working tree clean" {
-					fmt.Println(err.Error())
-					os.Exit(1)
-				}
-				os.Exit(2)
+				fmt.Println("Error: could not read the message file:", err)
+				os.Exit(1)
 			}
-			printCommitResult(newCommit, summary)
-			os.Exit(0)
-		case "-m":
-			message = strings.Join(args[1:], " ")
-		default:
-			fmt.Println("Usage: kitcat commit <-m | -am | --amend> <message>")
-			os.Exit(2)
+			message = string(data)
+		case len(messages) == 0:
+			opts.Edit = true
 		}
 
-		// Handle amend or normal commit
 		if isAmend {
-			newCommit, err := core.AmendCommit(message)
+			newCommit, err := core.AmendCommitWith(message, opts)
 			if err != nil {
 				fmt.Println("Error:", err)
 				os.Exit(1)
@@ -138,32 +184,35 @@ var commands = map[string]CommandFunc{
 				ref := strings.TrimSpace(string(headData))
 				headState = strings.TrimPrefix(ref, "ref: refs/heads/")
 			}
-			fmt.Printf("[%s %s] %s (amended)\n", headState, newCommit.ID[:7], newCommit.Message)
+			fmt.Printf("[%s %s] %s (amended)\n", headState, newCommit.ID[:7], newCommit.Subject())
 			os.Exit(0)
-		} else {
-			newCommit, summary, err := core.Commit(message)
-			if err != nil {
-				if err.Error() == "nothing to commit, working tree clean" {
-					fmt.Println(err.Error())
-					os.Exit(1)
-				} else {
-					fmt.Println("Error:", err)
-					os.Exit(1)
-				}
+		}
+		newCommit, summary, err := core.CommitWith(message, opts)
+		if err != nil {
+			if err.Error() == "nothing to commit, working tree clean" {
+				fmt.Println(err.Error())
+			} else {
+				fmt.Println("Error:", err)
 			}
-			printCommitResult(newCommit, summary)
-			os.Exit(0)
+			os.Exit(1)
 		}
+		printCommitResult(newCommit, summary)
+		os.Exit(0)
 	},
 	"log": func(args []string) {
 		oneline := false
+		showSignature := false
 		limit := -1
+		revision := ""
 		i := 0
 		for i < len(args) {
 			switch args[i] {
 			case "--oneline":
 				oneline = true
 				i++
+			case "--show-signature":
+				showSignature = true
+				i++
 			case "-n":
 				if i+1 >= len(args) {
 					fmt.Println("Error: -n requires a positive integer argument")
@@ -178,11 +227,15 @@ var commands = map[string]CommandFunc{
 				limit = n
 				i += 2
 			default:
-				fmt.Printf("Error: unknown flag %s\n", args[i])
-				os.Exit(2)
+				if strings.HasPrefix(args[i], "-") || revision != "" {
+					fmt.Printf("Error: unknown flag %s\n", args[i])
+					os.Exit(2)
+				}
+				revision = args[i]
+				i++
 			}
 		}
-		if err := core.ShowLog(oneline, limit); err != nil {
+		if err := core.ShowLog(oneline, limit, revision, showSignature); err != nil {
 			fmt.Println("Error:", err)
 			os.Exit(1)
 		}
@@ -221,11 +274,30 @@ var commands = map[string]CommandFunc{
 	"checkout": func(args []string) {
 		if len(args) < 1 {
 			fmt.Println(
-				"Usage: kitcat checkout [-b] <branch-name> | <file-path> | <branch> -- <file-path>",
+				"Usage: kitcat checkout [-b] <branch-name> | <file-path> | <branch> -- <file-path> | --ours|--theirs <file-path>",
 			)
 			os.Exit(2)
 		}
 
+		// Handle conflict resolution: kitcat checkout --ours|--theirs <file-path>...
+		if args[0] == "--ours" || args[0] == "--theirs" {
+			paths := args[1:]
+			if len(paths) > 0 && paths[0] == "--" {
+				paths = paths[1:]
+			}
+			if len(paths) == 0 {
+				fmt.Printf("Usage: kitcat checkout %s <file-path>...\n", args[0])
+				os.Exit(2)
+			}
+			for _, path := range paths {
+				if err := core.CheckoutConflictSide(path, args[0] == "--theirs"); err != nil {
+					fmt.Println("Error:", err)
+					os.Exit(1)
+				}
+			}
+			os.Exit(0)
+		}
+
 		// Handle branch creation: kitcat checkout -b <branch-name>
 		if args[0] == "-b" {
 			if len(args) != 2 {
@@ -304,11 +376,18 @@ var commands = map[string]CommandFunc{
 				fmt.Println("Error:", err)
 				os.Exit(1)
 			}
-		} else {
-			if _, err := os.Stat(name); err != nil {
+		} else if _, err := os.Stat(name); err != nil {
+			// Not a branch or a file: a commit is checked out with a detached HEAD
+			hash, resolveErr := core.ResolveRevision(name)
+			if resolveErr != nil {
 				fmt.Printf("Error: file '%s' does not exist on disk\n", name)
 				os.Exit(1)
 			}
+			if err := core.CheckoutCommit(hash); err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+		} else {
 			if err := core.CheckoutFile(name); err != nil {
 				fmt.Println("Error:", err)
 				os.Exit(1)
@@ -316,19 +395,27 @@ var commands = map[string]CommandFunc{
 		}
 	},
 	"merge": func(args []string) {
-		if len(args) < 1 {
-			fmt.Println("Usage: kitcat merge <branch-name>")
+		args, noVerify := cutFlag(args, "--no-verify")
+		if len(args) != 1 {
+			fmt.Println("Usage: kitcat merge [--no-verify] <branch-name> | --abort")
 			os.Exit(2)
 		}
-		if err := core.Merge(args[0]); err != nil {
+		if args[0] == "--abort" {
+			if err := core.MergeAbort(); err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+		if err := core.MergeWith(args[0], noVerify); err != nil {
 			fmt.Println("Error:", err)
 			os.Exit(1)
 		}
 		os.Exit(0)
 	},
 	"reset": func(args []string) {
-		if len(args) < 2 {
-			fmt.Println("Usage: kitkat reset [--soft | --mixed | --hard] <commit-hash>")
+		if len(args) < 1 {
+			fmt.Println("Usage: kitcat reset [--soft | --mixed | --hard] [<commit>]")
 			os.Exit(2)
 		}
 
@@ -367,13 +454,12 @@ var commands = map[string]CommandFunc{
 
 		if mode == "" {
 			fmt.Println("Error: must specify --soft, --mixed, or --hard")
-			fmt.Println("Usage: kitkat reset [--soft | --mixed | --hard] <commit-hash>")
+			fmt.Println("Usage: kitcat reset [--soft | --mixed | --hard] [<commit>]")
 			os.Exit(2)
 		}
 
 		if commitHash == "" {
-			fmt.Println("Error: commit hash required")
-			os.Exit(2)
+			commitHash = "HEAD"
 		}
 
 		if err := core.Reset(commitHash, mode); err != nil {
@@ -383,6 +469,7 @@ var commands = map[string]CommandFunc{
 		os.Exit(0)
 	},
 	"rebase": func(args []string) {
+		args, noVerify := cutFlag(args, "--no-verify")
 		if len(args) < 1 {
 			fmt.Println("Usage: kitcat rebase [-i <commit> | --continue | --abort]")
 			os.Exit(2)
@@ -402,11 +489,11 @@ var commands = map[string]CommandFunc{
 			}
 			os.Exit(0)
 		case "-i":
-			if len(args) < 2 {
-				fmt.Println("Usage: kitcat rebase -i <commit>")
+			if len(args) != 2 {
+				fmt.Println("Usage: kitcat rebase -i <commit> [--no-verify]")
 				os.Exit(2)
 			}
-			if err := core.RebaseInteractive(args[1]); err != nil {
+			if err := core.RebaseInteractiveWith(args[1], noVerify); err != nil {
 				fmt.Println("Error:", err)
 				os.Exit(1)
 			}
@@ -417,6 +504,105 @@ var commands = map[string]CommandFunc{
 			os.Exit(2)
 		}
 	},
+	"cherry-pick": func(args []string) {
+		usage := "Usage: kitcat cherry-pick [--no-commit] <commit>... | --continue | --skip | --abort"
+		if len(args) < 1 {
+			fmt.Println(usage)
+			os.Exit(2)
+		}
+
+		var err error
+		switch args[0] {
+		case "--continue":
+			core.EnsureArgs(args, 1, 1, "cherry-pick --continue")
+			err = core.CherryPickContinue()
+		case "--skip":
+			core.EnsureArgs(args, 1, 1, "cherry-pick --skip")
+			err = core.CherryPickSkip()
+		case "--abort":
+			core.EnsureArgs(args, 1, 1, "cherry-pick --abort")
+			err = core.CherryPickAbort()
+		default:
+			noCommit := false
+			var commits []string
+			for _, arg := range args {
+				switch {
+				case arg == "--no-commit" || arg == "-n":
+					noCommit = true
+				case strings.HasPrefix(arg, "-"):
+					fmt.Printf("Error: unknown option '%s'\n", arg)
+					fmt.Println(usage)
+					os.Exit(2)
+				default:
+					commits = append(commits, arg)
+				}
+			}
+			if len(commits) == 0 {
+				fmt.Println(usage)
+				os.Exit(2)
+			}
+			err = core.CherryPick(commits, noCommit)
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	},
+	"revert": func(args []string) {
+		usage := "Usage: kitcat revert [--no-commit] <commit>|<from>..<to>... | --continue | --skip | --abort"
+		if len(args) < 1 {
+			fmt.Println(usage)
+			os.Exit(2)
+		}
+
+		var err error
+		switch args[0] {
+		case "--continue":
+			core.EnsureArgs(args, 1, 1, "revert --continue")
+			err = core.RevertContinue()
+		case "--skip":
+			core.EnsureArgs(args, 1, 1, "revert --skip")
+			err = core.RevertSkip()
+		case "--abort":
+			core.EnsureArgs(args, 1, 1, "revert --abort")
+			err = core.RevertAbort()
+		default:
+			noCommit := false
+			var revisions []string
+			for _, arg := range args {
+				switch {
+				case arg == "--no-commit" || arg == "-n":
+					noCommit = true
+				case strings.HasPrefix(arg, "-"):
+					fmt.Printf("Error: unknown option '%s'\n", arg)
+					fmt.Println(usage)
+					os.Exit(2)
+				default:
+					revisions = append(revisions, arg)
+				}
+			}
+			if len(revisions) == 0 {
+				fmt.Println(usage)
+				os.Exit(2)
+			}
+			err = core.Revert(revisions, noCommit)
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	},
+	"reflog": func(args []string) {
+		core.EnsureArgs(args, 0, 1, "reflog")
+		ref := ""
+		if len(args) == 1 {
+			ref = args[0]
+		}
+		if err := core.Reflog(ref); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	},
 	"ls-files": func(args []string) {
 		core.EnsureArgs(args, 0, 0, "ls-files")
 		if !core.IsRepoInitialized() {
@@ -482,25 +668,93 @@ var commands = map[string]CommandFunc{
 			os.Exit(1)
 		}
 
-		if len(args) == 1 && (args[0] == "--list") {
-			if err := core.PrintTags(); err != nil {
-				fmt.Println("Error:", err)
-				os.Exit(1)
+		const usage = "Usage: kitcat tag [-a | -s] [-m <message>] <tag-name> [<commit>]\n   or: kitcat tag -d <tag-name>...\n   or: kitcat tag [-l] [-n[<num>]] [<pattern>...]"
+		list, remove, annotate, sign := false, false, false, false
+		lines := 0
+		var messages, names []string
+		for i := 0; i < len(args); i++ {
+			switch arg := args[i]; {
+			case arg == "-l" || arg == "--list":
+				list = true
+			case arg == "-d" || arg == "--delete":
+				remove = true
+			case arg == "-a" || arg == "--annotate":
+				annotate = true
+			case arg == "-s" || arg == "--sign":
+				annotate, sign = true, true
+			case arg == "-m":
+				if i+1 >= len(args) {
+					fmt.Println("Error: -m requires a message")
+					os.Exit(2)
+				}
+				messages = append(messages, args[i+1])
+				i++
+			case arg == "-n":
+				lines = 1
+			case strings.HasPrefix(arg, "-n"):
+				n, err := strconv.Atoi(arg[2:])
+				if err != nil || n < 0 {
+					fmt.Println("Error: -n takes a number of lines, as in -n3")
+					os.Exit(2)
+				}
+				lines = n
+			case strings.HasPrefix(arg, "-"):
+				fmt.Printf("Error: unknown flag %s\n", arg)
+				os.Exit(2)
+			default:
+				names = append(names, arg)
 			}
-			os.Exit(0)
 		}
 
-		if len(args) < 2 {
-			fmt.Println("Usage: kitcat tag <tag-name> <commit-id>")
-			os.Exit(2)
-		}
-
-		if err := core.CreateTag(args[0], args[1]); err != nil {
-			fmt.Println("Error:", err)
-			os.Exit(1)
+		switch {
+		case remove:
+			if len(names) == 0 || list || annotate || len(messages) > 0 {
+				fmt.Println(usage)
+				os.Exit(2)
+			}
+			failed := false
+			for _, name := range names {
+				if err := core.DeleteTag(name); err != nil {
+					fmt.Println("Error:", err)
+					failed = true
+				}
+			}
+			if failed {
+				os.Exit(1)
+			}
+		case list || len(names) == 0 || (lines > 0 && !annotate && len(messages) == 0):
+			if annotate || len(messages) > 0 {
+				fmt.Println(usage)
+				os.Exit(2)
+			}
+			if err := core.PrintTags(names, lines); err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+		default:
+			if len(names) > 2 || lines > 0 {
+				fmt.Println(usage)
+				os.Exit(2)
+			}
+			commit := "HEAD"
+			if len(names) == 2 {
+				commit = names[1]
+			}
+			var err error
+			if annotate || len(messages) > 0 {
+				if len(messages) == 0 {
+					fmt.Println("Error: an annotated tag needs a message; give it with -m")
+					os.Exit(2)
+				}
+				err = core.CreateAnnotatedTag(names[0], commit, strings.Join(messages, "\n\n"), sign)
+			} else {
+				err = core.CreateTag(names[0], commit)
+			}
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
 		}
-
-		os.Exit(0)
 	},
 	"config": func(args []string) {
 		if len(args) == 0 {
@@ -558,6 +812,126 @@ var commands = map[string]CommandFunc{
 		fmt.Println("Usage: kitcat config [--global] <key> [<value>]")
 		os.Exit(2)
 	},
+	"gc": func(args []string) {
+		core.EnsureArgs(args, 0, 1, "gc")
+		prune, age := false, core.DefaultPruneAge
+		if len(args) == 1 {
+			spec, ok := strings.CutPrefix(args[0], "--prune=")
+			if args[0] != "--prune" && !ok {
+				fmt.Println("Usage: kitcat gc [--prune[=<age>]]")
+				os.Exit(2)
+			}
+			prune = true
+			if ok {
+				var err error
+				if age, err = core.ParsePruneAge(spec); err != nil {
+					fmt.Println("Error:", err)
+					os.Exit(2)
+				}
+			}
+		}
+		if err := core.GarbageCollect(prune, age); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	},
+	"prune": func(args []string) {
+		core.EnsureArgs(args, 0, 1, "prune")
+		age := core.DefaultPruneAge
+		if len(args) == 1 {
+			spec, ok := strings.CutPrefix(args[0], "--expire=")
+			if !ok {
+				fmt.Println("Usage: kitcat prune [--expire=<age>]")
+				os.Exit(2)
+			}
+			var err error
+			if age, err = core.ParsePruneAge(spec); err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(2)
+			}
+		}
+		if err := core.Prune(age); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	},
+	"fsck": func(args []string) {
+		core.EnsureArgs(args, 0, 1, "fsck")
+		if !core.IsRepoInitialized() {
+			fmt.Println("Error: not a kitcat repository (or any of the parent directories): .kitcat")
+			os.Exit(1)
+		}
+		showUnreachable := false
+		if len(args) == 1 {
+			if args[0] != "--unreachable" {
+				fmt.Println("Usage: kitcat fsck [--unreachable]")
+				os.Exit(2)
+			}
+			showUnreachable = true
+		}
+		if _, err := core.Fsck(showUnreachable); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	},
+	"migrate": func(args []string) {
+		core.EnsureArgs(args, 0, 0, "migrate")
+		if err := core.Migrate(); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	},
+	"show": func(args []string) {
+		core.EnsureArgs(args, 0, 1, "show")
+		if !core.IsRepoInitialized() {
+			fmt.Println("Error: not a kitcat repository (or any of the parent directories): .kitcat")
+			os.Exit(1)
+		}
+		name := "HEAD"
+		if len(args) == 1 {
+			name = args[0]
+		}
+		if err := core.ShowObject(name); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	},
+	"verify-commit": func(args []string) {
+		core.EnsureArgs(args, 1, -1, "verify-commit")
+		if !core.IsRepoInitialized() {
+			fmt.Println("Error: not a kitcat repository (or any of the parent directories): .kitcat")
+			os.Exit(1)
+		}
+		failed := false
+		for _, rev := range args {
+			if err := core.VerifyCommit(rev); err != nil {
+				fmt.Println("Error:", err)
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+	"verify-tag": func(args []string) {
+		core.EnsureArgs(args, 1, -1, "verify-tag")
+		if !core.IsRepoInitialized() {
+			fmt.Println("Error: not a kitcat repository (or any of the parent directories): .kitcat")
+			os.Exit(1)
+		}
+		failed := false
+		for _, name := range args {
+			if err := core.VerifyTag(name); err != nil {
+				fmt.Println("Error:", err)
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
 	"show-object": func(args []string) {
 		if len(args) != 1 {
 			fmt.Println("Usage: kitcat show-object <hash>")
@@ -624,6 +998,231 @@ var commands = map[string]CommandFunc{
 			os.Exit(0)
 		}
 	},
+	"remote": func(args []string) {
+		if !core.IsRepoInitialized() {
+			fmt.Println("Error: not a kitcat repository (or any of the parent directories): .kitcat")
+			os.Exit(1)
+		}
+		var err error
+		switch {
+		case len(args) == 0:
+			err = core.ListRemotes(false)
+		case args[0] == "-v" || args[0] == "--verbose":
+			core.EnsureArgs(args, 1, 1, "remote -v")
+			err = core.ListRemotes(true)
+		case args[0] == "add":
+			if len(args) != 3 {
+				fmt.Println("Usage: kitcat remote add <name> <path-or-url>")
+				os.Exit(2)
+			}
+			err = core.AddRemote(args[1], args[2])
+		case args[0] == "remove" || args[0] == "rm":
+			if len(args) != 2 {
+				fmt.Println("Usage: kitcat remote remove <name>")
+				os.Exit(2)
+			}
+			err = core.RemoveRemote(args[1])
+		default:
+			fmt.Println("Usage: kitcat remote [-v | add <name> <path-or-url> | remove <name>]")
+			os.Exit(2)
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	},
+	"fetch": func(args []string) {
+		core.EnsureArgs(args, 0, 1, "fetch")
+		if !core.IsRepoInitialized() {
+			fmt.Println("Error: not a kitcat repository (or any of the parent directories): .kitcat")
+			os.Exit(1)
+		}
+		remote := "origin"
+		if len(args) == 1 {
+			remote = args[0]
+		}
+		if err := core.Fetch(remote); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	},
+	"push": func(args []string) {
+		if !core.IsRepoInitialized() {
+			fmt.Println("Error: not a kitcat repository (or any of the parent directories): .kitcat")
+			os.Exit(1)
+		}
+		force := false
+		var rest []string
+		for _, arg := range args {
+			switch {
+			case arg == "-f" || arg == "--force":
+				force = true
+			case strings.HasPrefix(arg, "-"):
+				fmt.Println("Usage: kitcat push [--force] [<remote> [<branch>]]")
+				os.Exit(2)
+			default:
+				rest = append(rest, arg)
+			}
+		}
+		core.EnsureArgs(rest, 0, 2, "push")
+		remote, branch := "origin", ""
+		if len(rest) > 0 {
+			remote = rest[0]
+		}
+		if len(rest) > 1 {
+			branch = rest[1]
+		}
+		if err := core.Push(remote, branch, force); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	},
+	"pull": func(args []string) {
+		core.EnsureArgs(args, 0, 2, "pull")
+		if !core.IsRepoInitialized() {
+			fmt.Println("Error: not a kitcat repository (or any of the parent directories): .kitcat")
+			os.Exit(1)
+		}
+		remote, branch := "origin", ""
+		if len(args) > 0 {
+			remote = args[0]
+		}
+		if len(args) > 1 {
+			branch = args[1]
+		}
+		if err := core.Pull(remote, branch); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	},
+	"clone": func(args []string) {
+		core.EnsureArgs(args, 1, 2, "clone")
+		dir := ""
+		if len(args) == 2 {
+			dir = args[1]
+		}
+		if err := core.Clone(args[0], dir); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	},
+	"bundle": func(args []string) {
+		usage := "Usage: kitcat bundle create <file> (--all | <ref> | <A>..<B>)...\n   or: kitcat bundle verify <file>\n   or: kitcat bundle unbundle <file>"
+		if len(args) < 2 {
+			fmt.Println(usage)
+			os.Exit(2)
+		}
+		if !core.IsRepoInitialized() {
+			fmt.Println("Error: not a kitcat repository (or any of the parent directories): .kitcat")
+			os.Exit(1)
+		}
+		var err error
+		switch args[0] {
+		case "create":
+			all := false
+			var revs []string
+			for _, arg := range args[2:] {
+				if arg == "--all" {
+					all = true
+				} else {
+					revs = append(revs, arg)
+				}
+			}
+			if !all && len(revs) == 0 {
+				fmt.Println(usage)
+				os.Exit(2)
+			}
+			err = core.CreateBundle(args[1], revs, all)
+		case "verify":
+			core.EnsureArgs(args, 2, 2, "bundle")
+			err = core.VerifyBundle(args[1])
+		case "unbundle":
+			core.EnsureArgs(args, 2, 2, "bundle")
+			err = core.Unbundle(args[1])
+		default:
+			fmt.Println(usage)
+			os.Exit(2)
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	},
+	"export-git": func(args []string) {
+		core.EnsureArgs(args, 1, 1, "export-git")
+		if !core.IsRepoInitialized() {
+			fmt.Println("Error: not a kitcat repository (or any of the parent directories): .kitcat")
+			os.Exit(1)
+		}
+		if err := core.ExportGit(args[0]); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	},
+	"import-git": func(args []string) {
+		core.EnsureArgs(args, 1, 1, "import-git")
+		if !core.IsRepoInitialized() {
+			fmt.Println("Error: not a kitcat repository (or any of the parent directories): .kitcat")
+			os.Exit(1)
+		}
+		if err := core.ImportGit(args[0]); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	},
+	"fast-export": func(args []string) {
+		if !core.IsRepoInitialized() {
+			fmt.Println("Error: not a kitcat repository (or any of the parent directories): .kitcat")
+			os.Exit(1)
+		}
+		all := false
+		var revs []string
+		for _, arg := range args {
+			if arg == "--all" {
+				all = true
+			} else {
+				revs = append(revs, arg)
+			}
+		}
+		if err := core.FastExport(os.Stdout, revs, all); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+	},
+	"fast-import": func(args []string) {
+		core.EnsureArgs(args, 0, 1, "fast-import")
+		if !core.IsRepoInitialized() {
+			fmt.Println("Error: not a kitcat repository (or any of the parent directories): .kitcat")
+			os.Exit(1)
+		}
+		force := false
+		if len(args) == 1 {
+			if args[0] != "--force" {
+				fmt.Println("Usage: kitcat fast-import [--force]")
+				os.Exit(2)
+			}
+			force = true
+		}
+		if err := core.FastImport(os.Stdin, force); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	},
+	"serve": func(args []string) {
+		core.EnsureArgs(args, 0, 1, "serve")
+		if !core.IsRepoInitialized() {
+			fmt.Println("Error: not a kitcat repository (or any of the parent directories): .kitcat")
+			os.Exit(1)
+		}
+		addr := "localhost:8080"
+		if len(args) == 1 {
+			addr = args[0]
+		}
+		if err := core.Serve(addr); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	},
 	"mv": func(args []string) {
 		force := false
 		paths := make([]string, 0, 2)
@@ -655,6 +1254,7 @@ var commands = map[string]CommandFunc{
 			)
 			os.Exit(1)
 		}
+		args, noVerify := cutFlag(args, "--no-verify")
 		if len(args) > 0 && args[0] == "list" {
 			if err := core.StashList(); err != nil {
 				fmt.Println("Error:", err)
@@ -668,7 +1268,7 @@ var commands = map[string]CommandFunc{
 			if len(args) > 1 {
 				message = strings.Join(args[1:], " ")
 			}
-			if err := core.StashPush(message); err != nil {
+			if err := core.StashPushWith(message, noVerify); err != nil {
 				fmt.Println("Error:", err)
 				os.Exit(1)
 			}
@@ -720,7 +1320,7 @@ var commands = map[string]CommandFunc{
 		}
 
 		// Default: stash save
-		if err := core.Stash(); err != nil {
+		if err := core.StashPushWith("", noVerify); err != nil {
 			fmt.Println("Error:", err)
 			os.Exit(1)
 		}
@@ -729,6 +1329,17 @@ var commands = map[string]CommandFunc{
 	},
 }
 
+// cutFlag removes every occurrence of flag from args and reports whether it was there
+func cutFlag(args []string, flag string) ([]string, bool) {
+	rest := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg != flag {
+			rest = append(rest, arg)
+		}
+	}
+	return rest, len(rest) != len(args)
+}
+
 // parseStashIndex parses a string index for stash commands.
 func parseStashIndex(s string) (int, error) {
 	var idx int
@@ -747,7 +1358,7 @@ func printCommitResult(newCommit models.Commit, summary string) {
 		ref := strings.TrimSpace(string(headData))
 		headState = strings.TrimPrefix(ref, "ref: refs/heads/")
 	}
-	fmt.Printf("[%s %s] %s\n%s\n", headState, newCommit.ID[:7], newCommit.Message, summary)
+	fmt.Printf("[%s %s] %s\n%s\n", headState, newCommit.ID[:7], newCommit.Subject(), summary)
 }
 
 func main() {
@@ -20,29 +20,35 @@ func AddFile(path string) error {
 		return errors.New("not a kitcat repository (run `kitcat init`)")
 	}
 
+	// Stat before hashing, so a change made while hashing leaves stale stat data in the index
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
 	hash, err := storage.HashAndStoreFile(path)
 	if err != nil {
 		return err
 	}
 
-	// Use UpdateIndex to safely update the index transactionally
-	return storage.UpdateIndex(func(index map[string]string) error {
-		// Skip if already tracked with same hash
-		if existing, ok := index[path]; ok && existing == hash {
-			return nil
-		}
-
-		index[path] = hash
+	// Use UpdateIndexEntries to safely update the index transactionally
+	return storage.UpdateIndexEntries(func(index map[string]storage.IndexEntry) error {
+		index[path] = storage.IndexEntryFromInfo(hash, info)
 		return nil
 	})
 }
 
 // AddAll stages all changes in the working directory.
 // This includes new files, modified files, and deleted files.
+// Unmerged paths are marked resolved with whatever the working directory holds.
 func AddAll() error {
-	// Use UpdateIndex to safely update the index transactionally.
+	// Use UpdateIndexState to safely update the index transactionally.
 	// We hold the lock during the entire walk to ensure consistency.
-	return storage.UpdateIndex(func(index map[string]string) error {
+	return storage.UpdateIndexState(func(entries map[string]storage.IndexEntry, conflicts map[string]storage.Conflict) error {
+		index := make(map[string]string, len(entries))
+		for path, entry := range entries {
+			index[path] = entry.Hash
+		}
+
 		// Load ignore patterns
 		ignorePatterns, err := LoadIgnorePatterns()
 		if err != nil {
@@ -88,6 +94,11 @@ func AddAll() error {
 			// Mark this file as "seen" in the working directory
 			filesInWorkDir[cleanPath] = true
 
+			// Files whose stat data matches the index are unchanged and need no rehashing
+			if entry, ok := entries[cleanPath]; ok && entry.StatMatches(info) {
+				return nil
+			}
+
 			// Hash the file and add/update it in the index.
 			// This is the same logic as AddFile, but applied to every file we find
 			hash, err := storage.HashAndStoreFile(cleanPath)
@@ -96,7 +107,7 @@ func AddAll() error {
 				fmt.Printf("warning: could not add file %s: %v\n", cleanPath, err)
 				return nil
 			}
-			index[cleanPath] = hash
+			entries[cleanPath] = storage.IndexEntryFromInfo(hash, info)
 			return nil
 		})
 		if err != nil {
@@ -106,10 +117,17 @@ func AddAll() error {
 		// Find and handle deleted files.
 		// We loop through the original index. If a file from the index was NOT seen
 		// during our walk of the working directory, it must have been deleted
-		for pathInIndex := range index {
+		for pathInIndex := range entries {
 			if !filesInWorkDir[pathInIndex] {
 				// Remove the deleted file from our index map
-				delete(index, pathInIndex)
+				delete(entries, pathInIndex)
+			}
+		}
+
+		// Conflicts are resolved by staging the file, or its deletion if it was removed
+		for path := range conflicts {
+			if _, staged := entries[path]; staged || !filesInWorkDir[path] {
+				delete(conflicts, path)
 			}
 		}
 
@@ -62,21 +62,12 @@ func CreateBranch(name string) error {
 		return err
 	}
 	commitHash, err := readCommitHash(head)
-	if err != nil {
-		// If HEAD can't be read, maybe there are no commits yet
-		lastCommit, err := storage.GetLastCommit()
-		if err != nil {
-			return errors.New("cannot create branch: no commits yet")
-		}
-		commitHash = lastCommit.ID
-	}
-
-	if err := os.MkdirAll(headsDir, 0o755); err != nil {
-		return err
+	if err != nil || commitHash == "" {
+		// If HEAD can't be resolved, there are no commits yet
+		return errors.New("cannot create branch: no commits yet")
 	}
 
-	branchPath := filepath.Join(headsDir, name)
-	return os.WriteFile(branchPath, []byte(strings.TrimSpace(commitHash)), 0o644)
+	return updateRef("refs/heads/"+name, strings.TrimSpace(commitHash), "branch: Created from HEAD")
 }
 
 // Checks if a branch with the given name exists.
@@ -163,7 +154,13 @@ func RenameCurrentBranch(newName string) error {
 		return err
 	}
 
-	return nil
+	// The branch keeps its history under the new name
+	oldRefName, newRefName := "refs/heads/"+oldName, "refs/heads/"+newName
+	if err := storage.RenameReflog(oldRefName, newRefName); err != nil {
+		return err
+	}
+	hash := strings.TrimSpace(string(commitHash))
+	return appendReflog(newRefName, hash, hash, fmt.Sprintf("Branch: renamed %s to %s", oldRefName, newRefName))
 }
 
 // DeleteBranch deletes the branch
@@ -186,5 +183,5 @@ func DeleteBranch(name string) error {
 		return fmt.Errorf("branch `%s` doesn't exist", name)
 	}
 
-	return nil
+	return storage.DeleteReflog("refs/heads/" + name)
 }
@@ -70,8 +70,8 @@ func setupTestRepo(t *testing.T) (string, func()) {
 		TreeHash:  treeHash,
 		Message:   "Initial commit",
 		Timestamp: time.Now(),
-		ID:        "test-commit-hash",
 	}
+	commit.ID = commit.Hash()
 	if err := storage.AppendCommit(commit); err != nil {
 		cleanup()
 		t.Fatalf("failed to append commit: %v", err)
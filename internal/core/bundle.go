@@ -0,0 +1,311 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// CreateBundle writes the history selected by revs to file, for a repository that cannot
+// fetch from this one. Each revision is a branch or tag, whose whole history is included,
+// or a range A..B or A...B, which includes the commits of the range and records the
+// commits it builds on as prerequisites. With all, every branch and tag is included.
+func CreateBundle(file string, revs []string, all bool) error {
+	refs, exclude, err := selectHistory(revs, all)
+	if err != nil {
+		return err
+	}
+	if all {
+		if ref, hash, ok := fullRefName(headRef); ok {
+			refs = append(refs, storage.BundleRef{Name: ref, Hash: hash})
+		}
+	}
+	if len(refs) == 0 {
+		return errors.New("refusing to create an empty bundle")
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Name < refs[j].Name })
+
+	tips := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		tips = append(tips, ref.Hash)
+	}
+	objects, err := storage.ReachableObjects(tips, func(commit string) bool { return exclude[commit] })
+	if err != nil {
+		return err
+	}
+
+	// The prerequisites are the excluded parents of the commits that are included
+	var prerequisites []string
+	seen := make(map[string]bool)
+	commits := 0
+	for _, name := range objects {
+		commit, err := storage.FindCommit(name)
+		if err != nil || commit.ID != name {
+			continue
+		}
+		commits++
+		for _, parent := range commit.Parents {
+			if exclude[parent] && !seen[parent] {
+				seen[parent] = true
+				prerequisites = append(prerequisites, parent)
+			}
+		}
+	}
+	if commits == 0 {
+		return errors.New("refusing to create an empty bundle")
+	}
+	sort.Strings(prerequisites)
+
+	header := storage.BundleHeader{Prerequisites: prerequisites, Refs: refs}
+	if err := storage.WriteBundle(file, header, objects); err != nil {
+		return err
+	}
+	fmt.Printf("Created %s with %d commit%s and %d object%s\n", file, commits, pluralize(commits), len(objects), pluralize(len(objects)))
+	return nil
+}
+
+// selectHistory turns the revisions given to bundle or fast-export into the refs to
+// carry and the commits to leave out. Each revision is a ref, whose whole history is
+// selected, or a range: A..B selects B without the history of A, A...B both sides without
+// the history of their merge base. With all, every branch and tag is selected.
+func selectHistory(revs []string, all bool) ([]storage.BundleRef, map[string]bool, error) {
+	var refs []storage.BundleRef
+	exclude := make(map[string]bool)
+	addRef := func(name string) error {
+		ref, hash, ok := fullRefName(name)
+		if !ok {
+			return fmt.Errorf("'%s' is not a branch or tag", name)
+		}
+		for _, r := range refs {
+			if r.Name == ref {
+				return nil
+			}
+		}
+		refs = append(refs, storage.BundleRef{Name: ref, Hash: hash})
+		return nil
+	}
+	excludeHistory := func(rev string) error {
+		hash, err := ResolveRevision(rev)
+		if err != nil {
+			return err
+		}
+		history, err := commitClosure([]string{hash})
+		if err != nil {
+			return err
+		}
+		for id := range history {
+			exclude[id] = true
+		}
+		return nil
+	}
+
+	if all {
+		heads, err := readRefs("refs/heads", "refs/tags")
+		if err != nil {
+			return nil, nil, err
+		}
+		for ref, hash := range heads {
+			refs = append(refs, storage.BundleRef{Name: ref, Hash: hash})
+		}
+	}
+	for _, rev := range revs {
+		from, to, symmetric, isRange := splitRange(rev)
+		if !isRange {
+			if err := addRef(rev); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if err := addRef(to); err != nil {
+			return nil, nil, err
+		}
+		if !symmetric {
+			if err := excludeHistory(from); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if err := addRef(from); err != nil {
+			return nil, nil, err
+		}
+		fromHash, err := ResolveRevision(from)
+		if err != nil {
+			return nil, nil, fmt.Errorf("bad revision '%s': %w", from, err)
+		}
+		toHash, err := ResolveRevision(to)
+		if err != nil {
+			return nil, nil, fmt.Errorf("bad revision '%s': %w", to, err)
+		}
+		// Unrelated histories have no merge base, and are both selected whole
+		base, err := storage.FindMergeBase(fromHash, toHash)
+		switch {
+		case errors.Is(err, storage.ErrNoMergeBase):
+		case err != nil:
+			return nil, nil, err
+		default:
+			if err := excludeHistory(base); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return refs, exclude, nil
+}
+
+// fullRefName returns the full name of the ref called name and the commit it points at:
+// HEAD, refs/<path>, a remote-tracking branch, or else a tag or branch of that name
+func fullRefName(name string) (string, string, bool) {
+	if name == headRef || name == "@" {
+		hash, err := readHead()
+		return headRef, hash, err == nil && hash != ""
+	}
+	full := name
+	switch {
+	case strings.HasPrefix(name, "refs/"):
+	case strings.Contains(name, "/"):
+		full = "refs/remotes/" + name
+	default:
+		if _, ok := lookupRef("refs/tags/" + name); ok {
+			full = "refs/tags/" + name
+		} else {
+			full = "refs/heads/" + name
+		}
+	}
+	hash, ok := lookupRef(full)
+	return full, hash, ok
+}
+
+// VerifyBundle checks that every object in the bundle at file is intact, that it holds
+// the commits its refs name, and that this repository has its prerequisites
+func VerifyBundle(file string) error {
+	header, err := readBundle(file, func(string, storage.Object) error { return nil })
+	if err != nil {
+		return err
+	}
+	fmt.Printf("The bundle contains %d ref%s:\n", len(header.Refs), pluralize(len(header.Refs)))
+	for _, ref := range header.Refs {
+		fmt.Printf("%s %s\n", ref.Hash, ref.Name)
+	}
+	if len(header.Prerequisites) == 0 {
+		fmt.Println("The bundle records a complete history.")
+	} else {
+		fmt.Printf("The bundle requires %d commit%s:\n", len(header.Prerequisites), pluralize(len(header.Prerequisites)))
+		for _, hash := range header.Prerequisites {
+			fmt.Println(hash)
+		}
+	}
+	fmt.Printf("%s is okay\n", file)
+	return nil
+}
+
+// Unbundle stores the objects of the bundle at file in this repository and prints the
+// refs it provides. Branches are not changed; fetch from the bundle as a remote, or
+// clone it, to get them.
+func Unbundle(file string) error {
+	header, err := readBundle(file, storage.StoreObject)
+	if err != nil {
+		return err
+	}
+	if err := checkBundleComplete(header); err != nil {
+		return err
+	}
+	for _, ref := range header.Refs {
+		fmt.Printf("%s %s\n", ref.Hash, ref.Name)
+	}
+	return nil
+}
+
+// readBundle checks that this repository has the prerequisites of the bundle at file,
+// then passes each of its objects to visit. The commits the refs name must be in the
+// bundle or in this repository.
+func readBundle(file string, visit func(hash string, obj storage.Object) error) (storage.BundleHeader, error) {
+	header, err := storage.ReadBundleHeader(file)
+	if err != nil {
+		return storage.BundleHeader{}, fmt.Errorf("%s: %w", file, err)
+	}
+	var missing []string
+	for _, hash := range header.Prerequisites {
+		if _, err := storage.FindCommit(hash); err != nil {
+			missing = append(missing, hash)
+		}
+	}
+	if len(missing) > 0 {
+		return storage.BundleHeader{}, fmt.Errorf("repository lacks these prerequisite commits:\n%s", strings.Join(missing, "\n"))
+	}
+
+	inBundle := make(map[string]bool)
+	header, err = storage.ReadBundle(file, func(hash string, obj storage.Object) error {
+		inBundle[hash] = true
+		return visit(hash, obj)
+	})
+	if err != nil {
+		return storage.BundleHeader{}, fmt.Errorf("%s: %w", file, err)
+	}
+	for _, ref := range header.Refs {
+		if !inBundle[ref.Hash] && !storage.HasObject(ref.Hash) {
+			return storage.BundleHeader{}, fmt.Errorf("%s: commit %s of %s is missing", file, ref.Hash, ref.Name)
+		}
+	}
+	return header, nil
+}
+
+// checkBundleComplete makes sure every object the refs of an unpacked bundle need is
+// now in the repository
+func checkBundleComplete(header storage.BundleHeader) error {
+	have, err := commitClosure(header.Prerequisites)
+	if err != nil {
+		return err
+	}
+	tips := make([]string, 0, len(header.Refs))
+	for _, ref := range header.Refs {
+		tips = append(tips, ref.Hash)
+	}
+	if _, err := storage.ReachableObjects(tips, func(commit string) bool { return have[commit] }); err != nil {
+		return fmt.Errorf("the bundle is incomplete: %w", err)
+	}
+	return nil
+}
+
+// bundleTransport reads a bundle file as if it were a remote repository that can only be
+// fetched from
+type bundleTransport struct {
+	path string
+}
+
+func (t bundleTransport) advertise() (advertisement, error) {
+	header, err := storage.ReadBundleHeader(t.path)
+	if err != nil {
+		return advertisement{}, fmt.Errorf("%s: %w", t.path, err)
+	}
+	adv := advertisement{Refs: make(map[string]string)}
+	head := ""
+	for _, ref := range header.Refs {
+		if ref.Name == headRef {
+			head = ref.Hash
+		} else if strings.HasPrefix(ref.Name, "refs/heads/") || strings.HasPrefix(ref.Name, "refs/tags/") {
+			adv.Refs[ref.Name] = ref.Hash
+		}
+	}
+	// A bundle records HEAD as a commit; the branch it was on is the one at that commit
+	for _, ref := range header.Refs {
+		if strings.HasPrefix(ref.Name, "refs/heads/") && ref.Hash == head && (adv.Head == "" || ref.Name == "refs/heads/main") {
+			adv.Head = ref.Name
+		}
+	}
+	return adv, nil
+}
+
+func (t bundleTransport) fetch(wants, haves []string) ([]transferObject, error) {
+	var objects []transferObject
+	_, err := readBundle(t.path, func(hash string, obj storage.Object) error {
+		objects = append(objects, transferObject{name: hash, obj: obj})
+		return nil
+	})
+	return objects, err
+}
+
+func (t bundleTransport) push(update refUpdate, objects []transferObject) error {
+	return fmt.Errorf("cannot push to the bundle %s", t.path)
+}
@@ -1,11 +1,8 @@
 package core
 
 import (
-	"crypto/sha1"
-	"encoding/hex"
 	"errors"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -15,26 +12,28 @@ import (
 
 // Restore a file in the working directory to its state in the last commit
 func CheckoutFile(filePath string) error {
-	// Get the target content (from HEAD/Last Commit)
-	lastCommit, err := storage.GetLastCommit()
+	// Get the target content (from HEAD)
+	lastCommit, err := GetHeadCommit()
 	if err != nil {
 		return err
 	}
 
-	tree, err := storage.ParseTree(lastCommit.TreeHash)
+	tree, err := storage.FlattenTree(lastCommit.TreeHash)
 	if err != nil {
 		return err
 	}
 
-	blobHash, ok := tree[filePath]
+	entry, ok := tree[filePath]
 	if !ok {
 		return errors.New("file not found in the last commit")
 	}
+	blobHash := entry.Hash
 
 	// SAFETY CHECK: Prevent overwriting dirty or untracked files
-	if _, err := os.Stat(filePath); err == nil {
-		// File exists, check if it is safe to overwrite
-		currentHash, err := calculateHash(filePath)
+	if _, err := os.Lstat(filePath); err == nil {
+		// File exists, check if it is safe to overwrite; a symlink is hashed by its target
+		// path, as the index records it
+		currentHash, err := storage.HashFile(filePath)
 		if err != nil {
 			return fmt.Errorf("failed to calculate hash for safety check: %v", err)
 		}
@@ -57,43 +56,67 @@ func CheckoutFile(filePath string) error {
 	}
 
 	// Safe to overwrite: Perform the checkout
-	content, err := storage.ReadObject(blobHash)
+	content, err := storage.ReadBlob(blobHash)
 	if err != nil {
 		return err
 	}
 
-	if err := os.WriteFile(filePath, content, 0o644); err != nil {
+	if err := writeWorkingFile(filePath, content, entry.Mode); err != nil {
 		return err
 	}
 
 	// Update the index to reflect the checked-out version
-	index, err := storage.LoadIndex()
+	err = storage.UpdateIndexEntries(func(index map[string]storage.IndexEntry) error {
+		index[filePath] = storage.NewIndexEntry(filePath, blobHash, entry.Mode)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	index[filePath] = blobHash
-	return storage.WriteIndex(index)
+	runPostCheckout(lastCommit.ID, lastCommit.ID, false)
+	return nil
 }
 
-// Switch the current HEAD to the named branch and updates the working directory.
-func CheckoutBranch(name string) error {
-	branchPath := filepath.Join(headsDir, name)
-	commitHashBytes, err := os.ReadFile(branchPath)
+// CheckoutConflictSide writes one side of an unmerged path to the working directory:
+// our version, or theirs when theirs is true. The path stays unmerged until it is added.
+func CheckoutConflictSide(filePath string, theirs bool) error {
+	filePath = filepath.Clean(filePath)
+	conflicts, err := storage.LoadIndexConflicts()
 	if err != nil {
-		return fmt.Errorf("branch '%s' not found", name)
+		return err
+	}
+	conflict, ok := conflicts[filePath]
+	if !ok {
+		return fmt.Errorf("path '%s' is not unmerged", filePath)
 	}
-	commitHash := strings.TrimSpace(string(commitHashBytes))
 
-	// Get the tree of the target commit
-	// We need to find the commit object to get its tree hash
-	commit, err := storage.FindCommit(commitHash)
+	side, name := conflict.Ours, "our"
+	if theirs {
+		side, name = conflict.Theirs, "their"
+	}
+	if side == nil {
+		return fmt.Errorf("path '%s' does not have %s version", filePath, name)
+	}
+	content, err := storage.ReadBlob(side.Hash)
 	if err != nil {
 		return err
 	}
-	targetTree, err := storage.ParseTree(commit.TreeHash)
-	if err != nil {
+	if err := writeWorkingFile(filePath, content, side.Mode); err != nil {
 		return err
 	}
+	head, _ := readHead()
+	runPostCheckout(head, head, false)
+	return nil
+}
+
+// Switch the current HEAD to the named branch and updates the working directory.
+func CheckoutBranch(name string) error {
+	branchPath := filepath.Join(headsDir, name)
+	commitHashBytes, err := os.ReadFile(branchPath)
+	if err != nil {
+		return fmt.Errorf("branch '%s' not found", name)
+	}
+	commitHash := strings.TrimSpace(string(commitHashBytes))
 
 	isDirty, err := IsWorkDirDirty()
 	if err != nil {
@@ -105,70 +128,46 @@ func CheckoutBranch(name string) error {
 		)
 	}
 
-	// Before making changes, we should check if the user has unstaged work
-	// that would be overwritten
-	// So the real Git would abort here
-	// For now, this is what i have done
-
-	// Update the working directory to match the target tree
-	// First, delete files that are not in the target tree
-	currentIndex, _ := storage.LoadIndex()
-	for path := range currentIndex {
-		if _, existsInTarget := targetTree[path]; !existsInTarget {
-			os.Remove(path)
-		}
-	}
-
-	// Now, write/update files from the target tree
-	for path, hash := range targetTree {
-		content, err := storage.ReadObject(hash)
-		if err != nil {
-			return err
-		}
-		// Ensure directory exists before writing file
-		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
-			return err
-		}
-		if err := os.WriteFile(path, content, 0o644); err != nil {
-			return err
-		}
-	}
-
-	// Update the index to match the new tree
-	if err := storage.WriteIndex(targetTree); err != nil {
+	// Update the working directory and index to match the target commit
+	if err := UpdateWorkspaceAndIndex(commitHash); err != nil {
 		return err
 	}
 
 	// Update HEAD to point to the new branch
+	previousHead, _ := readHead()
+	reason := fmt.Sprintf("checkout: moving from %s to %s", describeHead(), name)
 	newHEADContent := fmt.Sprintf("ref: refs/heads/%s", name)
-	return os.WriteFile(".kitcat/HEAD", []byte(newHEADContent), 0o644)
+	if err := os.WriteFile(".kitcat/HEAD", []byte(newHEADContent), 0o644); err != nil {
+		return err
+	}
+	if err := appendReflog(headRef, previousHead, commitHash, reason); err != nil {
+		return err
+	}
+	runPostCheckout(previousHead, commitHash, true)
+	return nil
 }
 
 // CheckoutCommit moves HEAD to a specific commit and updates the working directory
 // This puts the repository in a "detached HEAD" state
 func CheckoutCommit(commitHash string) error {
 	// Verify the commit actually exists
-	_, err := storage.FindCommit(commitHash)
+	commit, err := resolveCommit(commitHash)
 	if err != nil {
 		return fmt.Errorf("commit '%s' not found", commitHash)
 	}
 
-	if err := UpdateWorkspaceAndIndex(commitHash); err != nil {
+	if err := UpdateWorkspaceAndIndex(commit.ID); err != nil {
 		return err
 	}
 
-	return os.WriteFile(".kitcat/HEAD", []byte(commitHash), 0o644)
-}
-
-func calculateHash(path string) (string, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return "", err
+	previousHead, _ := readHead()
+	reason := fmt.Sprintf("checkout: moving from %s to %s", describeHead(), commit.ID)
+	if err := os.WriteFile(".kitcat/HEAD", []byte(commit.ID), 0o644); err != nil {
+		return err
 	}
-	defer f.Close()
-	h := sha1.New()
-	if _, err := io.Copy(h, f); err != nil {
-		return "", err
+	if err := appendReflog(headRef, previousHead, commit.ID, reason); err != nil {
+		return err
 	}
-	return hex.EncodeToString(h.Sum(nil)), nil
+	runPostCheckout(previousHead, commit.ID, true)
+	return nil
 }
@@ -71,9 +71,9 @@ func Test_CheckoutFile_PreservesDirtyFile(t *testing.T) {
 		TreeHash:  treeHash,
 		Message:   "Initial commit",
 		Timestamp: time.Now(),
-		ID:        "test-commit-hash", // ID doesn't matter for GetLastCommit logic if we append it
 	}
-	// AppendCommit writes to .kitcat/commits.log
+	commit.ID = commit.Hash()
+	// AppendCommit stores the commit under .kitcat/objects
 	if err := storage.AppendCommit(commit); err != nil {
 		t.Fatalf("failed to append commit: %v", err)
 	}
@@ -171,8 +171,8 @@ func Test_CheckoutFile_UpdatesIndex(t *testing.T) {
 		TreeHash:  treeHash,
 		Message:   "Initial commit",
 		Timestamp: time.Now(),
-		ID:        "test-commit-id",
 	}
+	commit.ID = commit.Hash()
 	if err := storage.AppendCommit(commit); err != nil {
 		t.Fatalf("failed to append commit: %v", err)
 	}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+)
+
+// CherryPick applies the changes introduced by each of the given commits onto HEAD, in order,
+// committing each one with its original message and author. A range A..B or A...B picks
+// each of its commits, oldest first, leaving out merges. With noCommit the changes are
+// only applied to the working directory and index. When a commit conflicts the sequence
+// stops so it can be resolved and resumed with CherryPickContinue.
+func CherryPick(commits []string, noCommit bool) error {
+	if err := ensureCanStartSequencer(actionCherryPick); err != nil {
+		return err
+	}
+	todo := make([]string, 0, len(commits))
+	for _, rev := range commits {
+		picked, err := revisionCommits(rev)
+		if err != nil {
+			return fmt.Errorf("bad revision '%s': %w", rev, err)
+		}
+		_, _, _, isRange := splitRange(rev)
+		for _, commit := range picked {
+			if commit.IsMerge() {
+				if isRange {
+					continue
+				}
+				return fmt.Errorf("commit %s is a merge; cherry-picking merge commits is not supported", shortHash(commit.ID))
+			}
+			todo = append(todo, commit.ID)
+		}
+	}
+	if len(todo) == 0 {
+		return fmt.Errorf("empty commit set passed")
+	}
+	return startSequencer(actionCherryPick, todo, noCommit)
+}
+
+// CherryPickContinue commits the resolved changes of the commit the cherry-pick stopped at
+// and applies the remaining ones
+func CherryPickContinue() error {
+	return continueSequencer(actionCherryPick)
+}
+
+// CherryPickSkip discards the changes of the commit the cherry-pick stopped at and applies
+// the remaining ones
+func CherryPickSkip() error {
+	return skipSequencer(actionCherryPick)
+}
+
+// CherryPickAbort stops the cherry-pick and restores the branch, working directory and
+// index to where they were before it started
+func CherryPickAbort() error {
+	return abortSequencer(actionCherryPick)
+}
+
+// pickCommit applies the changes of commit onto the index and, unless noCommit is set,
+// commits them with its message and author
+func pickCommit(commit models.Commit, noCommit bool) error {
+	if err := cherryPick(commit.ID, true); err != nil {
+		return err
+	}
+	if noCommit {
+		return nil
+	}
+	return commitStep(actionCherryPick, commit)
+}
+
+// firstLine returns the subject line of a commit message
+func firstLine(message string) string {
+	subject, _, _ := strings.Cut(message, "\n")
+	return subject
+}
+
+// isNothingToCommit reports whether err is Commit refusing an empty commit
+func isNothingToCommit(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "nothing to commit")
+}
@@ -1,43 +1,131 @@
 package core
 
 import (
-	"crypto/sha1"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/LeeFred3042U/kitcat/internal/diff"
 	"github.com/LeeFred3042U/kitcat/internal/models"
 	"github.com/LeeFred3042U/kitcat/internal/storage"
 )
 
-// note task - Move commit storage from commits.log to individual objects in .kitcat/objects/
-// hashCommit creates a unique, content-based SHA-1 hash for a Commit object.
-func hashCommit(c models.Commit) string {
-	h := sha1.New()
-	h.Write([]byte(c.TreeHash))
-	h.Write([]byte(c.Parent))
-	h.Write([]byte(c.Message))
-	h.Write([]byte(c.Timestamp.UTC().Format(time.RFC3339Nano)))
-	return hex.EncodeToString(h.Sum(nil))
+// CommitOptions changes how CommitWith and AmendCommitWith record a commit
+type CommitOptions struct {
+	All        bool   // stage every tracked file first, as commit -a does
+	Author     string // "Name <email>" to record as the author instead of the configured user
+	Date       string // the date to record the change as authored, in a format parseDate reads
+	Edit       bool   // open the editor on the message before committing
+	AllowEmpty bool   // commit even when the tree is the same as the parent's
+	NoVerify   bool   // skip the pre-commit and commit-msg hooks
+}
+
+// author returns by with the author and date of opts applied
+func (opts CommitOptions) author(by identity) (identity, error) {
+	if opts.Author != "" {
+		name, email, err := parseIdentity(opts.Author)
+		if err != nil {
+			return identity{}, fmt.Errorf("invalid --author: %w", err)
+		}
+		by.Name, by.Email = name, email
+	}
+	if opts.Date != "" {
+		when, err := parseDate(opts.Date)
+		if err != nil {
+			return identity{}, fmt.Errorf("invalid --date: %w", err)
+		}
+		by.When = when
+	}
+	return by, nil
 }
 
 // Commit creates a new snapshot of the repository based on the current state of the index
 // It prevents empty commits and returns the full commit object and a formatted summary
 func Commit(message string) (models.Commit, string, error) {
-	authorName, _, _ := GetConfig("user.name")
-	if authorName == "" {
-		authorName = "Unknown"
+	return CommitWith(message, CommitOptions{})
+}
+
+// CommitWith is Commit with options. The author is the configured user or the one the
+// KITCAT_AUTHOR_* variables name, unless opts gives another. The message is cleaned up
+// as git does; the commit is aborted if nothing is left of it. The pre-commit hook runs
+// first and commit-msg once the message is known; either can abort the commit.
+// post-commit runs after it is made.
+func CommitWith(message string, opts CommitOptions) (models.Commit, string, error) {
+	by, err := authorIdent()
+	if err != nil {
+		return models.Commit{}, "", err
+	}
+	if by, err = opts.author(by); err != nil {
+		return models.Commit{}, "", err
+	}
+	if opts.All {
+		// Staging everything would silently mark conflicts resolved
+		if err := ensureNoUnmergedPaths("commit"); err != nil {
+			return models.Commit{}, "", err
+		}
+		if err := AddAll(); err != nil {
+			return models.Commit{}, "", fmt.Errorf("failed to stage changes before committing: %w", err)
+		}
+	}
+
+	if !opts.NoVerify {
+		if err := runHook(hookPreCommit, commitHookEnv(by)); err != nil {
+			return models.Commit{}, "", err
+		}
+	}
+
+	// The message of a merge being concluded is the default
+	if message == "" && IsMergeInProgress() {
+		if _, mergeMsg, err := readMergeState(); err == nil {
+			message = mergeMsg
+		}
+	}
+	if opts.Edit {
+		if message, err = editCommitMessage(message); err != nil {
+			return models.Commit{}, "", err
+		}
+	}
+	if message, err = verifyMessage(message, by, opts); err != nil {
+		return models.Commit{}, "", err
+	}
+	commit, summary, err := commitIndex(message, by, opts.AllowEmpty)
+	if err != nil {
+		return models.Commit{}, "", err
+	}
+	runPostHook(hookPostCommit)
+	return commit, summary, nil
+}
+
+// verifyMessage cleans up message and, unless opts.NoVerify is set, passes it through the
+// commit-msg hook. It fails if the hook does or if the message ends up empty.
+func verifyMessage(message string, by identity, opts CommitOptions) (string, error) {
+	message = cleanupMessage(message, false)
+	if !opts.NoVerify {
+		edited, err := runCommitMsgHook(message, by)
+		if err != nil {
+			return "", err
+		}
+		message = cleanupMessage(edited, opts.Edit)
 	}
-	authorEmail, _, _ := GetConfig("user.email")
-	if authorEmail == "" {
-		authorEmail = "unknown@example.com"
+	if message == "" {
+		return "", errEmptyMessage
 	}
+	return message, nil
+}
+
+// commitAs is Commit for a replayed commit, keeping the author and author date of original
+func commitAs(message string, original models.Commit) (models.Commit, string, error) {
+	return commitIndex(message, identity{Name: original.AuthorName, Email: original.AuthorEmail, When: original.Timestamp}, false)
+}
 
+// commitIndex commits the index on top of HEAD with the given author. Unless allowEmpty
+// is set it refuses to commit a tree unchanged from HEAD's.
+func commitIndex(message string, by identity, allowEmpty bool) (models.Commit, string, error) {
+	if err := ensureNoUnmergedPaths("commit"); err != nil {
+		return models.Commit{}, "", err
+	}
 	treeHash, err := storage.CreateTree()
 	if err != nil {
 		return models.Commit{}, "", err
@@ -52,21 +140,30 @@ func Commit(message string) (models.Commit, string, error) {
 		parentTreeHash = parentCommit.TreeHash
 	}
 
-	if treeHash == parentTreeHash {
-		return models.Commit{}, "", errors.New("nothing to commit, working tree clean")
+	// A commit made while a conflicted merge is in progress concludes that merge
+	var mergeHead, mergeMsg string
+	if IsMergeInProgress() {
+		if mergeHead, mergeMsg, err = readMergeState(); err != nil {
+			return models.Commit{}, "", fmt.Errorf("could not read merge state: %w", err)
+		}
+		if message == "" {
+			message = mergeMsg
+		}
 	}
 
-	commit := models.Commit{
-		Parent:      parentID,
-		Message:     message,
-		Timestamp:   time.Now().UTC(),
-		TreeHash:    treeHash,
-		AuthorName:  authorName,
-		AuthorEmail: authorEmail,
+	if treeHash == parentTreeHash && mergeHead == "" && !allowEmpty {
+		return models.Commit{}, "", errors.New("nothing to commit, working tree clean")
 	}
-	commit.ID = hashCommit(commit)
 
-	if err := storage.AppendCommit(commit); err != nil {
+	var parents []string
+	if parentID != "" {
+		parents = []string{parentID}
+	}
+	if mergeHead != "" {
+		parents = append(parents, mergeHead)
+	}
+	commit, err := newCommit(treeHash, parents, message, by)
+	if err != nil {
 		return models.Commit{}, "", err
 	}
 
@@ -90,6 +187,21 @@ func Commit(message string) (models.Commit, string, error) {
 	if err := SafeWrite(branchFilePath, []byte(commit.ID), 0o644); err != nil {
 		return models.Commit{}, "", fmt.Errorf("failed to update branch pointer: %w", err)
 	}
+	reason := "commit"
+	switch {
+	case parentID == "":
+		reason = "commit (initial)"
+	case mergeHead != "":
+		reason = "commit (merge)"
+	}
+	if err := logRefUpdate(refPath, parentID, commit.ID, reason+": "+firstLine(message)); err != nil {
+		return models.Commit{}, "", err
+	}
+	if mergeHead != "" {
+		if err := clearMergeState(); err != nil {
+			return models.Commit{}, "", err
+		}
+	}
 
 	parentTree := make(map[string]string)
 	if parentID != "" {
@@ -101,33 +213,78 @@ func Commit(message string) (models.Commit, string, error) {
 	return commit, summary, nil
 }
 
+// newCommit stores a commit of treeHash on top of parents, authored by by and committed
+// by the configured committer. It does not move any branch
+func newCommit(treeHash string, parents []string, message string, by identity) (models.Commit, error) {
+	committer, err := committerIdent()
+	if err != nil {
+		return models.Commit{}, err
+	}
+	commit := models.Commit{
+		Parents:        parents,
+		Message:        message,
+		Timestamp:      by.When,
+		TreeHash:       treeHash,
+		AuthorName:     by.Name,
+		AuthorEmail:    by.Email,
+		CommitterName:  committer.Name,
+		CommitterEmail: committer.Email,
+		CommitTime:     committer.When,
+	}
+	if err := signCommit(&commit); err != nil {
+		return models.Commit{}, err
+	}
+	commit.ID = commit.Hash()
+
+	if err := storage.AppendCommit(commit); err != nil {
+		return models.Commit{}, err
+	}
+	return commit, nil
+}
+
 // AmendCommit updates the message of the most recent commit without changing files.
 // It loads the last commit, updates its message, re-hashes it, and updates the branch pointer.
 func AmendCommit(newMessage string) (models.Commit, error) {
-	// Get the last commit
-	lastCommit, err := storage.GetLastCommit()
+	return AmendCommitWith(newMessage, CommitOptions{})
+}
+
+// AmendCommitWith is AmendCommit with options. The commit keeps its author and author
+// date unless opts replaces them; the committer is whoever amends it. With opts.Edit the
+// editor opens on newMessage, or on the message of the commit when newMessage is empty.
+// The commit hooks run as for CommitWith.
+func AmendCommitWith(newMessage string, opts CommitOptions) (models.Commit, error) {
+	// Get the commit HEAD points to
+	lastCommit, err := GetHeadCommit()
 	if err != nil {
 		if err == storage.ErrNoCommits {
 			return models.Commit{}, errors.New("no commits to amend")
 		}
 		return models.Commit{}, fmt.Errorf("failed to get last commit: %w", err)
 	}
-
-	// Create a new commit with the updated message but same tree and parent
-	amendedCommit := models.Commit{
-		Parent:      lastCommit.Parent,
-		Message:     newMessage,
-		Timestamp:   lastCommit.Timestamp, // Keep original timestamp
-		TreeHash:    lastCommit.TreeHash,  // Same files
-		AuthorName:  lastCommit.AuthorName,
-		AuthorEmail: lastCommit.AuthorEmail,
+	by, err := opts.author(identity{Name: lastCommit.AuthorName, Email: lastCommit.AuthorEmail, When: lastCommit.Timestamp})
+	if err != nil {
+		return models.Commit{}, err
+	}
+	if !opts.NoVerify {
+		if err := runHook(hookPreCommit, commitHookEnv(by)); err != nil {
+			return models.Commit{}, err
+		}
+	}
+	if opts.Edit {
+		if newMessage == "" {
+			newMessage = lastCommit.Message
+		}
+		if newMessage, err = editCommitMessage(newMessage); err != nil {
+			return models.Commit{}, err
+		}
+	}
+	if newMessage, err = verifyMessage(newMessage, by, opts); err != nil {
+		return models.Commit{}, err
 	}
 
-	// Re-hash the commit (this generates a new ID)
-	amendedCommit.ID = hashCommit(amendedCommit)
-
-	// Save the amended commit
-	if err := storage.AppendCommit(amendedCommit); err != nil {
+	// Create a new commit with the updated message but same tree and parent
+	amendedCommit, err := newCommit(lastCommit.TreeHash, lastCommit.Parents, newMessage, by)
+	if err != nil {
 		return models.Commit{}, fmt.Errorf("failed to save amended commit: %w", err)
 	}
 
@@ -141,16 +298,17 @@ func AmendCommit(newMessage string) (models.Commit, error) {
 	if err := SafeWrite(branchFilePath, []byte(amendedCommit.ID), 0o644); err != nil {
 		return models.Commit{}, fmt.Errorf("failed to update branch pointer: %w", err)
 	}
+	if err := logRefUpdate(refPath, lastCommit.ID, amendedCommit.ID, "commit (amend): "+firstLine(newMessage)); err != nil {
+		return models.Commit{}, err
+	}
+	runPostHook(hookPostCommit)
 
 	return amendedCommit, nil
 }
 
 // CommitAll is a convenience function that implements the `commit -am` shortcut.
 func CommitAll(message string) (models.Commit, string, error) {
-	if err := AddAll(); err != nil {
-		return models.Commit{}, "", fmt.Errorf("failed to stage changes before committing: %w", err)
-	}
-	return Commit(message)
+	return CommitWith(message, CommitOptions{All: true})
 }
 
 func getCurrentBranchRefPath() (string, error) {
@@ -191,16 +349,16 @@ func GenerateCommitSummary(parentTree, newTree map[string]string) (string, error
 
 		if inOld && !inNew {
 			filesChanged++
-			oldContent, _ := storage.ReadObject(oldHash)
+			oldContent, _ := storage.ReadBlob(oldHash)
 			deletions += len(strings.Split(string(oldContent), "\n"))
 		} else if !inOld && inNew {
 			filesChanged++
-			newContent, _ := storage.ReadObject(newHash)
+			newContent, _ := storage.ReadBlob(newHash)
 			insertions += len(strings.Split(string(newContent), "\n"))
 		} else if inOld && inNew && oldHash != newHash {
 			filesChanged++
-			oldContent, _ := storage.ReadObject(oldHash)
-			newContent, _ := storage.ReadObject(newHash)
+			oldContent, _ := storage.ReadBlob(oldHash)
+			newContent, _ := storage.ReadBlob(newHash)
 			d := diff.NewMyersDiff(strings.Split(string(oldContent), "\n"), strings.Split(string(newContent), "\n"))
 			for _, chk := range d.Diffs() {
 				if chk.Operation == diff.INSERT {
@@ -0,0 +1,110 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// commitInstructions follow the message in the file the editor opens
+const commitInstructions = `
+# Please enter the commit message for your changes. Lines starting
+# with '#' will be ignored, and an empty message aborts the commit.
+#
+`
+
+// errEmptyMessage is returned when the message left after cleanup is empty
+var errEmptyMessage = errors.New("aborting commit due to empty commit message")
+
+// cleanupMessage tidies a commit message the way git does: trailing whitespace is removed
+// from every line, runs of blank lines become one, and leading and trailing blank lines
+// are dropped. With stripComments, lines starting with '#' are removed first.
+func cleanupMessage(message string, stripComments bool) string {
+	var lines []string
+	blank := false
+	for _, line := range strings.Split(message, "\n") {
+		if stripComments && strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			blank = len(lines) > 0
+			continue
+		}
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// commitTemplate returns the contents of the file commit.template names, or "" when it is
+// not set
+func commitTemplate() (string, error) {
+	file, _, err := GetConfig("commit.template")
+	if err != nil || file == "" {
+		return "", err
+	}
+	data, err := os.ReadFile(expandHome(file))
+	if err != nil {
+		return "", fmt.Errorf("could not read the commit template: %w", err)
+	}
+	return string(data), nil
+}
+
+// editCommitMessage opens the editor on the commit message and returns what the user
+// wrote, with comments removed. The file starts with message, or with the commit.template
+// when message is empty, followed by the status of the commit as comments. It fails if
+// the result is empty or an unchanged template.
+func editCommitMessage(message string) (string, error) {
+	template := ""
+	if message == "" {
+		var err error
+		if template, err = commitTemplate(); err != nil {
+			return "", err
+		}
+		message = template
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(message)
+	if message != "" && !strings.HasSuffix(message, "\n") {
+		buf.WriteString("\n")
+	}
+	buf.WriteString(commitInstructions)
+	var status bytes.Buffer
+	if err := writeStatus(&status); err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(strings.TrimRight(status.String(), "\n"), "\n") {
+		switch {
+		case line == "", strings.HasPrefix(line, "\t"):
+			buf.WriteString("#" + line + "\n")
+		default:
+			buf.WriteString("# " + line + "\n")
+		}
+	}
+	if err := os.WriteFile(CommitEditMsgPath, buf.Bytes(), 0o644); err != nil {
+		return "", err
+	}
+
+	if err := runEditor(CommitEditMsgPath); err != nil {
+		return "", err
+	}
+	edited, err := os.ReadFile(CommitEditMsgPath)
+	if err != nil {
+		return "", err
+	}
+	result := cleanupMessage(string(edited), true)
+	if result == "" {
+		return "", errEmptyMessage
+	}
+	if template != "" && result == cleanupMessage(template, true) {
+		return "", errors.New("aborting commit; you did not edit the message")
+	}
+	return result, nil
+}
@@ -154,3 +154,40 @@ func PrintAllConfig() error {
 	}
 	return nil
 }
+
+// UnsetConfig removes a key from the config file (local or global)
+// Removing a key that is not set is not an error
+func UnsetConfig(key string, global bool) error {
+	var path string
+	var err error
+	if global {
+		path, err = getConfigPath()
+	} else {
+		path, err = getLocalConfigPath()
+	}
+	if err != nil {
+		return err
+	}
+
+	config, err := readConfigFromPath(path)
+	if err != nil {
+		return fmt.Errorf("could not read existing config: %w", err)
+	}
+	if _, ok := config[key]; !ok {
+		return nil
+	}
+	delete(config, key)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	for k, v := range config {
+		if _, err := fmt.Fprintf(file, "%s = %s\n", k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -15,8 +15,16 @@ const (
 	IndexPath = ".kitcat/index"
 	// HeadPath is the full path to the HEAD file.
 	HeadPath = ".kitcat/HEAD"
-	// CommitsPath is the full path to the commit log file.
+	// CommitsPath is the full path to the legacy commit log file, replaced by commit objects.
 	CommitsPath = ".kitcat/commits.log"
 	// StashPath is the full path to the stash reference file.
 	StashPath = ".kitcat/refs/stash"
+	// MergeHeadPath records the commit being merged while a conflicted merge is resolved.
+	MergeHeadPath = ".kitcat/MERGE_HEAD"
+	// MergeMsgPath holds the message prepared for the commit that concludes a merge.
+	MergeMsgPath = ".kitcat/MERGE_MSG"
+	// HooksDir holds the hooks, programs run at points such as before a commit.
+	HooksDir = ".kitcat/hooks"
+	// CommitEditMsgPath is the file a commit message is edited in.
+	CommitEditMsgPath = ".kitcat/COMMIT_EDITMSG"
 )
@@ -65,8 +65,8 @@ func displayDiff(diffs []diff.Diff[string]) {
 // It identifies which files have been added, deleted, or modified.
 func Diff(staged, stat bool) error {
 	stats := make(map[string]FileStat)
-	// Retrieve the metadata for the most recent commit.
-	lastCommit, err := storage.GetLastCommit()
+	// Retrieve the metadata for the commit HEAD points to.
+	lastCommit, err := GetHeadCommit()
 	if err != nil {
 		// If there are no commits yet, there's nothing to compare against.
 		if err == storage.ErrNoCommits {
@@ -101,7 +101,7 @@ func Diff(staged, stat bool) error {
 				}
 
 				// Show content of added file (all lines are additions)
-				content, err := storage.ReadObject(indexHash)
+				content, err := storage.ReadBlob(indexHash)
 				if err != nil {
 					return err
 				}
@@ -133,11 +133,11 @@ func Diff(staged, stat bool) error {
 				}
 
 				// Read the old and new content from the object store.
-				oldContent, err := storage.ReadObject(treeHash)
+				oldContent, err := storage.ReadBlob(treeHash)
 				if err != nil {
 					return err
 				}
-				newContent, err := storage.ReadObject(indexHash)
+				newContent, err := storage.ReadBlob(indexHash)
 				if err != nil {
 					return err
 				}
@@ -181,7 +181,7 @@ func Diff(staged, stat bool) error {
 			// If a file was in the old tree but is no longer in the index, it has been deleted.
 			if _, ok := index[path]; !ok {
 				if stat {
-					content, err := storage.ReadObject(treeHash)
+					content, err := storage.ReadBlob(treeHash)
 					if err != nil {
 						return err
 					}
@@ -202,7 +202,7 @@ func Diff(staged, stat bool) error {
 			if err != nil {
 				// File deleted from working directory (but still staged)
 				if stat {
-					indexContent, err := storage.ReadObject(indexHash)
+					indexContent, err := storage.ReadBlob(indexHash)
 					if err == nil {
 						lines := strings.Split(strings.TrimRight(string(indexContent), "\n"), "\n")
 						stats[path] = FileStat{Deletions: len(lines)}
@@ -214,7 +214,7 @@ func Diff(staged, stat bool) error {
 			}
 
 			// Read staged content from index
-			indexContent, err := storage.ReadObject(indexHash)
+			indexContent, err := storage.ReadBlob(indexHash)
 			if err != nil {
 				return fmt.Errorf("failed to read index object %s: %w", indexHash, err)
 			}
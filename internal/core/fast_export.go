@@ -0,0 +1,247 @@
+package core
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// FastExport writes the history selected by revs to w in git's fast-import format: a
+// blob command for each file version, a commit command listing the changes against the
+// first parent for each commit, a tag command for each annotated tag, and a reset for
+// refs that end elsewhere. Revisions are selected as for bundles; with all, or without
+// revisions, every branch and tag is exported. Parents outside the selection are
+// referred to by their hash.
+func FastExport(w io.Writer, revs []string, all bool) error {
+	refs, exclude, err := selectHistory(revs, all || len(revs) == 0)
+	if err != nil {
+		return err
+	}
+	// HEAD is exported as the branch it is on
+	for i := range refs {
+		if refs[i].Name == headRef {
+			branch, err := readHEAD()
+			if err != nil {
+				return errors.New("HEAD is detached; name the branch to export instead")
+			}
+			refs[i].Name = branch
+		}
+	}
+	sort.SliceStable(refs, func(i, j int) bool { return refs[i].Name < refs[j].Name })
+
+	x := fastExporter{
+		out:     bufio.NewWriter(w),
+		exclude: exclude,
+		marks:   make(map[string]int),
+	}
+	exported := make(map[string]bool)
+	for _, ref := range refs {
+		if exported[ref.Name] || exclude[ref.Hash] {
+			continue
+		}
+		exported[ref.Name] = true
+		tip, err := storage.PeelTag(ref.Hash)
+		if err != nil {
+			return err
+		}
+		if exclude[tip] {
+			continue
+		}
+		last, err := x.exportHistory(ref.Name, tip)
+		if err != nil {
+			return err
+		}
+		if name, ok := strings.CutPrefix(ref.Name, "refs/tags/"); ok && tip != ref.Hash {
+			if err := x.exportTag(name, ref.Hash, tip); err != nil {
+				return err
+			}
+		} else if last != tip {
+			fmt.Fprintf(x.out, "reset %s\nfrom %s\n\n", ref.Name, x.commitRef(tip))
+		}
+	}
+	return x.out.Flush()
+}
+
+// exportTag writes the annotated tag hash as a tag command for the commit it leads to
+func (x *fastExporter) exportTag(name, hash, commit string) error {
+	tag, err := storage.ReadTag(hash)
+	if err != nil {
+		return err
+	}
+	message := tag.Message
+	if message != "" {
+		message += "\n"
+	}
+	fmt.Fprintf(x.out, "tag %s\nfrom %s\ntagger %s\ndata %d\n%s\n",
+		name, x.commitRef(commit), formatGitIdent(tag.TaggerName, tag.TaggerEmail, tag.Time), len(message), message)
+	return nil
+}
+
+// fastExporter writes a fast-import stream, numbering each blob and commit it writes
+// with a mark that later commands refer to
+type fastExporter struct {
+	out      *bufio.Writer
+	exclude  map[string]bool
+	marks    map[string]int // kitcat object -> mark
+	lastMark int
+}
+
+// mark assigns the next mark to hash
+func (x *fastExporter) mark(hash string) int {
+	x.lastMark++
+	x.marks[hash] = x.lastMark
+	return x.lastMark
+}
+
+// commitRef names a commit in the stream: by its mark when it was exported, by its hash
+// when it is outside the selection
+func (x *fastExporter) commitRef(hash string) string {
+	if mark, ok := x.marks[hash]; ok {
+		return fmt.Sprintf(":%d", mark)
+	}
+	return hash
+}
+
+// exportHistory writes the commits reachable from tip that are neither excluded nor
+// already written, parents first, as commits on ref. It returns the last commit written.
+func (x *fastExporter) exportHistory(ref, tip string) (string, error) {
+	var pending []models.Commit
+	seen := make(map[string]bool)
+	queue := []string{tip}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if seen[hash] || x.exclude[hash] {
+			continue
+		}
+		if _, done := x.marks[hash]; done {
+			continue
+		}
+		seen[hash] = true
+		c, err := storage.FindCommit(hash)
+		if err != nil {
+			return "", err
+		}
+		pending = append(pending, c)
+		queue = append(queue, c.Parents...)
+	}
+
+	last := ""
+	for _, c := range topoOrder(pending) {
+		if err := x.exportCommit(ref, c); err != nil {
+			return "", err
+		}
+		last = c.ID
+	}
+	return last, nil
+}
+
+// exportCommit writes the blobs c introduces and then c itself
+func (x *fastExporter) exportCommit(ref string, c models.Commit) error {
+	files, err := storage.FlattenTree(c.TreeHash)
+	if err != nil {
+		return fmt.Errorf("commit %s: %w", c.ID, err)
+	}
+	parentFiles := map[string]storage.TreeEntry{}
+	if parent := c.FirstParent(); parent != "" {
+		p, err := storage.FindCommit(parent)
+		if err != nil {
+			return err
+		}
+		if parentFiles, err = storage.FlattenTree(p.TreeHash); err != nil {
+			return fmt.Errorf("commit %s: %w", parent, err)
+		}
+	}
+
+	var changed, deleted []string
+	for path, entry := range files {
+		if old, ok := parentFiles[path]; !ok || old != entry {
+			changed = append(changed, path)
+		}
+	}
+	for path := range parentFiles {
+		if _, ok := files[path]; !ok {
+			deleted = append(deleted, path)
+		}
+	}
+	sort.Strings(changed)
+	sort.Strings(deleted)
+
+	for _, path := range changed {
+		hash := files[path].Hash
+		if _, done := x.marks[hash]; done {
+			continue
+		}
+		data, err := storage.ReadBlob(hash)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(x.out, "blob\nmark :%d\ndata %d\n", x.mark(hash), len(data))
+		x.out.Write(data)
+		x.out.WriteString("\n")
+	}
+
+	if len(c.Parents) == 0 {
+		fmt.Fprintf(x.out, "reset %s\n", ref)
+	}
+	message := c.Message
+	if !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+	committer, committerEmail, commitTime := c.Committer()
+	fmt.Fprintf(x.out, "commit %s\nmark :%d\nauthor %s\ncommitter %s\ndata %d\n%s",
+		ref, x.mark(c.ID), formatGitIdent(c.AuthorName, c.AuthorEmail, c.Timestamp),
+		formatGitIdent(committer, committerEmail, commitTime), len(message), message)
+	for i, parent := range c.Parents {
+		command := "merge"
+		if i == 0 {
+			command = "from"
+		}
+		fmt.Fprintf(x.out, "%s %s\n", command, x.commitRef(parent))
+	}
+	for _, path := range deleted {
+		fmt.Fprintf(x.out, "D %s\n", quoteStreamPath(filepath.ToSlash(path)))
+	}
+	for _, path := range changed {
+		entry := files[path]
+		fmt.Fprintf(x.out, "M %s :%d %s\n", entry.Mode, x.marks[entry.Hash], quoteStreamPath(filepath.ToSlash(path)))
+	}
+	x.out.WriteString("\n")
+	return nil
+}
+
+// quoteStreamPath quotes a path the way fast-import expects when it starts with a quote
+// or holds a quote, backslash or control character
+func quoteStreamPath(path string) string {
+	if !strings.HasPrefix(path, `"`) && !strings.ContainsFunc(path, func(r rune) bool {
+		return r < 0x20 || r == 0x7f || r == '"' || r == '\\'
+	}) {
+		return path
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, `\%03o`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
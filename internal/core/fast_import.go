@@ -0,0 +1,604 @@
+package core
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// FastImport reads a stream in git's fast-import format from r and stores its blobs and
+// commits in this repository. Branches and tags the stream sets are then created or
+// fast-forwarded, or overwritten with force. The blob, commit, reset, tag, progress,
+// checkpoint, feature and done commands are understood; file changes may be M, D, C, R
+// and deleteall. Timestamps have whole seconds, as the format records no more.
+func FastImport(r io.Reader, force bool) error {
+	im := fastImporter{
+		r:        bufio.NewReader(r),
+		marks:    make(map[string]string),
+		branches: make(map[string]*fastBranch),
+		refs:     make(map[string]string),
+	}
+	if err := im.run(); err != nil {
+		if im.lineNo > 0 {
+			return fmt.Errorf("fast-import, line %d: %w", im.lineNo, err)
+		}
+		return err
+	}
+	fmt.Printf("fast-import: %d commit%s, %d blob%s\n", im.commits, pluralize(im.commits), im.blobs, pluralize(im.blobs))
+	return applyImportedRefs(im.refs, "", "fast-import", force)
+}
+
+// fastBranch is the state of a branch while a stream is read: its last commit and files
+type fastBranch struct {
+	tip   string
+	files map[string]storage.TreeEntry
+}
+
+// fastImporter reads a fast-import stream one command at a time
+type fastImporter struct {
+	r        *bufio.Reader
+	lineNo   int
+	unread   *string
+	marks    map[string]string // ":<n>" -> kitcat object
+	branches map[string]*fastBranch
+	refs     map[string]string // refs to update at the end
+	commits  int
+	blobs    int
+}
+
+// readLine returns the next line without its newline, or io.EOF at the end of the stream
+func (im *fastImporter) readLine() (string, error) {
+	if im.unread != nil {
+		line := *im.unread
+		im.unread = nil
+		return line, nil
+	}
+	line, err := im.r.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return "", io.EOF
+	}
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	im.lineNo++
+	return strings.TrimSuffix(line, "\n"), nil
+}
+
+// unreadLine makes the next readLine return line again
+func (im *fastImporter) unreadLine(line string) {
+	im.unread = &line
+}
+
+// optional reads the next line if it starts with prefix and returns the rest of it;
+// otherwise the line is left for the next read
+func (im *fastImporter) optional(prefix string) (string, bool, error) {
+	line, err := im.readLine()
+	if err == io.EOF {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	if value, ok := strings.CutPrefix(line, prefix); ok {
+		return value, true, nil
+	}
+	im.unreadLine(line)
+	return "", false, nil
+}
+
+func (im *fastImporter) run() error {
+	for {
+		line, err := im.readLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || line == "checkpoint":
+		case line == "done":
+			return nil
+		case line == "blob":
+			err = im.blob()
+		case strings.HasPrefix(line, "commit "):
+			err = im.commit(strings.TrimPrefix(line, "commit "))
+		case strings.HasPrefix(line, "reset "):
+			err = im.reset(strings.TrimPrefix(line, "reset "))
+		case strings.HasPrefix(line, "tag "):
+			err = im.tag(strings.TrimPrefix(line, "tag "))
+		case strings.HasPrefix(line, "progress "):
+			fmt.Println(strings.TrimPrefix(line, "progress "))
+		case strings.HasPrefix(line, "feature "):
+			switch feature := strings.TrimPrefix(line, "feature "); feature {
+			case "done", "date-format=raw", "date-format=raw-permissive":
+			default:
+				err = fmt.Errorf("unsupported feature '%s'", feature)
+			}
+		case strings.HasPrefix(line, "option "):
+			// Options tune a particular importer; none of git's apply here
+		default:
+			err = fmt.Errorf("unsupported command '%s'", line)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// data reads a "data <count>" or "data <<<delimiter>" block
+func (im *fastImporter) data() ([]byte, error) {
+	line, err := im.readLine()
+	if err != nil {
+		return nil, fmt.Errorf("expected data: %w", err)
+	}
+	spec, ok := strings.CutPrefix(line, "data ")
+	if !ok {
+		return nil, fmt.Errorf("expected data, got '%s'", line)
+	}
+	if delim, ok := strings.CutPrefix(spec, "<<"); ok {
+		var b strings.Builder
+		for {
+			line, err := im.readLine()
+			if err != nil {
+				return nil, fmt.Errorf("data ended before %s", delim)
+			}
+			if line == delim {
+				return []byte(b.String()), nil
+			}
+			b.WriteString(line + "\n")
+		}
+	}
+	n, err := strconv.Atoi(spec)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("bad data length '%s'", spec)
+	}
+	buf := make([]byte, 0, min(n, 1<<20))
+	for len(buf) < n {
+		chunk := make([]byte, min(n-len(buf), 1<<20))
+		read, err := io.ReadFull(im.r, chunk)
+		buf = append(buf, chunk[:read]...)
+		if err != nil {
+			return nil, fmt.Errorf("data ended after %d of %d bytes", len(buf), n)
+		}
+	}
+	im.lineNo += strings.Count(string(buf), "\n")
+	// A newline may follow the data
+	if next, err := im.r.Peek(1); err == nil && next[0] == '\n' {
+		im.r.ReadByte()
+		im.lineNo++
+	}
+	return buf, nil
+}
+
+// markAndOID reads the optional mark and original-oid lines that start a blob or commit
+func (im *fastImporter) markAndOID() (string, error) {
+	mark, hasMark, err := im.optional("mark ")
+	if err != nil {
+		return "", err
+	}
+	if hasMark && (!strings.HasPrefix(mark, ":") || len(mark) < 2) {
+		return "", fmt.Errorf("bad mark '%s'", mark)
+	}
+	if _, _, err := im.optional("original-oid "); err != nil {
+		return "", err
+	}
+	return mark, nil
+}
+
+func (im *fastImporter) blob() error {
+	mark, err := im.markAndOID()
+	if err != nil {
+		return err
+	}
+	data, err := im.data()
+	if err != nil {
+		return err
+	}
+	hash, err := storage.WriteBlob(data)
+	if err != nil {
+		return err
+	}
+	if mark != "" {
+		im.marks[mark] = hash
+	}
+	im.blobs++
+	return nil
+}
+
+// commitish resolves what from, merge and tag commands point at: a mark, a branch of
+// the stream, or any revision of this repository. A tag is followed to its commit.
+func (im *fastImporter) commitish(ref string) (string, error) {
+	hash := ""
+	if strings.HasPrefix(ref, ":") {
+		var ok bool
+		if hash, ok = im.marks[ref]; !ok {
+			return "", fmt.Errorf("unknown mark %s", ref)
+		}
+	} else if b, ok := im.branches[ref]; ok {
+		hash = b.tip
+	} else {
+		var err error
+		if hash, err = ResolveRevision(strings.TrimSuffix(ref, "^0")); err != nil {
+			return "", err
+		}
+	}
+	c, err := storage.FindCommit(hash)
+	if err != nil {
+		return "", fmt.Errorf("%s is not a commit", ref)
+	}
+	return c.ID, nil
+}
+
+// branchAt returns the state of a branch at the commit hash
+func branchAt(hash string) (*fastBranch, error) {
+	c, err := storage.FindCommit(hash)
+	if err != nil {
+		return nil, err
+	}
+	files, err := storage.FlattenTree(c.TreeHash)
+	if err != nil {
+		return nil, err
+	}
+	return &fastBranch{tip: c.ID, files: files}, nil
+}
+
+func (im *fastImporter) commit(ref string) error {
+	mark, err := im.markAndOID()
+	if err != nil {
+		return err
+	}
+	var author, committer identity
+	for _, header := range []struct {
+		prefix string
+		who    *identity
+	}{{"author ", &author}, {"committer ", &committer}} {
+		value, ok, err := im.optional(header.prefix)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		who := header.who
+		if who.Name, who.Email, who.When, err = parseGitIdent(value); err != nil {
+			return err
+		}
+	}
+	// Either one stands in for the other when it is missing
+	switch {
+	case author.When.IsZero() && committer.When.IsZero():
+		return errors.New("commit without author or committer")
+	case author.When.IsZero():
+		author = committer
+	case committer.When.IsZero():
+		committer = author
+	}
+	if _, _, err := im.optional("encoding "); err != nil {
+		return err
+	}
+	message, err := im.data()
+	if err != nil {
+		return err
+	}
+
+	// The commit builds on from, or else on the branch's previous commit in the stream, or
+	// on the branch as this repository has it
+	state := &fastBranch{files: make(map[string]storage.TreeEntry)}
+	if b, ok := im.branches[ref]; ok {
+		state = &fastBranch{tip: b.tip, files: maps.Clone(b.files)}
+	} else if hash, ok := lookupRef(ref); ok {
+		if state, err = branchAt(hash); err != nil {
+			return err
+		}
+	}
+	var parents []string
+	if from, ok, err := im.optional("from "); err != nil {
+		return err
+	} else if ok && strings.Trim(from, "0") == "" {
+		// The null commit starts the branch afresh
+		state = &fastBranch{files: make(map[string]storage.TreeEntry)}
+	} else if ok {
+		if hash, err := im.commitish(from); err != nil {
+			return err
+		} else if state, err = branchAt(hash); err != nil {
+			return err
+		}
+	}
+	if state.tip != "" {
+		parents = append(parents, state.tip)
+	}
+	for {
+		merge, ok, err := im.optional("merge ")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		hash, err := im.commitish(merge)
+		if err != nil {
+			return err
+		}
+		parents = append(parents, hash)
+	}
+	if err := im.fileChanges(state.files); err != nil {
+		return err
+	}
+
+	tree, err := storage.WriteTree(state.files)
+	if err != nil {
+		return err
+	}
+	commit := models.Commit{
+		Parents:        parents,
+		Message:        strings.TrimSuffix(string(message), "\n"),
+		Timestamp:      author.When,
+		TreeHash:       tree,
+		AuthorName:     author.Name,
+		AuthorEmail:    author.Email,
+		CommitterName:  committer.Name,
+		CommitterEmail: committer.Email,
+		CommitTime:     committer.When,
+	}
+	commit.ID = commit.Hash()
+	if err := storage.AppendCommit(commit); err != nil {
+		return err
+	}
+	state.tip = commit.ID
+	im.branches[ref] = state
+	im.refs[ref] = commit.ID
+	if mark != "" {
+		im.marks[mark] = commit.ID
+	}
+	im.commits++
+	return nil
+}
+
+// fileChanges applies the M, D, C, R and deleteall lines that end a commit command to files
+func (im *fastImporter) fileChanges(files map[string]storage.TreeEntry) error {
+	for {
+		line, err := im.readLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		op, rest, _ := strings.Cut(line, " ")
+		switch op {
+		case "M":
+			mode, rest, _ := strings.Cut(rest, " ")
+			ref, rest, _ := strings.Cut(rest, " ")
+			path, _, err := streamPath(rest, true)
+			if err != nil {
+				return err
+			}
+			if mode, err = streamMode(mode); err != nil {
+				return err
+			}
+			var hash string
+			switch {
+			case ref == "inline":
+				data, err := im.data()
+				if err != nil {
+					return err
+				}
+				if hash, err = storage.WriteBlob(data); err != nil {
+					return err
+				}
+			case strings.HasPrefix(ref, ":"):
+				var ok bool
+				if hash, ok = im.marks[ref]; !ok {
+					return fmt.Errorf("unknown mark %s", ref)
+				}
+			default:
+				if t, err := storage.ReadObjectType(ref); err != nil || t != storage.BlobObject {
+					return fmt.Errorf("%s is not a blob", ref)
+				}
+				hash = ref
+			}
+			files[path] = storage.TreeEntry{Mode: mode, Hash: hash}
+		case "D":
+			path, _, err := streamPath(rest, true)
+			if err != nil {
+				return err
+			}
+			for p := range files {
+				if p == path || strings.HasPrefix(p, path+string(filepath.Separator)) {
+					delete(files, p)
+				}
+			}
+		case "C", "R":
+			src, rest, err := streamPath(rest, false)
+			if err != nil {
+				return err
+			}
+			dst, _, err := streamPath(rest, true)
+			if err != nil {
+				return err
+			}
+			moved := 0
+			for p, entry := range maps.Clone(files) {
+				if p != src && !strings.HasPrefix(p, src+string(filepath.Separator)) {
+					continue
+				}
+				if op == "R" {
+					delete(files, p)
+				}
+				files[dst+strings.TrimPrefix(p, src)] = entry
+				moved++
+			}
+			if moved == 0 {
+				return fmt.Errorf("path %s not in branch", src)
+			}
+		case "deleteall":
+			clear(files)
+		case "N":
+			return errors.New("notes are not supported")
+		default:
+			// The commit ends at the first line that is not a file change
+			im.unreadLine(line)
+			return nil
+		}
+	}
+}
+
+// streamMode maps the file modes fast-import accepts to kitcat's
+func streamMode(mode string) (string, error) {
+	switch mode {
+	case "644", storage.ModeRegular:
+		return storage.ModeRegular, nil
+	case "755", storage.ModeExecutable:
+		return storage.ModeExecutable, nil
+	case storage.ModeSymlink:
+		return storage.ModeSymlink, nil
+	case storage.ModeTree, storage.GitModeTree:
+		return "", errors.New("tree entries cannot be imported; list their files instead")
+	case storage.GitModeGitlink:
+		return "", errors.New("submodules are not supported")
+	}
+	return "", fmt.Errorf("unknown file mode '%s'", mode)
+}
+
+// streamPath reads a path from a file change line, unquoting it if it is quoted, and
+// returns it in the form used for working tree paths with the rest of the line. An
+// unquoted path runs to the end of the line when last is set, and to a space otherwise.
+func streamPath(s string, last bool) (string, string, error) {
+	var path, rest string
+	if strings.HasPrefix(s, `"`) {
+		var b strings.Builder
+		i := 1
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] != '\\' {
+				b.WriteByte(s[i])
+				continue
+			}
+			if i++; i >= len(s) {
+				break
+			}
+			switch c := s[i]; c {
+			case 'a':
+				b.WriteByte('\a')
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'v':
+				b.WriteByte('\v')
+			default:
+				if c >= '0' && c <= '7' && i+2 < len(s) {
+					n, err := strconv.ParseUint(s[i:i+3], 8, 8)
+					if err != nil {
+						return "", "", fmt.Errorf("bad escape in path %s", s)
+					}
+					b.WriteByte(byte(n))
+					i += 2
+				} else {
+					b.WriteByte(c)
+				}
+			}
+		}
+		if i >= len(s) {
+			return "", "", fmt.Errorf("unterminated path %s", s)
+		}
+		path, rest = b.String(), strings.TrimPrefix(s[i+1:], " ")
+	} else if last {
+		path = s
+	} else {
+		path, rest, _ = strings.Cut(s, " ")
+	}
+
+	path = filepath.FromSlash(path)
+	if path == "" || !IsSafePath(path) || filepath.Clean(path) != path {
+		return "", "", fmt.Errorf("invalid path '%s'", path)
+	}
+	for _, part := range strings.Split(filepath.ToSlash(path), "/") {
+		if part == RepoDir {
+			return "", "", fmt.Errorf("invalid path '%s'", path)
+		}
+	}
+	return path, rest, nil
+}
+
+func (im *fastImporter) reset(ref string) error {
+	from, ok, err := im.optional("from ")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		delete(im.branches, ref)
+		delete(im.refs, ref)
+		return nil
+	}
+	hash, err := im.commitish(from)
+	if err != nil {
+		return err
+	}
+	state, err := branchAt(hash)
+	if err != nil {
+		return err
+	}
+	im.branches[ref] = state
+	im.refs[ref] = hash
+	return nil
+}
+
+// tag stores an annotated tag of the stream as a tag object for its commit. A tag without
+// a tagger, as very old git tags are, becomes a lightweight tag.
+func (im *fastImporter) tag(name string) error {
+	mark, hasMark, err := im.optional("mark ")
+	if err != nil {
+		return err
+	}
+	from, ok, err := im.optional("from ")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("tag %s has no from", name)
+	}
+	hash, err := im.commitish(from)
+	if err != nil {
+		return err
+	}
+	if _, _, err := im.optional("original-oid "); err != nil {
+		return err
+	}
+	tagger, hasTagger, err := im.optional("tagger ")
+	if err != nil {
+		return err
+	}
+	message, err := im.data()
+	if err != nil {
+		return err
+	}
+	if hasTagger {
+		tag := storage.Tag{Object: hash, Type: storage.CommitObject, Name: name, Message: strings.TrimSuffix(string(message), "\n")}
+		if tag.TaggerName, tag.TaggerEmail, tag.Time, err = parseGitIdent(tagger); err != nil {
+			return err
+		}
+		if hash, err = storage.WriteTag(tag); err != nil {
+			return err
+		}
+	}
+	if hasMark {
+		im.marks[mark] = hash
+	}
+	im.refs["refs/tags/"+name] = hash
+	return nil
+}
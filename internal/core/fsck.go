@@ -0,0 +1,417 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// Kinds of problem Fsck reports. Dangling and unreachable objects are only reported;
+// every other kind means the repository is damaged.
+const (
+	FsckCorrupt     = "corrupt"
+	FsckMissing     = "missing"
+	FsckBrokenLink  = "broken-link"
+	FsckBadRef      = "bad-ref"
+	FsckBadReflog   = "bad-reflog"
+	FsckBadStash    = "bad-stash"
+	FsckBadIndex    = "bad-index"
+	FsckDangling    = "dangling"
+	FsckUnreachable = "unreachable"
+)
+
+// FsckProblem is one finding of Fsck
+type FsckProblem struct {
+	Kind   string
+	Name   string // the object ("commit <hash>"), ref or entry concerned
+	Detail string
+}
+
+func (p FsckProblem) String() string {
+	if p.Detail == "" {
+		return fmt.Sprintf("%s %s", p.Kind, p.Name)
+	}
+	return fmt.Sprintf("%s %s: %s", p.Kind, p.Name, p.Detail)
+}
+
+// IsError reports whether the problem means the repository is damaged
+func (p FsckProblem) IsError() bool {
+	return p.Kind != FsckDangling && p.Kind != FsckUnreachable
+}
+
+// FsckReport is what Fsck found
+type FsckReport struct {
+	Objects  int
+	Refs     int
+	Problems []FsckProblem
+}
+
+// Errors returns the number of problems that mean the repository is damaged
+func (r FsckReport) Errors() int {
+	n := 0
+	for _, p := range r.Problems {
+		if p.IsError() {
+			n++
+		}
+	}
+	return n
+}
+
+// Fsck checks the integrity of the repository. Every object is rehashed and parsed, and
+// every commit's tree and parents and every tree's entries must exist with the right
+// type. HEAD, refs, reflogs, stash entries, the state of an interrupted merge, rebase,
+// cherry-pick or revert, and the index must name objects that exist. Objects that none
+// of them reach are reported as dangling when nothing else refers to them, or all of
+// them with showUnreachable. The report is printed, one problem per line, and an error is
+// returned when the repository is damaged.
+func Fsck(showUnreachable bool) (FsckReport, error) {
+	if storage.HasCommitLog() {
+		return FsckReport{}, errors.New("this repository still uses commits.log; run 'kitcat migrate' first")
+	}
+	report, _, err := checkRepository(showUnreachable)
+	if err != nil {
+		return report, err
+	}
+	for _, p := range report.Problems {
+		fmt.Println(p)
+	}
+	errs := report.Errors()
+	fmt.Printf("Checked %d object%s and %d ref%s: %d error%s\n",
+		report.Objects, pluralize(report.Objects), report.Refs, pluralize(report.Refs), errs, pluralize(errs))
+	if errs > 0 {
+		return report, fmt.Errorf("the repository is damaged: %d error%s found", errs, pluralize(errs))
+	}
+	return report, nil
+}
+
+// checkRepository does the work of Fsck and also returns the objects that nothing in the
+// repository reaches
+func checkRepository(showUnreachable bool) (FsckReport, map[string]bool, error) {
+	var report FsckReport
+	graph, err := loadObjectGraph()
+	if err != nil {
+		return report, nil, err
+	}
+	report.Objects = len(graph.types) + len(graph.corrupt)
+	for _, hash := range sortedKeys(graph.corrupt) {
+		// Errors from the object store already name the object
+		detail := strings.TrimPrefix(graph.corrupt[hash].Error(), fmt.Sprintf("%s %s: ", storage.ErrCorruptObject, hash))
+		report.Problems = append(report.Problems, FsckProblem{Kind: FsckCorrupt, Name: "object " + hash, Detail: detail})
+	}
+
+	// Every link must lead to an object of the expected type; a missing object is
+	// reported once, for the first object found referring to it
+	missing := make(map[string]bool)
+	for _, hash := range sortedKeys(graph.types) {
+		for _, link := range graph.links[hash] {
+			t, ok := graph.types[link.Hash]
+			switch {
+			case ok && t != link.Type:
+				report.Problems = append(report.Problems, FsckProblem{
+					Kind:   FsckBrokenLink,
+					Name:   fmt.Sprintf("%s %s", graph.types[hash], hash),
+					Detail: fmt.Sprintf("refers to %s as a %s, but it is a %s", link.Hash, link.Type, t),
+				})
+			case !ok && graph.corrupt[link.Hash] == nil && !missing[link.Hash]:
+				missing[link.Hash] = true
+				report.Problems = append(report.Problems, FsckProblem{
+					Kind:   FsckMissing,
+					Name:   fmt.Sprintf("%s %s", link.Type, link.Hash),
+					Detail: fmt.Sprintf("referred to by %s %s", graph.types[hash], hash),
+				})
+			}
+		}
+	}
+
+	roots, problems, refs, err := repositoryRoots()
+	if err != nil {
+		return report, nil, err
+	}
+	report.Refs = refs
+	report.Problems = append(report.Problems, problems...)
+	var tips []string
+	for _, root := range roots {
+		t, ok := graph.types[root.hash]
+		switch {
+		case !ok && graph.corrupt[root.hash] != nil:
+			report.Problems = append(report.Problems, FsckProblem{Kind: root.kind, Name: root.name, Detail: fmt.Sprintf("points to the corrupt object %s", root.hash)})
+		case !ok:
+			report.Problems = append(report.Problems, FsckProblem{Kind: root.kind, Name: root.name, Detail: fmt.Sprintf("points to the missing %s %s", root.want, root.hash)})
+		case t != root.want:
+			report.Problems = append(report.Problems, FsckProblem{Kind: root.kind, Name: root.name, Detail: fmt.Sprintf("points to %s, which is a %s, not a %s", root.hash, t, root.want)})
+		default:
+			tips = append(tips, root.hash)
+		}
+	}
+
+	reachable := graph.reachable(tips)
+	unreachable := make(map[string]bool)
+	for hash := range graph.types {
+		if !reachable[hash] {
+			unreachable[hash] = true
+		}
+	}
+	// An unreachable object is dangling when no other unreachable object refers to it
+	referenced := make(map[string]bool)
+	for hash := range unreachable {
+		for _, link := range graph.links[hash] {
+			referenced[link.Hash] = true
+		}
+	}
+	for _, hash := range sortedKeys(unreachable) {
+		kind := FsckDangling
+		if showUnreachable {
+			kind = FsckUnreachable
+		} else if referenced[hash] {
+			continue
+		}
+		report.Problems = append(report.Problems, FsckProblem{Kind: kind, Name: fmt.Sprintf("%s %s", graph.types[hash], hash)})
+	}
+	return report, unreachable, nil
+}
+
+// objectGraph holds the type of every readable object and what it refers to
+type objectGraph struct {
+	types   map[string]storage.ObjectType
+	links   map[string][]storage.ObjectLink
+	corrupt map[string]error
+}
+
+// loadObjectGraph checks every object in the repository
+func loadObjectGraph() (objectGraph, error) {
+	names, err := storage.ListObjects()
+	if err != nil {
+		return objectGraph{}, err
+	}
+	graph := objectGraph{
+		types:   make(map[string]storage.ObjectType, len(names)),
+		links:   make(map[string][]storage.ObjectLink),
+		corrupt: make(map[string]error),
+	}
+	for _, name := range names {
+		t, links, err := storage.CheckObject(name)
+		if err != nil {
+			graph.corrupt[name] = err
+			continue
+		}
+		graph.types[name] = t
+		if len(links) > 0 {
+			graph.links[name] = links
+		}
+	}
+	return graph, nil
+}
+
+// reachable returns every object reachable from tips
+func (g objectGraph) reachable(tips []string) map[string]bool {
+	seen := make(map[string]bool)
+	stack := append([]string(nil), tips...)
+	for len(stack) > 0 {
+		hash := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+		for _, link := range g.links[hash] {
+			if !seen[link.Hash] {
+				stack = append(stack, link.Hash)
+			}
+		}
+	}
+	return seen
+}
+
+// repositoryRoot is an object the repository keeps alive: what a ref, reflog entry, stash
+// entry, operation in progress or index entry points to
+type repositoryRoot struct {
+	name string // e.g. "refs/heads/main", "HEAD@{2}" or "index entry README"
+	hash string
+	want storage.ObjectType
+	kind string // how Fsck reports the root when it is broken
+}
+
+// repositoryRoots returns every object the repository keeps alive, with problems in the
+// refs, reflogs and stash themselves, and the number of refs read
+func repositoryRoots() ([]repositoryRoot, []FsckProblem, int, error) {
+	var roots []repositoryRoot
+	var problems []FsckProblem
+	commit := func(name, hash, kind string) {
+		roots = append(roots, repositoryRoot{name: name, hash: hash, want: storage.CommitObject, kind: kind})
+	}
+
+	// HEAD is a branch, which may not have been born yet, or a commit
+	refs := 1
+	data, err := os.ReadFile(HeadPath)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	head := strings.TrimSpace(string(data))
+	if target, ok := strings.CutPrefix(head, "ref: "); ok {
+		if branch, ok := strings.CutPrefix(target, "refs/heads/"); !ok || !IsValidRefName(branch) {
+			problems = append(problems, FsckProblem{Kind: FsckBadRef, Name: headRef, Detail: fmt.Sprintf("points to %q, which is not a branch", target)})
+		}
+	} else if storage.IsFullHash(head) {
+		commit(headRef, head, FsckBadRef)
+	} else {
+		problems = append(problems, FsckProblem{Kind: FsckBadRef, Name: headRef, Detail: fmt.Sprintf("invalid content %q", head)})
+	}
+
+	err = walkRepoFiles(RefsDir, func(ref string) error {
+		refs++
+		if !validRefLocation(ref) {
+			problems = append(problems, FsckProblem{Kind: FsckBadRef, Name: ref, Detail: "not a valid branch, tag or remote-tracking branch name"})
+		}
+		hash, err := readCommitHash(ref)
+		if err != nil {
+			return err
+		}
+		if !storage.IsFullHash(hash) {
+			problems = append(problems, FsckProblem{Kind: FsckBadRef, Name: ref, Detail: fmt.Sprintf("invalid content %q", hash)})
+			return nil
+		}
+		// A tag may point at an annotated tag object, whose own target is checked as a link
+		if strings.HasPrefix(ref, "refs/tags/") {
+			if t, err := storage.ReadObjectType(hash); err == nil && t == storage.TagObject {
+				roots = append(roots, repositoryRoot{name: ref, hash: hash, want: storage.TagObject, kind: FsckBadRef})
+				return nil
+			}
+		}
+		commit(ref, hash, FsckBadRef)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	logsDir := filepath.Join(RepoDir, "logs")
+	err = walkRepoFiles(logsDir, func(ref string) error {
+		ref = strings.TrimPrefix(ref, "logs/")
+		entries, err := storage.ReadReflog(ref)
+		if err != nil {
+			problems = append(problems, FsckProblem{Kind: FsckBadReflog, Name: ref, Detail: err.Error()})
+			return nil
+		}
+		for i, entry := range entries {
+			name := fmt.Sprintf("%s@{%d}", ref, i)
+			for _, hash := range []string{entry.New, entry.Old} {
+				if hash == storage.ZeroHash {
+					continue
+				}
+				if !storage.IsFullHash(hash) {
+					problems = append(problems, FsckProblem{Kind: FsckBadReflog, Name: name, Detail: fmt.Sprintf("invalid commit %q", hash)})
+					continue
+				}
+				commit(name, hash, FsckBadReflog)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	stashes, err := storage.ListStashes()
+	if err != nil {
+		problems = append(problems, FsckProblem{Kind: FsckBadStash, Name: "stash", Detail: err.Error()})
+	}
+	for i, hash := range stashes {
+		commit(fmt.Sprintf("stash@{%d}", i), hash, FsckBadStash)
+	}
+
+	// Operations in progress remember where they started and what they apply
+	for _, file := range []string{
+		MergeHeadPath,
+		filepath.Join(RepoDir, "rebase-merge", "onto"),
+		filepath.Join(RepoDir, "rebase-merge", "orig-head"),
+		filepath.Join(sequencerDir, "orig-head"),
+	} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		if hash := strings.TrimSpace(string(data)); hash != "" {
+			commit(filepath.ToSlash(strings.TrimPrefix(file, RepoDir+string(filepath.Separator))), hash, FsckBadRef)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(sequencerDir, "todo")); err == nil {
+		for _, hash := range strings.Fields(string(data)) {
+			commit("sequencer/todo", hash, FsckBadRef)
+		}
+	}
+
+	index, err := storage.LoadIndexEntries()
+	if err != nil {
+		problems = append(problems, FsckProblem{Kind: FsckBadIndex, Name: "index", Detail: err.Error()})
+	}
+	conflicts, err := storage.LoadIndexConflicts()
+	if err != nil {
+		problems = append(problems, FsckProblem{Kind: FsckBadIndex, Name: "index", Detail: err.Error()})
+	}
+	for _, path := range sortedKeys(index) {
+		roots = append(roots, repositoryRoot{name: "index entry " + path, hash: index[path].Hash, want: storage.BlobObject, kind: FsckBadIndex})
+	}
+	for _, path := range sortedKeys(conflicts) {
+		c := conflicts[path]
+		for _, side := range []*storage.IndexEntry{c.Base, c.Ours, c.Theirs} {
+			if side != nil {
+				roots = append(roots, repositoryRoot{name: "conflicted index entry " + path, hash: side.Hash, want: storage.BlobObject, kind: FsckBadIndex})
+			}
+		}
+	}
+	return roots, problems, refs, nil
+}
+
+// walkRepoFiles calls visit with the path, relative to the repository directory and
+// with forward slashes, of every file under dir
+func walkRepoFiles(dir string, visit func(rel string) error) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		// Skip directories and temporary files left by an interrupted SafeWrite
+		if d.IsDir() || strings.HasPrefix(d.Name(), "atomic-") {
+			return nil
+		}
+		rel, err := filepath.Rel(RepoDir, path)
+		if err != nil {
+			return err
+		}
+		return visit(filepath.ToSlash(rel))
+	})
+}
+
+// validRefLocation reports whether ref is a branch, tag or remote-tracking branch with
+// a valid name
+func validRefLocation(ref string) bool {
+	if name, ok := strings.CutPrefix(ref, "refs/heads/"); ok {
+		return IsValidRefName(name)
+	}
+	if name, ok := strings.CutPrefix(ref, "refs/tags/"); ok {
+		return IsValidRefName(name)
+	}
+	if name, ok := strings.CutPrefix(ref, "refs/remotes/"); ok {
+		remote, branch, ok := strings.Cut(name, "/")
+		return ok && IsValidRefName(remote) && IsValidRefName(branch)
+	}
+	return false
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
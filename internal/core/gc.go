@@ -0,0 +1,203 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// DefaultPruneAge is how old an unreachable object must be before prune deletes it
+const DefaultPruneAge = 14 * 24 * time.Hour
+
+// GarbageCollect compacts the object store by packing every object into a single
+// delta-compressed pack file and removing the loose copies. With prune, objects that
+// nothing reaches are left out of the pack, and those older than pruneAge are deleted.
+func GarbageCollect(prune bool, pruneAge time.Duration) error {
+	if !IsRepoInitialized() {
+		return fmt.Errorf("not a kitcat repository (or any of the parent directories): .kitcat")
+	}
+	unlock, err := storage.LockRepository()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var unreachable map[string]bool
+	if prune {
+		if unreachable, err = unreachableObjects(); err != nil {
+			return err
+		}
+	}
+	stats, err := storage.PackObjectsExcept(func(name string) bool { return unreachable[name] })
+	if err != nil {
+		return fmt.Errorf("failed to pack objects: %w", err)
+	}
+	if stats.Objects == 0 && stats.PacksMerged == 0 {
+		fmt.Println("Nothing to pack, the object store is already compact")
+	} else {
+		printPackStats(stats)
+	}
+	if prune {
+		return removeExpiredObjects(unreachable, pruneAge)
+	}
+	return nil
+}
+
+// Prune deletes the objects that nothing in the repository reaches and that were written
+// more than age ago. Branches, tags, remote-tracking branches, HEAD, reflogs, stash
+// entries, the index and operations in progress all keep objects alive. Younger objects
+// are kept, since a command running at the same time may be about to refer to them.
+func Prune(age time.Duration) error {
+	if !IsRepoInitialized() {
+		return fmt.Errorf("not a kitcat repository (or any of the parent directories): .kitcat")
+	}
+	unlock, err := storage.LockRepository()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	unreachable, err := unreachableObjects()
+	if err != nil {
+		return err
+	}
+	// Packed objects can only be deleted by rewriting their pack; the unreachable ones
+	// are taken out of it first, keeping the age of the pack
+	for name := range unreachable {
+		if _, loose := storage.LooseObjectTime(name); !loose {
+			stats, err := storage.PackObjectsExcept(func(name string) bool { return unreachable[name] })
+			if err != nil {
+				return fmt.Errorf("failed to repack objects: %w", err)
+			}
+			printPackStats(stats)
+			break
+		}
+	}
+	return removeExpiredObjects(unreachable, age)
+}
+
+// unreachableObjects returns the objects nothing in the repository reaches. A damaged
+// repository is refused, since what its broken objects refer to cannot be known.
+func unreachableObjects() (map[string]bool, error) {
+	if storage.HasCommitLog() {
+		return nil, fmt.Errorf("this repository still uses commits.log; run 'kitcat migrate' first")
+	}
+	report, unreachable, err := checkRepository(false)
+	if err != nil {
+		return nil, err
+	}
+	if errs := report.Errors(); errs > 0 {
+		return nil, fmt.Errorf("refusing to prune: the repository has %d error%s; run 'kitcat fsck' for details", errs, pluralize(errs))
+	}
+	return unreachable, nil
+}
+
+// removeExpiredObjects deletes the unreachable loose objects written more than age ago
+func removeExpiredObjects(unreachable map[string]bool, age time.Duration) error {
+	cutoff := time.Now().Add(-age)
+	pruned, kept := 0, 0
+	for _, name := range sortedKeys(unreachable) {
+		written, ok := storage.LooseObjectTime(name)
+		if !ok {
+			continue
+		}
+		if written.After(cutoff) {
+			kept++
+			continue
+		}
+		if err := storage.RemoveLooseObject(name); err != nil {
+			return err
+		}
+		pruned++
+	}
+	fmt.Printf("Pruned %d unreachable object%s\n", pruned, pluralize(pruned))
+	if kept > 0 {
+		fmt.Printf("Kept %d unreachable object%s written in the last %s\n", kept, pluralize(kept), formatAge(age))
+	}
+	return nil
+}
+
+func printPackStats(stats storage.PackStats) {
+	if stats.Objects > 0 {
+		fmt.Printf("Packed %d object%s (%d stored as deltas) into %s\n",
+			stats.Objects, pluralize(stats.Objects), stats.Deltas, stats.PackName)
+	}
+	if stats.LooseRemoved > 0 {
+		fmt.Printf("Removed %d loose object%s\n", stats.LooseRemoved, pluralize(stats.LooseRemoved))
+	}
+	if stats.PacksMerged > 0 {
+		fmt.Printf("Merged %d existing pack%s\n", stats.PacksMerged, pluralize(stats.PacksMerged))
+	}
+	fmt.Printf("Object store size: %s -> %s\n", formatSize(stats.SizeBefore), formatSize(stats.SizeAfter))
+}
+
+// pruneUnits are the units an age can be given in, longest names first
+var pruneUnits = []struct {
+	names []string
+	unit  time.Duration
+}{
+	{[]string{"seconds", "second", "sec", "s"}, time.Second},
+	{[]string{"minutes", "minute", "min", "m"}, time.Minute},
+	{[]string{"hours", "hour", "h"}, time.Hour},
+	{[]string{"days", "day", "d"}, 24 * time.Hour},
+	{[]string{"weeks", "week", "w"}, 7 * 24 * time.Hour},
+	{[]string{"months", "month"}, 30 * 24 * time.Hour},
+	{[]string{"years", "year", "y"}, 365 * 24 * time.Hour},
+}
+
+// ParsePruneAge parses how old unreachable objects must be to be pruned: "now", a count
+// and a unit as git writes them ("2.weeks.ago", "3 days") or in short form ("12h", "30d")
+func ParsePruneAge(s string) (time.Duration, error) {
+	spec := strings.TrimSpace(strings.ToLower(s))
+	if spec == "now" {
+		return 0, nil
+	}
+	spec = strings.TrimSuffix(strings.TrimSuffix(spec, "ago"), ".")
+	spec = strings.TrimSpace(strings.ReplaceAll(spec, ".", " "))
+	digits := strings.IndexFunc(spec, func(r rune) bool { return r < '0' || r > '9' })
+	if digits > 0 {
+		count, _ := strconv.Atoi(spec[:digits])
+		unit := strings.TrimSpace(spec[digits:])
+		for _, u := range pruneUnits {
+			for _, name := range u.names {
+				if unit == name {
+					return time.Duration(count) * u.unit, nil
+				}
+			}
+		}
+	}
+	return 0, fmt.Errorf("invalid age '%s'; use e.g. now, 30.minutes.ago, 2.weeks.ago or 12h", s)
+}
+
+// formatAge writes an age in the largest unit that divides it
+func formatAge(age time.Duration) string {
+	for i := len(pruneUnits) - 1; i >= 0; i-- {
+		u := pruneUnits[i]
+		if age >= u.unit && age%u.unit == 0 {
+			n := int(age / u.unit)
+			name := u.names[1]
+			if n != 1 {
+				name = u.names[0]
+			}
+			return fmt.Sprintf("%d %s", n, name)
+		}
+	}
+	return age.String()
+}
+
+// formatSize renders a byte count in a human readable unit
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
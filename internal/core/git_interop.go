@@ -0,0 +1,789 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// Commits exported to git keep their kitcat content: the tree, the parents, the message,
+// the author and the committer. Git records time in whole seconds while kitcat commit IDs
+// cover the full timestamps, so a commit made at a fraction of a second carries them in
+// extra headers that git ignores. The signature of a signed commit is carried the same
+// way, as git carries its own, and so is the fact that an old commit records no committer.
+// Importing the exported history again yields the same commit IDs.
+const (
+	gitTimestampHeader       = "kitcat-timestamp"
+	gitCommitTimestampHeader = "kitcat-commit-timestamp"
+	gitNoCommitterHeader     = "kitcat-committer"
+	gitSignatureHeader       = "kitcat-signature"
+)
+
+// gitDirOf returns the git directory of the repository at dir: dir/.git, or dir itself
+// for a bare repository
+func gitDirOf(dir string) (string, bool) {
+	isGitDir := func(d string) bool {
+		info, err := os.Stat(filepath.Join(d, "objects"))
+		if err != nil || !info.IsDir() {
+			return false
+		}
+		_, err = os.Stat(filepath.Join(d, "HEAD"))
+		return err == nil
+	}
+	if isGitDir(filepath.Join(dir, ".git")) {
+		return filepath.Join(dir, ".git"), true
+	}
+	if isGitDir(dir) {
+		return dir, true
+	}
+	return "", false
+}
+
+// initGitDir creates an empty git repository at dir/.git with HEAD on branch
+func initGitDir(dir, branch string) (string, error) {
+	gitDir := filepath.Join(dir, ".git")
+	for _, sub := range []string{"objects", "refs/heads", "refs/tags"} {
+		if err := os.MkdirAll(filepath.Join(gitDir, sub), 0o755); err != nil {
+			return "", err
+		}
+	}
+	files := map[string]string{
+		"HEAD":   "ref: refs/heads/" + branch + "\n",
+		"config": "[core]\n\trepositoryformatversion = 0\n\tfilemode = true\n\tbare = false\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(gitDir, name), []byte(content), 0o644); err != nil {
+			return "", err
+		}
+	}
+	return gitDir, nil
+}
+
+// ExportGit writes every branch and tag, with all the commits, trees and blobs they
+// reach, into the git repository at dir, creating it if needed. Refs of the same name
+// in the git repository are overwritten; its working tree is left alone.
+func ExportGit(dir string) error {
+	refs, err := readRefs("refs/heads", "refs/tags")
+	if err != nil {
+		return err
+	}
+	if len(refs) == 0 {
+		return errors.New("nothing to export: the repository has no commits")
+	}
+
+	gitDir, exists := gitDirOf(dir)
+	if !exists {
+		branch := "main"
+		if head, err := readHEAD(); err == nil {
+			branch = strings.TrimPrefix(head, "refs/heads/")
+		}
+		if gitDir, err = initGitDir(dir, branch); err != nil {
+			return err
+		}
+	}
+
+	e := gitExporter{gitDir: gitDir, commits: make(map[string]string), trees: make(map[string]string)}
+	names := make([]string, 0, len(refs))
+	for ref := range refs {
+		names = append(names, ref)
+	}
+	sort.Strings(names)
+	for _, ref := range names {
+		hash, err := e.exportRef(refs[ref])
+		if err != nil {
+			return err
+		}
+		if err := storage.SafeWriteFile(filepath.Join(gitDir, filepath.FromSlash(ref)), []byte(hash+"\n"), 0o644); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Exported %d commit%s and %d ref%s to %s\n", len(e.commits), pluralize(len(e.commits)), len(names), pluralize(len(names)), gitDir)
+	if !exists {
+		fmt.Println("The git working tree is empty; run 'git reset --hard' there to check out HEAD.")
+	}
+	return nil
+}
+
+// gitExporter converts kitcat objects into git objects, remembering what it already wrote
+type gitExporter struct {
+	gitDir  string
+	commits map[string]string // kitcat commit -> git commit
+	trees   map[string]string // kitcat tree -> git tree
+}
+
+// exportHistory writes the commit hash and all of its ancestors, parents first, and
+// returns the git name of the commit
+func (e *gitExporter) exportHistory(hash string) (string, error) {
+	var pending []models.Commit
+	seen := make(map[string]bool)
+	queue := []string{hash}
+	for len(queue) > 0 {
+		c, err := storage.FindCommit(queue[0])
+		if err != nil {
+			return "", err
+		}
+		queue = queue[1:]
+		if _, done := e.commits[c.ID]; done || seen[c.ID] {
+			continue
+		}
+		seen[c.ID] = true
+		pending = append(pending, c)
+		queue = append(queue, c.Parents...)
+	}
+	for _, c := range topoOrder(pending) {
+		if err := e.exportCommit(c); err != nil {
+			return "", err
+		}
+	}
+	commit, err := storage.FindCommit(hash)
+	if err != nil {
+		return "", err
+	}
+	return e.commits[commit.ID], nil
+}
+
+// exportRef writes what a ref points at, an annotated tag or a commit with its history,
+// and returns its git name
+func (e *gitExporter) exportRef(hash string) (string, error) {
+	if t, err := storage.ReadObjectType(hash); err != nil || t != storage.TagObject {
+		return e.exportHistory(hash)
+	}
+	tag, err := storage.ReadTag(hash)
+	if err != nil {
+		return "", err
+	}
+	if tag.Object, err = e.exportRef(tag.Object); err != nil {
+		return "", err
+	}
+	return storage.WriteGitObject(e.gitDir, storage.TagObject, storage.EncodeTag(tag))
+}
+
+// exportCommit writes c as a git commit; its parents must have been exported already
+func (e *gitExporter) exportCommit(c models.Commit) error {
+	tree, err := e.exportTree(c.TreeHash)
+	if err != nil {
+		return fmt.Errorf("commit %s: %w", c.ID, err)
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "tree %s\n", tree)
+	for _, parent := range c.Parents {
+		gitParent, ok := e.commits[parent]
+		if !ok {
+			return fmt.Errorf("commit %s: parent %s was not exported", c.ID, parent)
+		}
+		fmt.Fprintf(&buf, "parent %s\n", gitParent)
+	}
+	committer, committerEmail, commitTime := c.Committer()
+	fmt.Fprintf(&buf, "author %s\n", formatGitIdent(c.AuthorName, c.AuthorEmail, c.Timestamp))
+	fmt.Fprintf(&buf, "committer %s\n", formatGitIdent(committer, committerEmail, commitTime))
+	if c.Timestamp.Nanosecond() != 0 {
+		fmt.Fprintf(&buf, "%s %s\n", gitTimestampHeader, c.Timestamp.UTC().Format(time.RFC3339Nano))
+	}
+	if !c.RecordsCommitter() {
+		fmt.Fprintf(&buf, "%s unrecorded\n", gitNoCommitterHeader)
+	} else if commitTime.Nanosecond() != 0 {
+		fmt.Fprintf(&buf, "%s %s\n", gitCommitTimestampHeader, commitTime.UTC().Format(time.RFC3339Nano))
+	}
+	if c.Signature != "" {
+		// Further lines of a header value start with a space
+		value := strings.ReplaceAll(strings.TrimSuffix(c.Signature, "\n"), "\n", "\n ")
+		fmt.Fprintf(&buf, "%s %s\n", gitSignatureHeader, value)
+	}
+	buf.WriteString("\n" + c.Message)
+	if !strings.HasSuffix(c.Message, "\n") {
+		buf.WriteString("\n")
+	}
+
+	hash, err := storage.WriteGitObject(e.gitDir, storage.CommitObject, buf.Bytes())
+	if err != nil {
+		return err
+	}
+	e.commits[c.ID] = hash
+	return nil
+}
+
+// exportTree writes the kitcat tree hash and everything below it as git objects and
+// returns the git name of the tree
+func (e *gitExporter) exportTree(hash string) (string, error) {
+	if gitHash, ok := e.trees[hash]; ok {
+		return gitHash, nil
+	}
+	entries, err := storage.ReadTree(hash)
+	if err != nil {
+		return "", err
+	}
+	// Trees from before nested trees existed list whole paths; rewrite them nested first
+	for _, entry := range entries {
+		if strings.Contains(entry.Name, "/") {
+			files, err := storage.FlattenTree(hash)
+			if err != nil {
+				return "", err
+			}
+			nested, err := storage.WriteTree(files)
+			if err != nil {
+				return "", err
+			}
+			gitHash, err := e.exportTree(nested)
+			e.trees[hash] = gitHash
+			return gitHash, err
+		}
+	}
+
+	gitEntries := make([]storage.GitTreeEntry, 0, len(entries))
+	for _, entry := range entries {
+		var mode, gitHash string
+		if entry.IsTree() {
+			mode = storage.GitModeTree
+			if gitHash, err = e.exportTree(entry.Hash); err != nil {
+				return "", err
+			}
+		} else {
+			mode = entry.Mode
+			data, err := storage.ReadBlob(entry.Hash)
+			if err != nil {
+				return "", err
+			}
+			if gitHash, err = storage.WriteGitObject(e.gitDir, storage.BlobObject, data); err != nil {
+				return "", err
+			}
+		}
+		gitEntries = append(gitEntries, storage.GitTreeEntry{Mode: mode, Name: entry.Name, Hash: gitHash})
+	}
+	data, err := storage.EncodeGitTree(gitEntries)
+	if err != nil {
+		return "", err
+	}
+	gitHash, err := storage.WriteGitObject(e.gitDir, storage.TreeObject, data)
+	if err != nil {
+		return "", err
+	}
+	e.trees[hash] = gitHash
+	return gitHash, nil
+}
+
+// formatGitIdent formats the "Name <email> seconds zone" of git's author and committer lines
+func formatGitIdent(name, email string, t time.Time) string {
+	clean := func(s string) string {
+		return strings.TrimSpace(strings.Map(func(r rune) rune {
+			if r == '<' || r == '>' || r == '\n' {
+				return -1
+			}
+			return r
+		}, s))
+	}
+	return fmt.Sprintf("%s <%s> %d %s", clean(name), clean(email), t.Unix(), t.Format("-0700"))
+}
+
+// parseGitIdent splits a git author or committer value into its name, email and time
+func parseGitIdent(value string) (string, string, time.Time, error) {
+	open := strings.Index(value, "<")
+	end := strings.LastIndex(value, ">")
+	if open < 0 || end < open {
+		return "", "", time.Time{}, fmt.Errorf("malformed identity %q", value)
+	}
+	name := strings.TrimSpace(value[:open])
+	email := value[open+1 : end]
+	fields := strings.Fields(value[end+1:])
+	if len(fields) != 2 {
+		return "", "", time.Time{}, fmt.Errorf("malformed identity %q", value)
+	}
+	seconds, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return "", "", time.Time{}, fmt.Errorf("malformed identity %q", value)
+	}
+	zone, err := time.Parse("-0700", fields[1])
+	if err != nil {
+		return "", "", time.Time{}, fmt.Errorf("malformed identity %q", value)
+	}
+	_, offset := zone.Zone()
+	return name, email, time.Unix(seconds, 0).In(time.FixedZone("", offset)), nil
+}
+
+// ImportGit copies the branches and tags of the git repository at dir, with every commit,
+// tree and blob they reach, into this repository. Branches are created or fast-forwarded;
+// a branch that has diverged, or that is checked out with uncommitted changes, is left
+// alone and reported. Only loose git objects can be read.
+func ImportGit(dir string) error {
+	gitDir, ok := gitDirOf(dir)
+	if !ok {
+		return fmt.Errorf("'%s' is not a git repository", dir)
+	}
+	refs, gitHead, err := readGitRefs(gitDir)
+	if err != nil {
+		return err
+	}
+	if len(refs) == 0 {
+		return fmt.Errorf("nothing to import: '%s' has no branches or tags", dir)
+	}
+
+	im := gitImporter{
+		gitDir:  gitDir,
+		commits: make(map[string]string),
+		trees:   make(map[string]string),
+		blobs:   make(map[string]string),
+	}
+	names := make([]string, 0, len(refs))
+	for ref := range refs {
+		names = append(names, ref)
+	}
+	sort.Strings(names)
+	imported := make(map[string]string)
+	for _, ref := range names {
+		commit, err := im.peel(refs[ref])
+		if err != nil {
+			return fmt.Errorf("%s: %w", ref, err)
+		}
+		if commit == "" {
+			fmt.Printf("warning: skipping %s, which does not point to a commit\n", ref)
+			continue
+		}
+		if imported[ref], err = im.importRef(refs[ref], commit); err != nil {
+			return fmt.Errorf("%s: %w", ref, err)
+		}
+	}
+	fmt.Printf("Imported %d commit%s from %s\n", len(im.commits), pluralize(len(im.commits)), gitDir)
+
+	return applyImportedRefs(imported, gitHead, "import-git: from "+gitDir, false)
+}
+
+// applyImportedRefs points the branches and tags in refs at their imported commits. New
+// branches and tags are created and existing branches fast-forwarded; with force, branches
+// and tags are overwritten instead. A branch that cannot be updated, or that is checked out
+// with uncommitted changes, is reported and left alone. In a repository without commits
+// the current branch, or else head, is checked out afterwards.
+func applyImportedRefs(refs map[string]string, head, reason string, force bool) error {
+	names := make([]string, 0, len(refs))
+	for ref := range refs {
+		names = append(names, ref)
+	}
+	sort.Strings(names)
+
+	current, _ := readHEAD()
+	unborn := false
+	if hash, _ := readHead(); hash == "" {
+		unborn = true
+	}
+	rejected := 0
+	for _, ref := range names {
+		hash := refs[ref]
+		if tag, isTag := strings.CutPrefix(ref, "refs/tags/"); isTag {
+			old, exists := lookupRef(ref)
+			if (exists && (!force || old == hash)) || !IsValidRefName(tag) {
+				continue
+			}
+			if err := os.MkdirAll(tagsDir, 0o755); err != nil {
+				return err
+			}
+			if err := SafeWrite(filepath.Join(tagsDir, tag), []byte(hash), 0o644); err != nil {
+				return err
+			}
+			if exists {
+				fmt.Printf(" + %-18s %s\n", "[tag update]", tag)
+			} else {
+				fmt.Printf(" * %-18s %s\n", "[new tag]", tag)
+			}
+			continue
+		}
+
+		branch, isBranch := strings.CutPrefix(ref, "refs/heads/")
+		if !isBranch || !IsValidRefName(branch) {
+			fmt.Printf("warning: skipping %s, which is not a valid branch name here\n", ref)
+			continue
+		}
+		old, _ := readCommitHash(ref)
+		if old == hash {
+			continue
+		}
+		checkedOut := ref == current && old != ""
+		forced := false
+		if old != "" {
+			if fastForward, _ := storage.IsAncestor(old, hash); !fastForward {
+				if !force {
+					fmt.Printf(" ! %-18s %s (non-fast-forward)\n", "[rejected]", branch)
+					rejected++
+					continue
+				}
+				forced = true
+			}
+			if checkedOut {
+				if dirty, err := IsWorkDirDirty(); err != nil {
+					return err
+				} else if dirty {
+					fmt.Printf(" ! %-18s %s (checked out with uncommitted changes)\n", "[rejected]", branch)
+					rejected++
+					continue
+				}
+			}
+		}
+		if err := updateRef(ref, hash, reason); err != nil {
+			return err
+		}
+		if checkedOut {
+			if err := UpdateWorkspaceAndIndex(hash); err != nil {
+				return err
+			}
+		}
+		switch {
+		case old == "":
+			fmt.Printf(" * %-18s %s\n", "[new branch]", branch)
+		case forced:
+			fmt.Printf(" + %-18s %s (forced update)\n", shortHash(old)+"..."+shortHash(hash), branch)
+		default:
+			fmt.Printf("   %-18s %s\n", shortHash(old)+".."+shortHash(hash), branch)
+		}
+	}
+
+	// A repository without commits takes over the imported branch, as a clone does
+	if unborn {
+		target := current
+		if _, ok := lookupRef(current); !ok && refs[head] != "" {
+			target = head
+			if err := os.WriteFile(HeadPath, []byte("ref: "+target), 0o644); err != nil {
+				return err
+			}
+			if hash, _ := readCommitHash(current); hash == "" {
+				os.Remove(filepath.Join(RepoDir, filepath.FromSlash(current)))
+			}
+		}
+		if hash, ok := lookupRef(target); ok {
+			if err := UpdateWorkspaceAndIndex(hash); err != nil {
+				return err
+			}
+		}
+	}
+	if rejected > 0 {
+		return fmt.Errorf("%d of the imported branches could not be updated", rejected)
+	}
+	return nil
+}
+
+// readGitRefs returns the branches and tags of the git repository at gitDir, from its
+// loose refs and packed-refs, and the branch its HEAD points at
+func readGitRefs(gitDir string) (map[string]string, string, error) {
+	refs := make(map[string]string)
+	if data, err := os.ReadFile(filepath.Join(gitDir, "packed-refs")); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			hash, ref, ok := strings.Cut(strings.TrimSpace(line), " ")
+			if !ok || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "^") {
+				continue
+			}
+			if strings.HasPrefix(ref, "refs/heads/") || strings.HasPrefix(ref, "refs/tags/") {
+				refs[ref] = hash
+			}
+		}
+	}
+	for _, prefix := range []string{"refs/heads", "refs/tags"} {
+		root := filepath.Join(gitDir, prefix)
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() || strings.HasSuffix(d.Name(), ".lock") {
+				return nil
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(gitDir, path)
+			if err != nil {
+				return err
+			}
+			refs[filepath.ToSlash(rel)] = strings.TrimSpace(string(data))
+			return nil
+		})
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	head := ""
+	if data, err := os.ReadFile(filepath.Join(gitDir, "HEAD")); err == nil {
+		head, _ = strings.CutPrefix(strings.TrimSpace(string(data)), "ref: ")
+	}
+	return refs, head, nil
+}
+
+// gitImporter converts git objects into kitcat objects, remembering what it already wrote
+type gitImporter struct {
+	gitDir  string
+	commits map[string]string // git commit -> kitcat commit
+	trees   map[string]string // git tree -> kitcat tree
+	blobs   map[string]string // git blob -> kitcat blob
+}
+
+// gitCommit is the part of a git commit kitcat keeps
+type gitCommit struct {
+	tree           string
+	parents        []string
+	authorName     string
+	authorEmail    string
+	timestamp      time.Time
+	committerName  string
+	committerEmail string
+	commitTime     time.Time
+	noCommitter    bool // exported from a kitcat commit that does not record its committer
+	message        string
+	signature      string
+}
+
+// peel follows annotated tags from hash to the commit they point at. It returns "" if
+// they end at something other than a commit.
+func (im *gitImporter) peel(hash string) (string, error) {
+	for range 10 {
+		t, data, err := storage.ReadGitObject(im.gitDir, hash)
+		if err != nil {
+			return "", err
+		}
+		switch t {
+		case storage.CommitObject:
+			return hash, nil
+		case storage.TagObject:
+			firstLine, _, _ := strings.Cut(string(data), "\n")
+			target, ok := strings.CutPrefix(firstLine, "object ")
+			if !ok {
+				return "", fmt.Errorf("malformed git tag %s", hash)
+			}
+			hash = target
+		default:
+			return "", nil
+		}
+	}
+	return "", fmt.Errorf("tag chain at %s is too long", hash)
+}
+
+// importRef imports what a git ref points at, an annotated tag or a commit with its
+// history, and returns its kitcat name. commit is the commit the ref peels to. A tag
+// kitcat cannot represent, such as one without a tagger, is replaced by its commit.
+func (im *gitImporter) importRef(hash, commit string) (string, error) {
+	if hash == commit {
+		return im.importHistory(commit)
+	}
+	_, data, err := storage.ReadGitObject(im.gitDir, hash)
+	if err != nil {
+		return "", err
+	}
+	tag, err := storage.ParseTag(data)
+	if err != nil {
+		return im.importHistory(commit)
+	}
+	if tag.Object, err = im.importRef(tag.Object, commit); err != nil {
+		return "", err
+	}
+	return storage.WriteTag(tag)
+}
+
+// importHistory imports the git commit hash and all of its ancestors, parents first,
+// and returns the kitcat ID of the commit
+func (im *gitImporter) importHistory(hash string) (string, error) {
+	parsed := make(map[string]gitCommit)
+	stack := []string{hash}
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		if _, done := im.commits[top]; done {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		c, ok := parsed[top]
+		if !ok {
+			var err error
+			if c, err = im.readCommit(top); err != nil {
+				return "", err
+			}
+			parsed[top] = c
+		}
+		waiting := false
+		for _, parent := range c.parents {
+			if _, done := im.commits[parent]; !done {
+				stack = append(stack, parent)
+				waiting = true
+			}
+		}
+		if waiting {
+			continue
+		}
+
+		tree, err := im.importTree(c.tree)
+		if err != nil {
+			return "", fmt.Errorf("commit %s: %w", top, err)
+		}
+		commit := models.Commit{
+			TreeHash:    tree,
+			Message:     c.message,
+			Timestamp:   c.timestamp,
+			AuthorName:  c.authorName,
+			AuthorEmail: c.authorEmail,
+			Signature:   c.signature,
+		}
+		if !c.noCommitter {
+			commit.CommitterName = c.committerName
+			commit.CommitterEmail = c.committerEmail
+			commit.CommitTime = c.commitTime
+		}
+		for _, parent := range c.parents {
+			commit.Parents = append(commit.Parents, im.commits[parent])
+		}
+		commit.ID = commit.Hash()
+		if err := storage.AppendCommit(commit); err != nil {
+			return "", err
+		}
+		im.commits[top] = commit.ID
+		stack = stack[:len(stack)-1]
+	}
+	return im.commits[hash], nil
+}
+
+// readCommit parses the git commit hash
+func (im *gitImporter) readCommit(hash string) (gitCommit, error) {
+	t, data, err := storage.ReadGitObject(im.gitDir, hash)
+	if err != nil {
+		return gitCommit{}, err
+	}
+	if t != storage.CommitObject {
+		return gitCommit{}, fmt.Errorf("git object %s is a %s, not a commit", hash, t)
+	}
+	headers, message, _ := strings.Cut(string(data), "\n\n")
+	c := gitCommit{message: strings.TrimSuffix(message, "\n")}
+	preciseTime, preciseCommitTime := "", ""
+	lastKey := ""
+	for _, line := range strings.Split(headers, "\n") {
+		if continued, ok := strings.CutPrefix(line, " "); ok {
+			if lastKey == gitSignatureHeader {
+				c.signature += continued + "\n"
+			}
+			continue
+		}
+		key, value, _ := strings.Cut(line, " ")
+		lastKey = key
+		switch key {
+		case "tree":
+			c.tree = value
+		case "parent":
+			c.parents = append(c.parents, value)
+		case "author":
+			if c.authorName, c.authorEmail, c.timestamp, err = parseGitIdent(value); err != nil {
+				return gitCommit{}, fmt.Errorf("git commit %s: %w", hash, err)
+			}
+		case "committer":
+			if c.committerName, c.committerEmail, c.commitTime, err = parseGitIdent(value); err != nil {
+				return gitCommit{}, fmt.Errorf("git commit %s: %w", hash, err)
+			}
+		case gitTimestampHeader:
+			preciseTime = value
+		case gitCommitTimestampHeader:
+			preciseCommitTime = value
+		case gitNoCommitterHeader:
+			c.noCommitter = true
+		case gitSignatureHeader:
+			c.signature = value + "\n"
+		}
+	}
+	if c.tree == "" {
+		return gitCommit{}, fmt.Errorf("git commit %s has no tree", hash)
+	}
+	c.timestamp = withPreciseTime(c.timestamp, preciseTime)
+	c.commitTime = withPreciseTime(c.commitTime, preciseCommitTime)
+	return c, nil
+}
+
+// withPreciseTime returns the RFC 3339 time precise in the zone of t when it is t to the
+// second, and t otherwise
+func withPreciseTime(t time.Time, precise string) time.Time {
+	if precise == "" {
+		return t
+	}
+	if p, err := time.Parse(time.RFC3339Nano, precise); err == nil && p.Unix() == t.Unix() {
+		return p.In(t.Location())
+	}
+	return t
+}
+
+// importTree imports the git tree hash and everything below it and returns the hash of
+// the kitcat tree
+func (im *gitImporter) importTree(hash string) (string, error) {
+	if tree, ok := im.trees[hash]; ok {
+		return tree, nil
+	}
+	t, data, err := storage.ReadGitObject(im.gitDir, hash)
+	if err != nil {
+		return "", err
+	}
+	if t != storage.TreeObject {
+		return "", fmt.Errorf("git object %s is a %s, not a tree", hash, t)
+	}
+	gitEntries, err := storage.DecodeGitTree(data)
+	if err != nil {
+		return "", err
+	}
+
+	entries := make([]storage.TreeEntry, 0, len(gitEntries))
+	for _, e := range gitEntries {
+		if !IsSafePath(e.Name) || strings.ContainsAny(e.Name, "/\\") || e.Name == "." || e.Name == RepoDir || strings.EqualFold(e.Name, ".git") {
+			return "", fmt.Errorf("refusing to import the unsafe path %q", e.Name)
+		}
+		var entry storage.TreeEntry
+		switch e.Mode {
+		case storage.GitModeTree:
+			sub, err := im.importTree(e.Hash)
+			if err != nil {
+				return "", err
+			}
+			entry = storage.TreeEntry{Mode: storage.ModeTree, Hash: sub}
+		case storage.GitModeGitlink:
+			return "", fmt.Errorf("the submodule %s cannot be imported", e.Name)
+		default:
+			mode := storage.ModeRegular
+			switch e.Mode {
+			case storage.ModeExecutable, storage.ModeSymlink:
+				mode = e.Mode
+			}
+			blob, err := im.importBlob(e.Hash)
+			if err != nil {
+				return "", err
+			}
+			entry = storage.TreeEntry{Mode: mode, Hash: blob}
+		}
+		entry.Name = e.Name
+		entries = append(entries, entry)
+	}
+	tree, err := storage.WriteTreeEntries(entries)
+	if err != nil {
+		return "", err
+	}
+	im.trees[hash] = tree
+	return tree, nil
+}
+
+// importBlob imports the git blob hash and returns the hash of the kitcat blob
+func (im *gitImporter) importBlob(hash string) (string, error) {
+	if blob, ok := im.blobs[hash]; ok {
+		return blob, nil
+	}
+	t, data, err := storage.ReadGitObject(im.gitDir, hash)
+	if err != nil {
+		return "", err
+	}
+	if t != storage.BlobObject {
+		return "", fmt.Errorf("git object %s is a %s, not a blob", hash, t)
+	}
+	blob, err := storage.WriteBlob(data)
+	if err != nil {
+		return "", err
+	}
+	im.blobs[hash] = blob
+	return blob, nil
+}
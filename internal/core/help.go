@@ -18,7 +18,7 @@ var helpMessages = map[string]CommandHelp{
 	},
 	"commit": {
 		Summary: "Record changes to the repository.",
-		Usage:   "Usage: kitcat commit <-m | -am | --amend> <message>\n\nCreates a new commit from the staging area.\nUse '-am' to automatically stage all tracked files before committing.\nUse '--amend' to modify the previous commit.",
+		Usage:   "Usage: kitcat commit [-a] [--amend] [--allow-empty] [-n | --no-verify] [--author <name-and-email>] [--date <date>] [-m <message>... | -F <file>]\n\nCreates a new commit from the staging area.\nWithout -m or -F the editor ($EDITOR) opens on the message: the file commit.template names, or the message of the merge being concluded, followed by the status of the commit as comments. Lines starting with '#' are removed, and an empty message, or a template left unedited, aborts the commit. Trailing whitespace and extra blank lines are removed from every message.\n  -m <message>             Gives the message; each further -m adds a paragraph\n  -F <file>                Reads the message from the file, or from standard input for -\n  -a, -am <message>        Stages all tracked files first\n  --amend                  Replaces the previous commit, keeping its author and author date; without -m or -F its message is edited\n  --allow-empty            Commits even if nothing changed\n  -n, --no-verify          Skips the pre-commit and commit-msg hooks\n  --author 'Name <email>'  Records another author\n  --date <date>            Records another author date: ISO 8601 such as 2024-05-01T12:00:00+02:00 or 2024-05-01 12:00:00, RFC 2822, the format log prints, or a Unix time as @<seconds>\n\nA commit records its author, who made the change and when, and its committer, who made the commit and when. Both are the configured user.name and user.email at the current time, unless the environment sets KITCAT_AUTHOR_NAME, KITCAT_AUTHOR_EMAIL and KITCAT_AUTHOR_DATE, or KITCAT_COMMITTER_NAME, KITCAT_COMMITTER_EMAIL and KITCAT_COMMITTER_DATE. Rebasing or cherry-picking a commit keeps its author and changes its committer.\n\nHooks are executables in .kitcat/hooks, or in the directory core.hooksPath names, run as git runs them, from the top of the working tree with KITCAT_DIR set. pre-commit runs first, with KITCAT_INDEX_FILE and the KITCAT_AUTHOR_* variables set; commit-msg is given the file holding the message, which it may change; a non-zero exit from either aborts the commit. post-commit runs after the commit is made.",
 	},
 	"diff": {
 		Summary: "Show changes between the last commit and staging area",
@@ -26,15 +26,15 @@ var helpMessages = map[string]CommandHelp{
 	},
 	"log": {
 		Summary: "Show the commit history",
-		Usage:   "Usage: kitcat log [--oneline] [-n <limit>]\n\nDisplays the commit history for the current branch.\nFlags:\n  --oneline   Compact, single-line view\n  -n <limit>  Limits output to N commits",
+		Usage:   "Usage: kitcat log [--oneline] [--show-signature] [-n <limit>] [<revision> | <A>..<B> | <A>...<B>]\n\nDisplays the commit history for the current branch, or of the given revision, with each commit's subject and body. A..B lists the commits reachable from B but not from A; A...B those reachable from either side but not both.\nFlags:\n  --oneline          Compact view with the subject of each commit\n  --show-signature   Checks the signature of each signed commit, as verify-commit does\n  -n <limit>         Limits output to N commits",
 	},
 	"tag": {
-		Summary: "Create a new tag for a commit",
-		Usage:   "Usage: kitcat tag <tag-name> <commit-id>\n\nCreates a new lightweight tag that points to the specified commit",
+		Summary: "Create, list or delete tags",
+		Usage:   "Usage: kitcat tag [-a | -s] [-m <message>] <tag-name> [<commit>]\n   or: kitcat tag -d <tag-name>...\n   or: kitcat tag [-l] [-n[<num>]] [<pattern>...]\n\nCreates a tag for <commit>, HEAD by default. Without -a or -m the tag is lightweight and points straight at the commit; with them it is annotated: a tag object recording the configured user as tagger, the date and the message is stored and the tag points at it. -m may be repeated for several paragraphs. 'kitcat show <tag>' displays the annotation. -s makes an annotated tag signed with the key in user.signingkey, as does setting tag.gpgsign; see verify-commit.\n-d deletes tags.\n-l lists the tags matching any of the patterns, which use shell wildcards such as 'v1.*', or every tag; listing is also what tag does without a name. -n shows the first line of each tag's message, or of its commit for a lightweight tag, and -n<num> up to <num> lines.",
 	},
 	"merge": {
 		Summary: "Merge a branch into the current branch.",
-		Usage:   "Usage: kitcat merge <branch-name>\n\nJoins another branch's history into the current branch. Currently, only fast-forward merges are supported.",
+		Usage:   "Usage: kitcat merge [--no-verify] <branch-name> | <commit>\n   or: kitcat merge --abort\n\nJoins another branch's history into the current branch. If the current branch has not diverged it is fast-forwarded; otherwise a three-way merge creates a merge commit with both branch heads as parents. Files edited on both sides are merged line by line; overlapping edits are left between <<<<<<< ======= >>>>>>> markers. Resolve them and commit to conclude the merge, or run 'kitcat merge --abort' to go back.\nThe pre-merge-commit hook runs before a merge commit is made and stops the merge if it fails; --no-verify skips it. The post-merge hook runs after a successful merge with the argument 0.",
 	},
 	"ls-files": {
 		Summary: "Show information about files in the index",
@@ -50,15 +50,27 @@ var helpMessages = map[string]CommandHelp{
 	},
 	"reset": {
 		Summary: "Reset current HEAD to the specified state",
-		Usage:   "Usage: kitcat reset --hard <commit>\n\nResets the index and working tree. Any changes to tracked files in the working tree since <commit> are discarded.",
+		Usage:   "Usage: kitcat reset [--soft | --mixed | --hard] [<commit>]\n\nMoves the current branch to <commit> (HEAD by default). --mixed, the default, also resets the index; --hard also resets the working tree, discarding any changes to tracked files.\n\nWherever a commit is expected, a revision can be given: HEAD, a branch or tag name, a full or abbreviated hash, or <ref>@{n} from the reflog, followed by ~n (n-th first-parent ancestor) or ^n (n-th parent). For example HEAD~3, main^2 or v1.0~1.",
 	},
 	"checkout": {
 		Summary: "Switch branches or restore working tree files",
-		Usage:   "Usage: kitcat checkout <branch> or checkout -b <new-branch>\n\nSwitches to a branch. Use -b to create a new branch and switch to it.",
+		Usage:   "Usage: kitcat checkout <branch> or checkout -b <new-branch>\n   or: kitcat checkout <commit>\n   or: kitcat checkout --ours|--theirs <file>...\n\nSwitches to a branch. Use -b to create a new branch and switch to it. Checking out any other revision, such as HEAD~2, detaches HEAD at that commit. For a file left unmerged by a conflict, --ours or --theirs writes that side's version to the working directory; run 'kitcat add' afterwards to mark it resolved.\nAfter switching, the post-checkout hook runs with the previous HEAD commit, the new one, and 1; after files are checked out it runs with the HEAD commit twice and 0.",
+	},
+	"verify-commit": {
+		Summary: "Check the signatures of commits",
+		Usage:   "Usage: kitcat verify-commit <commit>...\n\nChecks the SSH signature of each commit and fails if a commit is unsigned or its signature is not good.\nCommits are signed when commit.gpgsign is true, with the unencrypted ed25519 private key whose file user.signingkey names; the key may be in OpenSSH or PKCS#8 format. A signature is good when it signs the commit and its key is listed in the file gpg.ssh.allowedsignersfile names, in the allowed signers format of ssh-keygen:\n  alice@example.com ssh-ed25519 AAAA...\nKeys limited to other namespaces with namespaces=\"...\" are not accepted. Signatures use the namespace kitcat.",
+	},
+	"verify-tag": {
+		Summary: "Check the signatures of tags",
+		Usage:   "Usage: kitcat verify-tag <tag>...\n\nChecks the SSH signature of each annotated tag, made with 'kitcat tag -s' or when tag.gpgsign is true, against the allowed signers file. It fails if a tag is lightweight, unsigned or its signature is not good. See verify-commit for the keys and configuration.",
+	},
+	"show": {
+		Summary: "Show a commit, tag or other object",
+		Usage:   "Usage: kitcat show [<revision> | <tag> | <hash>]\n\nShows the commit a revision names, HEAD by default, or the object identified by the hash. An annotated tag is shown with its tagger, date and message, followed by the commit it points at.",
 	},
 	"show-object": {
 		Summary: "Provide content or type and size information for repository objects",
-		Usage:   "Usage: kitcat show-object <hash>\n\nShows the contents of the object identified by the hash.",
+		Usage:   "Usage: kitcat show-object <hash> | <revision>\n\nShows the contents of the object identified by the hash, or of the commit a revision such as HEAD~1 names. An annotated tag is shown with its tagger, date and message first.",
 	},
 	"branch": {
 		Summary: "List, create, or delete branches",
@@ -74,11 +86,67 @@ var helpMessages = map[string]CommandHelp{
 	},
 	"stash": {
 		Summary: "Stash the current working directory changes",
-		Usage:   "Usage: kitcat stash\n\nTemporarily saves changes in the working directory and index, allowing you to work on a clean state and reapply them later.",
+		Usage:   "Usage: kitcat stash [push [<message>]] [--no-verify]\n\nTemporarily saves changes in the working directory and index, allowing you to work on a clean state and reapply them later. The pre-stash hook runs first and stops the stash if it fails; --no-verify skips it. Once the files are restored from HEAD, the post-checkout hook runs with the HEAD commit twice and 0.",
 	},
 	"rebase": {
 		Summary: "Reapply commits on top of another base commit",
-		Usage:   "Usage: kitcat rebase <branch>\n\nReapplies the current branch commits on top of the specified branch, resulting in a linear commit history.",
+		Usage:   "Usage: kitcat rebase -i <commit> [--no-verify]\n   or: kitcat rebase --continue | --abort\n\nReapplies the current branch commits made after <commit> (for example HEAD~3) on top of it, following the todo list edited in your editor, resulting in a linear commit history.\nThe pre-rebase hook is given <commit> and stops the rebase before anything changes if it fails; --no-verify skips it.",
+	},
+	"cherry-pick": {
+		Summary: "Apply the changes introduced by existing commits",
+		Usage:   "Usage: kitcat cherry-pick [--no-commit] <commit>|<A>..<B>...\n   or: kitcat cherry-pick --continue | --skip | --abort\n\nReplays each commit's changes on top of the current branch, in order (a range picks its commits oldest first), keeping its message, author and author date. Files changed on both sides are merged line by line. --no-commit applies the changes to the working directory and index without committing. When a commit conflicts, resolve the files, mark them with 'kitcat add' and run --continue; --skip drops that commit and --abort restores the branch to where it was. A commit whose changes are already on the branch is skipped with a note. Only the post-commit hook runs for the commits made.",
+	},
+	"revert": {
+		Summary: "Create commits that undo earlier commits",
+		Usage:   "Usage: kitcat revert [--no-commit] <commit>|<from>..<to>...\n   or: kitcat revert --continue | --skip | --abort\n\nUndoes the changes of each commit with a new \"Revert\" commit, leaving history intact. The inverse changes are merged with the current files line by line. A range <from>..<to> reverts every commit after <from> up to <to>, newest first. --no-commit applies the changes to the working directory and index without committing. Conflicts are resolved as with cherry-pick.",
+	},
+	"reflog": {
+		Summary: "Show where HEAD and branches have pointed",
+		Usage:   "Usage: kitcat reflog [HEAD | <branch>]\n\nLists every update of HEAD, or of the given branch, newest first: commits, amends, checkouts, merges, resets and rebases. Entry n can be used wherever a commit is expected as HEAD@{n} or <branch>@{n}; @{n} refers to the current branch. For example, 'kitcat reset --hard HEAD@{1}' undoes the last reset.",
+	},
+	"remote": {
+		Summary: "Manage the repositories you sync with",
+		Usage:   "Usage: kitcat remote [-v]\n   or: kitcat remote add <name> <path-or-url>\n   or: kitcat remote remove <name>\n\nLists, adds or removes remotes: other kitcat repositories, on disk, in a bundle file or served with 'kitcat serve' at an http:// URL, stored by name in the repository config. -v also shows each remote's location. Removing a remote deletes its remote-tracking branches.",
+	},
+	"fetch": {
+		Summary: "Download objects and branches from a remote",
+		Usage:   "Usage: kitcat fetch [<remote>]\n\nCopies the objects this repository is missing from the remote (origin by default) and records each of its branches as <remote>/<branch>, under refs/remotes. Tags that do not exist locally are created. Your own branches are not changed.",
+	},
+	"push": {
+		Summary: "Update a remote branch with local commits",
+		Usage:   "Usage: kitcat push [--force] [<remote> [<branch>]]\n\nSends the branch (the current one by default) and the objects it needs to the remote (origin by default). The remote branch must be an ancestor of the local one; otherwise pull first, or use --force to overwrite it. If the branch is checked out in a remote repository on disk, its working directory is updated as well, which is refused while it has uncommitted changes; a server reached over http:// refuses pushes to its checked out branch.",
+	},
+	"pull": {
+		Summary: "Fetch from a remote and merge into the current branch",
+		Usage:   "Usage: kitcat pull [<remote> [<branch>]]\n\nRuns fetch, then merges <remote>/<branch> into the current branch. The remote defaults to origin and the branch to the current branch's name.",
+	},
+	"clone": {
+		Summary: "Copy a repository into a new directory",
+		Usage:   "Usage: kitcat clone <path-or-url> [<directory>]\n\nCreates a repository in <directory> (named after the source by default), adds the source as the remote origin, fetches everything and checks out the branch the source has checked out.",
+	},
+	"bundle": {
+		Summary: "Move history between repositories as a file",
+		Usage:   "Usage: kitcat bundle create <file> (--all | <ref> | <A>..<B>)...\n   or: kitcat bundle verify <file>\n   or: kitcat bundle unbundle <file>\n\ncreate packs commits and every tree and blob they need into a single file, for repositories that cannot reach each other. A branch or tag includes its whole history; a range A..B only the commits after A, recording A's side as prerequisites the receiving repository must already have. --all includes every branch and tag.\nverify checks that the bundle is intact and that this repository has its prerequisites.\nunbundle stores its objects here and lists the refs it provides. To get its branches, clone the bundle or add it as a remote and fetch, as with a repository path.",
+	},
+	"export-git": {
+		Summary: "Write the history into a git repository",
+		Usage:   "Usage: kitcat export-git <dir>\n\nConverts every branch and tag, with all the commits, trees and blobs they reach, into git objects in the git repository at <dir> (its .git directory, or <dir> itself if it is bare), which is created if needed. Branches and tags of the same name there are overwritten; the git working tree is not changed. Annotated tags become annotated git tags. Importing the result again gives back the same kitcat commits and tags.",
+	},
+	"import-git": {
+		Summary: "Copy the history of a git repository",
+		Usage:   "Usage: kitcat import-git <dir>\n\nReads the branches and tags of the git repository at <dir> with every commit, tree and blob they reach, and adds them here. New branches and tags are created and existing branches fast-forwarded; a branch that has diverged, or that is checked out with uncommitted changes, is reported and left alone. In a repository without commits, the branch git has checked out is checked out. Annotated tags are kept, except those without a tagger, which become lightweight tags. Only loose git objects can be read: unpack packed repositories first. Submodules are not supported.",
+	},
+	"fast-export": {
+		Summary: "Write history as a git fast-import stream",
+		Usage:   "Usage: kitcat fast-export [--all | <ref> | <A>..<B>]...\n\nWrites the selected commits, with the file versions they introduce and the branches and tags that point at them, to standard output in the format git fast-import reads, e.g. kitcat fast-export --all | git fast-import. Without arguments, every branch and tag is written. A range A..B only writes the commits after A, referring to A's side by commit ID. The stream records whole seconds, so timestamps lose any finer precision.",
+	},
+	"fast-import": {
+		Summary: "Read history from a git fast-import stream",
+		Usage:   "Usage: kitcat fast-import [--force]\n\nReads a stream in git's fast-import format from standard input, such as the output of git fast-export --all, and stores its files and commits here. The branches and tags it sets are then created or fast-forwarded; --force overwrites branches that have diverged and tags that differ. Annotated tags are kept, except those without a tagger, which become lightweight tags. Notes and submodules are not supported.",
+	},
+	"serve": {
+		Summary: "Serve the repository over HTTP",
+		Usage:   "Usage: kitcat serve [<address>]\n\nServes the current repository on <address> (localhost:8080 by default) so that others can clone, fetch from and push to it with an http:// URL such as http://localhost:8080. Pushes are accepted under the same rules as for a repository on disk, except that the branch checked out here cannot be pushed to, since a push never updates the working tree of a server, and that a single push can send at most 64 MiB. There is no authentication: only listen on addresses you trust.",
 	},
 	"grep": {
 		Summary: "Search for patterns in tracked files",
@@ -88,6 +156,22 @@ var helpMessages = map[string]CommandHelp{
 		Summary: "Summarize commit history by author",
 		Usage:   "Usage: kitcat shortlog\n\nDisplays a condensed summary of commit history, grouped by author, showing commit counts and messages.",
 	},
+	"fsck": {
+		Summary: "Verify the integrity of the repository",
+		Usage:   "Usage: kitcat fsck [--unreachable]\n\nRehashes and parses every object, and checks that the trees and parents of every commit, the entries of every tree and the target of every annotated tag exist with the right type. HEAD, branches, tags, remote-tracking branches, reflogs, stash entries, an interrupted merge, rebase, cherry-pick or revert, and the index must all point to objects that exist.\nEach problem is printed on its own line as '<kind> <name>: <detail>', where the kind is corrupt, missing, broken-link, bad-ref, bad-reflog, bad-stash or bad-index, followed by a summary. Objects that nothing reaches are listed as dangling when no other unreachable object refers to them; --unreachable lists every unreachable object instead. These do not count as errors.\nExits with status 1 when any error is found.",
+	},
+	"gc": {
+		Summary: "Pack loose objects to reduce repository size",
+		Usage:   "Usage: kitcat gc [--prune[=<age>]]\n\nPacks all objects into a single pack file with an index, storing similar objects as deltas against each other, and removes the loose copies. Objects stay readable by every command afterwards.\nWith --prune, objects that nothing in the repository reaches are left out of the pack and, as with 'kitcat prune', deleted once they are older than <age> (2.weeks.ago by default).",
+	},
+	"prune": {
+		Summary: "Delete unreachable objects",
+		Usage:   "Usage: kitcat prune [--expire=<age>]\n\nDeletes the objects that no branch, tag, remote-tracking branch, HEAD, reflog entry, stash entry, index entry or interrupted merge, rebase, cherry-pick or revert reaches, such as the commits left behind by amends, resets and dropped stashes. Only objects written more than <age> ago are deleted, 2.weeks.ago by default, so that commands running at the same time are not affected, and storing an object again renews its age; an age is written like now, 30.minutes.ago, 3.days.ago or 12h. Unreachable objects in a pack are taken out of it, and keep the age of the pack.\nA repository that 'kitcat fsck' finds errors in is not pruned.",
+	},
+	"migrate": {
+		Summary: "Upgrade an existing repository to the current storage format",
+		Usage:   "Usage: kitcat migrate\n\nMoves commits recorded in the legacy .kitcat/commits.log into the object store, moves objects into the sharded objects/ab/cdef... layout and rewrites uncompressed objects in the compressed, type-prefixed format. Safe to run more than once.",
+	},
 	"rm": {
 		Summary: "Remove files from the working tree and index",
 		Usage:   "Usage: kitcat rm <file-path>\n\nRemoves the specified file from the working directory & stages the removal for the next commit.",
@@ -17,35 +17,90 @@ func UpdateWorkspaceAndIndex(commitHash string) error {
 	if err != nil {
 		return err
 	}
-	targetTree, err := storage.ParseTree(commit.TreeHash)
+	targetFiles, err := storage.FlattenTree(commit.TreeHash)
 	if err != nil {
 		return err
 	}
+	return checkoutFiles(targetFiles)
+}
 
-	// Delete files from the current index that are not in the target tree
+// checkoutFiles makes the working directory and index hold exactly targetFiles,
+// removing tracked and unmerged files that are not part of it
+func checkoutFiles(targetFiles map[string]storage.TreeEntry) error {
+	// Delete files from the current index that are not in the target tree, including
+	// those only a conflict brought in
 	currentIndex, _ := storage.LoadIndex()
+	conflicts, _ := storage.LoadIndexConflicts()
+	for path := range conflicts {
+		currentIndex[path] = ""
+	}
 	for path := range currentIndex {
-		if _, existsInTarget := targetTree[path]; !existsInTarget {
+		if _, existsInTarget := targetFiles[path]; !existsInTarget {
 			os.Remove(path)
 		}
 	}
 
 	// Write/update files from the target tree
-	for path, hash := range targetTree {
-		content, err := storage.ReadObject(hash)
+	targetIndex := make(map[string]storage.IndexEntry, len(targetFiles))
+	for path, entry := range targetFiles {
+		content, err := storage.ReadBlob(entry.Hash)
 		if err != nil {
 			return err
 		}
-		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		if err := writeWorkingFile(path, content, entry.Mode); err != nil {
 			return err
 		}
-		if err := SafeWrite(path, content, 0o644); err != nil {
+		targetIndex[path] = storage.NewIndexEntry(path, entry.Hash, entry.Mode)
+	}
+
+	// Update the index to match the new tree
+	return storage.WriteIndexEntries(targetIndex)
+}
+
+// writeWorkingFile writes a blob to the working directory, honoring its tree mode:
+// symlinks are recreated as links and executable files keep their executable bit
+func writeWorkingFile(path string, content []byte, mode string) error {
+	if err := checkParentsNotSymlinks(path); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if mode == storage.ModeSymlink {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
 			return err
 		}
+		return os.Symlink(filepath.FromSlash(string(content)), path)
+	}
+	perm := os.FileMode(0o644)
+	if mode == storage.ModeExecutable {
+		perm = 0o755
 	}
+	return SafeWrite(path, content, perm)
+}
 
-	// Update the index to match the new tree
-	return storage.WriteIndex(targetTree)
+// checkParentsNotSymlinks makes sure no directory leading to path is a symlink, so a
+// tracked link to another directory cannot redirect a write outside the working tree
+func checkParentsNotSymlinks(path string) error {
+	dir := filepath.Dir(filepath.Clean(path))
+	if dir == "." {
+		return nil
+	}
+	prefix := ""
+	for _, part := range strings.Split(dir, string(filepath.Separator)) {
+		prefix = filepath.Join(prefix, part)
+		info, err := os.Lstat(prefix)
+		if os.IsNotExist(err) {
+			return nil // MkdirAll creates the rest as plain directories
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to write '%s' through the symlink '%s'", path, prefix)
+		}
+	}
+	return nil
 }
 
 // GetHeadState returns the current branch name or detached HEAD state.
@@ -79,23 +134,27 @@ func GetHeadState() (string, error) {
 func IsWorkDirDirty() (bool, error) {
 	// Load the tree from the last commit (HEAD)
 	headTree := make(map[string]string)
-	lastCommit, err := GetHeadCommit() // Use GetHeadCommit, not storage.GetLastCommit
+	lastCommit, err := GetHeadCommit()
 	if err == nil {
 		tree, parseErr := storage.ParseTree(lastCommit.TreeHash)
 		if parseErr != nil {
 			return false, parseErr
 		}
 		headTree = tree
-	} else if !os.IsNotExist(err) && !strings.Contains(err.Error(), "no such file") && !strings.Contains(err.Error(), "cannot find the file") {
+	} else if err != storage.ErrNoCommits && !os.IsNotExist(err) && !strings.Contains(err.Error(), "no such file") && !strings.Contains(err.Error(), "cannot find the file") {
 		// If the error is NOT "file not found" (meaning no branch tip yet), return it.
 		return false, err
 	}
 
 	// Load the current staging area
-	index, err := storage.LoadIndex()
+	entries, err := storage.LoadIndexEntries()
 	if err != nil {
 		return false, err
 	}
+	index := make(map[string]string, len(entries))
+	for path, entry := range entries {
+		index[path] = entry.Hash
+	}
 
 	// Check for staged changes (Index vs. HEAD)
 	allPaths := make(map[string]bool)
@@ -136,7 +195,7 @@ func IsWorkDirDirty() (bool, error) {
 			return nil
 		}
 
-		indexHash, isTracked := index[cleanPath]
+		entry, isTracked := entries[cleanPath]
 
 		// If the file is not in the index, check if it should be ignored
 		if !isTracked {
@@ -147,12 +206,12 @@ func IsWorkDirDirty() (bool, error) {
 			return fmt.Errorf("untracked") // Use error to signal dirty state
 		}
 
-		// If the file is tracked, hash it and compare with the index
-		currentHash, hashErr := storage.HashFile(cleanPath)
-		if hashErr != nil {
-			return hashErr
+		// If the file is tracked, compare it with the index
+		changed, changeErr := workingFileChanged(cleanPath, info, entry, nil)
+		if changeErr != nil {
+			return changeErr
 		}
-		if currentHash != indexHash {
+		if changed {
 			return fmt.Errorf("modified") // Use error to signal dirty state
 		}
 		return nil
@@ -168,9 +227,35 @@ func IsWorkDirDirty() (bool, error) {
 	return false, nil
 }
 
+// workingFileChanged reports whether the content or mode of the working file at path
+// differs from its index entry. When the stat data recorded in the index still matches,
+// the file is not rehashed. Files that had to be rehashed but turned out unchanged are
+// added to refreshed, if non-nil, so the caller can store their new stat data with
+// storage.RefreshIndex.
+func workingFileChanged(path string, info os.FileInfo, entry storage.IndexEntry, refreshed map[string]storage.IndexEntry) (bool, error) {
+	if entry.StatMatches(info) {
+		return false, nil
+	}
+	if !entry.ModeMatches(info) {
+		return true, nil
+	}
+	currentHash, err := storage.HashFile(path)
+	if err != nil {
+		return false, err
+	}
+	if currentHash != entry.Hash {
+		return true, nil
+	}
+	if refreshed != nil {
+		refreshed[path] = storage.IndexEntryFromInfo(currentHash, info)
+	}
+	return false, nil
+}
+
 // UpdateBranchPointer updates the current branch pointer or HEAD to point to a specific commit.
 // Handles both branch mode (updates refs/heads/<branch>) and detached HEAD mode (updates HEAD directly).
-func UpdateBranchPointer(commitHash string) error {
+// The update is recorded in the reflog with reason.
+func UpdateBranchPointer(commitHash, reason string) error {
 	headData, err := os.ReadFile(HeadPath)
 	if err != nil {
 		return fmt.Errorf("unable to read HEAD file: %w", err)
@@ -189,17 +274,18 @@ func UpdateBranchPointer(commitHash string) error {
 		}
 
 		// Update the branch pointer
+		old, _ := readCommitHash(refPath)
 		if err := SafeWrite(branchFile, []byte(commitHash), 0o644); err != nil {
 			return fmt.Errorf("failed to update branch pointer: %w", err)
 		}
-		return nil
+		return logRefUpdate(refPath, old, commitHash, reason)
 	}
 
 	// Case B: Detached HEAD (HEAD contains a commit hash directly)
 	if err := SafeWrite(HeadPath, []byte(commitHash), 0o644); err != nil {
 		return fmt.Errorf("failed to update HEAD: %w", err)
 	}
-	return nil
+	return appendReflog(headRef, ref, commitHash, reason)
 }
 
 // readHead returns the commit hash that HEAD currently points to.
@@ -246,8 +332,7 @@ func IsSafePath(path string) bool {
 }
 
 // GetHeadCommit returns the commit that HEAD currently points to.
-// This differs from storage.GetLastCommit() which returns the last commit in the log.
-// After a reset, HEAD might point to an earlier commit than the last one in the log.
+// Returns storage.ErrNoCommits when the current branch has no commits yet.
 func GetHeadCommit() (models.Commit, error) {
 	// Get the commit hash that HEAD points to
 	commitHash, err := readHead()
@@ -0,0 +1,130 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Hooks are executables in .kitcat/hooks, or the directory core.hooksPath names, run at
+// the points git runs its hooks of the same names. They run in the top of the working
+// tree with KITCAT_DIR set to the repository directory, and print to standard error.
+// A pre-* hook or commit-msg that exits with a non-zero status stops the operation;
+// the status of the others is ignored.
+const (
+	hookPreCommit      = "pre-commit"
+	hookCommitMsg      = "commit-msg"
+	hookPostCommit     = "post-commit"
+	hookPreRebase      = "pre-rebase"
+	hookPostCheckout   = "post-checkout"
+	hookPreMergeCommit = "pre-merge-commit"
+	hookPostMerge      = "post-merge"
+	hookPreStash       = "pre-stash"
+)
+
+// hookPath returns the program for the hook name, or "" when there is none. A hook file
+// that is not executable is reported and skipped, as git does.
+func hookPath(name string) string {
+	dir := HooksDir
+	if configured, _, _ := GetConfig("core.hooksPath"); configured != "" {
+		dir = expandHome(configured)
+	}
+	path := filepath.Join(dir, name)
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return ""
+	}
+	if runtime.GOOS != "windows" && info.Mode()&0o111 == 0 {
+		fmt.Fprintf(os.Stderr, "hint: The '%s' hook was ignored because it's not set as executable.\n", path)
+		return ""
+	}
+	return path
+}
+
+// runHook runs the hook name with args and env added to the environment. A missing hook
+// succeeds; a hook that cannot be run or exits with a non-zero status is an error.
+func runHook(name string, env []string, args ...string) error {
+	return runHookAt(hookPath(name), name, env, args...)
+}
+
+// runHookAt runs the hook name found by hookPath at path, as runHook does
+func runHookAt(path, name string, env []string, args ...string) error {
+	if path == "" {
+		return nil
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	repoDir, err := filepath.Abs(RepoDir)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(absPath, args...)
+	cmd.Env = append(append(os.Environ(), "KITCAT_DIR="+repoDir), env...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("the %s hook failed with exit status %d", name, exitErr.ExitCode())
+		}
+		return fmt.Errorf("could not run the %s hook: %w", name, err)
+	}
+	return nil
+}
+
+// runPostHook runs a hook whose outcome cannot change what already happened
+func runPostHook(name string, args ...string) {
+	_ = runHook(name, nil, args...)
+}
+
+// runPostCheckout runs the post-checkout hook with the commits HEAD was at and is now at,
+// and whether a branch or commit was checked out rather than files restored
+func runPostCheckout(previous, current string, branch bool) {
+	flag := "0"
+	if branch {
+		flag = "1"
+	}
+	if previous == "" {
+		previous = strings.Repeat("0", 40)
+	}
+	runPostHook(hookPostCheckout, previous, current, flag)
+}
+
+// commitHookEnv is the environment of the hooks run for a commit by the author by: the
+// index and the author, as KITCAT_AUTHOR_* would set it
+func commitHookEnv(by identity) []string {
+	index, _ := filepath.Abs(IndexPath)
+	return []string{
+		"KITCAT_INDEX_FILE=" + index,
+		"KITCAT_AUTHOR_NAME=" + by.Name,
+		"KITCAT_AUTHOR_EMAIL=" + by.Email,
+		"KITCAT_AUTHOR_DATE=" + by.When.Format(time.RFC3339Nano),
+	}
+}
+
+// runCommitMsgHook lets the commit-msg hook check and edit message, which it is given in
+// CommitEditMsgPath, and returns the message as the hook left it
+func runCommitMsgHook(message string, by identity) (string, error) {
+	path := hookPath(hookCommitMsg)
+	if path == "" {
+		return message, nil
+	}
+	if err := os.WriteFile(CommitEditMsgPath, []byte(message+"\n"), 0o644); err != nil {
+		return "", err
+	}
+	if err := runHookAt(path, hookCommitMsg, commitHookEnv(by), CommitEditMsgPath); err != nil {
+		return "", err
+	}
+	edited, err := os.ReadFile(CommitEditMsgPath)
+	if err != nil {
+		return "", err
+	}
+	return string(edited), nil
+}
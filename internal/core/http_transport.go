@@ -0,0 +1,217 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// The HTTP protocol has three endpoints below the repository URL, all speaking JSON:
+//
+//	GET  /info/refs        the advertisement: HEAD, branches and tags
+//	POST /upload-objects   {"wants": [...], "haves": [...]} -> the objects the client needs
+//	POST /receive-objects  {"update": {...}, "objects": [...]} -> stores objects, moves a branch
+//
+// Errors are reported with a non-200 status and the message as a plain text body: 409 for
+// a refused ref update, 400 for a malformed request or corrupt objects, 413 for a body
+// over maxRequestBytes and 500 when the repository itself fails.
+const (
+	refsEndpoint    = "/info/refs"
+	uploadEndpoint  = "/upload-objects"
+	receiveEndpoint = "/receive-objects"
+)
+
+// maxRequestBytes bounds the body of a request the server decodes. A push sends every
+// object the server is missing in one body, which is held in memory while it is decoded,
+// so larger histories have to reach the server another way, such as a bundle.
+const maxRequestBytes = 64 << 20
+
+// errorStatus returns the HTTP status the server reports err with
+func errorStatus(err error) int {
+	var rejected rejectedError
+	var bad badRequestError
+	switch {
+	case errors.As(err, &rejected):
+		return http.StatusConflict
+	case errors.As(err, &bad), errors.Is(err, storage.ErrCorruptObject):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// wireObject is an object as it is sent over HTTP; Data is base64 encoded by encoding/json
+type wireObject struct {
+	Hash string             `json:"hash"`
+	Type storage.ObjectType `json:"type"`
+	Data []byte             `json:"data"`
+}
+
+type uploadRequest struct {
+	Wants []string `json:"wants"`
+	Haves []string `json:"haves"`
+}
+
+type receiveRequest struct {
+	Update  refUpdate    `json:"update"`
+	Objects []wireObject `json:"objects"`
+}
+
+func toWire(objects []transferObject) []wireObject {
+	wire := make([]wireObject, 0, len(objects))
+	for _, o := range objects {
+		wire = append(wire, wireObject{Hash: o.name, Type: o.obj.Type, Data: o.obj.Data})
+	}
+	return wire
+}
+
+func fromWire(wire []wireObject) []transferObject {
+	objects := make([]transferObject, 0, len(wire))
+	for _, w := range wire {
+		objects = append(objects, transferObject{name: w.Hash, obj: storage.Object{Type: w.Type, Data: w.Data}})
+	}
+	return objects
+}
+
+// httpTransport reaches a repository served by 'kitcat serve'
+type httpTransport struct {
+	base   string
+	client *http.Client
+}
+
+func newHTTPTransport(url string) httpTransport {
+	return httpTransport{
+		base:   strings.TrimSuffix(url, "/"),
+		client: &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// call sends body (if not nil) to endpoint and decodes the JSON reply into out (if not nil)
+func (t httpTransport) call(endpoint string, body, out any) error {
+	var resp *http.Response
+	var err error
+	if body == nil {
+		resp, err = t.client.Get(t.base + endpoint)
+	} else {
+		data, jsonErr := json.Marshal(body)
+		if jsonErr != nil {
+			return jsonErr
+		}
+		resp, err = t.client.Post(t.base+endpoint, "application/json", bytes.NewReader(data))
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if text := strings.TrimSpace(string(msg)); text != "" {
+			return errors.New(text)
+		}
+		return fmt.Errorf("server returned %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", t.base, err)
+	}
+	return nil
+}
+
+func (t httpTransport) advertise() (advertisement, error) {
+	var adv advertisement
+	err := t.call(refsEndpoint, nil, &adv)
+	return adv, err
+}
+
+func (t httpTransport) fetch(wants, haves []string) ([]transferObject, error) {
+	var wire []wireObject
+	if err := t.call(uploadEndpoint, uploadRequest{Wants: wants, Haves: haves}, &wire); err != nil {
+		return nil, err
+	}
+	return fromWire(wire), nil
+}
+
+func (t httpTransport) push(update refUpdate, objects []transferObject) error {
+	return t.call(receiveEndpoint, receiveRequest{Update: update, Objects: toWire(objects)}, nil)
+}
+
+// NewServer returns a handler that serves the repository at dir to fetch, push and clone
+// over HTTP. Requests are handled one at a time: each runs with dir as the working
+// directory, like the local transport does.
+func NewServer(dir string) http.Handler {
+	var mu sync.Mutex
+	serve := func(fn func() (any, error)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			var reply any
+			err := inRepo(dir, func() (err error) {
+				reply, err = fn()
+				return err
+			})
+			mu.Unlock()
+			if err != nil {
+				http.Error(w, err.Error(), errorStatus(err))
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(reply)
+		}
+	}
+	decode := func(w http.ResponseWriter, r *http.Request, v any) bool {
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(v)
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			http.Error(w, fmt.Sprintf("request larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		case err != nil:
+			http.Error(w, fmt.Sprintf("malformed request: %v", err), http.StatusBadRequest)
+		}
+		return err == nil
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+refsEndpoint, func(w http.ResponseWriter, r *http.Request) {
+		serve(func() (any, error) { return advertiseRefs() })(w, r)
+	})
+	mux.HandleFunc("POST "+uploadEndpoint, func(w http.ResponseWriter, r *http.Request) {
+		var req uploadRequest
+		if !decode(w, r, &req) {
+			return
+		}
+		serve(func() (any, error) {
+			objects, err := uploadObjects(req.Wants, req.Haves)
+			return toWire(objects), err
+		})(w, r)
+	})
+	mux.HandleFunc("POST "+receiveEndpoint, func(w http.ResponseWriter, r *http.Request) {
+		var req receiveRequest
+		if !decode(w, r, &req) {
+			return
+		}
+		serve(func() (any, error) {
+			return struct{}{}, receivePush(req.Update, fromWire(req.Objects), false)
+		})(w, r)
+	})
+	return mux
+}
+
+// Serve serves the current repository over HTTP on addr until the server fails
+func Serve(addr string) error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Serving %s on http://%s\n", dir, addr)
+	return http.ListenAndServe(addr, NewServer(dir))
+}
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// identity is who authored or committed something, and when
+type identity struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+// configuredUser returns the user configured with user.name and user.email, acting now
+func configuredUser() identity {
+	name, _, _ := GetConfig("user.name")
+	if name == "" {
+		name = "Unknown"
+	}
+	email, _, _ := GetConfig("user.email")
+	if email == "" {
+		email = "unknown@example.com"
+	}
+	return identity{Name: name, Email: email, When: time.Now().UTC()}
+}
+
+// authorIdent returns the author of a new commit: the configured user, with the name,
+// email and date replaced by KITCAT_AUTHOR_NAME, KITCAT_AUTHOR_EMAIL and
+// KITCAT_AUTHOR_DATE when they are set
+func authorIdent() (identity, error) {
+	return envIdentity("AUTHOR")
+}
+
+// committerIdent returns who is committing: the configured user, with the name, email and
+// date replaced by KITCAT_COMMITTER_NAME, KITCAT_COMMITTER_EMAIL and KITCAT_COMMITTER_DATE
+// when they are set
+func committerIdent() (identity, error) {
+	return envIdentity("COMMITTER")
+}
+
+// envIdentity returns the configured user overridden by the KITCAT_<role>_* variables.
+// The name and email are filled in even when the date is invalid.
+func envIdentity(role string) (identity, error) {
+	who := configuredUser()
+	if name := os.Getenv("KITCAT_" + role + "_NAME"); name != "" {
+		who.Name = name
+	}
+	if email := os.Getenv("KITCAT_" + role + "_EMAIL"); email != "" {
+		who.Email = email
+	}
+	if date := os.Getenv("KITCAT_" + role + "_DATE"); date != "" {
+		when, err := parseDate(date)
+		if err != nil {
+			return who, fmt.Errorf("invalid KITCAT_%s_DATE: %w", role, err)
+		}
+		who.When = when
+	}
+	return who, nil
+}
+
+// parseIdentity parses "Name <email>", as given to commit --author
+func parseIdentity(s string) (name, email string, err error) {
+	open := strings.Index(s, "<")
+	end := strings.LastIndex(s, ">")
+	if open < 0 || end < open || strings.TrimSpace(s[end+1:]) != "" {
+		return "", "", fmt.Errorf("'%s' is not of the form 'Name <email>'", s)
+	}
+	name = strings.TrimSpace(s[:open])
+	email = strings.TrimSpace(s[open+1 : end])
+	if name == "" || email == "" || strings.ContainsAny(email, "<>") {
+		return "", "", fmt.Errorf("'%s' is not of the form 'Name <email>'", s)
+	}
+	return name, email, nil
+}
+
+// dateLayouts are the formats parseDate accepts besides a Unix time, tried in order.
+// Layouts without a zone are read as local time.
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"Mon Jan 02 15:04:05 2006 -0700", // as log prints dates
+	"Mon Jan 2 15:04:05 2006 -0700",
+	time.RFC1123Z,
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// parseDate parses the date of commit --date or a KITCAT_*_DATE variable: an ISO 8601
+// or RFC 2822 date, the format log prints, or a Unix time as "@<seconds>" or
+// "<seconds> <zone>" the way git records it
+func parseDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	seconds, zone, hasZone := strings.Cut(strings.TrimPrefix(s, "@"), " ")
+	if strings.HasPrefix(s, "@") || hasZone {
+		if n, err := strconv.ParseInt(seconds, 10, 64); err == nil {
+			t := time.Unix(n, 0).UTC()
+			if !hasZone {
+				return t, nil
+			}
+			if z, err := time.Parse("-0700", zone); err == nil {
+				_, offset := z.Zone()
+				return t.In(time.FixedZone("", offset)), nil
+			}
+		}
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date '%s'", s)
+}
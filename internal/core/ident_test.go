@@ -0,0 +1,103 @@
+package core
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+func TestParseDate_Formats(t *testing.T) {
+	want := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	for _, s := range []string{
+		"2024-05-01T12:00:00+02:00",
+		"2024-05-01 12:00:00 +0200",
+		"Wed May 01 12:00:00 2024 +0200",
+		"Wed, 01 May 2024 12:00:00 +0200",
+		"@1714557600",
+		"1714557600 +0200",
+	} {
+		got, err := parseDate(s)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("parseDate(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+	if got, err := parseDate("1714557600 +0200"); err != nil || got.Format("-0700") != "+0200" {
+		t.Errorf("a Unix time with a zone is in zone %s (%v)", got.Format("-0700"), err)
+	}
+	for _, s := range []string{"", "tomorrow", "1714557600", "2024-13-01"} {
+		if _, err := parseDate(s); err == nil {
+			t.Errorf("parseDate(%q) succeeded", s)
+		}
+	}
+
+	name, email, err := parseIdentity("  Ann  Author <ann@example.com> ")
+	if err != nil || name != "Ann  Author" || email != "ann@example.com" {
+		t.Errorf("parseIdentity = %q, %q, %v", name, email, err)
+	}
+	for _, s := range []string{"Ann", "<ann@example.com>", "Ann <>", "Ann <a@b> extra"} {
+		if _, _, err := parseIdentity(s); err == nil {
+			t.Errorf("parseIdentity(%q) succeeded", s)
+		}
+	}
+}
+
+func TestLegacyCommit_KeepsIDThroughGit(t *testing.T) {
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if err := InitRepo(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A commit from before committers were recorded
+	tree, err := storage.WriteTree(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := models.Commit{
+		Message:     "old",
+		Timestamp:   time.Date(2023, 1, 2, 3, 4, 5, 6, time.UTC),
+		TreeHash:    tree,
+		AuthorName:  "Old Timer",
+		AuthorEmail: "old@example.com",
+	}
+	legacy.ID = legacy.Hash()
+	sum := sha1.Sum([]byte(tree + "old" + "2023-01-02T03:04:05.000000006Z"))
+	if got, want := legacy.LegacyHash(), hex.EncodeToString(sum[:]); got != want {
+		t.Fatalf("a commit without a committer hashes to %s, want %s as before", got, want)
+	}
+	if err := storage.AppendCommit(legacy); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateBranchPointer(legacy.ID, "legacy"); err != nil {
+		t.Fatal(err)
+	}
+
+	gitRepo := t.TempDir()
+	if err := ExportGit(gitRepo); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if err := InitRepo(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ImportGit(gitRepo); err != nil {
+		t.Fatal(err)
+	}
+	head, err := GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.ID != legacy.ID || head.RecordsCommitter() {
+		t.Errorf("the legacy commit %s came back as %s, recording a committer: %v", legacy.ID, head.ID, head.RecordsCommitter())
+	}
+}
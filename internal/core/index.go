@@ -1,9 +1,9 @@
 package core
 
 import (
-	"encoding/json"
-	"fmt"
-	"os"
+	"sort"
+
+	"github.com/LeeFred3042U/kitcat/internal/storage"
 )
 
 // IndexEntry represents a file in the staging area
@@ -12,54 +12,28 @@ type IndexEntry struct {
 	Hash string
 }
 
-// LoadIndex reads the .kitcat/index file
+// LoadIndex reads the .kitcat/index file, sorted by path
 func LoadIndex() ([]IndexEntry, error) {
-	data, err := os.ReadFile(IndexPath)
-	if os.IsNotExist(err) {
-		return []IndexEntry{}, nil
-	}
+	index, err := storage.LoadIndex()
 	if err != nil {
 		return nil, err
 	}
 
-	if len(data) == 0 {
-		return []IndexEntry{}, nil
-	}
-
-	var entryMap map[string]string
-	err = json.Unmarshal(data, &entryMap)
-	if err != nil {
-		return nil, fmt.Errorf("index file corrupted")
-	}
-
-	var entries []IndexEntry
-	for key, value := range entryMap {
+	entries := make([]IndexEntry, 0, len(index))
+	for key, value := range index {
 		entries = append(entries, IndexEntry{Path: key, Hash: value})
 	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
 	return entries, nil
 }
 
 // SaveIndex writes the index back to disk
 func SaveIndex(entries []IndexEntry) error {
-	file, err := os.Create(IndexPath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	entryMap := make(map[string]string)
+	entryMap := make(map[string]string, len(entries))
 	for _, entry := range entries {
 		entryMap[entry.Path] = entry.Hash
 	}
-
-	data, err := json.Marshal(entryMap)
-	if err != nil {
-		return err
-	}
-
-	_, err = file.Write(data)
-	if err != nil {
-		return fmt.Errorf("unable to write to index")
-	}
-	return nil
+	return storage.WriteIndex(entryMap)
 }
@@ -16,6 +16,12 @@ func isPathExist(path string) bool {
 
 // InitRepo sets up the .kitcat directory structure.
 func InitRepo() error {
+	return initRepo(true)
+}
+
+// initRepo sets up the .kitcat directory structure, printing the usual
+// guidance about the default branch only if verbose
+func initRepo(verbose bool) error {
 	// Idempotent: do not error if repo exists, just ensure structure is correct
 
 	// Create all necessary subdirectories using the public constants.
@@ -24,6 +30,7 @@ func InitRepo() error {
 		ObjectsDir,
 		HeadsDir,
 		TagsDir,
+		HooksDir,
 	}
 
 	for _, dir := range dirs {
@@ -33,7 +40,7 @@ func InitRepo() error {
 	}
 
 	// Create empty files only if they do not exist.
-	files := []string{IndexPath, CommitsPath}
+	files := []string{IndexPath}
 	for _, file := range files {
 		if !isPathExist(file) {
 			f, err := os.Create(file)
@@ -46,10 +53,13 @@ func InitRepo() error {
 
 	// Create the HEAD file to point to the default branch (main) only if it does not exist.
 	headContent := []byte("ref: refs/heads/main")
-	if !isPathExist(HeadPath) {
+	headExisted := isPathExist(HeadPath)
+	if !headExisted {
 		if err := os.WriteFile(HeadPath, headContent, 0o644); err != nil {
 			return err
 		}
+	}
+	if !headExisted && verbose {
 		fmt.Printf("%sUsing 'main' as the name for the default branch.%s\n\n", colorYellow, colorReset)
 		fmt.Printf("%sBranches can be renamed via this command:%s\n", colorYellow, colorReset)
 		fmt.Printf("%s\tkitcat branch -m <branch_name>%s\n\n", colorYellow, colorReset)
@@ -74,7 +84,7 @@ func InitRepo() error {
 
 	if absPath, err := filepath.Abs(RepoDir); err != nil {
 		return err
-	} else {
+	} else if verbose {
 		fmt.Printf("%s\nInitialized empty kitcat repository in %s\n\n%s", colorYellow, absPath, colorReset)
 	}
 	return nil
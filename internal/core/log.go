@@ -1,8 +1,10 @@
 package core
 
 import (
+	"container/heap"
 	"fmt"
 	"sort"
+	"strings"
 
 	"github.com/LeeFred3042U/kitcat/internal/models"
 	"github.com/LeeFred3042U/kitcat/internal/storage"
@@ -10,52 +12,153 @@ import (
 
 // ShowLog prints the commit log. It accepts a boolean for oneline format
 // and an optional limit to restrict the number of commits shown (use -1 or 0 for no limit)
-func ShowLog(oneline bool, limit int) error {
-	// 1Start from HEAD (Architecture from reset-hard branch)
-	// We must walk backwards from HEAD, otherwise 'reset' changes won't be reflected
-	currentCommit, err := GetHeadCommit()
-	if err != nil {
-		// Handle the case where the repo is empty or HEAD is invalid
-		return nil
+// Every commit reachable from revision (HEAD when empty) is shown once, newest first, and
+// never before its children. A range A..B or A...B shows the commits it selects.
+// With showSignature, the check of each signed commit's signature follows its hash.
+func ShowLog(oneline bool, limit int, revision string, showSignature bool) error {
+	var ordered []models.Commit
+	if _, _, _, isRange := splitRange(revision); isRange {
+		commits, err := revisionCommits(revision)
+		if err != nil {
+			return err
+		}
+		ordered = commits
+	} else {
+		// Start from HEAD: we must walk backwards from HEAD, otherwise 'reset' changes won't be reflected
+		start := revision
+		if start == "" {
+			start = headRef
+		}
+		currentCommit, err := resolveCommit(start)
+		if err != nil {
+			if revision == "" {
+				// Handle the case where the repo is empty or HEAD is invalid
+				return nil
+			}
+			return err
+		}
+
+		var reachable []models.Commit
+		if err := storage.WalkCommits(currentCommit.ID, func(c models.Commit) bool {
+			reachable = append(reachable, c)
+			return true
+		}); err != nil {
+			return err
+		}
+		ordered = topoOrder(reachable)
 	}
 
-	commitHash := currentCommit.ID
 	count := 0
-
-	// Walk the graph (Architecture from reset-hard branch)
-	for commitHash != "" {
-		// Apply the Limit Check (Feature from main branch)
+	for i := len(ordered) - 1; i >= 0; i-- {
 		if limit > 0 && count >= limit {
 			break
 		}
-
-		commit, err := storage.FindCommit(commitHash)
-		if err != nil {
-			return err
-		}
+		commit := ordered[i]
 
 		// Print Logic
 		if oneline {
-			fmt.Printf("%s %s\n", commit.ID[:7], commit.Message)
+			fmt.Printf("%s %s\n", commit.ID[:7], commit.Subject())
 		} else {
 			fmt.Printf("commit %s\n", commit.ID)
+			if showSignature && commit.Signature != "" {
+				fmt.Println(checkSignature(commit.Payload(), commit.Signature))
+			}
+			if commit.IsMerge() {
+				short := make([]string, len(commit.Parents))
+				for j, p := range commit.Parents {
+					short[j] = shortHash(p)
+				}
+				fmt.Printf("Merge: %s\n", strings.Join(short, " "))
+			}
 			fmt.Printf("Author: %s <%s>\n", commit.AuthorName, commit.AuthorEmail)
 			fmt.Printf("Date:   %s\n", commit.Timestamp.Local().Format("Mon Jan 02 15:04:05 2006 -0700"))
-			fmt.Printf("\n    %s\n\n", commit.Message)
+			// The subject and body are indented line by line, as git does
+			fmt.Println()
+			for _, line := range strings.Split(commit.Message, "\n") {
+				fmt.Printf("    %s\n", line)
+			}
+			fmt.Println()
 		}
-
-		// Move to parent pointer
-		commitHash = commit.Parent
 		count++
 	}
 
 	return nil
 }
 
+// topoOrder sorts commits so that every commit comes after those of its parents that are
+// in the set. Among commits whose parents are all placed, the oldest goes first.
+func topoOrder(commits []models.Commit) []models.Commit {
+	byID := make(map[string]models.Commit, len(commits))
+	for _, c := range commits {
+		byID[c.ID] = c
+	}
+	pending := make(map[string]int, len(commits))
+	children := make(map[string][]string)
+	for _, c := range commits {
+		for _, p := range c.Parents {
+			if _, ok := byID[p]; ok {
+				pending[c.ID]++
+				children[p] = append(children[p], c.ID)
+			}
+		}
+	}
+
+	ready := &commitHeap{}
+	for _, c := range byID {
+		if pending[c.ID] == 0 {
+			heap.Push(ready, c)
+		}
+	}
+	ordered := make([]models.Commit, 0, len(byID))
+	for ready.Len() > 0 {
+		c := heap.Pop(ready).(models.Commit)
+		ordered = append(ordered, c)
+		for _, child := range children[c.ID] {
+			pending[child]--
+			if pending[child] == 0 {
+				heap.Push(ready, byID[child])
+			}
+		}
+	}
+	return ordered
+}
+
+// commitHeap is a min-heap of commits ordered by timestamp, then ID for determinism
+type commitHeap []models.Commit
+
+func (h commitHeap) Len() int { return len(h) }
+func (h commitHeap) Less(i, j int) bool {
+	if !h[i].Timestamp.Equal(h[j].Timestamp) {
+		return h[i].Timestamp.Before(h[j].Timestamp)
+	}
+	return h[i].ID < h[j].ID
+}
+func (h commitHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *commitHeap) Push(x any)   { *h = append(*h, x.(models.Commit)) }
+func (h *commitHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// shortHash abbreviates a commit hash for display
+func shortHash(hash string) string {
+	if len(hash) > 7 {
+		return hash[:7]
+	}
+	return hash
+}
+
 // ShowShortLog prints commit messages grouped by author,
 // sorted by commit counts of each author.
+// Commits are those reachable from HEAD, the branches, tags and remote-tracking refs.
 func ShowShortLog() error {
-	commits, err := storage.ReadCommits()
+	tips, err := refTips()
+	if err != nil {
+		return err
+	}
+	commits, err := storage.ReadCommits(tips)
 	if err != nil {
 		return err
 	}
@@ -88,7 +191,7 @@ func ShowShortLog() error {
 	for _, log := range logs {
 		fmt.Printf("%s (%d):\n", log.name, len(log.commits))
 		for _, commit := range log.commits {
-			fmt.Printf("\t%s\n", commit.Message)
+			fmt.Printf("\t%s\n", commit.Subject())
 		}
 		fmt.Println()
 	}
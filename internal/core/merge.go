@@ -4,20 +4,33 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
+	"sort"
 	"strings"
 
+	"github.com/LeeFred3042U/kitcat/internal/diff"
 	"github.com/LeeFred3042U/kitcat/internal/storage"
 )
 
-// Merge attempts to merge the given branch into the current branch
-// Currently only supports strict fast-forward merges
+// Merge merges the given branch, or any other revision, into the current branch
+// When the current branch is an ancestor of the other one the branch is fast-forwarded;
+// otherwise the two histories are combined with a three-way merge into a merge commit
 func Merge(branchToMerge string) error {
+	return MergeWith(branchToMerge, false)
+}
+
+// MergeWith is Merge that skips the pre-merge-commit hook when noVerify is set. That hook
+// runs before a merge commit is made and can stop it; post-merge runs once the branch
+// has moved.
+func MergeWith(branchToMerge string, noVerify bool) error {
 	// Guard: ensure we're inside a kitcat repo
 	if _, err := os.Stat(RepoDir); os.IsNotExist(err) {
 		return errors.New("not a kitcat repository (run `kitcat init`)")
 	}
 
+	if IsMergeInProgress() {
+		return errors.New("you have not concluded your merge (MERGE_HEAD exists); commit the result or run 'kitcat merge --abort'")
+	}
+
 	// Safety Check: Verify working directory is clean
 	dirty, err := IsWorkDirDirty()
 	if err != nil {
@@ -29,13 +42,11 @@ func Merge(branchToMerge string) error {
 		)
 	}
 
-	// Getting the commit hash of the branch to merge
-	branchPath := filepath.Join(HeadsDir, branchToMerge)
-	featureHeadHashBytes, err := os.ReadFile(branchPath)
+	// Getting the commit hash of the branch (or other revision) to merge
+	featureHeadHash, err := ResolveRevision(branchToMerge)
 	if err != nil {
 		return fmt.Errorf("branch '%s' not found", branchToMerge)
 	}
-	featureHeadHash := strings.TrimSpace(string(featureHeadHashBytes))
 
 	// Getting the commit hash of the current branch (HEAD)
 	currentHeadHash, err := readHead()
@@ -63,24 +74,368 @@ func Merge(branchToMerge string) error {
 
 	default:
 		// diverged
+		if err := mergeDiverged(branchToMerge, currentHeadHash, featureHeadHash, mergeBase, noVerify); err != nil {
+			return err
+		}
+		runPostHook(hookPostMerge, "0")
+		return nil
+	}
+
+	// Fast-Forward Execution
+	if err := moveBranchAndCheckout(featureHeadHash, currentHeadHash, "merge "+branchToMerge+": Fast-forward"); err != nil {
+		return err
+	}
+	runPostHook(hookPostMerge, "0")
+	return nil
+}
+
+// mergeDiverged performs a three-way merge of theirs into ours using base as the common
+// ancestor, and records the result as a commit with both heads as parents. When some
+// changes overlap, the clean part of the merge is checked out, the conflicting files are
+// left with conflict markers, and the merge is concluded by the next commit.
+func mergeDiverged(branch, ours, theirs, base string, noVerify bool) error {
+	baseFiles, err := commitFiles(base)
+	if err != nil {
+		return err
+	}
+	ourFiles, err := commitFiles(ours)
+	if err != nil {
+		return err
+	}
+	theirFiles, err := commitFiles(theirs)
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("Merge branch '%s'", branch)
+	if _, ok := lookupRef("refs/remotes/" + branch); ok {
+		message = fmt.Sprintf("Merge remote-tracking branch '%s'", branch)
+	} else if !IsBranch(branch) {
+		message = fmt.Sprintf("Merge commit '%s'", branch)
+	}
+	merged, conflicts, err := mergeTrees(baseFiles, ourFiles, theirFiles, mergeLabels{ours: "HEAD", theirs: branch})
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		if err := checkoutMergeResult(merged, conflicts); err != nil {
+			return err
+		}
+		if err := SafeWrite(MergeHeadPath, []byte(theirs), 0o644); err != nil {
+			return err
+		}
+		if err := SafeWrite(MergeMsgPath, []byte(message), 0o644); err != nil {
+			return err
+		}
+		printConflicts(conflicts)
+		return errors.New("automatic merge failed; fix conflicts and then commit the result")
+	}
+
+	treeHash, err := storage.WriteTree(merged)
+	if err != nil {
+		return fmt.Errorf("failed to write merged tree: %w", err)
+	}
+	if !noVerify {
+		if err := runHook(hookPreMergeCommit, nil); err != nil {
+			return fmt.Errorf("%w; not committing the merge", err)
+		}
+	}
+	by, err := authorIdent()
+	if err != nil {
+		return err
+	}
+	commit, err := newCommit(treeHash, []string{ours, theirs}, message, by)
+	if err != nil {
+		return fmt.Errorf("failed to create merge commit: %w", err)
+	}
+	if err := moveBranchAndCheckout(commit.ID, ours, "merge "+branch+": Merge made by the 'three-way' strategy."); err != nil {
+		return err
+	}
+	fmt.Println("Merge made by the 'three-way' strategy.")
+	return nil
+}
+
+// MergeAbort abandons a conflicted merge, restoring the working directory and index to HEAD
+func MergeAbort() error {
+	if !IsMergeInProgress() {
+		return errors.New("there is no merge to abort (MERGE_HEAD missing)")
+	}
+	head, err := readHead()
+	if err != nil {
+		return err
+	}
+	if err := UpdateWorkspaceAndIndex(head); err != nil {
+		return fmt.Errorf("failed to restore HEAD: %w", err)
+	}
+	return clearMergeState()
+}
+
+// IsMergeInProgress reports whether a conflicted merge is waiting to be committed
+func IsMergeInProgress() bool {
+	_, err := os.Stat(MergeHeadPath)
+	return err == nil
+}
+
+// readMergeState returns the commit being merged and the prepared merge message
+func readMergeState() (string, string, error) {
+	head, err := os.ReadFile(MergeHeadPath)
+	if err != nil {
+		return "", "", err
+	}
+	msg, _ := os.ReadFile(MergeMsgPath)
+	return strings.TrimSpace(string(head)), strings.TrimSpace(string(msg)), nil
+}
+
+// clearMergeState removes the files recording a merge in progress
+func clearMergeState() error {
+	for _, path := range []string{MergeHeadPath, MergeMsgPath} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// commitFiles returns the flattened tree of a commit
+func commitFiles(hash string) (map[string]storage.TreeEntry, error) {
+	commit, err := storage.FindCommit(hash)
+	if err != nil {
+		return nil, err
+	}
+	return storage.FlattenTree(commit.TreeHash)
+}
+
+// mergeLabels name the two sides of a merge in conflict markers and messages
+type mergeLabels struct {
+	ours   string
+	theirs string
+}
+
+// mergeConflict is a path a three-way merge could not resolve on its own. Base, Ours and
+// Theirs are nil when the path is absent on that side. Content is what the working file
+// should hold for the user to resolve, conflict markers included.
+type mergeConflict struct {
+	Path    string
+	Kind    string
+	Message string
+	Base    *storage.TreeEntry
+	Ours    *storage.TreeEntry
+	Theirs  *storage.TreeEntry
+	Content []byte
+	Mode    string
+}
+
+// mergeTrees combines two trees that diverged from base. A path changed on only one side
+// takes that side's version, and a file edited on both sides is merged line by line.
+// Paths that cannot be merged are returned as conflicts and left out of the merged tree.
+func mergeTrees(base, ours, theirs map[string]storage.TreeEntry, labels mergeLabels) (map[string]storage.TreeEntry, []mergeConflict, error) {
+	paths := make(map[string]bool)
+	for _, tree := range []map[string]storage.TreeEntry{base, ours, theirs} {
+		for path := range tree {
+			paths[path] = true
+		}
+	}
+
+	merged := make(map[string]storage.TreeEntry)
+	var conflicts []mergeConflict
+	for path := range paths {
+		result, conflict, err := mergePath(path, lookupEntry(base, path), lookupEntry(ours, path), lookupEntry(theirs, path), labels)
+		if err != nil {
+			return nil, nil, err
+		}
+		if conflict != nil {
+			conflicts = append(conflicts, *conflict)
+			continue
+		}
+		if result != nil {
+			merged[path] = *result
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Path < conflicts[j].Path })
+	return merged, conflicts, nil
+}
+
+// lookupEntry returns the entry for path in tree, or nil when it is absent
+func lookupEntry(tree map[string]storage.TreeEntry, path string) *storage.TreeEntry {
+	entry, ok := tree[path]
+	if !ok {
+		return nil
+	}
+	return &entry
+}
+
+// mergePath merges one path given its base, ours and theirs versions, any of which may be nil.
+// It returns the merged entry (nil when the path ends up deleted) or a conflict.
+func mergePath(path string, base, ours, theirs *storage.TreeEntry, labels mergeLabels) (*storage.TreeEntry, *mergeConflict, error) {
+	switch {
+	case sameEntry(ours, theirs):
+		// Both sides agree (including both deleting it)
+		return ours, nil, nil
+	case sameEntry(base, ours):
+		// Only their side changed it
+		return theirs, nil, nil
+	case sameEntry(base, theirs):
+		// Only our side changed it
+		return ours, nil, nil
+	}
+
+	conflict := &mergeConflict{Path: path, Base: base, Ours: ours, Theirs: theirs}
+	if ours == nil || theirs == nil {
+		// One side deleted the file the other changed; keep the surviving version on disk
+		deletedIn, modifiedIn, kept := labels.ours, labels.theirs, theirs
+		if theirs == nil {
+			deletedIn, modifiedIn, kept = labels.theirs, labels.ours, ours
+		}
+		content, err := storage.ReadBlob(kept.Hash)
+		if err != nil {
+			return nil, nil, err
+		}
+		conflict.Kind = "modify/delete"
+		conflict.Message = fmt.Sprintf("%s deleted in %s and modified in %s", path, deletedIn, modifiedIn)
+		conflict.Content, conflict.Mode = content, kept.Mode
+		return nil, conflict, nil
+	}
+
+	var baseContent []byte
+	if base != nil {
+		var err error
+		if baseContent, err = storage.ReadBlob(base.Hash); err != nil {
+			return nil, nil, err
+		}
+	}
+	ourContent, err := storage.ReadBlob(ours.Hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	theirContent, err := storage.ReadBlob(theirs.Hash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mode := ours.Mode
+	if base != nil && ours.Mode == base.Mode {
+		mode = theirs.Mode
+	}
+
+	if ours.Mode == storage.ModeSymlink || theirs.Mode == storage.ModeSymlink ||
+		isDiffBinary(baseContent) || isDiffBinary(ourContent) || isDiffBinary(theirContent) {
+		conflict.Kind = "binary"
+		conflict.Message = fmt.Sprintf("cannot merge binary file %s", path)
+		conflict.Content, conflict.Mode = ourContent, ours.Mode
+		return nil, conflict, nil
+	}
+
+	result := diff.MergeText(string(baseContent), string(ourContent), string(theirContent), labels.ours, labels.theirs)
+	if result.Conflicts > 0 {
+		conflict.Kind = "content"
+		if base == nil {
+			conflict.Kind = "add/add"
+		}
+		conflict.Message = fmt.Sprintf("Merge conflict in %s", path)
+		conflict.Content, conflict.Mode = []byte(result.Text), mode
+		return nil, conflict, nil
+	}
+
+	hash, err := storage.WriteBlob([]byte(result.Text))
+	if err != nil {
+		return nil, nil, err
+	}
+	return &storage.TreeEntry{Name: ours.Name, Hash: hash, Mode: mode}, nil, nil
+}
+
+// sameEntry reports whether two optional tree entries are identical
+func sameEntry(a, b *storage.TreeEntry) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Hash == b.Hash && a.Mode == b.Mode
+}
+
+// checkoutMergeResult writes a conflicted merge to the working directory. The index and
+// working tree get the cleanly merged files; conflicting paths are recorded as unmerged
+// in the index while their working files receive the content left for the user to resolve.
+func checkoutMergeResult(merged map[string]storage.TreeEntry, conflicts []mergeConflict) error {
+	if err := checkoutFiles(merged); err != nil {
+		return err
+	}
+	return recordConflicts(conflicts)
+}
+
+// recordConflicts writes the content of each conflicting path to the working directory
+// and stores its base, ours and theirs versions in the index stages
+func recordConflicts(conflicts []mergeConflict) error {
+	unmerged := make(map[string]storage.Conflict, len(conflicts))
+	for _, c := range conflicts {
+		if err := writeWorkingFile(c.Path, c.Content, c.Mode); err != nil {
+			return err
+		}
+		unmerged[c.Path] = storage.Conflict{
+			Base:   conflictStage(c.Base),
+			Ours:   conflictStage(c.Ours),
+			Theirs: conflictStage(c.Theirs),
+		}
+	}
+	return storage.RecordConflicts(unmerged)
+}
+
+// conflictStage converts one side of a conflict to an index entry
+func conflictStage(entry *storage.TreeEntry) *storage.IndexEntry {
+	if entry == nil {
+		return nil
+	}
+	return &storage.IndexEntry{Hash: entry.Hash, Mode: entry.Mode}
+}
+
+// unmergedPaths returns the sorted paths the index records as unmerged
+func unmergedPaths() ([]string, error) {
+	conflicts, err := storage.LoadIndexConflicts()
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(conflicts))
+	for path := range conflicts {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// ensureNoUnmergedPaths fails when the index still holds unresolved conflicts
+func ensureNoUnmergedPaths(action string) error {
+	paths, err := unmergedPaths()
+	if err != nil {
+		return err
+	}
+	if len(paths) > 0 {
 		return fmt.Errorf(
-			"fatal: Not possible to fast-forward, aborting.\n"+
-				"Merge commits are not supported. Please rebase '%s' onto the current branch",
-			branchToMerge,
+			"cannot %s, you have unmerged paths:\n\t%s\nfix them and run 'kitcat add <path>' to mark them resolved",
+			action, strings.Join(paths, "\n\t"),
 		)
 	}
+	return nil
+}
+
+// printConflicts reports each conflicting path of a merge
+func printConflicts(conflicts []mergeConflict) {
+	for _, c := range conflicts {
+		fmt.Printf("CONFLICT (%s): %s\n", c.Kind, c.Message)
+	}
+}
 
-	// Fast-Forward Execution
-	if err := UpdateBranchPointer(featureHeadHash); err != nil {
+// moveBranchAndCheckout points the current branch at target and updates the working
+// directory and index to match. If the checkout fails the branch is moved back to previous.
+// Both moves are recorded in the reflog with reason.
+func moveBranchAndCheckout(target, previous, reason string) error {
+	if err := UpdateBranchPointer(target, reason); err != nil {
 		return fmt.Errorf("failed to update branch pointer: %w", err)
 	}
 
 	// Update the working directory and index to match the new HEAD state
-	err = UpdateWorkspaceAndIndex(featureHeadHash)
+	err := UpdateWorkspaceAndIndex(target)
 	if err != nil {
 		// Attempt to roll back the branch pointer on failure
 		fmt.Printf("UpdateWorkspaceAndIndex failed: %v. Rolling back branch pointer...\n", err)
-		if rollbackErr := UpdateBranchPointer(currentHeadHash); rollbackErr != nil {
+		if rollbackErr := UpdateBranchPointer(previous, reason+" (rolled back)"); rollbackErr != nil {
 			return fmt.Errorf(
 				"failed to update workspace: %w; additionally failed to rollback branch pointer: %v",
 				err,
@@ -90,7 +445,7 @@ func Merge(branchToMerge string) error {
 		return fmt.Errorf(
 			"failed to update workspace: %w; branch pointer rolled back to %s",
 			err,
-			currentHeadHash,
+			previous,
 		)
 	}
 
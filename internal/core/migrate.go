@@ -0,0 +1,44 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// Migrate upgrades a repository created by an older kitcat to the current storage format.
+// It is idempotent: running it on an up-to-date repository does nothing.
+func Migrate() error {
+	if !IsRepoInitialized() {
+		return fmt.Errorf("not a kitcat repository (or any of the parent directories): .kitcat")
+	}
+
+	migrated, err := storage.MigrateCommitLog()
+	if err != nil {
+		return fmt.Errorf("failed to migrate commits.log: %w", err)
+	}
+	if migrated > 0 {
+		fmt.Printf("Migrated %d commit%s from commits.log to the object store\n", migrated, pluralize(migrated))
+	}
+
+	moved, err := storage.MigrateObjectLayout()
+	if err != nil {
+		return fmt.Errorf("failed to migrate object layout: %w", err)
+	}
+	if moved > 0 {
+		fmt.Printf("Moved %d object%s into fan-out directories\n", moved, pluralize(moved))
+	}
+
+	compressed, err := storage.MigrateLegacyObjects()
+	if err != nil {
+		return fmt.Errorf("failed to migrate objects: %w", err)
+	}
+	if compressed > 0 {
+		fmt.Printf("Compressed %d legacy object%s\n", compressed, pluralize(compressed))
+	}
+
+	if migrated == 0 && moved == 0 && compressed == 0 {
+		fmt.Println("Repository is already up to date")
+	}
+	return nil
+}
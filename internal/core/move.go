@@ -59,17 +59,11 @@ func MoveFile(oldPath, newPath string, force bool) error {
 		return err
 	}
 
-	// Load index
-	idx, err := storage.LoadIndex()
-	if err != nil {
-		return err
-	}
-
 	// Remove old file from index
-	delete(idx, oldPath)
-
-	// Write index
-	if err := storage.WriteIndex(idx); err != nil {
+	if err := storage.UpdateIndex(func(idx map[string]string) error {
+		delete(idx, oldPath)
+		return nil
+	}); err != nil {
 		return err
 	}
 
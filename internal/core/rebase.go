@@ -1,12 +1,12 @@
 package core
 
 import (
-	"crypto/sha1"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 
 	"github.com/LeeFred3042U/kitcat/internal/models"
@@ -41,9 +41,31 @@ func getEditor() (string, []string, error) {
 	return "", nil, fmt.Errorf("no suitable editor found (checked code, nano, micro, vim)")
 }
 
+// runEditor opens path in the user's editor and waits for it to exit
+func runEditor(path string) error {
+	editor, editorArgs, err := getEditor()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(editor, append(editorArgs, path)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to run editor: %w", err)
+	}
+	return nil
+}
+
 // RebaseInteractive starts an interactive rebase onto the specified commit
 // returns an error if any operation fails
 func RebaseInteractive(commitHash string) error {
+	return RebaseInteractiveWith(commitHash, false)
+}
+
+// RebaseInteractiveWith is RebaseInteractive that skips the pre-rebase hook when noVerify
+// is set. The hook is given commitHash and can stop the rebase before anything changes.
+func RebaseInteractiveWith(commitHash string, noVerify bool) error {
 	if !IsRepoInitialized() {
 		return fmt.Errorf("not a kitcat repository")
 	}
@@ -56,10 +78,15 @@ func RebaseInteractive(commitHash string) error {
 		return fmt.Errorf("cannot rebase: you have unstaged changes")
 	}
 
-	ontoCommit, err := storage.FindCommit(commitHash)
+	ontoCommit, err := resolveCommit(commitHash)
 	if err != nil {
 		return fmt.Errorf("invalid base commit '%s': %w", commitHash, err)
 	}
+	if !noVerify {
+		if err := runHook(hookPreRebase, nil, commitHash); err != nil {
+			return fmt.Errorf("%w; the rebase was not started", err)
+		}
+	}
 
 	headState, err := GetHeadState()
 	if err != nil {
@@ -89,19 +116,13 @@ func RebaseInteractive(commitHash string) error {
 		return err
 	}
 
-	editor, editorArgs, err := getEditor()
+	editor, _, err := getEditor()
 	if err != nil {
 		return err
 	}
-
-	cmdArgs := append(editorArgs, todoPath)
-	cmd := exec.Command(editor, cmdArgs...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
 	fmt.Printf("Opening editor (%s) to modify rebase todo list...\n", editor)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to run editor: %w", err)
+	if err := runEditor(todoPath); err != nil {
+		return err
 	}
 
 	newTodoContent, err := os.ReadFile(todoPath)
@@ -129,16 +150,15 @@ func RebaseInteractive(commitHash string) error {
 	// This branch will be used as the new HEAD during the rebase
 	// It will be deleted after the rebase completes or is aborted
 	tmpBranch := "kitcat-rebase-tmp"
-	tmpBranchPath := filepath.Join(".kitcat", "refs", "heads", tmpBranch)
-	if err := os.MkdirAll(filepath.Dir(tmpBranchPath), 0o755); err != nil {
-		return err
-	}
-	if err := os.WriteFile(tmpBranchPath, []byte(ontoCommit.ID), 0o644); err != nil {
+	if err := updateRef("refs/heads/"+tmpBranch, ontoCommit.ID, "rebase -i (start)"); err != nil {
 		return err
 	}
 	if err := os.WriteFile(".kitcat/HEAD", []byte("ref: refs/heads/"+tmpBranch), 0o644); err != nil {
 		return fmt.Errorf("failed to update HEAD: %w", err)
 	}
+	if err := appendReflog(headRef, headHash, ontoCommit.ID, "rebase -i (start): checkout "+commitHash); err != nil {
+		return err
+	}
 	if err := UpdateWorkspaceAndIndex(ontoCommit.ID); err != nil {
 		return fmt.Errorf("failed to checkout base: %w", err)
 	}
@@ -175,7 +195,7 @@ func RebaseContinue() error {
 	switch cmd {
 	case "pick", "reword":
 		msg := originalCommit.Message
-		_, _, err := Commit(msg)
+		_, _, err := commitAs(msg, originalCommit)
 		if err != nil {
 			if strings.Contains(err.Error(), "nothing to commit") {
 				fmt.Println("Nothing to commit. Skipping step.")
@@ -222,23 +242,16 @@ func RebaseAbort() error {
 
 	fmt.Printf("Aborting rebase. restoring HEAD to %s\n", state.OrigHead[:7])
 
-	if state.HeadName != "" {
-		if err := os.WriteFile(".kitcat/HEAD", []byte("ref: "+state.HeadName), 0o644); err != nil {
-			return err
-		}
-		if err := os.WriteFile(filepath.Join(".kitcat", state.HeadName), []byte(state.OrigHead), 0o644); err != nil {
-			return err
-		}
-		if err := UpdateWorkspaceAndIndex(state.OrigHead); err != nil {
-			return err
-		}
-	} else {
-		if err := Reset(state.OrigHead, "hard"); err != nil {
-			return err
-		}
+	if err := restoreRebaseHead(state, state.OrigHead, "rebase -i (abort)"); err != nil {
+		return err
+	}
+	if err := UpdateWorkspaceAndIndex(state.OrigHead); err != nil {
+		return err
 	}
 
-	os.Remove(filepath.Join(".kitcat", "refs", "heads", "kitcat-rebase-tmp"))
+	if err := removeRebaseTmpBranch(); err != nil {
+		return err
+	}
 	return ClearRebaseState()
 }
 
@@ -303,18 +316,38 @@ func finishRebase(state *RebaseState) error {
 		return err
 	}
 
-	if state.HeadName != "" {
-		if err := os.WriteFile(".kitcat/HEAD", []byte("ref: "+state.HeadName), 0o644); err != nil {
-			return err
-		}
-		refPath := filepath.Join(".kitcat", state.HeadName)
-		if err := os.WriteFile(refPath, []byte(headHash), 0o644); err != nil {
+	if err := restoreRebaseHead(state, headHash, "rebase -i (finish)"); err != nil {
+		return err
+	}
+
+	if err := removeRebaseTmpBranch(); err != nil {
+		return err
+	}
+	return ClearRebaseState()
+}
+
+// restoreRebaseHead leaves the temporary rebase branch: HEAD is attached again to the
+// branch being rebased, which is moved to hash, or detached at hash if the rebase
+// started from a detached HEAD. action prefixes the reflog message.
+func restoreRebaseHead(state *RebaseState, hash, action string) error {
+	if state.HeadName == "" {
+		previous, _ := readHead()
+		if err := os.WriteFile(".kitcat/HEAD", []byte(hash), 0o644); err != nil {
 			return err
 		}
+		return appendReflog(headRef, previous, hash, action+": returning to "+hash)
+	}
+
+	if err := os.WriteFile(".kitcat/HEAD", []byte("ref: "+state.HeadName), 0o644); err != nil {
+		return err
 	}
+	return updateRef(state.HeadName, hash, action+": returning to "+state.HeadName)
+}
 
+// removeRebaseTmpBranch deletes the temporary rebase branch and its reflog
+func removeRebaseTmpBranch() error {
 	os.Remove(filepath.Join(".kitcat", "refs", "heads", "kitcat-rebase-tmp"))
-	return ClearRebaseState()
+	return storage.DeleteReflog("refs/heads/kitcat-rebase-tmp")
 }
 
 // executePick applies the changes from the commit with the given hash onto the current HEAD
@@ -354,106 +387,130 @@ func cherryPick(hash string, noCommit bool) error {
 	if err != nil {
 		return err
 	}
-	parentHash := commit.Parent
+	parentHash := commit.FirstParent()
 	changes, err := getChanges(parentHash, hash)
 	if err != nil {
 		return err
 	}
-	if err := applyChanges(changes); err != nil {
+	label := fmt.Sprintf("%s (%s)", shortHash(commit.ID), firstLine(commit.Message))
+	if err := applyChanges(changes, mergeLabels{ours: "HEAD", theirs: label}); err != nil {
 		return err
 	}
 	if noCommit {
 		return nil
 	}
-	_, _, err = Commit(commit.Message)
-	if err != nil && strings.Contains(err.Error(), "nothing to commit") {
-		return nil
+	if _, _, err := commitAs(commit.Message, commit); err != nil && !isNothingToCommit(err) {
+		return err
 	}
-	return err
+	return nil
 }
 
+// Change is the transition of one path between a commit and its parent.
+// Old and New are nil when the path is absent on that side.
 type Change struct {
-	OldHash string
-	NewHash string
+	Old *storage.TreeEntry
+	New *storage.TreeEntry
 }
 
 // getChanges computes the changes between parentHash and childHash
-// returns a map of file paths to their old and new hashes
+// returns a map of file paths to their old and new entries
 func getChanges(parentHash, childHash string) (map[string]Change, error) {
-	parentTree := make(map[string]string)
+	parentTree := make(map[string]storage.TreeEntry)
 	if parentHash != "" {
-		pC, err := storage.FindCommit(parentHash)
-		if err == nil {
-			parentTree, _ = storage.ParseTree(pC.TreeHash)
+		var err error
+		if parentTree, err = commitFiles(parentHash); err != nil {
+			return nil, err
 		}
 	}
-
-	childCommit, err := storage.FindCommit(childHash)
-	if err != nil {
-		return nil, err
-	}
-	childTree, err := storage.ParseTree(childCommit.TreeHash)
+	childTree, err := commitFiles(childHash)
 	if err != nil {
 		return nil, err
 	}
 
 	changes := make(map[string]Change)
-	for path, hash := range childTree {
-		if pHash, ok := parentTree[path]; !ok || pHash != hash {
-			changes[path] = Change{OldHash: parentTree[path], NewHash: hash}
+	for path := range childTree {
+		if old, ok := parentTree[path]; !ok || old != childTree[path] {
+			changes[path] = Change{Old: lookupEntry(parentTree, path), New: lookupEntry(childTree, path)}
 		}
 	}
 	for path := range parentTree {
 		if _, ok := childTree[path]; !ok {
-			changes[path] = Change{OldHash: parentTree[path], NewHash: ""}
+			changes[path] = Change{Old: lookupEntry(parentTree, path)}
 		}
 	}
 	return changes, nil
 }
 
-// applyChanges applies the given changes to the working directory and index
-// returns an error if any conflicts are detected
-func applyChanges(changes map[string]Change) error {
-	headCommit, _ := GetHeadCommit()
-	headTree, _ := storage.ParseTree(headCommit.TreeHash)
-
-	for path, change := range changes {
-		targetHash := change.NewHash
-		if targetHash == "" {
-			headFileHash, existsInHead := headTree[path]
-			if existsInHead && headFileHash != change.OldHash {
-				return fmt.Errorf(
-					"conflict in %s: deleted in incoming commit, but modified in HEAD",
-					path,
-				)
-			}
+// applyChanges applies the given changes to the working directory and index, merging
+// them line by line with the staged files where both touched the same file. Conflicting
+// files are left in the working directory with conflict markers, and an error lists them.
+func applyChanges(changes map[string]Change, labels mergeLabels) error {
+	if err := ensureNoUnmergedPaths("apply changes"); err != nil {
+		return err
+	}
+	// Merge into the index rather than HEAD, so changes applied without committing accumulate
+	entries, err := storage.LoadIndexEntries()
+	if err != nil {
+		return err
+	}
+	headTree := make(map[string]storage.TreeEntry, len(entries))
+	for path, entry := range entries {
+		mode := entry.Mode
+		if mode == "" {
+			mode = storage.FileMode(path)
+		}
+		headTree[path] = storage.TreeEntry{Name: filepath.Base(path), Hash: entry.Hash, Mode: mode}
+	}
+
+	paths := make([]string, 0, len(changes))
+	for path := range changes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var conflicts []mergeConflict
+	for _, path := range paths {
+		change := changes[path]
+		head := lookupEntry(headTree, path)
+		result, conflict, err := mergePath(path, change.Old, head, change.New, labels)
+		if err != nil {
+			return err
+		}
+		if conflict != nil {
+			conflicts = append(conflicts, *conflict)
+			continue
+		}
+		if sameEntry(result, head) {
+			continue
+		}
+		if result == nil {
 			if err := RemoveFile(path, false); err != nil {
 				return err
 			}
-		} else {
-			content, err := storage.ReadObject(targetHash)
-			if err != nil {
-				return err
-			}
-			headFileHash, existsInHead := headTree[path]
-			if existsInHead {
-				if headFileHash != change.OldHash {
-					return fmt.Errorf("conflict in %s: modified in incoming commit, but modified in HEAD", path)
-				}
-			} else if change.OldHash != "" {
-				return fmt.Errorf("conflict in %s: modified in incoming commit, but deleted in HEAD", path)
-			}
+			continue
+		}
+		content, err := storage.ReadBlob(result.Hash)
+		if err != nil {
+			return err
+		}
+		if err := writeWorkingFile(path, content, result.Mode); err != nil {
+			return err
+		}
+		if err := AddFile(path); err != nil {
+			return err
+		}
+	}
 
-			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
-				return err
-			}
-			if err := os.WriteFile(path, content, 0o644); err != nil {
-				return err
-			}
-			if err := AddFile(path); err != nil {
-				return err
-			}
+	if len(conflicts) > 0 {
+		if err := recordConflicts(conflicts); err != nil {
+			return err
 		}
+		printConflicts(conflicts)
+		names := make([]string, len(conflicts))
+		for i, c := range conflicts {
+			names[i] = c.Path
+		}
+		return fmt.Errorf("could not apply changes cleanly; conflicts in: %s", strings.Join(names, ", "))
 	}
 	return nil
 }
@@ -463,7 +520,7 @@ func generateTodo(hashes []string) string {
 	var sb strings.Builder
 	for _, h := range hashes {
 		c, _ := storage.FindCommit(h)
-		sb.WriteString(fmt.Sprintf("pick %s %s\n", h, c.Message))
+		sb.WriteString(fmt.Sprintf("pick %s %s\n", h, firstLine(c.Message)))
 	}
 	sb.WriteString("\n# Commands:\n")
 	sb.WriteString("# p, pick <commit> = use commit\n")
@@ -488,30 +545,36 @@ func parseTodo(content string) []string {
 	return steps
 }
 
-// getCommitsBetween returns a list of commit hashes from start (exclusive) to end (inclusive)
-// in chronological order
+// getCommitsBetween returns the hashes of commits reachable from end but not from start,
+// oldest first, so every commit comes after its parents. Merge commits are left out:
+// replaying their first-parent changes would duplicate the commits they merged in.
 func getCommitsBetween(start, end string) ([]string, error) {
-	var chain []string
-	curr := end
-	for curr != "" {
-		if curr == start {
-			break
-		}
-		chain = append(chain, curr)
-		c, err := storage.FindCommit(curr)
-		if err != nil {
+	excluded := make(map[string]bool)
+	if start != "" {
+		if err := storage.WalkCommits(start, func(c models.Commit) bool {
+			excluded[c.ID] = true
+			return true
+		}); err != nil {
 			return nil, err
 		}
-		if c.Parent == "" {
-			if start == "" {
-				return chain, nil
-			}
-			break
+	}
+
+	var included []models.Commit
+	if err := storage.WalkCommits(end, func(c models.Commit) bool {
+		if !excluded[c.ID] {
+			included = append(included, c)
 		}
-		curr = c.Parent
+		return true
+	}); err != nil {
+		return nil, err
 	}
-	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
-		chain[i], chain[j] = chain[j], chain[i]
+
+	ordered := topoOrder(included)
+	chain := make([]string, 0, len(ordered))
+	for _, c := range ordered {
+		if !c.IsMerge() {
+			chain = append(chain, c.ID)
+		}
 	}
 	return chain, nil
 }
@@ -519,24 +582,17 @@ func getCommitsBetween(start, end string) ([]string, error) {
 // promptForMessage opens the user's editor to edit the commit message, starting with defaultMsg
 // and returns the edited message
 func promptForMessage(defaultMsg string) string {
-	tmp := ".kitcat/COMMIT_EDITMSG"
+	tmp := CommitEditMsgPath
 	if err := os.WriteFile(tmp, []byte(defaultMsg), 0o644); err != nil {
 		fmt.Printf("Warning: failed to write temp commit msg: %v\n", err)
 	}
 
-	editor, editorArgs, err := getEditor()
-	if err != nil {
+	if _, _, err := getEditor(); err != nil {
 		fmt.Printf("Warning: %v. Using default message.\n", err)
 		return defaultMsg
 	}
-
-	cmdArgs := append(editorArgs, tmp)
-	cmd := exec.Command(editor, cmdArgs...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		fmt.Printf("Warning: editor exited with error: %v\n", err)
+	if err := runEditor(tmp); err != nil {
+		fmt.Printf("Warning: %v\n", err)
 	}
 
 	out, _ := os.ReadFile(tmp)
@@ -550,48 +606,26 @@ func amendCommitMessage(commitID, newVal string) error {
 	if err != nil {
 		return err
 	}
-	parentBlock := ""
-	if c.Parent != "" {
-		parentBlock = "parent " + c.Parent + "\n"
-	}
-	content := fmt.Sprintf("tree %s\n%s\n%s", c.TreeHash, parentBlock, newVal)
-	newHash, err := saveObject([]byte(content))
-	if err != nil {
-		return err
-	}
-	return UpdateBranchPointer(newHash)
+	return replaceCommit(c, c.TreeHash, newVal, "rebase -i (reword)")
 }
 
-// amendCommit creates a new commit with the same tree and parent as prevHead but with newMsg
-// and updates the current branch to point to it
+// amendCommit creates a new commit with the current index as its tree, the same parent as prevHead
+// and newMsg as its message, then updates the current branch to point to it
 func amendCommit(prevHead models.Commit, newMsg string) error {
 	treeHash, err := storage.CreateTree()
 	if err != nil {
 		return err
 	}
-	parentBlock := ""
-	if prevHead.Parent != "" {
-		parentBlock = "parent " + prevHead.Parent + "\n"
-	}
-	content := fmt.Sprintf("tree %s\n%s\n%s", treeHash, parentBlock, newMsg)
-	newHash, err := saveObject([]byte(content))
-	if err != nil {
-		return err
-	}
-	return UpdateBranchPointer(newHash)
+	return replaceCommit(prevHead, treeHash, newMsg, "rebase -i (squash)")
 }
 
-// saveObject saves the given content as an object and returns its hash
-func saveObject(content []byte) (string, error) {
-	h := sha1.New()
-	h.Write(content)
-	hash := fmt.Sprintf("%x", h.Sum(nil))
-	objPath := filepath.Join(".kitcat", "objects", hash)
-	if err := os.MkdirAll(filepath.Dir(objPath), 0o755); err != nil {
-		return "", err
-	}
-	if err := os.WriteFile(objPath, content, 0o644); err != nil {
-		return "", err
+// replaceCommit stores a copy of base with the given tree and message
+// and moves the current branch to the new commit, logging the move as action
+func replaceCommit(base models.Commit, treeHash, msg, action string) error {
+	by := identity{Name: base.AuthorName, Email: base.AuthorEmail, When: base.Timestamp}
+	replacement, err := newCommit(treeHash, base.Parents, msg, by)
+	if err != nil {
+		return err
 	}
-	return hash, nil
+	return UpdateBranchPointer(replacement.ID, action+": "+firstLine(msg))
 }
@@ -1,7 +1,9 @@
 package core
 
 import (
+	"os"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -53,3 +55,57 @@ func TestParseTodo(t *testing.T) {
 		})
 	}
 }
+
+// commitContent writes content to path, stages it and commits it, returning the commit ID
+func commitContent(t *testing.T, path, content, message string) string {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddFile(path); err != nil {
+		t.Fatal(err)
+	}
+	commit, _, err := Commit(message)
+	if err != nil {
+		t.Fatalf("commit %q failed: %v", message, err)
+	}
+	return commit.ID
+}
+
+func TestCherryPick_MergesEditsToTheSameFile(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitContent(t, "file.txt", "a\nb\nc\nd\n", "base")
+	if err := CreateBranch("topic"); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckoutBranch("topic"); err != nil {
+		t.Fatal(err)
+	}
+	clean := commitContent(t, "file.txt", "a\nb\nc\nD\n", "edit last line")
+	clash := commitContent(t, "file.txt", "a\nb\nc\ntopic\n", "rewrite last line")
+
+	if err := CheckoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	commitContent(t, "file.txt", "A\nb\nc\nd\n", "edit first line")
+
+	if err := cherryPick(clean, false); err != nil {
+		t.Fatalf("cherryPick of a non-overlapping edit failed: %v", err)
+	}
+	if data, _ := os.ReadFile("file.txt"); string(data) != "A\nb\nc\nD\n" {
+		t.Errorf("edits were not merged, file.txt = %q", data)
+	}
+
+	commitContent(t, "file.txt", "A\nb\nc\nmain\n", "rewrite last line on main")
+	err := cherryPick(clash, false)
+	if err == nil || !strings.Contains(err.Error(), "file.txt") {
+		t.Fatalf("expected a conflict in file.txt, got %v", err)
+	}
+	data, _ := os.ReadFile("file.txt")
+	want := "A\nb\nc\n<<<<<<< HEAD\nmain\n=======\ntopic\n>>>>>>> " + clash[:7] + " (rewrite last line)\n"
+	if string(data) != want {
+		t.Errorf("file.txt = %q, want %q", data, want)
+	}
+}
@@ -0,0 +1,144 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// headRef is the name HEAD's own reflog is kept under
+const headRef = "HEAD"
+
+// updateRef points ref (e.g. "refs/heads/main") at hash and records the update with reason
+func updateRef(ref, hash, reason string) error {
+	old, _ := readCommitHash(ref)
+	refFile := filepath.Join(RepoDir, ref)
+	if err := os.MkdirAll(filepath.Dir(refFile), 0o755); err != nil {
+		return err
+	}
+	if err := SafeWrite(refFile, []byte(hash), 0o644); err != nil {
+		return err
+	}
+	return logRefUpdate(ref, old, hash, reason)
+}
+
+// logRefUpdate records that ref moved from old to new in the reflog of ref and, when HEAD
+// is attached to ref, in the reflog of HEAD as well
+func logRefUpdate(ref, old, new, reason string) error {
+	if err := appendReflog(ref, old, new, reason); err != nil {
+		return err
+	}
+	if head, err := readHEAD(); err == nil && head == ref {
+		return appendReflog(headRef, old, new, reason)
+	}
+	return nil
+}
+
+// appendReflog records a single update of ref, attributed to the committer
+func appendReflog(ref, old, new, reason string) error {
+	who, _ := committerIdent()
+	err := storage.AppendReflog(ref, storage.ReflogEntry{
+		Old:     old,
+		New:     new,
+		Name:    who.Name,
+		Email:   who.Email,
+		Time:    time.Now(),
+		Message: reason,
+	})
+	if err != nil {
+		return fmt.Errorf("could not write reflog of %s: %w", ref, err)
+	}
+	return nil
+}
+
+// describeHead names what HEAD points at for reflog messages: the branch, or the commit
+// when it is detached
+func describeHead() string {
+	if ref, err := readHEAD(); err == nil {
+		return strings.TrimPrefix(ref, "refs/heads/")
+	}
+	hash, _ := readHead()
+	return hash
+}
+
+// Reflog prints the recorded updates of ref, newest first. ref is "HEAD" or a branch name;
+// an empty ref means HEAD.
+func Reflog(ref string) error {
+	if !IsRepoInitialized() {
+		return fmt.Errorf("not a kitcat repository (or any of the parent directories): .kitcat")
+	}
+	if ref == "" {
+		ref = headRef
+	}
+	logRef, err := reflogRef(ref)
+	if err != nil {
+		return err
+	}
+	entries, err := storage.ReadReflog(logRef)
+	if err != nil {
+		return err
+	}
+	for i, entry := range entries {
+		fmt.Printf("%s%s%s %s@{%d}: %s\n", colorYellow, shortHash(entry.New), colorReset, ref, i, entry.Message)
+	}
+	return nil
+}
+
+// reflogRef maps a name used on the command line to the ref whose log records it.
+// "HEAD" is HEAD itself, an empty name is the current branch, and anything else is a branch
+// or a remote-tracking branch such as origin/main.
+func reflogRef(name string) (string, error) {
+	switch {
+	case name == headRef:
+		return headRef, nil
+	case name == "":
+		ref, err := readHEAD()
+		if err != nil {
+			return "", fmt.Errorf("HEAD is detached; use HEAD@{n} instead of @{n}")
+		}
+		return ref, nil
+	case strings.HasPrefix(name, "refs/"):
+		return name, nil
+	case IsBranch(name):
+		return "refs/heads/" + name, nil
+	}
+	if _, ok := lookupRef("refs/remotes/" + name); ok {
+		return "refs/remotes/" + name, nil
+	}
+	return "", fmt.Errorf("unknown ref '%s'", name)
+}
+
+// parseReflogRevision splits "<ref>@{<n>}" into its ref and index
+func parseReflogRevision(rev string) (string, int, bool) {
+	at := strings.LastIndex(rev, "@{")
+	if at < 0 || !strings.HasSuffix(rev, "}") {
+		return "", 0, false
+	}
+	n, err := strconv.Atoi(rev[at+2 : len(rev)-1])
+	if err != nil || n < 0 {
+		return "", 0, false
+	}
+	return rev[:at], n, true
+}
+
+// reflogCommit returns the commit the ref named by name pointed at n updates ago
+func reflogCommit(name string, n int) (models.Commit, error) {
+	ref, err := reflogRef(name)
+	if err != nil {
+		return models.Commit{}, err
+	}
+	entries, err := storage.ReadReflog(ref)
+	if err != nil {
+		return models.Commit{}, err
+	}
+	if n >= len(entries) {
+		return models.Commit{}, fmt.Errorf("log for '%s' only has %d entries", ref, len(entries))
+	}
+	return storage.FindCommit(entries[n].New)
+}
@@ -0,0 +1,497 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// remotesDir holds the remote-tracking refs: refs/remotes/<remote>/<branch> records where
+// <branch> of <remote> pointed at the last fetch or push
+const remotesDir = ".kitcat/refs/remotes"
+
+// remoteURLKey is the config key a remote's location is stored under
+func remoteURLKey(name string) string {
+	return "remote." + name + ".url"
+}
+
+// repoPath turns a remote location into the absolute path of a kitcat repository
+func repoPath(url string) (string, error) {
+	path, err := filepath.Abs(strings.TrimPrefix(url, "file://"))
+	if err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(filepath.Join(path, RepoDir)); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("'%s' does not appear to be a kitcat repository", url)
+	}
+	return path, nil
+}
+
+// AddRemote records the repository at url under name. url is a path to a repository or
+// a bundle file, or an http:// URL.
+func AddRemote(name, url string) error {
+	if !IsValidRefName(name) {
+		return fmt.Errorf("'%s' is not a valid remote name", name)
+	}
+	if _, ok, err := GetConfig(remoteURLKey(name)); err != nil {
+		return err
+	} else if ok {
+		return fmt.Errorf("remote %s already exists", name)
+	}
+	location, err := remoteLocation(url)
+	if err != nil {
+		return err
+	}
+	return SetConfig(remoteURLKey(name), location, false)
+}
+
+// RemoveRemote forgets the remote and deletes its remote-tracking refs
+func RemoveRemote(name string) error {
+	if _, err := remoteURL(name); err != nil {
+		return err
+	}
+	if err := UnsetConfig(remoteURLKey(name), false); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(remotesDir, name)); err != nil {
+		return err
+	}
+	return storage.DeleteReflog("refs/remotes/" + name)
+}
+
+// remoteNames returns the configured remotes, sorted, with their locations
+func remoteNames() ([]string, map[string]string, error) {
+	path, err := getLocalConfigPath()
+	if err != nil {
+		return nil, nil, err
+	}
+	config, err := readConfigFromPath(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	urls := make(map[string]string)
+	var names []string
+	for key, value := range config {
+		name, isRemote := strings.CutPrefix(key, "remote.")
+		if name, isURL := strings.CutSuffix(name, ".url"); isRemote && isURL && name != "" {
+			names = append(names, name)
+			urls[name] = value
+		}
+	}
+	sort.Strings(names)
+	return names, urls, nil
+}
+
+// ListRemotes prints the configured remotes, with their locations if verbose
+func ListRemotes(verbose bool) error {
+	names, urls, err := remoteNames()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if verbose {
+			fmt.Printf("%s\t%s\n", name, urls[name])
+		} else {
+			fmt.Println(name)
+		}
+	}
+	return nil
+}
+
+// remoteURL returns the location of a configured remote
+func remoteURL(name string) (string, error) {
+	url, ok, err := GetConfig(remoteURLKey(name))
+	if err != nil {
+		return "", err
+	}
+	if !ok || url == "" {
+		return "", fmt.Errorf("no such remote '%s'", name)
+	}
+	return url, nil
+}
+
+// inRepo runs fn with the repository at dir as the current one. Every path kitcat uses
+// is relative to the repository root, so this is how objects and refs of another
+// repository on disk are read and written.
+func inRepo(dir string, fn func() error) (err error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if err := os.Chdir(dir); err != nil {
+		return fmt.Errorf("cannot open repository %s: %w", dir, err)
+	}
+	defer func() {
+		if cdErr := os.Chdir(cwd); cdErr != nil && err == nil {
+			err = cdErr
+		}
+	}()
+	return fn()
+}
+
+// readRefs returns every ref below the given prefixes (such as "refs/heads") with the
+// commit it points to. Refs without a commit yet are left out.
+func readRefs(prefixes ...string) (map[string]string, error) {
+	refs := make(map[string]string)
+	for _, prefix := range prefixes {
+		root := filepath.Join(RepoDir, filepath.FromSlash(prefix))
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			// Skip directories and temporary files left by an interrupted SafeWrite
+			if d.IsDir() || strings.HasPrefix(d.Name(), "atomic-") {
+				return nil
+			}
+			rel, err := filepath.Rel(RepoDir, path)
+			if err != nil {
+				return err
+			}
+			ref := filepath.ToSlash(rel)
+			hash, err := readCommitHash(ref)
+			if err != nil {
+				return err
+			}
+			if hash != "" {
+				refs[ref] = hash
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return refs, nil
+}
+
+// transferObject is an object on its way between two repositories
+type transferObject struct {
+	name string
+	obj  storage.Object
+}
+
+// readObjects loads the named objects from the current repository
+func readObjects(names []string) ([]transferObject, error) {
+	objects := make([]transferObject, 0, len(names))
+	for _, name := range names {
+		obj, err := storage.ReadObject(name)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, transferObject{name: name, obj: obj})
+	}
+	return objects, nil
+}
+
+// storeObjects writes objects into the current repository
+func storeObjects(objects []transferObject) error {
+	for _, o := range objects {
+		if err := storage.StoreObject(o.name, o.obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fetch copies the branches and tags of a remote, with every object they need that is
+// missing here. Branches are stored as refs/remotes/<remote>/<branch>; tags that do not
+// exist locally are created.
+func Fetch(remote string) error {
+	url, err := remoteURL(remote)
+	if err != nil {
+		return err
+	}
+	t, err := openTransport(url)
+	if err != nil {
+		return err
+	}
+	adv, err := t.advertise()
+	if err != nil {
+		return fmt.Errorf("could not read from remote '%s': %w", remote, err)
+	}
+	refs := adv.Refs
+
+	// Having a commit means having its whole history, so only missing tips are wanted
+	var wants []string
+	for _, hash := range refs {
+		if !storage.HasObject(hash) && !slices.Contains(wants, hash) {
+			wants = append(wants, hash)
+		}
+	}
+	if len(wants) > 0 {
+		haves, err := refTips()
+		if err != nil {
+			return err
+		}
+		objects, err := t.fetch(wants, haves)
+		if err != nil {
+			return fmt.Errorf("could not read from remote '%s': %w", remote, err)
+		}
+		if err := storeObjects(objects); err != nil {
+			return err
+		}
+		have, err := commitClosure(haves)
+		if err != nil {
+			return err
+		}
+		if _, err := storage.ReachableObjects(wants, func(commit string) bool { return have[commit] }); err != nil {
+			return fmt.Errorf("remote '%s' sent an incomplete history: %w", remote, err)
+		}
+	}
+
+	names := make([]string, 0, len(refs))
+	for ref := range refs {
+		names = append(names, ref)
+	}
+	sort.Strings(names)
+
+	printedHeader := false
+	report := func(format string, args ...any) {
+		if !printedHeader {
+			fmt.Printf("From %s\n", url)
+			printedHeader = true
+		}
+		fmt.Printf(format, args...)
+	}
+	for _, ref := range names {
+		hash := refs[ref]
+		if !storage.HasObject(hash) {
+			return fmt.Errorf("remote '%s' did not send commit %s for %s", remote, hash, ref)
+		}
+		if tag, ok := strings.CutPrefix(ref, "refs/tags/"); ok {
+			if _, exists := lookupRef("refs/tags/" + tag); exists || !IsValidRefName(tag) {
+				continue
+			}
+			if err := os.MkdirAll(tagsDir, 0o755); err != nil {
+				return err
+			}
+			if err := SafeWrite(filepath.Join(tagsDir, tag), []byte(hash), 0o644); err != nil {
+				return err
+			}
+			report(" * %-18s %-10s -> %s\n", "[new tag]", tag, tag)
+			continue
+		}
+
+		branch, ok := strings.CutPrefix(ref, "refs/heads/")
+		if !ok || !IsValidRefName(branch) {
+			continue
+		}
+		tracking := "refs/remotes/" + remote + "/" + branch
+		short := remote + "/" + branch
+		old, _ := readCommitHash(tracking)
+		switch {
+		case old == hash:
+			continue
+		case old == "":
+			if err := updateRef(tracking, hash, "fetch: storing head"); err != nil {
+				return err
+			}
+			report(" * %-18s %-10s -> %s\n", "[new branch]", branch, short)
+		default:
+			fastForward, _ := storage.IsAncestor(old, hash)
+			reason, sep, flag := "fetch: fast-forward", "..", " "
+			if !fastForward {
+				reason, sep, flag = "fetch: forced-update", "...", "+"
+			}
+			if err := updateRef(tracking, hash, reason); err != nil {
+				return err
+			}
+			report(" %s %-18s %-10s -> %s\n", flag, shortHash(old)+sep+shortHash(hash), branch, short)
+		}
+	}
+	return nil
+}
+
+// Push sends a local branch and the objects it needs to the same branch of a remote.
+// The remote branch must be an ancestor of the local one unless force is set. When the
+// branch is checked out in the remote repository its working directory is updated too,
+// which is refused if it has uncommitted changes. An empty branch means the current one.
+func Push(remote, branch string, force bool) error {
+	url, err := remoteURL(remote)
+	if err != nil {
+		return err
+	}
+	if branch == "" {
+		head, err := readHEAD()
+		if err != nil {
+			return errors.New("you are not currently on a branch; name the branch to push")
+		}
+		branch = strings.TrimPrefix(head, "refs/heads/")
+	}
+	ref := "refs/heads/" + branch
+	local, _ := readCommitHash(ref)
+	if !IsValidRefName(branch) || local == "" {
+		return fmt.Errorf("src refspec %s does not match any commit", branch)
+	}
+
+	t, err := openTransport(url)
+	if err != nil {
+		return err
+	}
+	adv, err := t.advertise()
+	if err != nil {
+		return fmt.Errorf("could not read from remote '%s': %w", remote, err)
+	}
+	remoteOld := adv.Refs[ref]
+
+	tracking := "refs/remotes/" + remote + "/" + branch
+	if remoteOld == local {
+		fmt.Println("Everything up-to-date")
+		if old, _ := readCommitHash(tracking); old == local {
+			return nil
+		}
+		return updateRef(tracking, local, "update by push")
+	}
+	forced := false
+	if remoteOld != "" {
+		fastForward := storage.HasObject(remoteOld)
+		if fastForward {
+			fastForward, _ = storage.IsAncestor(remoteOld, local)
+		}
+		if !fastForward && !force {
+			return fmt.Errorf(
+				"rejected %s -> %s (non-fast-forward)\nthe remote branch has commits you do not have; pull them first, or use --force to overwrite them",
+				branch, branch,
+			)
+		}
+		forced = !fastForward
+	}
+
+	// The remote has the history of every ref it advertised that is also known here
+	var remoteTips []string
+	for _, hash := range adv.Refs {
+		remoteTips = append(remoteTips, hash)
+	}
+	remoteKnown, err := commitClosure(remoteTips)
+	if err != nil {
+		return err
+	}
+	names, err := storage.ReachableObjects([]string{local}, func(commit string) bool { return remoteKnown[commit] })
+	if err != nil {
+		return err
+	}
+	objects, err := readObjects(names)
+	if err != nil {
+		return err
+	}
+
+	update := refUpdate{Ref: ref, Old: remoteOld, New: local, Force: force}
+	if err := t.push(update, objects); err != nil {
+		return fmt.Errorf("failed to push to '%s': %w", remote, err)
+	}
+	if err := updateRef(tracking, local, "update by push"); err != nil {
+		return err
+	}
+
+	fmt.Printf("To %s\n", url)
+	switch {
+	case remoteOld == "":
+		fmt.Printf(" * %-18s %s -> %s\n", "[new branch]", branch, branch)
+	case forced:
+		fmt.Printf(" + %-18s %s -> %s (forced update)\n", shortHash(remoteOld)+"..."+shortHash(local), branch, branch)
+	default:
+		fmt.Printf("   %-18s %s -> %s\n", shortHash(remoteOld)+".."+shortHash(local), branch, branch)
+	}
+	return nil
+}
+
+// Pull fetches from a remote and merges its copy of branch into the current branch.
+// An empty branch means the branch of the same name as the current one.
+func Pull(remote, branch string) error {
+	if branch == "" {
+		head, err := readHEAD()
+		if err != nil {
+			return errors.New("you are not currently on a branch; name the branch to pull")
+		}
+		branch = strings.TrimPrefix(head, "refs/heads/")
+	}
+	if err := Fetch(remote); err != nil {
+		return err
+	}
+	tracking := remote + "/" + branch
+	theirs, ok := lookupRef("refs/remotes/" + tracking)
+	if !ok {
+		return fmt.Errorf("remote '%s' has no branch '%s'", remote, branch)
+	}
+
+	// A branch without commits simply takes the remote's history
+	if head, _ := readHead(); head == "" {
+		return moveBranchAndCheckout(theirs, "", "pull: initial")
+	}
+	return Merge(tracking)
+}
+
+// Clone creates a repository in dir with the repository at url as its "origin" remote,
+// fetches everything and checks out the branch the remote has checked out. An empty dir
+// means a directory named after the remote.
+func Clone(url, dir string) error {
+	src, err := remoteLocation(url)
+	if err != nil {
+		return err
+	}
+	if dir == "" {
+		dir = strings.TrimSuffix(path.Base(filepath.ToSlash(strings.TrimSuffix(src, "/"))), ".bundle")
+		if dir == "." || dir == "/" || strings.Contains(dir, ":") {
+			return fmt.Errorf("cannot guess a directory name from '%s'; please name one", url)
+		}
+	}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("destination path '%s' already exists and is not an empty directory", dir)
+	}
+
+	t, err := openTransport(src)
+	if err != nil {
+		return err
+	}
+	adv, err := t.advertise()
+	if err != nil {
+		return fmt.Errorf("could not read from '%s': %w", url, err)
+	}
+	defaultBranch := "main"
+	if branch, ok := strings.CutPrefix(adv.Head, "refs/heads/"); ok && IsValidRefName(branch) {
+		defaultBranch = branch
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	fmt.Printf("Cloning into '%s'...\n", dir)
+	return inRepo(dir, func() error {
+		if err := initRepo(false); err != nil {
+			return err
+		}
+		if err := AddRemote("origin", src); err != nil {
+			return err
+		}
+		if err := Fetch("origin"); err != nil {
+			return err
+		}
+		hash, ok := lookupRef("refs/remotes/origin/" + defaultBranch)
+		if !ok {
+			fmt.Println("warning: You appear to have cloned an empty repository.")
+			return nil
+		}
+
+		if defaultBranch != "main" {
+			if err := os.WriteFile(HeadPath, []byte("ref: refs/heads/"+defaultBranch), 0o644); err != nil {
+				return err
+			}
+			os.Remove(filepath.Join(HeadsDir, "main"))
+		}
+		if err := updateRef("refs/heads/"+defaultBranch, hash, "clone: from "+src); err != nil {
+			return err
+		}
+		return UpdateWorkspaceAndIndex(hash)
+	})
+}
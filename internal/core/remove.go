@@ -15,7 +15,16 @@ func RemoveFile(filename string, recursive bool) error {
 		return fmt.Errorf("unsafe path detected: %s", filename)
 	}
 
-	return storage.UpdateIndex(func(index map[string]string) error {
+	return storage.UpdateIndexState(func(entries map[string]storage.IndexEntry, conflicts map[string]storage.Conflict) error {
+		// Unmerged paths are tracked too; removing one resolves it as a deletion
+		index := make(map[string]bool, len(entries)+len(conflicts))
+		for path := range entries {
+			index[path] = true
+		}
+		for path := range conflicts {
+			index[path] = true
+		}
+
 		var filesToRemove []string
 
 		if recursive {
@@ -47,7 +56,8 @@ func RemoveFile(filename string, recursive bool) error {
 
 		// Step 2: Remove from index
 		for _, filePath := range filesToRemove {
-			delete(index, filePath)
+			delete(entries, filePath)
+			delete(conflicts, filePath)
 		}
 
 		// Success message
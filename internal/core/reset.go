@@ -3,8 +3,6 @@ package core
 import (
 	"fmt"
 	"maps"
-	"os"
-	"strings"
 
 	"github.com/LeeFred3042U/kitcat/internal/storage"
 )
@@ -17,65 +15,57 @@ const (
 
 // Reset performs reset operation with specified mode
 // Modes: "soft", "mixed", "hard"
+// The current branch (or HEAD, when detached) is moved to the commit and the move is
+// recorded in the reflog.
 func Reset(commitHash string, mode string) error {
 	if !IsRepoInitialized() {
 		return fmt.Errorf("not a kitcat repository (or any of the parent directories): .kitcat")
 	}
 
+	if mode != ResetSoft && mode != ResetMixed && mode != ResetHard {
+		return fmt.Errorf("unknown reset mode: %s. Use --soft, --mixed, or --hard", mode)
+	}
+
 	// Step 1: Validate commit exists
-	commit, err := storage.FindCommit(commitHash)
+	commit, err := resolveCommit(commitHash)
 	if err != nil {
 		return fmt.Errorf("fatal: invalid commit: %s", commitHash)
 	}
 
 	// Step 2: Backup current HEAD
-	headData, err := os.ReadFile(".kitcat/HEAD")
+	oldHead, err := readHead()
 	if err != nil {
 		return fmt.Errorf("fatal: unable to read HEAD: %w", err)
 	}
-	oldHead := strings.TrimSpace(string(headData))
 
-	// Step 3: Move HEAD (ALL modes)
-	if err := os.WriteFile(".kitcat/HEAD", []byte(commitHash), 0o644); err != nil {
-		return fmt.Errorf("failed to update HEAD: %w", err)
+	// Step 3: Move the branch (ALL modes)
+	reason := "reset: moving to " + commitHash
+	if err := UpdateBranchPointer(commit.ID, reason); err != nil {
+		return err
+	}
+	rollback := func(cause error) error {
+		if err := UpdateBranchPointer(oldHead, reason+" (rolled back)"); err != nil {
+			return fmt.Errorf("%w; additionally failed to restore HEAD: %v", cause, err)
+		}
+		return cause
 	}
 
 	// Step 4: Mode-specific operations
 	switch mode {
-	case ResetSoft:
-		fmt.Printf("HEAD is now at %s %s\n", commitHash[:7], commit.Message)
-
 	case ResetMixed:
-		if err := resetIndex(commitHash); err != nil {
-			if err = os.WriteFile(".kitcat/HEAD", []byte(oldHead), 0o644); err != nil {
-				return fmt.Errorf("failed to update HEAD: %w", err)
-			}
-			return fmt.Errorf("failed to reset index: %w", err)
+		if err := resetIndex(commit.ID); err != nil {
+			return rollback(fmt.Errorf("failed to reset index: %w", err))
 		}
-		fmt.Printf("HEAD is now at %s %s\n", commitHash[:7], commit.Message)
 
 	case ResetHard:
-		if err := resetIndex(commitHash); err != nil {
-			if err = os.WriteFile(".kitcat/HEAD", []byte(oldHead), 0o644); err != nil {
-				return fmt.Errorf("failed to update HEAD: %w", err)
-			}
-			return fmt.Errorf("failed to reset index: %w", err)
-		}
-		if err := resetWorkspace(commitHash); err != nil {
-			if err = os.WriteFile(".kitcat/HEAD", []byte(oldHead), 0o644); err != nil {
-				return fmt.Errorf("failed to update HEAD: %w", err)
-			}
-			return fmt.Errorf("failed to reset workspace: %w", err)
+		// The workspace reset updates the index too; it needs the old index to know
+		// which files the target commit no longer tracks
+		if err := resetWorkspace(commit.ID); err != nil {
+			return rollback(fmt.Errorf("failed to reset workspace: %w", err))
 		}
-		fmt.Printf("HEAD is now at %s %s\n", commitHash[:7], commit.Message)
-
-	default:
-		if err = os.WriteFile(".kitcat/HEAD", []byte(oldHead), 0o644); err != nil {
-			return fmt.Errorf("failed to update HEAD: %w", err)
-		}
-		return fmt.Errorf("unknown reset mode: %s. Use --soft, --mixed, or --hard", mode)
 	}
 
+	fmt.Printf("HEAD is now at %s %s\n", shortHash(commit.ID), commit.Subject())
 	return nil
 }
 
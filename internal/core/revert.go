@@ -0,0 +1,102 @@
+package core
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// Revert creates, for each given commit, a new commit undoing its changes. The inverse
+// changes are merged into HEAD line by line, so later edits to the same files are kept.
+// A range A..B or A...B reverts each of its commits, newest first. With noCommit the
+// inverse changes are only applied to the working directory and index.
+func Revert(revisions []string, noCommit bool) error {
+	if err := ensureCanStartSequencer(actionRevert); err != nil {
+		return err
+	}
+
+	var todo []string
+	for _, rev := range revisions {
+		hashes, err := revertTargets(rev)
+		if err != nil {
+			return err
+		}
+		for _, hash := range hashes {
+			commit, err := storage.FindCommit(hash)
+			if err != nil {
+				return err
+			}
+			if commit.IsMerge() {
+				return fmt.Errorf("commit %s is a merge; reverting merge commits is not supported", shortHash(commit.ID))
+			}
+			todo = append(todo, commit.ID)
+		}
+	}
+	if len(todo) == 0 {
+		return fmt.Errorf("empty commit set passed")
+	}
+	return startSequencer(actionRevert, todo, noCommit)
+}
+
+// RevertContinue commits the resolved revert the sequence stopped at and reverts the
+// remaining commits
+func RevertContinue() error {
+	return continueSequencer(actionRevert)
+}
+
+// RevertSkip discards the revert the sequence stopped at and reverts the remaining commits
+func RevertSkip() error {
+	return skipSequencer(actionRevert)
+}
+
+// RevertAbort stops the revert and restores the branch, working directory and index
+// to where they were before it started
+func RevertAbort() error {
+	return abortSequencer(actionRevert)
+}
+
+// revertTargets expands a revision into the commits to revert, newest first.
+// Merge commits inside a range are left out.
+func revertTargets(rev string) ([]string, error) {
+	commits, err := revisionCommits(rev)
+	if err != nil {
+		return nil, fmt.Errorf("bad revision '%s': %w", rev, err)
+	}
+	if _, _, _, isRange := splitRange(rev); isRange {
+		commits = slices.DeleteFunc(commits, models.Commit.IsMerge)
+	}
+	hashes := make([]string, len(commits))
+	for i, c := range commits {
+		hashes[len(commits)-1-i] = c.ID
+	}
+	return hashes, nil
+}
+
+// revertCommit applies the inverse of commit's changes onto the index and, unless
+// noCommit is set, commits them with a "Revert" message
+func revertCommit(commit models.Commit, noCommit bool) error {
+	changes, err := getChanges(commit.FirstParent(), commit.ID)
+	if err != nil {
+		return err
+	}
+	inverse := make(map[string]Change, len(changes))
+	for path, change := range changes {
+		inverse[path] = Change{Old: change.New, New: change.Old}
+	}
+
+	label := fmt.Sprintf("parent of %s (%s)", shortHash(commit.ID), firstLine(commit.Message))
+	if err := applyChanges(inverse, mergeLabels{ours: "HEAD", theirs: label}); err != nil {
+		return err
+	}
+	if noCommit {
+		return nil
+	}
+	return commitStep(actionRevert, commit)
+}
+
+// revertMessage is the message of the commit reverting commit
+func revertMessage(commit models.Commit) string {
+	return fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %s.", firstLine(commit.Message), commit.ID)
+}
@@ -0,0 +1,218 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// A revision names a commit. It starts with one of
+//
+//	HEAD or @            the current commit
+//	<name>               a tag, then a branch, of that name
+//	<remote>/<branch>    a remote-tracking branch
+//	refs/<path>          a ref by its full name
+//	<ref>@{n}, @{n}      the commit the ref pointed at n updates ago (see Reflog)
+//	<hash>               a full or abbreviated commit hash
+//
+// followed by any number of
+//
+//	~n                   the n-th first-parent ancestor (~ alone means ~1)
+//	^n                   the n-th parent (^ alone means ^1, ^0 is the commit itself)
+//
+// A range selects a set of commits:
+//
+//	A..B                 commits reachable from B but not from A
+//	A...B                commits reachable from either A or B, but not both
+//
+// A missing side of a range means HEAD.
+
+// ResolveRevision returns the hash of the commit a revision names
+func ResolveRevision(rev string) (string, error) {
+	commit, err := resolveCommit(rev)
+	if err != nil {
+		return "", err
+	}
+	return commit.ID, nil
+}
+
+// resolveCommit finds the commit a revision names
+func resolveCommit(rev string) (models.Commit, error) {
+	base, suffix := rev, ""
+	if i := strings.IndexAny(rev, "~^"); i >= 0 {
+		base, suffix = rev[:i], rev[i:]
+	}
+
+	commit, err := resolveBase(base)
+	if err != nil {
+		return models.Commit{}, fmt.Errorf("unknown revision '%s': %w", rev, err)
+	}
+
+	for suffix != "" {
+		op := suffix[0]
+		digits := 1
+		for digits < len(suffix) && suffix[digits] >= '0' && suffix[digits] <= '9' {
+			digits++
+		}
+		n := 1
+		if digits > 1 {
+			if n, err = strconv.Atoi(suffix[1:digits]); err != nil {
+				return models.Commit{}, fmt.Errorf("invalid revision '%s'", rev)
+			}
+		}
+		suffix = suffix[digits:]
+		if op != '~' && op != '^' {
+			return models.Commit{}, fmt.Errorf("invalid revision '%s'", rev)
+		}
+
+		if op == '^' {
+			if n == 0 {
+				continue
+			}
+			if n > len(commit.Parents) {
+				return models.Commit{}, fmt.Errorf("revision '%s': commit %s has no parent %d", rev, shortHash(commit.ID), n)
+			}
+			if commit, err = storage.FindCommit(commit.Parents[n-1]); err != nil {
+				return models.Commit{}, err
+			}
+			continue
+		}
+		for range n {
+			parent := commit.FirstParent()
+			if parent == "" {
+				return models.Commit{}, fmt.Errorf("revision '%s' goes past the root commit %s", rev, shortHash(commit.ID))
+			}
+			if commit, err = storage.FindCommit(parent); err != nil {
+				return models.Commit{}, err
+			}
+		}
+	}
+	return commit, nil
+}
+
+// resolveBase finds the commit named by a revision without its ~ and ^ suffixes
+func resolveBase(name string) (models.Commit, error) {
+	if name == "" {
+		return models.Commit{}, fmt.Errorf("empty revision")
+	}
+	if name == headRef || name == "@" {
+		hash, err := readHead()
+		if err != nil {
+			return models.Commit{}, err
+		}
+		return storage.FindCommit(hash)
+	}
+	if ref, n, ok := parseReflogRevision(name); ok {
+		return reflogCommit(ref, n)
+	}
+	if hash, ok := lookupRef(name); ok {
+		return storage.FindCommit(hash)
+	}
+	return storage.FindCommit(name)
+}
+
+// lookupRef returns the commit hash stored in the ref called name: a full ref path such as
+// refs/heads/main, a remote-tracking branch such as origin/main, or else a tag or branch
+// of that name
+func lookupRef(name string) (string, bool) {
+	if strings.HasPrefix(name, "refs/") {
+		if !IsSafePath(name) {
+			return "", false
+		}
+		hash, err := readCommitHash(name)
+		return hash, err == nil && hash != ""
+	}
+	if remote, branch, ok := strings.Cut(name, "/"); ok {
+		if !IsValidRefName(remote) || !IsValidRefName(branch) {
+			return "", false
+		}
+		return lookupRef("refs/remotes/" + name)
+	}
+	if !IsValidRefName(name) {
+		return "", false
+	}
+	for _, dir := range []string{tagsDir, headsDir} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err == nil && strings.TrimSpace(string(data)) != "" {
+			return strings.TrimSpace(string(data)), true
+		}
+	}
+	return "", false
+}
+
+// splitRange splits A..B or A...B into its sides, filling in HEAD for a missing side.
+// ok is false when rev is not a range.
+func splitRange(rev string) (from, to string, symmetric, ok bool) {
+	if from, to, ok = strings.Cut(rev, "..."); ok {
+		symmetric = true
+	} else if from, to, ok = strings.Cut(rev, ".."); !ok {
+		return "", "", false, false
+	}
+	if from == "" {
+		from = headRef
+	}
+	if to == "" {
+		to = headRef
+	}
+	return from, to, symmetric, true
+}
+
+// revisionCommits expands a revision into the commits it selects, oldest first: a
+// single commit, or every commit of a range A..B or A...B
+func revisionCommits(rev string) ([]models.Commit, error) {
+	fromRev, toRev, symmetric, isRange := splitRange(rev)
+	if !isRange {
+		commit, err := resolveCommit(rev)
+		if err != nil {
+			return nil, err
+		}
+		return []models.Commit{commit}, nil
+	}
+
+	from, err := resolveCommit(fromRev)
+	if err != nil {
+		return nil, err
+	}
+	to, err := resolveCommit(toRev)
+	if err != nil {
+		return nil, err
+	}
+	fromSide, err := reachableCommits(from.ID)
+	if err != nil {
+		return nil, err
+	}
+	toSide, err := reachableCommits(to.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []models.Commit
+	for id, c := range toSide {
+		if _, shared := fromSide[id]; !shared {
+			selected = append(selected, c)
+		}
+	}
+	if symmetric {
+		for id, c := range fromSide {
+			if _, shared := toSide[id]; !shared {
+				selected = append(selected, c)
+			}
+		}
+	}
+	return topoOrder(selected), nil
+}
+
+// reachableCommits returns every commit reachable from hash, by ID
+func reachableCommits(hash string) (map[string]models.Commit, error) {
+	reachable := make(map[string]models.Commit)
+	err := storage.WalkCommits(hash, func(c models.Commit) bool {
+		reachable[c.ID] = c
+		return true
+	})
+	return reachable, err
+}
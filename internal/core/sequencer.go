@@ -0,0 +1,206 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// Sequencer actions: each replays a list of commits onto HEAD one at a time
+const (
+	actionCherryPick = "cherry-pick"
+	actionRevert     = "revert"
+)
+
+// startSequencer records the commits to replay with action and applies them in order.
+// When a commit conflicts the sequence stops so it can be resolved and resumed.
+func startSequencer(action string, todo []string, noCommit bool) error {
+	head, err := readHead()
+	if err != nil {
+		return fmt.Errorf("could not read current HEAD: %w", err)
+	}
+	state := SequencerState{
+		Action:    action,
+		OrigHead:  head,
+		TodoSteps: todo,
+		NoCommit:  noCommit,
+	}
+	if err := SaveSequencerState(state); err != nil {
+		return err
+	}
+	return runSequencer(&state)
+}
+
+// ensureCanStartSequencer checks the repository can start replaying commits with action:
+// no other operation may be half done and the working directory must be clean
+func ensureCanStartSequencer(action string) error {
+	if !IsRepoInitialized() {
+		return fmt.Errorf("not a kitcat repository")
+	}
+	if err := ensureNoSequenceInProgress(); err != nil {
+		return err
+	}
+	dirty, err := IsWorkDirDirty()
+	if err != nil {
+		return fmt.Errorf("failed to check working directory status: %w", err)
+	}
+	if dirty {
+		return fmt.Errorf("your local changes would be overwritten by %s; commit or stash them first", action)
+	}
+	return nil
+}
+
+// loadSequencerFor loads the sequencer state, failing unless it belongs to action
+func loadSequencerFor(action string) (*SequencerState, error) {
+	state, err := LoadSequencerState()
+	if err != nil || state.Action != action {
+		return nil, fmt.Errorf("no %s in progress", action)
+	}
+	return state, nil
+}
+
+// continueSequencer commits the resolved changes of the commit the sequence stopped at
+// and applies the remaining ones
+func continueSequencer(action string) error {
+	state, err := loadSequencerFor(action)
+	if err != nil {
+		return err
+	}
+	if state.CurrentStep >= len(state.TodoSteps) {
+		return ClearSequencerState()
+	}
+	if err := ensureNoUnmergedPaths("continue"); err != nil {
+		return err
+	}
+
+	if !state.NoCommit {
+		commit, err := storage.FindCommit(state.TodoSteps[state.CurrentStep])
+		if err != nil {
+			return err
+		}
+		if err := commitStep(action, commit); err != nil {
+			return err
+		}
+	}
+	state.CurrentStep++
+	if err := SaveSequencerState(*state); err != nil {
+		return err
+	}
+	return runSequencer(state)
+}
+
+// skipSequencer discards the changes of the commit the sequence stopped at and applies
+// the remaining ones
+func skipSequencer(action string) error {
+	state, err := loadSequencerFor(action)
+	if err != nil {
+		return err
+	}
+	head, err := readHead()
+	if err != nil {
+		return err
+	}
+	if err := UpdateWorkspaceAndIndex(head); err != nil {
+		return fmt.Errorf("failed to discard the skipped changes: %w", err)
+	}
+	state.CurrentStep++
+	if err := SaveSequencerState(*state); err != nil {
+		return err
+	}
+	return runSequencer(state)
+}
+
+// abortSequencer stops the sequence and restores the branch, working directory and
+// index to where they were before it started
+func abortSequencer(action string) error {
+	state, err := loadSequencerFor(action)
+	if err != nil {
+		return err
+	}
+	if err := UpdateBranchPointer(state.OrigHead, action+": aborting"); err != nil {
+		return err
+	}
+	if err := UpdateWorkspaceAndIndex(state.OrigHead); err != nil {
+		return err
+	}
+	fmt.Printf("Aborted %s, HEAD is back at %s\n", state.Action, shortHash(state.OrigHead))
+	return ClearSequencerState()
+}
+
+// runSequencer applies the remaining commits of state, saving its progress after each one.
+// On a conflict it stops, leaving the state in place so the user can continue, skip or abort.
+func runSequencer(state *SequencerState) error {
+	for state.CurrentStep < len(state.TodoSteps) {
+		hash := state.TodoSteps[state.CurrentStep]
+		commit, err := storage.FindCommit(hash)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s (%d/%d): %s %s\n", state.Action, state.CurrentStep+1, len(state.TodoSteps), shortHash(hash), firstLine(commit.Message))
+
+		var stepErr error
+		switch state.Action {
+		case actionRevert:
+			stepErr = revertCommit(commit, state.NoCommit)
+		default:
+			stepErr = pickCommit(commit, state.NoCommit)
+		}
+		if stepErr != nil {
+			return fmt.Errorf(
+				"could not %s %s: %w\nresolve the conflicts and mark them with 'kitcat add', then run 'kitcat %s --continue'\nuse '--skip' to drop this commit or '--abort' to start over",
+				state.Action, shortHash(hash), stepErr, state.Action,
+			)
+		}
+		state.CurrentStep++
+		if err := SaveSequencerState(*state); err != nil {
+			return err
+		}
+	}
+	return ClearSequencerState()
+}
+
+// commitStep commits the index for the commit a sequence is replaying. As in git, the
+// pre-commit and commit-msg hooks do not run for a message that was not written by hand;
+// post-commit does. A step that leaves nothing to commit is skipped with a note.
+func commitStep(action string, commit models.Commit) error {
+	var err error
+	switch action {
+	case actionRevert:
+		by, identErr := authorIdent()
+		if identErr != nil {
+			return identErr
+		}
+		_, _, err = commitIndex(revertMessage(commit), by, false)
+	default:
+		_, _, err = commitAs(commit.Message, commit)
+	}
+	if isNothingToCommit(err) {
+		fmt.Printf("Skipped %s: nothing to commit, the result is the same as HEAD\n", shortHash(commit.ID))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	runPostHook(hookPostCommit)
+	return nil
+}
+
+// ensureNoSequenceInProgress fails while a merge, rebase, cherry-pick or revert is waiting
+// to be concluded, since starting another one would lose its state
+func ensureNoSequenceInProgress() error {
+	switch {
+	case IsSequencerInProgress():
+		action := "cherry-pick"
+		if state, err := LoadSequencerState(); err == nil && state.Action != "" {
+			action = state.Action
+		}
+		return fmt.Errorf("a %s is already in progress; use 'kitcat %s --continue', --skip or --abort", action, action)
+	case IsRebaseInProgress():
+		return errors.New("a rebase is in progress; finish it with 'kitcat rebase --continue' or --abort")
+	case IsMergeInProgress():
+		return errors.New("a merge is in progress; commit the result or run 'kitcat merge --abort'")
+	}
+	return nil
+}
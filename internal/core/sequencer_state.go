@@ -0,0 +1,71 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SequencerState tracks an ongoing cherry-pick or revert that replays a list of commits
+type SequencerState struct {
+	Action      string   // "cherry-pick" or "revert"
+	OrigHead    string   // Commit ID where we started (for abort)
+	TodoSteps   []string // Commit IDs to apply, in order
+	CurrentStep int      // Index in TodoSteps (0-based)
+	NoCommit    bool     // Apply the changes without committing them
+}
+
+// sequencerDir holds the state of an ongoing cherry-pick or revert
+var sequencerDir = filepath.Join(RepoDir, "sequencer")
+
+func SaveSequencerState(state SequencerState) error {
+	if err := os.MkdirAll(sequencerDir, 0o755); err != nil {
+		return err
+	}
+
+	files := map[string]string{
+		"action":    state.Action,
+		"orig-head": state.OrigHead,
+		"todo":      strings.Join(state.TodoSteps, "\n"),
+		"current":   strconv.Itoa(state.CurrentStep),
+		"no-commit": strconv.FormatBool(state.NoCommit),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(sequencerDir, name), []byte(content), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func LoadSequencerState() (*SequencerState, error) {
+	if !IsSequencerInProgress() {
+		return nil, fmt.Errorf("no cherry-pick or revert in progress")
+	}
+
+	read := func(name string) string {
+		data, _ := os.ReadFile(filepath.Join(sequencerDir, name))
+		return strings.TrimSpace(string(data))
+	}
+	step, _ := strconv.Atoi(read("current"))
+	noCommit, _ := strconv.ParseBool(read("no-commit"))
+
+	return &SequencerState{
+		Action:      read("action"),
+		OrigHead:    read("orig-head"),
+		TodoSteps:   strings.Fields(read("todo")),
+		CurrentStep: step,
+		NoCommit:    noCommit,
+	}, nil
+}
+
+func IsSequencerInProgress() bool {
+	_, err := os.Stat(sequencerDir)
+	return err == nil
+}
+
+func ClearSequencerState() error {
+	return os.RemoveAll(sequencerDir)
+}
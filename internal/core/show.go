@@ -7,11 +7,36 @@ import (
 )
 
 // Displays the contents of a kitcat object
+// A revision such as HEAD~1 shows the commit it names. An annotated tag shows its
+// tagger, date and message before the object it points at.
 func ShowObject(hash string) error {
-	data, err := storage.ReadObject(hash)
-	if err != nil {
-		return err
+	target := hash
+	if ref, ok := lookupRef(hash); ok {
+		target = ref
+	}
+	if t, err := storage.ReadObjectType(target); err == nil && t == storage.TagObject {
+		hash = target
+	} else if commitHash, err := ResolveRevision(hash); err == nil {
+		hash = commitHash
+	}
+
+	for {
+		obj, err := storage.ReadObject(hash)
+		if err != nil {
+			return err
+		}
+		if obj.Type != storage.TagObject {
+			fmt.Println(string(obj.Data))
+			return nil
+		}
+		tag, err := storage.ParseTag(obj.Data)
+		if err != nil {
+			return fmt.Errorf("%w %s: %v", storage.ErrCorruptObject, hash, err)
+		}
+		fmt.Printf("tag %s\n", tag.Name)
+		fmt.Printf("Tagger: %s <%s>\n", tag.TaggerName, tag.TaggerEmail)
+		fmt.Printf("Date:   %s\n", tag.Time.Format("Mon Jan 02 15:04:05 2006 -0700"))
+		fmt.Printf("\n%s\n\n", tag.Message)
+		hash = tag.Object
 	}
-	fmt.Println(string(data))
-	return nil
 }
@@ -0,0 +1,463 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// Signatures are OpenSSH signatures (the SSHSIG format of ssh-keygen -Y sign) made with
+// an ed25519 key, so keys and allowed signers files are shared with ssh and git, and
+// ssh-keygen -Y verify accepts them.
+const (
+	// signatureNamespace is what kitcat signatures are made for, so that they cannot be
+	// passed off as signatures of another kind, such as those of git commits
+	signatureNamespace = "kitcat"
+	sshKeyType         = "ssh-ed25519"
+	sshSigMagic        = "SSHSIG"
+	sshSigHash         = "sha512"
+	sshSigBegin        = "-----BEGIN SSH SIGNATURE-----"
+	sshSigEnd          = "-----END SSH SIGNATURE-----"
+)
+
+// signingKey loads the private key named by user.signingkey: an OpenSSH private key
+// without a passphrase, such as ~/.ssh/id_ed25519, or a PKCS#8 PEM key. A public key
+// path ending in .pub names the private key next to it.
+func signingKey() (ed25519.PrivateKey, error) {
+	keyPath, _, err := GetConfig("user.signingkey")
+	if err != nil {
+		return nil, err
+	}
+	if keyPath == "" {
+		return nil, errors.New("no signing key configured; set user.signingkey to an ed25519 private key file")
+	}
+	keyPath = expandHome(strings.TrimSuffix(keyPath, ".pub"))
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the signing key: %w", err)
+	}
+	key, err := parsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", keyPath, err)
+	}
+	return key, nil
+}
+
+// expandHome replaces a leading ~/ with the home directory
+func expandHome(p string) string {
+	if rest, ok := strings.CutPrefix(p, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return p
+}
+
+// parsePrivateKey decodes an ed25519 private key in OpenSSH or PKCS#8 PEM form
+func parsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("not a PEM encoded private key")
+	}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if ed, ok := key.(ed25519.PrivateKey); ok {
+			return ed, nil
+		}
+		return nil, errors.New("only ed25519 keys can sign")
+	case "OPENSSH PRIVATE KEY":
+		return parseOpenSSHPrivateKey(block.Bytes)
+	}
+	return nil, fmt.Errorf("unsupported key type %q", block.Type)
+}
+
+// parseOpenSSHPrivateKey decodes the openssh-key-v1 format written by ssh-keygen
+func parseOpenSSHPrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	const magic = "openssh-key-v1\x00"
+	rest, ok := bytes.CutPrefix(data, []byte(magic))
+	if !ok {
+		return nil, errors.New("not an OpenSSH private key")
+	}
+	r := sshReader{data: rest}
+	cipher, kdf := r.string(), r.string()
+	r.string() // kdf options
+	count := r.uint32()
+	r.string() // public key
+	private := sshReader{data: r.bytes()}
+	if r.err != nil {
+		return nil, errors.New("malformed OpenSSH private key")
+	}
+	if string(cipher) != "none" || string(kdf) != "none" {
+		return nil, errors.New("the key is protected by a passphrase, which is not supported; use a key without one")
+	}
+	if count != 1 {
+		return nil, errors.New("the key file must hold exactly one key")
+	}
+	check1, check2 := private.uint32(), private.uint32()
+	keyType := private.string()
+	private.string() // public key
+	key := private.bytes()
+	if private.err != nil || check1 != check2 {
+		return nil, errors.New("malformed OpenSSH private key")
+	}
+	if string(keyType) != sshKeyType || len(key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("only ed25519 keys can sign, not %s", keyType)
+	}
+	return ed25519.PrivateKey(key), nil
+}
+
+// sshReader reads the length-prefixed values of SSH's wire format, recording the first
+// error instead of returning it from each call
+type sshReader struct {
+	data []byte
+	err  error
+}
+
+func (r *sshReader) uint32() uint32 {
+	if r.err != nil || len(r.data) < 4 {
+		r.err = errors.New("truncated data")
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.data)
+	r.data = r.data[4:]
+	return v
+}
+
+func (r *sshReader) bytes() []byte {
+	n := r.uint32()
+	if r.err != nil || uint32(len(r.data)) < n {
+		r.err = errors.New("truncated data")
+		return nil
+	}
+	v := r.data[:n]
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *sshReader) string() string {
+	return string(r.bytes())
+}
+
+// appendSSHString appends s to buf with its length in front, as SSH's wire format does
+func appendSSHString(buf []byte, s []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(s)))
+	return append(buf, s...)
+}
+
+// sshPublicKey returns the wire encoding of an ed25519 public key
+func sshPublicKey(pub ed25519.PublicKey) []byte {
+	return appendSSHString(appendSSHString(nil, []byte(sshKeyType)), pub)
+}
+
+// keyFingerprint returns the SHA256 fingerprint of a public key as ssh-keygen prints it
+func keyFingerprint(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(sshPublicKey(pub))
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// signedData is what an SSH signature of message in namespace actually signs
+func signedData(namespace string, message []byte) []byte {
+	sum := sha512.Sum512(message)
+	data := []byte(sshSigMagic)
+	data = appendSSHString(data, []byte(namespace))
+	data = appendSSHString(data, nil)
+	data = appendSSHString(data, []byte(sshSigHash))
+	return appendSSHString(data, sum[:])
+}
+
+// signPayload signs payload with the configured signing key and returns the armored
+// signature
+func signPayload(payload []byte) (string, error) {
+	key, err := signingKey()
+	if err != nil {
+		return "", err
+	}
+	sig := ed25519.Sign(key, signedData(signatureNamespace, payload))
+
+	blob := []byte(sshSigMagic)
+	blob = binary.BigEndian.AppendUint32(blob, 1)
+	blob = appendSSHString(blob, sshPublicKey(key.Public().(ed25519.PublicKey)))
+	blob = appendSSHString(blob, []byte(signatureNamespace))
+	blob = appendSSHString(blob, nil)
+	blob = appendSSHString(blob, []byte(sshSigHash))
+	blob = appendSSHString(blob, appendSSHString(appendSSHString(nil, []byte(sshKeyType)), sig))
+
+	encoded := base64.StdEncoding.EncodeToString(blob)
+	var b strings.Builder
+	b.WriteString(sshSigBegin + "\n")
+	for len(encoded) > 70 {
+		b.WriteString(encoded[:70] + "\n")
+		encoded = encoded[70:]
+	}
+	b.WriteString(encoded + "\n" + sshSigEnd + "\n")
+	return b.String(), nil
+}
+
+// parseSignature decodes an armored signature and returns the public key that made it,
+// after checking that it signs payload
+func parseSignature(armored string, payload []byte) (ed25519.PublicKey, error) {
+	body, ok := strings.CutPrefix(strings.TrimSpace(armored), sshSigBegin)
+	if !ok {
+		return nil, errors.New("not an SSH signature")
+	}
+	body, ok = strings.CutSuffix(body, sshSigEnd)
+	if !ok {
+		return nil, errors.New("not an SSH signature")
+	}
+	blob, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(body), ""))
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+	rest, ok := bytes.CutPrefix(blob, []byte(sshSigMagic))
+	if !ok {
+		return nil, errors.New("malformed signature")
+	}
+	r := sshReader{data: rest}
+	version := r.uint32()
+	publicKey := sshReader{data: r.bytes()}
+	namespace := r.string()
+	r.string() // reserved
+	hash := r.string()
+	signature := sshReader{data: r.bytes()}
+	keyType, pub := publicKey.string(), publicKey.bytes()
+	sigType, sig := signature.string(), signature.bytes()
+	if r.err != nil || publicKey.err != nil || signature.err != nil || version != 1 {
+		return nil, errors.New("malformed signature")
+	}
+	if keyType != sshKeyType || sigType != sshKeyType || len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("unsupported signature key type %s", keyType)
+	}
+	if namespace != signatureNamespace {
+		return nil, fmt.Errorf("the signature was made for %q, not %q", namespace, signatureNamespace)
+	}
+	if hash != sshSigHash {
+		return nil, fmt.Errorf("unsupported signature hash %s", hash)
+	}
+	if !ed25519.Verify(pub, signedData(namespace, payload), sig) {
+		return nil, errors.New("the signature does not match the content")
+	}
+	return ed25519.PublicKey(pub), nil
+}
+
+// allowedSigner is a line of an allowed signers file: the principals, usually email
+// addresses, that may sign with a key, and the namespaces they may sign for
+type allowedSigner struct {
+	principals []string
+	namespaces []string
+	key        ed25519.PublicKey
+}
+
+// readAllowedSigners reads the file named by gpg.ssh.allowedsignersfile, in the format of
+// ssh-keygen: "principal[,principal...] [options] ssh-ed25519 <key> [comment]" per line.
+// Keys of other types are skipped, since they cannot have made a kitcat signature.
+func readAllowedSigners() ([]allowedSigner, error) {
+	file, _, err := GetConfig("gpg.ssh.allowedsignersfile")
+	if err != nil {
+		return nil, err
+	}
+	if file == "" {
+		return nil, errors.New("gpg.ssh.allowedsignersfile is not set; it must name the file listing who may sign with which key")
+	}
+	data, err := os.ReadFile(expandHome(file))
+	if err != nil {
+		return nil, fmt.Errorf("could not read the allowed signers file: %w", err)
+	}
+
+	var signers []allowedSigner
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		fields := splitQuoted(strings.TrimSpace(scanner.Text()), " \t")
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("%s:%d: expected principals and a key", file, lineNo)
+		}
+		signer := allowedSigner{principals: strings.Split(fields[0], ",")}
+		keyAt := 1
+		if !strings.HasPrefix(fields[1], "ssh-") && !strings.HasPrefix(fields[1], "ecdsa-") {
+			keyAt = 2
+			for _, option := range splitQuoted(fields[1], ",") {
+				if list, ok := strings.CutPrefix(strings.ToLower(option), "namespaces="); ok {
+					signer.namespaces = strings.Split(strings.Trim(list, `"`), ",")
+				}
+			}
+		}
+		if len(fields) <= keyAt+1 {
+			return nil, fmt.Errorf("%s:%d: expected principals and a key", file, lineNo)
+		}
+		if fields[keyAt] != sshKeyType {
+			continue
+		}
+		wire, err := base64.StdEncoding.DecodeString(fields[keyAt+1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: malformed key", file, lineNo)
+		}
+		r := sshReader{data: wire}
+		keyType, pub := r.string(), r.bytes()
+		if r.err != nil || keyType != sshKeyType || len(pub) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%s:%d: malformed key", file, lineNo)
+		}
+		signer.key = ed25519.PublicKey(pub)
+		signers = append(signers, signer)
+	}
+	return signers, scanner.Err()
+}
+
+// splitQuoted splits s at the characters of seps outside double quotes, dropping empty
+// fields. An allowed signers line is split into fields at spaces and its options at commas.
+func splitQuoted(s, seps string) []string {
+	var fields []string
+	var current strings.Builder
+	quoted := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			current.WriteRune(r)
+		case strings.ContainsRune(seps, r) && !quoted:
+			if current.Len() > 0 {
+				fields = append(fields, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		fields = append(fields, current.String())
+	}
+	return fields
+}
+
+// SignatureCheck is the outcome of checking a signature
+type SignatureCheck struct {
+	Good        bool
+	Principal   string // who the allowed signers file says the key belongs to
+	Fingerprint string // of the key that made the signature, when it could be read
+	Problem     string // why the signature is not good
+}
+
+// String describes the check in the words git uses for SSH signatures
+func (c SignatureCheck) String() string {
+	switch {
+	case c.Good:
+		return fmt.Sprintf("Good %q signature for %s with ED25519 key %s", signatureNamespace, c.Principal, c.Fingerprint)
+	case c.Fingerprint != "":
+		return fmt.Sprintf("Could not verify signature made with ED25519 key %s: %s", c.Fingerprint, c.Problem)
+	}
+	return "Bad signature: " + c.Problem
+}
+
+// checkSignature checks that signature signs payload and that the allowed signers file
+// lists its key for kitcat signatures
+func checkSignature(payload []byte, signature string) SignatureCheck {
+	pub, err := parseSignature(signature, payload)
+	if err != nil {
+		return SignatureCheck{Problem: err.Error()}
+	}
+	check := SignatureCheck{Fingerprint: keyFingerprint(pub)}
+	signers, err := readAllowedSigners()
+	if err != nil {
+		check.Problem = err.Error()
+		return check
+	}
+	for _, signer := range signers {
+		if !signer.key.Equal(pub) {
+			continue
+		}
+		if len(signer.namespaces) > 0 && !matchesAny(signer.namespaces, signatureNamespace) {
+			continue
+		}
+		check.Good = true
+		check.Principal = signer.principals[0]
+		return check
+	}
+	check.Problem = "the key is not in the allowed signers file"
+	return check
+}
+
+// configBool reports whether the config key is set to true
+func configBool(key string) bool {
+	value, _, _ := GetConfig(key)
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return true
+	}
+	return false
+}
+
+// signCommit signs c with the configured key when commit.gpgsign is set. Its payload is
+// the content Hash names the commit by, so c.ID must be computed afterwards.
+func signCommit(c *models.Commit) error {
+	c.Signature = ""
+	if !configBool("commit.gpgsign") {
+		return nil
+	}
+	signature, err := signPayload(c.Payload())
+	if err != nil {
+		return fmt.Errorf("could not sign the commit: %w", err)
+	}
+	c.Signature = signature
+	return nil
+}
+
+// VerifyCommit checks the signature of the commit rev names and prints the result. It
+// fails when the commit is unsigned or the signature is not good.
+func VerifyCommit(rev string) error {
+	commit, err := resolveCommit(rev)
+	if err != nil {
+		return err
+	}
+	if commit.Signature == "" {
+		return fmt.Errorf("commit %s has no signature", commit.ID)
+	}
+	check := checkSignature(commit.Payload(), commit.Signature)
+	fmt.Println(check)
+	if !check.Good {
+		return fmt.Errorf("commit %s: the signature could not be verified", shortHash(commit.ID))
+	}
+	return nil
+}
+
+// VerifyTag checks the signature of the annotated tag name and prints the result. It
+// fails when the tag is lightweight or unsigned, or the signature is not good.
+func VerifyTag(name string) error {
+	hash, ok := lookupRef("refs/tags/" + name)
+	if !ok {
+		return fmt.Errorf("tag '%s' not found", name)
+	}
+	tag, err := storage.ReadTag(hash)
+	if err != nil {
+		return fmt.Errorf("%s: cannot verify a lightweight tag", name)
+	}
+	if tag.Signature == "" {
+		return fmt.Errorf("tag %s has no signature", name)
+	}
+	signature := tag.Signature
+	tag.Signature = ""
+	check := checkSignature(storage.EncodeTag(tag), signature)
+	fmt.Println(check)
+	if !check.Good {
+		return fmt.Errorf("tag %s: the signature could not be verified", name)
+	}
+	return nil
+}
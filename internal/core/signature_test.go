@@ -0,0 +1,151 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupSigningRepo changes into a new repository whose config signs with a fresh ed25519
+// key and trusts it for alice@example.com. It returns the key file and the allowed
+// signers file.
+func setupSigningRepo(t *testing.T) (string, string) {
+	t.Helper()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(cwd) })
+	if err := os.MkdirAll(".kitcat", 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "signing.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	signersFile := filepath.Join(dir, "allowed_signers")
+	line := "alice@example.com namespaces=\"git,kitcat\" ssh-ed25519 " + base64.StdEncoding.EncodeToString(sshPublicKey(pub)) + " alice\n"
+	if err := os.WriteFile(signersFile, []byte(line), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetConfig("user.signingkey", keyFile, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetConfig("gpg.ssh.allowedsignersfile", signersFile, false); err != nil {
+		t.Fatal(err)
+	}
+	return keyFile, signersFile
+}
+
+func TestSignPayload_VerifiesAndDetectsTampering(t *testing.T) {
+	setupSigningRepo(t)
+
+	payload := []byte("tree abc\n\nmessage")
+	sig, err := signPayload(payload)
+	if err != nil {
+		t.Fatalf("signPayload failed: %v", err)
+	}
+	if !strings.HasPrefix(sig, sshSigBegin+"\n") || !strings.HasSuffix(sig, sshSigEnd+"\n") {
+		t.Errorf("signature is not armored:\n%s", sig)
+	}
+
+	check := checkSignature(payload, sig)
+	if !check.Good || check.Principal != "alice@example.com" || !strings.HasPrefix(check.Fingerprint, "SHA256:") {
+		t.Errorf("checkSignature = %+v, want a good signature by alice@example.com", check)
+	}
+	if check := checkSignature([]byte("tree abc\n\nchanged"), sig); check.Good || check.Fingerprint != "" {
+		t.Errorf("a signature over other content checked as %+v", check)
+	}
+
+	// A key the allowed signers file does not list is recognised but not trusted
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(other)
+	otherFile := filepath.Join(t.TempDir(), "other.pem")
+	if err := os.WriteFile(otherFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetConfig("user.signingkey", otherFile, false); err != nil {
+		t.Fatal(err)
+	}
+	sig, err = signPayload(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check := checkSignature(payload, sig); check.Good || check.Fingerprint == "" {
+		t.Errorf("a signature by an unlisted key checked as %+v", check)
+	}
+}
+
+func TestSignPayload_InteroperatesWithSSHKeygen(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen is not installed")
+	}
+	_, signersFile := setupSigningRepo(t)
+
+	// Sign with a key generated by ssh-keygen, in the OpenSSH format
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", "bob", "-f", keyFile).CombinedOutput(); err != nil {
+		t.Fatalf("ssh-keygen failed: %v\n%s", err, out)
+	}
+	pub, err := os.ReadFile(keyFile + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(signersFile, []byte("bob@example.com "+string(pub)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetConfig("user.signingkey", keyFile+".pub", false); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := []byte("signed by kitcat\n")
+	sig, err := signPayload(payload)
+	if err != nil {
+		t.Fatalf("signPayload with an OpenSSH key failed: %v", err)
+	}
+	sigFile := filepath.Join(t.TempDir(), "payload.sig")
+	if err := os.WriteFile(sigFile, []byte(sig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	verify := exec.Command("ssh-keygen", "-Y", "verify", "-f", signersFile, "-I", "bob@example.com", "-n", signatureNamespace, "-s", sigFile)
+	verify.Stdin = strings.NewReader(string(payload))
+	if out, err := verify.CombinedOutput(); err != nil {
+		t.Errorf("ssh-keygen rejected the kitcat signature: %v\n%s", err, out)
+	}
+
+	// And the other way round
+	payloadFile := filepath.Join(t.TempDir(), "payload")
+	if err := os.WriteFile(payloadFile, payload, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("ssh-keygen", "-Y", "sign", "-f", keyFile, "-n", signatureNamespace, payloadFile).CombinedOutput(); err != nil {
+		t.Fatalf("ssh-keygen -Y sign failed: %v\n%s", err, out)
+	}
+	theirs, err := os.ReadFile(payloadFile + ".sig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check := checkSignature(payload, string(theirs)); !check.Good || check.Principal != "bob@example.com" {
+		t.Errorf("the ssh-keygen signature checked as %+v", check)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"os"
 	"strings"
-	"time"
 
 	"github.com/LeeFred3042U/kitcat/internal/models"
 	"github.com/LeeFred3042U/kitcat/internal/storage"
@@ -43,14 +42,58 @@ func StashApply(index int) error {
 		return fmt.Errorf("error: your local changes would be overwritten by stash apply\nPlease commit your changes or stash them before you apply")
 	}
 
-	if err := UpdateWorkspaceAndIndex(stashHash); err != nil {
-		return fmt.Errorf("failed to apply stash: %w", err)
+	if err := applyStash(stashHash); err != nil {
+		return err
 	}
 
 	fmt.Printf("Applied refs/stash@{%d} (%s)\n", index, stashHash[:7])
 	return nil
 }
 
+// applyStash merges the changes recorded in a stash commit into the current HEAD,
+// using the commit the stash was made on as the merge base. Overlapping changes are
+// left in the working directory with conflict markers.
+func applyStash(stashHash string) error {
+	stash, err := storage.FindCommit(stashHash)
+	if err != nil {
+		return fmt.Errorf("stash commit not found: %w", err)
+	}
+	baseFiles := make(map[string]storage.TreeEntry)
+	if parent := stash.FirstParent(); parent != "" {
+		if baseFiles, err = commitFiles(parent); err != nil {
+			return fmt.Errorf("failed to read stash base: %w", err)
+		}
+	}
+	head, err := GetHeadCommit()
+	if err != nil {
+		return fmt.Errorf("failed to get HEAD commit: %w", err)
+	}
+	ourFiles, err := storage.FlattenTree(head.TreeHash)
+	if err != nil {
+		return err
+	}
+	stashFiles, err := storage.FlattenTree(stash.TreeHash)
+	if err != nil {
+		return err
+	}
+
+	merged, conflicts, err := mergeTrees(baseFiles, ourFiles, stashFiles, mergeLabels{ours: "Updated upstream", theirs: "Stashed changes"})
+	if err != nil {
+		return fmt.Errorf("failed to apply stash: %w", err)
+	}
+	if len(conflicts) > 0 {
+		if err := checkoutMergeResult(merged, conflicts); err != nil {
+			return fmt.Errorf("failed to apply stash: %w", err)
+		}
+		printConflicts(conflicts)
+		return fmt.Errorf("stash applied with conflicts; fix them and add the result, the stash entry is kept")
+	}
+	if err := checkoutFiles(merged); err != nil {
+		return fmt.Errorf("failed to apply stash: %w", err)
+	}
+	return nil
+}
+
 // StashDrop removes the stash at the given index (0 = newest) from the stack.
 func StashDrop(index int) error {
 	if !IsRepoInitialized() {
@@ -99,6 +142,12 @@ func StashDrop(index int) error {
 // If message is empty, uses default format: "WIP on <branch>: <latest_commit_message>"
 // If message is provided, uses format: "WIP on <branch>: <custom_message>"
 func StashPush(message string) error {
+	return StashPushWith(message, false)
+}
+
+// StashPushWith is StashPush that skips the pre-stash hook when noVerify is set. The hook
+// runs once there is something to stash and can stop the stash before anything changes.
+func StashPushWith(message string, noVerify bool) error {
 	// Step 1: Validate repository is initialized
 	if !IsRepoInitialized() {
 		return fmt.Errorf(
@@ -123,6 +172,11 @@ func StashPush(message string) error {
 	if !isDirty {
 		return fmt.Errorf("nothing to stash, working tree clean")
 	}
+	if !noVerify {
+		if err := runHook(hookPreStash, nil); err != nil {
+			return fmt.Errorf("%w; nothing was stashed", err)
+		}
+	}
 
 	// Step 4: Get current branch name for WIP message
 	branchName, err := GetHeadState()
@@ -156,14 +210,14 @@ func StashPush(message string) error {
 		return fmt.Errorf("failed to create tree from index: %w", err)
 	}
 
-	// Step 7: Get author information
-	authorName, _, _ := GetConfig("user.name")
-	if authorName == "" {
-		authorName = "Unknown"
+	// Step 7: Get author and committer information
+	author, err := authorIdent()
+	if err != nil {
+		return err
 	}
-	authorEmail, _, _ := GetConfig("user.email")
-	if authorEmail == "" {
-		authorEmail = "unknown@example.com"
+	committer, err := committerIdent()
+	if err != nil {
+		return err
 	}
 
 	// Step 8: Create WIP commit message
@@ -171,21 +225,24 @@ func StashPush(message string) error {
 	if message != "" {
 		wipMessage = fmt.Sprintf("WIP on %s: %s", branchName, message)
 	} else {
-		wipMessage = fmt.Sprintf("WIP on %s: %s", branchName, headCommit.Message)
+		wipMessage = fmt.Sprintf("WIP on %s: %s", branchName, headCommit.Subject())
 	}
 
 	// Step 9: Create the stash commit
 	stashCommit := models.Commit{
-		Parent:      headCommit.ID,
-		Message:     wipMessage,
-		Timestamp:   time.Now().UTC(),
-		TreeHash:    treeHash,
-		AuthorName:  authorName,
-		AuthorEmail: authorEmail,
-	}
-	stashCommit.ID = hashCommit(stashCommit)
-
-	// Step 10: Save the stash commit to commits.log
+		Parents:        []string{headCommit.ID},
+		Message:        wipMessage,
+		Timestamp:      author.When,
+		TreeHash:       treeHash,
+		AuthorName:     author.Name,
+		AuthorEmail:    author.Email,
+		CommitterName:  committer.Name,
+		CommitterEmail: committer.Email,
+		CommitTime:     committer.When,
+	}
+	stashCommit.ID = stashCommit.Hash()
+
+	// Step 10: Save the stash commit to the object store
 	if err := storage.AppendCommit(stashCommit); err != nil {
 		return fmt.Errorf("failed to save stash commit: %w", err)
 	}
@@ -195,17 +252,20 @@ func StashPush(message string) error {
 		return fmt.Errorf("failed to push stash: %w", err)
 	}
 
-	// Step 12: Perform hard reset to HEAD to clean the workspace
-	if err := Reset(headCommit.ID, ResetHard); err != nil {
+	// Step 12: Restore the workspace and index to HEAD, leaving the branch checked out
+	if err := UpdateWorkspaceAndIndex(headCommit.ID); err != nil {
 		return fmt.Errorf("failed to reset workspace after stashing: %w", err)
 	}
+	// The files were restored from HEAD, which did not move
+	runPostCheckout(headCommit.ID, headCommit.ID, false)
 
 	fmt.Printf("Saved working directory and index state %s\n", wipMessage)
 	return nil
 }
 
 // StashPop applies the most recent stash to the working directory and removes it.
-// It reads the stash commit, applies it to the workspace, and deletes the stash reference.
+// It merges the stash into the workspace and deletes the stash reference; a stash that
+// conflicts with HEAD is kept so it can be applied again.
 // This operation will fail if the working directory has uncommitted changes to prevent data loss.
 func StashPop() error {
 	// Step 1: Validate repository is initialized
@@ -215,13 +275,13 @@ func StashPop() error {
 		)
 	}
 
-	// Step 2: Pop the most recent stash from the stack
-	stashHash, err := storage.PopStash()
+	// Step 2: Find the most recent stash; it is only removed once it applied cleanly
+	stashHash, err := storage.PeekStash()
 	if err != nil {
 		if err == storage.ErrNoStash {
 			return fmt.Errorf("no stash entries found")
 		}
-		return fmt.Errorf("failed to pop stash: %w", err)
+		return fmt.Errorf("failed to read stash: %w", err)
 	}
 
 	// Step 3: Verify the stash commit exists
@@ -241,9 +301,12 @@ func StashPop() error {
 		)
 	}
 
-	// Step 5: Apply the stash commit to the working directory
-	if err := UpdateWorkspaceAndIndex(stashHash); err != nil {
-		return fmt.Errorf("failed to apply stash: %w", err)
+	// Step 5: Merge the stashed changes into the working directory, then drop the stash
+	if err := applyStash(stashHash); err != nil {
+		return err
+	}
+	if _, err := storage.PopStash(); err != nil {
+		return fmt.Errorf("failed to pop stash: %w", err)
 	}
 
 	// Step 6: Print success message with commit info
@@ -2,8 +2,10 @@ package core
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/LeeFred3042U/kitcat/internal/storage"
@@ -12,16 +14,19 @@ import (
 // Status compares the state of the working directory, index, and last commit,
 // then prints a summary of the changes
 func Status() error {
+	return writeStatus(os.Stdout)
+}
+
+// writeStatus writes the summary Status prints to w
+func writeStatus(w io.Writer) error {
 	// Print the current branch status at the top
 	headState, err := GetHeadState()
 	if err != nil {
 		headState = "no commits yet"
 	}
-	fmt.Printf("On branch %s\n", headState)
+	fmt.Fprintf(w, "On branch %s\n", headState)
 
 	// Load the tree from the commit that HEAD points to
-	// Note: We use GetHeadCommit() instead of storage.GetLastCommit() because
-	// after a reset, HEAD might point to an earlier commit than the last in the log
 	headTree := make(map[string]string)
 	headCommit, err := GetHeadCommit()
 	if err == nil {
@@ -35,7 +40,15 @@ func Status() error {
 	}
 
 	// Load the current staging area
-	index, err := storage.LoadIndex()
+	entries, err := storage.LoadIndexEntries()
+	if err != nil {
+		return err
+	}
+	index := make(map[string]string, len(entries))
+	for path, entry := range entries {
+		index[path] = entry.Hash
+	}
+	conflicts, err := storage.LoadIndexConflicts()
 	if err != nil {
 		return err
 	}
@@ -62,6 +75,9 @@ func Status() error {
 
 	// Categorize Staged Changes (Index vs. HEAD)
 	for path := range allPaths {
+		if _, unmerged := conflicts[path]; unmerged {
+			continue
+		}
 		headHash, inHead := headTree[path]
 		indexHash, inIndex := index[path]
 
@@ -76,6 +92,8 @@ func Status() error {
 
 	// Track which files we've seen in the working directory
 	visitedPaths := make(map[string]bool)
+	// Files that had to be rehashed but are unchanged; their stat data is saved afterwards
+	refreshed := make(map[string]storage.IndexEntry)
 
 	// Categorize Unstaged & Untracked Changes (Working Directory vs. Index)
 	err = filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
@@ -91,8 +109,11 @@ func Status() error {
 		}
 
 		visitedPaths[cleanPath] = true
+		if _, unmerged := conflicts[cleanPath]; unmerged {
+			return nil
+		}
 
-		indexHash, isTracked := index[cleanPath]
+		entry, isTracked := entries[cleanPath]
 
 		// If the file is not in the index, it's untracked
 		if !isTracked {
@@ -104,12 +125,13 @@ func Status() error {
 			return nil
 		}
 
-		// If the file is tracked, hash it and compare with the index to see if it's been modified
-		currentHash, hashErr := storage.HashFile(cleanPath)
-		if hashErr != nil {
-			return hashErr
+		// If the file is tracked, compare it with the index to see if it's been modified
+		// Files whose stat data matches the index are not rehashed
+		changed, changeErr := workingFileChanged(cleanPath, info, entry, refreshed)
+		if changeErr != nil {
+			return changeErr
 		}
-		if currentHash != indexHash {
+		if changed {
 			unstagedChanges = append(unstagedChanges, fmt.Sprintf("modified:  %s", cleanPath))
 		}
 		return nil
@@ -128,32 +150,65 @@ func Status() error {
 		}
 	}
 
+	// Save the stat data of rehashed files so the next status can skip them.
+	// This is only an optimization, so a failure (e.g. a read-only repository) is ignored.
+	_ = storage.RefreshIndex(refreshed)
+
 	// Print Final Summary - Only show sections that have content
 	if len(stagedChanges) > 0 {
-		fmt.Println("\nChanges to be committed:")
+		fmt.Fprintln(w, "\nChanges to be committed:")
 		for _, change := range stagedChanges {
-			fmt.Printf("\t%s\n", change)
+			fmt.Fprintf(w, "\t%s\n", change)
+		}
+	}
+
+	if len(conflicts) > 0 {
+		paths := make([]string, 0, len(conflicts))
+		for path := range conflicts {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		fmt.Fprintln(w, "\nUnmerged paths:")
+		fmt.Fprintln(w, "  (use \"kitcat add <file>...\" to mark resolution)")
+		for _, path := range paths {
+			fmt.Fprintf(w, "\t%-16s%s\n", describeConflict(conflicts[path])+":", path)
 		}
 	}
 
 	if len(unstagedChanges) > 0 {
-		fmt.Println("\nChanges not staged for commit:")
+		fmt.Fprintln(w, "\nChanges not staged for commit:")
 		for _, change := range unstagedChanges {
-			fmt.Printf("\t%s\n", change)
+			fmt.Fprintf(w, "\t%s\n", change)
 		}
 	}
 
 	if len(untrackedFiles) > 0 {
-		fmt.Println("\nUntracked files:")
+		fmt.Fprintln(w, "\nUntracked files:")
 		for _, file := range untrackedFiles {
-			fmt.Printf("\t%s\n", file)
+			fmt.Fprintf(w, "\t%s\n", file)
 		}
 	}
 
 	// If all sections are empty, show a clean message
-	if len(stagedChanges) == 0 && len(unstagedChanges) == 0 && len(untrackedFiles) == 0 {
-		fmt.Println("nothing to commit, working tree clean")
+	if len(stagedChanges) == 0 && len(unstagedChanges) == 0 && len(untrackedFiles) == 0 && len(conflicts) == 0 {
+		fmt.Fprintln(w, "nothing to commit, working tree clean")
 	}
 
 	return nil
 }
+
+// describeConflict names how the two sides of an unmerged path disagree
+func describeConflict(c storage.Conflict) string {
+	switch {
+	case c.Ours == nil && c.Theirs == nil:
+		return "both deleted"
+	case c.Ours == nil:
+		return "deleted by us"
+	case c.Theirs == nil:
+		return "deleted by them"
+	case c.Base == nil:
+		return "both added"
+	default:
+		return "both modified"
+	}
+}
@@ -3,8 +3,13 @@ package core
 import (
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
+	"strings"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
 )
 
 const tagsDir = ".kitcat/refs/tags"
@@ -12,32 +17,110 @@ const tagsDir = ".kitcat/refs/tags"
 // Creates a new lightweight tag pointing to a specific commit
 
 func CreateTag(tagName, commitID string) error {
+	tagPath, commit, err := prepareTag(tagName, commitID)
+	if err != nil {
+		return err
+	}
+
+	// Creates a new tag.
+	if err := os.WriteFile(tagPath, []byte(commit.ID), 0o644); err != nil {
+		return err
+	}
+
+	fmt.Printf("Tag '%s' created for commit %s\n", tagName, commit.ID)
+	return nil
+}
+
+// CreateAnnotatedTag stores a tag object for the commit commitID, recording message and
+// the committer as the tagger, and points the tag tagName at it. The tag object is
+// signed with the configured key when sign is true or tag.gpgsign is set.
+func CreateAnnotatedTag(tagName, commitID, message string, sign bool) error {
+	tagPath, commit, err := prepareTag(tagName, commitID)
+	if err != nil {
+		return err
+	}
+	message = strings.TrimSpace(message)
+	if message == "" {
+		return fmt.Errorf("aborting tag due to empty message")
+	}
+
+	tagger, err := committerIdent()
+	if err != nil {
+		return err
+	}
+	tag := storage.Tag{
+		Object:      commit.ID,
+		Type:        storage.CommitObject,
+		Name:        tagName,
+		TaggerName:  tagger.Name,
+		TaggerEmail: tagger.Email,
+		Time:        tagger.When,
+		Message:     message,
+	}
+	if sign || configBool("tag.gpgsign") {
+		if tag.Signature, err = signPayload(storage.EncodeTag(tag)); err != nil {
+			return fmt.Errorf("could not sign the tag: %w", err)
+		}
+	}
+	hash, err := storage.WriteTag(tag)
+	if err != nil {
+		return err
+	}
+	if err := SafeWrite(tagPath, []byte(hash), 0o644); err != nil {
+		return err
+	}
+
+	fmt.Printf("Tag '%s' created for commit %s\n", tagName, commit.ID)
+	return nil
+}
+
+// prepareTag checks that a new tag called tagName can be created for commitID and
+// returns the path of its ref with the commit
+func prepareTag(tagName, commitID string) (string, models.Commit, error) {
 	if !IsRepoInitialized() {
-		return fmt.Errorf("not a kitcat repository (or any of the parent directories): .kitcat")
+		return "", models.Commit{}, fmt.Errorf("not a kitcat repository (or any of the parent directories): .kitcat")
 	}
 
 	if !IsValidRefName(tagName) {
-		return fmt.Errorf("invalid tag name: %s", tagName)
+		return "", models.Commit{}, fmt.Errorf("invalid tag name: %s", tagName)
 	}
 
 	if err := os.MkdirAll(tagsDir, 0o755); err != nil {
-		return err
+		return "", models.Commit{}, err
 	}
 
 	tagPath := filepath.Join(tagsDir, tagName)
 	// Checks if tag already exists.
 	if _, err := os.Stat(tagPath); err == nil {
-		return fmt.Errorf("error: tag %s already exists", tagName)
+		return "", models.Commit{}, fmt.Errorf("error: tag %s already exists", tagName)
 	} else if !os.IsNotExist(err) {
-		return err
+		return "", models.Commit{}, err
 	}
 
-	// Creates a new tag.
-	if err := os.WriteFile(tagPath, []byte(commitID), 0o644); err != nil {
-		return err
+	commit, err := resolveCommit(commitID)
+	if err != nil {
+		return "", models.Commit{}, fmt.Errorf("invalid commit '%s': %w", commitID, err)
 	}
+	return tagPath, commit, nil
+}
 
-	fmt.Printf("Tag '%s' created for commit %s\n", tagName, commitID)
+// DeleteTag removes the tag tagName. A tag object it pointed at stays in the object
+// store until it is pruned.
+func DeleteTag(tagName string) error {
+	if !IsRepoInitialized() {
+		return fmt.Errorf("not a kitcat repository (or any of the parent directories): .kitcat")
+	}
+	if !IsValidRefName(tagName) {
+		return fmt.Errorf("invalid tag name: %s", tagName)
+	}
+	hash, ok := lookupRef("refs/tags/" + tagName)
+	if !ok {
+		return fmt.Errorf("tag '%s' not found", tagName)
+	}
+	if err := os.Remove(filepath.Join(tagsDir, tagName)); err != nil {
+		return err
+	}
+	fmt.Printf("Deleted tag '%s' (was %s)\n", tagName, shortHash(hash))
 	return nil
 }
 
@@ -74,15 +157,64 @@ func ListTags() ([]string, error) {
 	return tags, nil
 }
 
-// PrintTags prints all tags, one per line
-func PrintTags() error {
+// PrintTags prints the tags matching any of patterns, or all tags without patterns, one
+// per line. Patterns use shell wildcards such as v1.*. With lines above zero, each tag is
+// followed by up to that many lines of its message; a lightweight tag shows the message
+// of its commit instead.
+func PrintTags(patterns []string, lines int) error {
 	tags, err := ListTags()
 	if err != nil {
 		return err
 	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern '%s'", pattern)
+		}
+	}
 
 	for _, tag := range tags {
-		fmt.Println(tag)
+		if len(patterns) > 0 && !matchesAny(patterns, tag) {
+			continue
+		}
+		if lines <= 0 {
+			fmt.Println(tag)
+			continue
+		}
+		message := strings.Split(tagMessage(tag), "\n")
+		fmt.Printf("%-15s %s\n", tag, message[0])
+		for i := 1; i < lines && i < len(message); i++ {
+			if message[i] == "" {
+				fmt.Println()
+				continue
+			}
+			fmt.Printf("    %s\n", message[i])
+		}
 	}
 	return nil
 }
+
+// matchesAny reports whether name matches one of the wildcard patterns
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// tagMessage returns the message of the tag called name: that of its tag object, or of
+// the commit a lightweight tag points at
+func tagMessage(name string) string {
+	hash, ok := lookupRef("refs/tags/" + name)
+	if !ok {
+		return ""
+	}
+	if tag, err := storage.ReadTag(hash); err == nil {
+		return tag.Message
+	}
+	if commit, err := storage.FindCommit(hash); err == nil {
+		return commit.Message
+	}
+	return ""
+}
@@ -0,0 +1,245 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// advertisement is what a repository tells others about itself before a transfer: the
+// branch HEAD points at and every branch and tag with the commit it names
+type advertisement struct {
+	Head string            `json:"head,omitempty"`
+	Refs map[string]string `json:"refs"`
+}
+
+// refUpdate asks a repository to move Ref from Old (empty if it does not exist yet) to New
+type refUpdate struct {
+	Ref   string `json:"ref"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+	Force bool   `json:"force"`
+}
+
+// transport is a connection to the repository behind a remote
+type transport interface {
+	// advertise returns the remote's HEAD, branches and tags
+	advertise() (advertisement, error)
+	// fetch returns every object reachable from wants, leaving out the history of the
+	// commits in haves
+	fetch(wants, haves []string) ([]transferObject, error)
+	// push stores objects in the remote and applies update
+	push(update refUpdate, objects []transferObject) error
+}
+
+// isHTTPURL reports whether a remote location is served over HTTP
+func isHTTPURL(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
+
+// remoteLocation normalizes where a remote lives: http:// and https:// URLs are kept as
+// they are, bundle files and repositories on disk become absolute paths
+func remoteLocation(url string) (string, error) {
+	if isHTTPURL(url) {
+		return url, nil
+	}
+	if path, err := filepath.Abs(strings.TrimPrefix(url, "file://")); err == nil && storage.IsBundle(path) {
+		return path, nil
+	}
+	return repoPath(url)
+}
+
+// openTransport returns the transport for a remote location: a kitcat server for http://
+// and https:// URLs, a bundle file, or a repository on disk
+func openTransport(url string) (transport, error) {
+	location, err := remoteLocation(url)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case isHTTPURL(location):
+		return newHTTPTransport(location), nil
+	case storage.IsBundle(location):
+		return bundleTransport{path: location}, nil
+	}
+	return localTransport{dir: location}, nil
+}
+
+// localTransport reaches a repository on the same machine
+type localTransport struct {
+	dir string
+}
+
+func (t localTransport) advertise() (adv advertisement, err error) {
+	err = inRepo(t.dir, func() error {
+		adv, err = advertiseRefs()
+		return err
+	})
+	return adv, err
+}
+
+func (t localTransport) fetch(wants, haves []string) (objects []transferObject, err error) {
+	err = inRepo(t.dir, func() error {
+		objects, err = uploadObjects(wants, haves)
+		return err
+	})
+	return objects, err
+}
+
+func (t localTransport) push(update refUpdate, objects []transferObject) error {
+	return inRepo(t.dir, func() error {
+		// The pusher can write to the repository directly, so its working tree may follow
+		return receivePush(update, objects, true)
+	})
+}
+
+// rejectedError is a ref update the serving side refuses, such as one that is not a
+// fast-forward; badRequestError is a request it cannot act on, such as a push missing the
+// objects it needs. Other errors are failures of the repository itself.
+type (
+	rejectedError   struct{ error }
+	badRequestError struct{ error }
+)
+
+func (e rejectedError) Unwrap() error   { return e.error }
+func (e badRequestError) Unwrap() error { return e.error }
+
+// The functions below are the serving side of a transfer and work on the current
+// repository. The local transport and the HTTP server both call them from inside the
+// remote repository.
+
+// advertiseRefs describes the current repository to a client
+func advertiseRefs() (advertisement, error) {
+	refs, err := readRefs("refs/heads", "refs/tags")
+	if err != nil {
+		return advertisement{}, err
+	}
+	adv := advertisement{Refs: refs}
+	if head, err := readHEAD(); err == nil {
+		adv.Head = head
+	}
+	return adv, nil
+}
+
+// uploadObjects returns the objects a client that has the commits in haves needs to
+// complete the history of wants. Haves this repository does not know are ignored.
+func uploadObjects(wants, haves []string) ([]transferObject, error) {
+	for _, want := range wants {
+		if t, err := storage.ReadObjectType(want); err != nil || (t != storage.CommitObject && t != storage.TagObject) {
+			return nil, badRequestError{fmt.Errorf("not our commit %s", want)}
+		}
+	}
+	common, err := commitClosure(haves)
+	if err != nil {
+		return nil, err
+	}
+	names, err := storage.ReachableObjects(wants, func(commit string) bool { return common[commit] })
+	if err != nil {
+		return nil, err
+	}
+	return readObjects(names)
+}
+
+// receivePush stores objects sent by a client and moves a branch as update asks. The
+// update is refused if the branch moved since the client looked at it, or if it would drop
+// commits without Force. A checked out branch has its working directory updated when
+// updateWorkTree is set, unless it has uncommitted changes; otherwise the push is refused,
+// like git's receive.denyCurrentBranch, so a push never writes files by itself.
+func receivePush(update refUpdate, objects []transferObject, updateWorkTree bool) error {
+	branch, ok := strings.CutPrefix(update.Ref, "refs/heads/")
+	if !ok || !IsValidRefName(branch) {
+		return badRequestError{fmt.Errorf("refusing to update '%s': only branches can be pushed", update.Ref)}
+	}
+	checkedOut := false
+	if head, err := readHEAD(); err == nil && head == update.Ref {
+		checkedOut = true
+		if !updateWorkTree {
+			return rejectedError{fmt.Errorf("refusing to update checked out branch %s: the server does not update its working tree; push to another branch", branch)}
+		}
+		dirty, err := IsWorkDirDirty()
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return rejectedError{fmt.Errorf("refusing to update checked out branch %s: the remote working tree has uncommitted changes", branch)}
+		}
+	}
+	if current, _ := readCommitHash(update.Ref); current != update.Old {
+		return rejectedError{errors.New("the remote branch moved during the push; fetch and try again")}
+	}
+	if err := storeObjects(objects); err != nil {
+		return err
+	}
+
+	// Every object the new commit needs must be here now, sent or already present
+	tips, err := refTips()
+	if err != nil {
+		return err
+	}
+	known, err := commitClosure(tips)
+	if err != nil {
+		return err
+	}
+	if _, err := storage.ReachableObjects([]string{update.New}, func(commit string) bool { return known[commit] }); err != nil {
+		return badRequestError{fmt.Errorf("incomplete push of %s: %w", update.New, err)}
+	}
+	if update.Old != "" && !update.Force {
+		if fastForward, _ := storage.IsAncestor(update.Old, update.New); !fastForward {
+			return rejectedError{fmt.Errorf("rejected %s -> %s (non-fast-forward)", branch, branch)}
+		}
+	}
+
+	if err := updateRef(update.Ref, update.New, "push"); err != nil {
+		return err
+	}
+	if checkedOut {
+		return UpdateWorkspaceAndIndex(update.New)
+	}
+	return nil
+}
+
+// refTips returns the commits the branches, tags, remote-tracking refs and HEAD of the
+// current repository point at
+func refTips() ([]string, error) {
+	refs, err := readRefs("refs/heads", "refs/tags", "refs/remotes")
+	if err != nil {
+		return nil, err
+	}
+	tips := make([]string, 0, len(refs)+1)
+	for _, hash := range refs {
+		tips = append(tips, hash)
+	}
+	if head, err := readHead(); err == nil && head != "" {
+		tips = append(tips, head)
+	}
+	return tips, nil
+}
+
+// commitClosure returns every commit reachable from tips. Tips that are not in the
+// current repository are skipped.
+func commitClosure(tips []string) (map[string]bool, error) {
+	var queue []string
+	for _, tip := range tips {
+		if storage.HasObject(tip) {
+			queue = append(queue, tip)
+		}
+	}
+	known := make(map[string]bool)
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if known[hash] {
+			continue
+		}
+		commit, err := storage.FindCommit(hash)
+		if err != nil {
+			return nil, fmt.Errorf("broken ref to %s: %w", hash, err)
+		}
+		known[commit.ID] = true
+		queue = append(queue, commit.Parents...)
+	}
+	return known, nil
+}
@@ -0,0 +1,166 @@
+package diff
+
+import (
+	"slices"
+	"strings"
+)
+
+// Conflict markers written around overlapping changes.
+const (
+	MarkerOurs   = "<<<<<<<"
+	MarkerSep    = "======="
+	MarkerTheirs = ">>>>>>>"
+)
+
+// MergeChunk is one region of a three-way merge. A stable chunk holds lines all three
+// versions share; an unstable chunk holds the diverging lines of each version.
+type MergeChunk[T comparable] struct {
+	Stable bool
+	Base   []T
+	Ours   []T
+	Theirs []T
+}
+
+// Conflict reports whether the chunk was changed differently on both sides.
+func (c MergeChunk[T]) Conflict() bool {
+	if c.Stable {
+		return false
+	}
+	return !slices.Equal(c.Ours, c.Base) && !slices.Equal(c.Theirs, c.Base) && !slices.Equal(c.Ours, c.Theirs)
+}
+
+// Resolved returns the merged content of a chunk that is not a conflict: the side
+// that changed, or the shared content when both sides agree.
+func (c MergeChunk[T]) Resolved() []T {
+	switch {
+	case c.Stable:
+		return c.Base
+	case slices.Equal(c.Ours, c.Base):
+		return c.Theirs
+	default:
+		return c.Ours
+	}
+}
+
+// Merge3 splits ours and theirs, both derived from base, into alternating stable and
+// unstable chunks. Lines are aligned through the Myers diff of base against each side,
+// and a stable chunk is a run of base lines both sides kept in place.
+func Merge3[T comparable](base, ours, theirs []T) []MergeChunk[T] {
+	matchOurs := matches(base, ours)
+	matchTheirs := matches(base, theirs)
+
+	var chunks []MergeChunk[T]
+	i, j, k := 0, 0, 0
+	for i < len(base) || j < len(ours) || k < len(theirs) {
+		// Extend a stable run while both sides keep the next base line in sequence
+		n := 0
+		for i+n < len(base) && matchOurs[i+n] == j+n && matchTheirs[i+n] == k+n {
+			n++
+		}
+		if n > 0 {
+			chunks = append(chunks, MergeChunk[T]{Stable: true, Base: base[i : i+n], Ours: ours[j : j+n], Theirs: theirs[k : k+n]})
+			i, j, k = i+n, j+n, k+n
+			continue
+		}
+
+		// Find the next base line both sides kept; everything before it diverges
+		next := i
+		for next < len(base) && (matchOurs[next] < 0 || matchTheirs[next] < 0) {
+			next++
+		}
+		endOurs, endTheirs := len(ours), len(theirs)
+		if next < len(base) {
+			endOurs, endTheirs = matchOurs[next], matchTheirs[next]
+		}
+		chunks = append(chunks, MergeChunk[T]{Base: base[i:next], Ours: ours[j:endOurs], Theirs: theirs[k:endTheirs]})
+		i, j, k = next, endOurs, endTheirs
+	}
+	return chunks
+}
+
+// matches maps each element of base to its position in other, or -1 when the Myers
+// diff deleted it
+func matches[T comparable](base, other []T) []int {
+	match := make([]int, len(base))
+	i, j := 0, 0
+	for _, d := range NewMyersDiff(base, other).Diffs() {
+		switch d.Operation {
+		case EQUAL:
+			for range d.Text {
+				match[i] = j
+				i++
+				j++
+			}
+		case DELETE:
+			for range d.Text {
+				match[i] = -1
+				i++
+			}
+		case INSERT:
+			j += len(d.Text)
+		}
+	}
+	return match
+}
+
+// MergeResult is the outcome of a line-level three-way merge.
+type MergeResult struct {
+	Text      string
+	Conflicts int
+}
+
+// MergeText merges the changes made to base in ours and theirs line by line. Changes
+// that do not overlap are combined; overlapping changes are written out between
+// conflict markers labelled with oursLabel and theirsLabel.
+func MergeText(base, ours, theirs, oursLabel, theirsLabel string) MergeResult {
+	var out strings.Builder
+	conflicts := 0
+	for _, chunk := range Merge3(SplitLines(base), SplitLines(ours), SplitLines(theirs)) {
+		if !chunk.Conflict() {
+			for _, line := range chunk.Resolved() {
+				out.WriteString(line)
+			}
+			continue
+		}
+		conflicts++
+		writeMarker(&out, MarkerOurs, oursLabel)
+		writeSide(&out, chunk.Ours)
+		writeMarker(&out, MarkerSep, "")
+		writeSide(&out, chunk.Theirs)
+		writeMarker(&out, MarkerTheirs, theirsLabel)
+	}
+	return MergeResult{Text: out.String(), Conflicts: conflicts}
+}
+
+// SplitLines splits text into lines that keep their trailing newline, so joining
+// them gives back the original text exactly.
+func SplitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// writeMarker writes a conflict marker line with an optional label
+func writeMarker(out *strings.Builder, marker, label string) {
+	out.WriteString(marker)
+	if label != "" {
+		out.WriteString(" " + label)
+	}
+	out.WriteString("\n")
+}
+
+// writeSide writes one side of a conflict, terminating an unfinished last line so the
+// following marker starts on a line of its own
+func writeSide(out *strings.Builder, lines []string) {
+	for _, line := range lines {
+		out.WriteString(line)
+	}
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		out.WriteString("\n")
+	}
+}
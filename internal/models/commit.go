@@ -1,13 +1,126 @@
 package models
 
-import "time"
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
 
 type Commit struct {
 	ID          string
-	Parent      string
+	Parents     []string
 	Message     string
-	Timestamp   time.Time
+	Timestamp   time.Time // when the author made the change
 	TreeHash    string
 	AuthorName  string
 	AuthorEmail string
+	// CommitterName, CommitterEmail and CommitTime record who made the commit and when,
+	// which differ from the author when a commit is rebased or cherry-picked. Commits from
+	// before committers were recorded leave them empty.
+	CommitterName  string    `json:",omitempty"`
+	CommitterEmail string    `json:",omitempty"`
+	CommitTime     time.Time `json:",omitzero"`
+	// Signature is the armored SSH signature of a signed commit, made over the content
+	// the commit ID is computed from
+	Signature string `json:",omitempty"`
+}
+
+// FirstParent returns the parent a commit was made on top of, or "" for a root commit
+func (c Commit) FirstParent() string {
+	if len(c.Parents) == 0 {
+		return ""
+	}
+	return c.Parents[0]
+}
+
+// Subject returns the first line of the commit message
+func (c Commit) Subject() string {
+	subject, _, _ := strings.Cut(c.Message, "\n")
+	return subject
+}
+
+// RecordsCommitter reports whether the commit stores its committer
+func (c Commit) RecordsCommitter() bool {
+	return c.CommitterName != "" || c.CommitterEmail != "" || !c.CommitTime.IsZero()
+}
+
+// Committer returns who made the commit and when. A commit that does not record its
+// committer was committed by its author when it was authored.
+func (c Commit) Committer() (name, email string, when time.Time) {
+	if !c.RecordsCommitter() {
+		return c.AuthorName, c.AuthorEmail, c.Timestamp
+	}
+	return c.CommitterName, c.CommitterEmail, c.CommitTime
+}
+
+// Payload returns the content a commit is named by, apart from its signature:
+// the tree, the parents in order, the message and the time, then the author and committer
+// of a commit that records its committer. A signature signs exactly this.
+func (c Commit) Payload() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(c.TreeHash)
+	for _, parent := range c.Parents {
+		buf.WriteString(parent)
+	}
+	buf.WriteString(c.Message)
+	buf.WriteString(c.Timestamp.UTC().Format(time.RFC3339Nano))
+	// Commits from before committers were recorded keep the IDs they always had
+	if c.RecordsCommitter() {
+		fmt.Fprintf(&buf, "\nauthor %s <%s>\ncommitter %s <%s> %s", c.AuthorName, c.AuthorEmail,
+			c.CommitterName, c.CommitterEmail, c.CommitTime.UTC().Format(time.RFC3339Nano))
+	}
+	return buf.Bytes()
+}
+
+// Hash returns the content-based SHA-1 a commit is named by, which its ID must equal.
+// Like every object name it covers a "commit size\x00" header, so a commit never shares
+// its name with a blob of the same bytes. The signature of a signed commit is hashed
+// after the payload, so it cannot be removed or replaced without changing the ID.
+func (c Commit) Hash() string {
+	content := append(c.Payload(), c.Signature...)
+	h := sha1.New()
+	fmt.Fprintf(h, "commit %d\x00", len(content))
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// LegacyHash returns the name commits were given before the header was hashed: the
+// SHA-1 of the payload and signature alone. Commits stored under it keep that ID.
+func (c Commit) LegacyHash() string {
+	h := sha1.New()
+	h.Write(c.Payload())
+	h.Write([]byte(c.Signature))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// NamedBy reports whether hash is the current or the legacy name of the commit
+func (c Commit) NamedBy(hash string) bool {
+	return c.Hash() == hash || c.LegacyHash() == hash
+}
+
+// IsMerge reports whether the commit has more than one parent
+func (c Commit) IsMerge() bool {
+	return len(c.Parents) > 1
+}
+
+// UnmarshalJSON decodes a commit, accepting the single "Parent" field written
+// before commits could have several parents
+func (c *Commit) UnmarshalJSON(data []byte) error {
+	type plain Commit
+	var decoded struct {
+		plain
+		Parent string
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*c = Commit(decoded.plain)
+	if len(c.Parents) == 0 && decoded.Parent != "" {
+		c.Parents = []string{decoded.Parent}
+	}
+	return nil
 }
@@ -3,79 +3,90 @@ package storage
 import (
 	"crypto/sha1"
 	"encoding/hex"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 const (
 	objectsDir = ".kitcat/objects"
 )
 
-// computeFileHash computes the SHA-1 hash of a file at the given path.
-// Returns the hash as a hexadecimal string and any error encountered.
-func computeFileHash(path string) (string, error) {
+// openContent opens the content kitcat stores for the file at path:
+// the file bytes for regular files and the link target for symlinks.
+// The content size is returned as well, since objects record it in their header.
+func openContent(path string) (io.ReadCloser, int64, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, 0, err
+		}
+		target = filepath.ToSlash(target)
+		return io.NopCloser(strings.NewReader(target)), int64(len(target)), nil
+	}
 	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+// computeFileHash returns the name the content of the file at path is stored under, or
+// would be, as objectName picks it for a blob
+func computeFileHash(path string) (string, error) {
+	f, size, err := openContent(path)
 	if err != nil {
 		return "", err
 	}
 	defer f.Close()
 
-	h := sha1.New()
-	if _, err := io.Copy(h, f); err != nil {
+	h, legacy := sha1.New(), sha1.New()
+	fmt.Fprintf(h, "%s %d\x00", BlobObject, size)
+	n, err := io.Copy(io.MultiWriter(h, legacy), f)
+	if err != nil {
 		return "", err
 	}
-
-	return hex.EncodeToString(h.Sum(nil)), nil
+	if n != size {
+		return "", fmt.Errorf("%s changed while it was being hashed (expected %d bytes, read %d)", path, size, n)
+	}
+	return objectName(BlobObject, hex.EncodeToString(h.Sum(nil)), hex.EncodeToString(legacy.Sum(nil))), nil
 }
 
+// HashAndStoreFile stores the file at path as a blob object and returns its hash
+// The content is streamed into the object, so large files are never held in memory
 func HashAndStoreFile(path string) (string, error) {
 	hash, err := computeFileHash(path)
 	if err != nil {
 		return "", err
 	}
-
-	objPath := filepath.Join(objectsDir, hash)
-	// ensure objects dir exists
-	if err := os.MkdirAll(objectsDir, 0o755); err != nil {
+	if ok, err := freshenObject(BlobObject, hash); err != nil {
 		return "", err
+	} else if ok {
+		return hash, nil
 	}
 
-	if _, err := os.Stat(objPath); os.IsNotExist(err) {
-		// write via tmp file — read file again for storage
-		tmp := objPath + ".tmp"
-		f, err := os.Open(path)
-		if err != nil {
-			return "", err
-		}
-		out, err := os.Create(tmp)
-		if err != nil {
-			f.Close()
-			return "", err
-		}
-		if _, err := io.Copy(out, f); err != nil {
-			f.Close()
-			out.Close()
-			os.Remove(tmp)
-			return "", err
-		}
-		f.Close()
-		out.Close()
-		if err := os.Rename(tmp, objPath); err != nil {
-			os.Remove(tmp)
-			return "", err
-		}
+	f, size, err := openContent(path)
+	if err != nil {
+		return "", err
 	}
-	return hash, nil
+	defer f.Close()
+
+	// Name the object by what was actually written, in case the file changed since it was hashed
+	return writeObjectStream(BlobObject, size, f, func(hash string) string { return hash })
 }
 
-// Reads an object from the objects directory
-func ReadObject(hash string) ([]byte, error) {
-	objectPath := filepath.Join(objectsDir, hash)
-	return os.ReadFile(objectPath)
+// WriteBlob stores data as a blob object and returns its hash
+func WriteBlob(data []byte) (string, error) {
+	return storeObject(BlobObject, data)
 }
 
-// Computes the SHA-1 hash of a file's content
+// Computes the name a file's content is stored under
 // does not store the file in the object database
 func HashFile(path string) (string, error) {
 	return computeFileHash(path)
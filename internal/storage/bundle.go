@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A bundle file carries commits and the objects they need from one repository to another
+// without a connection between them. It starts with a text header
+//
+//	# kitcat bundle v1
+//	-<hash> <subject>    a prerequisite: a commit the receiving repository must already have
+//	<hash> <ref>         a ref the bundle provides, such as refs/heads/main
+//
+// ended by an empty line, followed by a zlib stream of objects, each written as
+// "<hash> <type> <size>\n" and its content.
+const bundleSignature = "# kitcat bundle v1"
+
+// ErrNotBundle is returned when a file does not start with the bundle signature
+var ErrNotBundle = errors.New("not a kitcat bundle")
+
+// BundleRef is a ref recorded in a bundle header
+type BundleRef struct {
+	Name string
+	Hash string
+}
+
+// BundleHeader lists what a bundle provides and what it assumes
+type BundleHeader struct {
+	Prerequisites []string
+	Refs          []BundleRef
+}
+
+// IsBundle reports whether the file at path is a bundle
+func IsBundle(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadString('\n')
+	return err == nil && strings.TrimSuffix(line, "\n") == bundleSignature
+}
+
+// WriteBundle writes a bundle to path with the given header and the named objects, which
+// are read from the object store
+func WriteBundle(path string, header BundleHeader, objects []string) error {
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, bundleSignature)
+	for _, hash := range header.Prerequisites {
+		subject := ""
+		if commit, err := FindCommit(hash); err == nil {
+			subject, _, _ = strings.Cut(commit.Message, "\n")
+		}
+		fmt.Fprintf(&buf, "-%s %s\n", hash, subject)
+	}
+	for _, ref := range header.Refs {
+		fmt.Fprintf(&buf, "%s %s\n", ref.Hash, ref.Name)
+	}
+	buf.WriteByte('\n')
+
+	zw := zlib.NewWriter(&buf)
+	for _, hash := range objects {
+		obj, err := ReadObject(hash)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(zw, "%s %s %d\n", hash, obj.Type, len(obj.Data))
+		if _, err := zw.Write(obj.Data); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return SafeWriteFile(path, buf.Bytes(), 0o644)
+}
+
+// ReadBundleHeader reads only the header of the bundle at path
+func ReadBundleHeader(path string) (BundleHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return BundleHeader{}, err
+	}
+	defer f.Close()
+	return readBundleHeader(bufio.NewReader(f))
+}
+
+// ReadBundle reads the bundle at path, checks every object in it as StoreObject does and
+// passes it to visit, in the order they were written. It returns the header.
+func ReadBundle(path string, visit func(hash string, obj Object) error) (BundleHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return BundleHeader{}, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	header, err := readBundleHeader(r)
+	if err != nil {
+		return BundleHeader{}, err
+	}
+
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return BundleHeader{}, fmt.Errorf("%w: %v", ErrCorruptObject, err)
+	}
+	defer zr.Close()
+	objects := bufio.NewReader(zr)
+	for {
+		line, err := objects.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return header, nil
+		}
+		if err != nil {
+			return BundleHeader{}, fmt.Errorf("truncated bundle: %w", err)
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return BundleHeader{}, fmt.Errorf("%w: bad object header %q", ErrCorruptObject, strings.TrimSpace(line))
+		}
+		hash, t := fields[0], ObjectType(fields[1])
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil || size < 0 || !isKnownType(t) || !isValidObjectName(hash) {
+			return BundleHeader{}, fmt.Errorf("%w: bad object header %q", ErrCorruptObject, strings.TrimSpace(line))
+		}
+		data, err := io.ReadAll(io.LimitReader(objects, size))
+		if err != nil {
+			return BundleHeader{}, err
+		}
+		if int64(len(data)) != size {
+			return BundleHeader{}, fmt.Errorf("truncated bundle: object %s is incomplete", hash)
+		}
+		obj := Object{Type: t, Data: data}
+		if err := checkReceivedObject(hash, obj); err != nil {
+			return BundleHeader{}, err
+		}
+		if err := visit(hash, obj); err != nil {
+			return BundleHeader{}, err
+		}
+	}
+}
+
+// readBundleHeader parses the header up to and including the empty line that ends it
+func readBundleHeader(r *bufio.Reader) (BundleHeader, error) {
+	line, err := r.ReadString('\n')
+	if err != nil || strings.TrimSuffix(line, "\n") != bundleSignature {
+		return BundleHeader{}, ErrNotBundle
+	}
+	var header BundleHeader
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return BundleHeader{}, fmt.Errorf("truncated bundle header: %w", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return header, nil
+		}
+		if prereq, ok := strings.CutPrefix(line, "-"); ok {
+			hash, _, _ := strings.Cut(prereq, " ")
+			if !isHexHash(hash) {
+				return BundleHeader{}, fmt.Errorf("bad prerequisite in bundle header: %q", line)
+			}
+			header.Prerequisites = append(header.Prerequisites, hash)
+			continue
+		}
+		hash, name, ok := strings.Cut(line, " ")
+		if !ok || !isHexHash(hash) || name == "" {
+			return BundleHeader{}, fmt.Errorf("bad ref in bundle header: %q", line)
+		}
+		header.Refs = append(header.Refs, BundleRef{Name: name, Hash: hash})
+	}
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestBundle_RoundTrip(t *testing.T) {
+	chdirTemp(t)
+
+	data := []byte("bundled content\n")
+	blob := HashObject(BlobObject, data)
+	if err := writeObject(BlobObject, blob, data); err != nil {
+		t.Fatal(err)
+	}
+	prereq := "c0ffee0000000000000000000000000000000000"
+	header := BundleHeader{
+		Prerequisites: []string{prereq},
+		Refs:          []BundleRef{{Name: "refs/heads/main", Hash: "beef000000000000000000000000000000000000"}},
+	}
+	if err := WriteBundle("x.bundle", header, []string{blob}); err != nil {
+		t.Fatalf("WriteBundle failed: %v", err)
+	}
+	if !IsBundle("x.bundle") {
+		t.Fatal("IsBundle does not recognize a written bundle")
+	}
+
+	var got []Object
+	read, err := ReadBundle("x.bundle", func(hash string, obj Object) error {
+		if hash != blob {
+			t.Errorf("read object %s, want %s", hash, blob)
+		}
+		got = append(got, obj)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadBundle failed: %v", err)
+	}
+	if len(got) != 1 || got[0].Type != BlobObject || !bytes.Equal(got[0].Data, data) {
+		t.Errorf("ReadBundle returned %+v", got)
+	}
+	if len(read.Prerequisites) != 1 || read.Prerequisites[0] != prereq {
+		t.Errorf("prerequisites = %v, want [%s]", read.Prerequisites, prereq)
+	}
+	if len(read.Refs) != 1 || read.Refs[0] != header.Refs[0] {
+		t.Errorf("refs = %v, want %v", read.Refs, header.Refs)
+	}
+}
+
+func TestBundle_DetectsDamage(t *testing.T) {
+	chdirTemp(t)
+
+	data := []byte("bundled content\n")
+	blob := HashObject(BlobObject, data)
+	if err := writeObject(BlobObject, blob, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteBundle("x.bundle", BundleHeader{}, []string{blob}); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile("x.bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ignore := func(string, Object) error { return nil }
+
+	if err := os.WriteFile("short.bundle", raw[:len(raw)-6], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadBundle("short.bundle", ignore); err == nil {
+		t.Errorf("a truncated bundle should be rejected")
+	}
+
+	if err := os.WriteFile("plain.txt", []byte("just text\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadBundle("plain.txt", ignore); !errors.Is(err, ErrNotBundle) {
+		t.Errorf("expected ErrNotBundle, got %v", err)
+	}
+}
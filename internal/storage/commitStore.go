@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/LeeFred3042U/kitcat/internal/models"
@@ -13,100 +14,116 @@ import (
 
 var ErrNoCommits = errors.New("no commits yet")
 
+// ErrNoMergeBase is returned by FindMergeBase for commits with unrelated histories
+var ErrNoMergeBase = errors.New("no common ancestor found")
+
+// commitsPath is the legacy NDJSON commit log. Repositories created before commits
+// were stored as objects keep it around until MigrateCommitLog is run.
 const commitsPath = ".kitcat/commits.log"
 
-// Appends commit as NDJSON
+// AppendCommit stores the commit as an object named by its ID
+// Commits are immutable, so writing an already stored commit is a no-op
 func AppendCommit(commit models.Commit) error {
-	if err := os.MkdirAll(".kitcat", 0o755); err != nil {
-		return err
+	if commit.ID == "" {
+		return fmt.Errorf("cannot store commit without an ID")
 	}
-
-	f, err := os.OpenFile(commitsPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	data, err := json.Marshal(commit)
 	if err != nil {
-		return err
-	}
-	// Direct locking on the file descriptor
-	if err := LockFile(f); err != nil {
-		f.Close()
-		return err
+		return fmt.Errorf("failed to encode commit %s: %w", commit.ID, err)
 	}
-	// Defer close to unlock
-	defer f.Close()
-
-	enc := json.NewEncoder(f)
-	if err := enc.Encode(commit); err != nil {
-		return err
-	}
-	return f.Sync()
+	return writeObject(CommitObject, commit.ID, data)
 }
 
-// Reads commits (NDJSON)
-func ReadCommits() ([]models.Commit, error) {
-	var commits []models.Commit
-	if _, err := os.Stat(commitsPath); os.IsNotExist(err) {
-		return commits, nil
+// decodeCommit parses a commit object. The ID stored inside the object must match
+// the object name, which keeps blobs that merely look like JSON from being mistaken for commits.
+func decodeCommit(name string, data []byte) (models.Commit, bool) {
+	if len(data) == 0 || data[0] != '{' {
+		return models.Commit{}, false
+	}
+	var c models.Commit
+	if err := json.Unmarshal(data, &c); err != nil {
+		return models.Commit{}, false
+	}
+	if c.ID != name || c.TreeHash == "" {
+		return models.Commit{}, false
 	}
+	return c, true
+}
 
-	f, err := os.Open(commitsPath)
+// readCommitObject loads the commit stored under the exact object name. A tag object is
+// followed to the commit it points at.
+func readCommitObject(hash string) (models.Commit, error) {
+	obj, err := ReadObject(hash)
 	if err != nil {
-		return nil, err
+		return models.Commit{}, err
 	}
-	defer f.Close()
-
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		var c models.Commit
-		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
-			continue
+	if obj.Type == TagObject {
+		target, err := PeelTag(hash)
+		if err != nil {
+			return models.Commit{}, err
 		}
-		commits = append(commits, c)
+		return readCommitObject(target)
+	}
+	if obj.Type != CommitObject {
+		return models.Commit{}, fmt.Errorf("object %s is a %s, not a commit", hash, obj.Type)
 	}
-	return commits, scanner.Err()
+	c, ok := decodeCommit(hash, obj.Data)
+	if !ok {
+		return models.Commit{}, fmt.Errorf("object %s is not a commit", hash)
+	}
+	return c, nil
 }
 
-// Returns ErrNoCommits when none exist
-func GetLastCommit() (models.Commit, error) {
-	commits, err := ReadCommits()
+// ReadCommits returns every commit reachable from tips, each once, oldest first.
+// Its cost follows the history behind tips, not the number of objects in the store.
+func ReadCommits(tips []string) ([]models.Commit, error) {
+	var commits []models.Commit
+	err := walkCommits(tips, func(c models.Commit) bool {
+		commits = append(commits, c)
+		return true
+	})
 	if err != nil {
-		return models.Commit{}, err
-	}
-	if len(commits) == 0 {
-		return models.Commit{}, ErrNoCommits
+		return nil, err
 	}
-	return commits[len(commits)-1], nil
+	sort.SliceStable(commits, func(i, j int) bool {
+		return commits[i].Timestamp.Before(commits[j].Timestamp)
+	})
+	return commits, nil
 }
 
-// Search the commit log for a commit with a matching hash
-// Supports both full hashes and short hashes (prefix matching)
+// FindCommit looks up a commit by its hash
+// Full hashes are a direct object lookup; short hashes (prefixes) are matched against the object names
 func FindCommit(hash string) (models.Commit, error) {
-	file, err := os.Open(commitsPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return models.Commit{}, ErrNoCommits
-		}
+	if hash == "" {
+		return models.Commit{}, ErrNoCommits
+	}
+
+	// Exact match (full hash)
+	if c, err := readCommitObject(hash); err == nil {
+		return c, nil
+	} else if !os.IsNotExist(err) {
 		return models.Commit{}, err
 	}
-	defer file.Close()
 
+	// Prefix match (short hash)
+	names, err := listObjectsWithPrefix(hash)
+	if err != nil {
+		return models.Commit{}, err
+	}
 	var matches []models.Commit
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		var commit models.Commit
-		if err := json.Unmarshal(scanner.Bytes(), &commit); err != nil {
-			continue
+	for _, name := range names {
+		t, err := ReadObjectType(name)
+		if err != nil {
+			return models.Commit{}, err
 		}
-		// Exact match (full hash)
-		if commit.ID == hash {
-			return commit, nil
+		if t != CommitObject {
+			continue
 		}
-		// Prefix match (short hash)
-		if strings.HasPrefix(commit.ID, hash) {
-			matches = append(matches, commit)
+		c, err := readCommitObject(name)
+		if err != nil {
+			return models.Commit{}, err
 		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return models.Commit{}, err
+		matches = append(matches, c)
 	}
 
 	// If we found exactly one prefix match, return it
@@ -123,10 +140,73 @@ func FindCommit(hash string) (models.Commit, error) {
 		)
 	}
 
+	if HasCommitLog() {
+		return models.Commit{}, fmt.Errorf(
+			"commit with hash %s not found (this repository still uses commits.log, run 'kitcat migrate')",
+			hash,
+		)
+	}
 	return models.Commit{}, fmt.Errorf("commit with hash %s not found", hash)
 }
 
+// HasCommitLog reports whether the legacy commits.log still holds commits to migrate
+func HasCommitLog() bool {
+	info, err := os.Stat(commitsPath)
+	return err == nil && info.Size() > 0
+}
+
+// MigrateCommitLog moves every commit from the legacy commits.log into the object store
+// and removes the log afterwards. Commit IDs are preserved so refs stay valid.
+// Returns the number of commits migrated.
+func MigrateCommitLog() (int, error) {
+	if _, err := os.Stat(commitsPath); os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	l, err := lock(commitsPath)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock(l)
+
+	f, err := os.Open(commitsPath)
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var c models.Commit
+		if err := json.Unmarshal([]byte(line), &c); err != nil {
+			f.Close()
+			return migrated, fmt.Errorf("malformed entry in commits.log: %w", err)
+		}
+		if err := AppendCommit(c); err != nil {
+			f.Close()
+			return migrated, err
+		}
+		migrated++
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return migrated, err
+	}
+	f.Close()
+
+	if err := os.Remove(commitsPath); err != nil {
+		return migrated, err
+	}
+	return migrated, nil
+}
+
 // IsAncestor returns true if ancestorHash is equal to or is an ancestor of descendantHash
+// Every parent of a merge commit is followed
 func IsAncestor(ancestorHash, descendantHash string) (bool, error) {
 	if ancestorHash == "" || descendantHash == "" {
 		return false, nil
@@ -135,24 +215,62 @@ func IsAncestor(ancestorHash, descendantHash string) (bool, error) {
 	if ancestorHash == descendantHash {
 		return true, nil
 	}
+	found := false
+	err := WalkCommits(descendantHash, func(c models.Commit) bool {
+		if c.ID == ancestorHash {
+			found = true
+			return false
+		}
+		return true
+	})
+	return found, err
+}
+
+// WalkCommits visits hash and all of its ancestors breadth-first, each commit once
+// The walk stops early when visit returns false
+func WalkCommits(hash string, visit func(models.Commit) bool) error {
+	return walkCommits([]string{hash}, visit)
+}
 
-	current := descendantHash
-	for current != "" {
-		c, err := FindCommit(current)
+// walkCommits visits the commits reachable from any of tips breadth-first, each once.
+// A tip may name an annotated tag, which is followed to its commit.
+func walkCommits(tips []string, visit func(models.Commit) bool) error {
+	seen := make(map[string]bool)
+	var queue []string
+	for _, tip := range tips {
+		if !seen[tip] {
+			seen[tip] = true
+			queue = append(queue, tip)
+		}
+	}
+	visited := make(map[string]bool)
+	for len(queue) > 0 {
+		c, err := FindCommit(queue[0])
 		if err != nil {
-			return false, err
+			return err
 		}
-		if c.ID == ancestorHash {
-			return true, nil
+		queue = queue[1:]
+		// A tag and a branch can lead to the same commit under different names
+		if visited[c.ID] {
+			continue
+		}
+		visited[c.ID] = true
+		if !visit(c) {
+			return nil
+		}
+		for _, p := range c.Parents {
+			if !seen[p] {
+				seen[p] = true
+				queue = append(queue, p)
+			}
 		}
-		// walk up
-		current = c.Parent
 	}
-	return false, nil
+	return nil
 }
 
-// FindMergeBase calculates the best common ancestor between two commits.
-// Uses a simple ancestry path intersection (assumes linear/simple branching for now).
+// FindMergeBase calculates the best common ancestor between two commits:
+// a common ancestor that is not itself an ancestor of another common ancestor.
+// When several qualify (criss-cross history), the most recent one is returned.
 func FindMergeBase(hash1, hash2 string) (string, error) {
 	if hash1 == hash2 {
 		return hash1, nil
@@ -160,28 +278,59 @@ func FindMergeBase(hash1, hash2 string) (string, error) {
 
 	// Trace ancestry of hash1
 	ancestors1 := make(map[string]bool)
-	current := hash1
-	for current != "" {
-		ancestors1[current] = true
-		c, err := FindCommit(current)
+	if err := WalkCommits(hash1, func(c models.Commit) bool {
+		ancestors1[c.ID] = true
+		return true
+	}); err != nil {
+		return "", err
+	}
+
+	// Trace ancestry of hash2, stopping at the first common commit on every path
+	var candidates []models.Commit
+	seen := map[string]bool{hash2: true}
+	queue := []string{hash2}
+	for len(queue) > 0 {
+		c, err := FindCommit(queue[0])
 		if err != nil {
 			return "", err
 		}
-		current = c.Parent
+		queue = queue[1:]
+		if ancestors1[c.ID] {
+			candidates = append(candidates, c)
+			continue
+		}
+		for _, p := range c.Parents {
+			if !seen[p] {
+				seen[p] = true
+				queue = append(queue, p)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return "", ErrNoMergeBase
 	}
 
-	// Trace ancestry of hash2 and find first match
-	current = hash2
-	for current != "" {
-		if ancestors1[current] {
-			return current, nil
+	// Drop candidates reachable from another candidate
+	var best []models.Commit
+	for i, c := range candidates {
+		redundant := false
+		for j, other := range candidates {
+			if i == j {
+				continue
+			}
+			if ok, err := IsAncestor(c.ID, other.ID); err != nil {
+				return "", err
+			} else if ok {
+				redundant = true
+				break
+			}
 		}
-		c, err := FindCommit(current)
-		if err != nil {
-			return "", err
+		if !redundant {
+			best = append(best, c)
 		}
-		current = c.Parent
 	}
-
-	return "", fmt.Errorf("no common ancestor found")
+	sort.SliceStable(best, func(i, j int) bool {
+		return best[i].Timestamp.After(best[j].Timestamp)
+	})
+	return best[0].ID, nil
 }
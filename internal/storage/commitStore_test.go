@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+)
+
+// chdirTemp switches the working directory to a fresh temp dir for the duration of the test
+func chdirTemp(t *testing.T) {
+	t.Helper()
+	tmpDir := t.TempDir()
+	originalWd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.Chdir(originalWd)
+	})
+	if err := os.Chdir(tmpDir); err != nil {
+		t.Fatalf("Failed to chdir to temp dir: %v", err)
+	}
+}
+
+func TestAppendCommit_StoresObject(t *testing.T) {
+	chdirTemp(t)
+
+	commit := models.Commit{
+		Message:   "first",
+		TreeHash:  "da39a3ee5e6b4b0d3255bfef95601890afd80709",
+		Timestamp: time.Now().UTC(),
+	}
+	commit.ID = commit.Hash()
+	if err := AppendCommit(commit); err != nil {
+		t.Fatalf("AppendCommit failed: %v", err)
+	}
+
+	// The commit must live in the object store, not in commits.log
+	if _, err := os.Stat(objectPath(commit.ID)); err != nil {
+		t.Fatalf("commit object was not written: %v", err)
+	}
+	if _, err := os.Stat(commitsPath); !os.IsNotExist(err) {
+		t.Errorf("commits.log should not be created")
+	}
+
+	found, err := FindCommit(commit.ID)
+	if err != nil {
+		t.Fatalf("FindCommit failed: %v", err)
+	}
+	if found.Message != "first" {
+		t.Errorf("wrong commit returned: %+v", found)
+	}
+
+	short, err := FindCommit(commit.ID[:7])
+	if err != nil {
+		t.Fatalf("FindCommit by short hash failed: %v", err)
+	}
+	if short.ID != commit.ID {
+		t.Errorf("short hash resolved to %s, want %s", short.ID, commit.ID)
+	}
+
+	if _, err := FindCommit(""); err != ErrNoCommits {
+		t.Errorf("FindCommit(\"\") = %v, want ErrNoCommits", err)
+	}
+	if _, err := FindCommit("../index"); err == nil {
+		t.Errorf("FindCommit should reject names outside the object store")
+	}
+}
+
+func TestFindCommit_IgnoresNonCommitObjects(t *testing.T) {
+	chdirTemp(t)
+
+	if err := os.WriteFile("blob.txt", []byte(`{"ID":"x"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	blobHash, err := HashAndStoreFile("blob.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := FindCommit(blobHash); err == nil {
+		t.Errorf("a blob must not be returned as a commit")
+	}
+	if _, err := ReadCommits([]string{blobHash}); err == nil {
+		t.Errorf("ReadCommits should refuse a blob as a tip")
+	}
+}
+
+func TestReadCommits_WalksFromTips(t *testing.T) {
+	chdirTemp(t)
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	commit := func(message string, at time.Duration, parents ...string) models.Commit {
+		c := models.Commit{Parents: parents, Message: message, TreeHash: "t", Timestamp: base.Add(at)}
+		c.ID = c.Hash()
+		if err := AppendCommit(c); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	root := commit("root", 0)
+	left := commit("left", time.Hour, root.ID)
+	right := commit("right", 2*time.Hour, root.ID)
+	commit("unreachable", 3*time.Hour)
+	tag, err := WriteTag(Tag{Object: right.ID, Type: CommitObject, Name: "v1", Time: base})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The tag and the branch lead to the same commit, which is still listed once
+	commits, err := ReadCommits([]string{left.ID, right.ID, tag})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range commits {
+		got = append(got, c.Message)
+	}
+	if len(got) != 3 || got[0] != "root" || got[1] != "left" || got[2] != "right" {
+		t.Errorf("ReadCommits = %v, want [root left right]", got)
+	}
+}
+
+func TestMigrateCommitLog(t *testing.T) {
+	chdirTemp(t)
+
+	if err := os.MkdirAll(".kitcat", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	one := models.Commit{Message: "one", TreeHash: "t1", Timestamp: base}
+	one.ID = one.Hash()
+	two := models.Commit{Parents: []string{one.ID}, Message: "two", TreeHash: "t2", Timestamp: base.Add(time.Hour)}
+	two.ID = two.Hash()
+	legacy := []models.Commit{one, two}
+	f, err := os.Create(commitsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := json.NewEncoder(f)
+	for _, c := range legacy {
+		if err := enc.Encode(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+
+	if !HasCommitLog() {
+		t.Fatal("HasCommitLog should report the legacy log")
+	}
+
+	migrated, err := MigrateCommitLog()
+	if err != nil {
+		t.Fatalf("MigrateCommitLog failed: %v", err)
+	}
+	if migrated != 2 {
+		t.Errorf("migrated %d commits, want 2", migrated)
+	}
+	if _, err := os.Stat(commitsPath); !os.IsNotExist(err) {
+		t.Errorf("commits.log should be removed after migration")
+	}
+
+	ok, err := IsAncestor(legacy[0].ID, legacy[1].ID)
+	if err != nil || !ok {
+		t.Errorf("IsAncestor after migration = %v, %v", ok, err)
+	}
+
+	commits, err := ReadCommits([]string{legacy[1].ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 2 || commits[0].Message != "one" || commits[1].Message != "two" {
+		t.Errorf("ReadCommits returned %+v", commits)
+	}
+
+	// A second run is a no-op
+	if migrated, err := MigrateCommitLog(); err != nil || migrated != 0 {
+		t.Errorf("second migration = %d, %v", migrated, err)
+	}
+}
+
+func TestFindMergeBase_MergeHistory(t *testing.T) {
+	chdirTemp(t)
+
+	// root - a - m (merge of a and b)
+	//    \- b ---/   \
+	//                 c
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ids := map[string]string{}
+	add := func(name string, minutes int, parents ...string) {
+		c := models.Commit{Message: name, TreeHash: "t", Timestamp: base.Add(time.Duration(minutes) * time.Minute)}
+		for _, p := range parents {
+			c.Parents = append(c.Parents, ids[p])
+		}
+		c.ID = c.Hash()
+		if err := AppendCommit(c); err != nil {
+			t.Fatal(err)
+		}
+		ids[name] = c.ID
+	}
+	add("root", 0)
+	add("a", 1, "root")
+	add("b", 2, "root")
+	add("m", 3, "a", "b")
+	add("c", 4, "b")
+
+	if ok, err := IsAncestor(ids["b"], ids["m"]); err != nil || !ok {
+		t.Errorf("second parent should be an ancestor of the merge: %v, %v", ok, err)
+	}
+	mb, err := FindMergeBase(ids["m"], ids["c"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mb != ids["b"] {
+		t.Errorf("FindMergeBase(m, c) = %s, want b", mb)
+	}
+}
+
+func TestDecodeCommit_LegacySingleParent(t *testing.T) {
+	data := []byte(`{"ID":"child","Parent":"parent","Message":"m","TreeHash":"t"}`)
+	c, ok := decodeCommit("child", data)
+	if !ok {
+		t.Fatal("legacy commit was not decoded")
+	}
+	if len(c.Parents) != 1 || c.Parents[0] != "parent" {
+		t.Errorf("Parents = %v, want [parent]", c.Parents)
+	}
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Delta instructions. A delta starts with the base and target sizes as uvarints,
+// followed by a sequence of instructions that rebuild the target from the base.
+const (
+	deltaInsert byte = 0 // uvarint length, then that many literal bytes
+	deltaCopy   byte = 1 // uvarint offset and uvarint length into the base
+)
+
+// deltaBlockSize is the granularity at which the base is indexed when searching for matches
+const deltaBlockSize = 16
+
+var errBadDelta = errors.New("malformed delta")
+
+// computeDelta encodes target as a set of copy/insert instructions against base
+func computeDelta(base, target []byte) []byte {
+	var out bytes.Buffer
+	out.Write(binary.AppendUvarint(nil, uint64(len(base))))
+	out.Write(binary.AppendUvarint(nil, uint64(len(target))))
+
+	// Index the base at block boundaries; the first occurrence of a block wins
+	blocks := make(map[string]int)
+	for i := 0; i+deltaBlockSize <= len(base); i += deltaBlockSize {
+		key := string(base[i : i+deltaBlockSize])
+		if _, ok := blocks[key]; !ok {
+			blocks[key] = i
+		}
+	}
+
+	var pending []byte
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		out.WriteByte(deltaInsert)
+		out.Write(binary.AppendUvarint(nil, uint64(len(pending))))
+		out.Write(pending)
+		pending = pending[:0]
+	}
+
+	for i := 0; i < len(target); {
+		if i+deltaBlockSize <= len(target) {
+			if off, ok := blocks[string(target[i:i+deltaBlockSize])]; ok {
+				forward := deltaBlockSize
+				for off+forward < len(base) && i+forward < len(target) && base[off+forward] == target[i+forward] {
+					forward++
+				}
+				// Grow the match backwards into bytes that would otherwise be inserted
+				start, n := off, forward
+				for start > 0 && len(pending) > 0 && base[start-1] == pending[len(pending)-1] {
+					start--
+					n++
+					pending = pending[:len(pending)-1]
+				}
+				flush()
+				out.WriteByte(deltaCopy)
+				out.Write(binary.AppendUvarint(nil, uint64(start)))
+				out.Write(binary.AppendUvarint(nil, uint64(n)))
+				i += forward
+				continue
+			}
+		}
+		pending = append(pending, target[i])
+		i++
+	}
+	flush()
+	return out.Bytes()
+}
+
+// applyDelta rebuilds the target of a delta from its base
+func applyDelta(base, delta []byte) ([]byte, error) {
+	r := bytes.NewReader(delta)
+	baseSize, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errBadDelta
+	}
+	if baseSize != uint64(len(base)) {
+		return nil, fmt.Errorf("%w: base is %d bytes, delta expects %d", errBadDelta, len(base), baseSize)
+	}
+	targetSize, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errBadDelta
+	}
+
+	// The declared size is only a capacity hint; a corrupt delta must not force a huge allocation
+	out := make([]byte, 0, min(targetSize, uint64(len(base)+len(delta))))
+	for r.Len() > 0 {
+		op, _ := r.ReadByte()
+		switch op {
+		case deltaInsert:
+			n, err := binary.ReadUvarint(r)
+			if err != nil || n > uint64(r.Len()) {
+				return nil, errBadDelta
+			}
+			start := len(delta) - r.Len()
+			out = append(out, delta[start:start+int(n)]...)
+			r.Seek(int64(n), io.SeekCurrent)
+		case deltaCopy:
+			off, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, errBadDelta
+			}
+			n, err := binary.ReadUvarint(r)
+			if err != nil || off > uint64(len(base)) || n > uint64(len(base))-off {
+				return nil, errBadDelta
+			}
+			out = append(out, base[off:off+n]...)
+		default:
+			return nil, fmt.Errorf("%w: unknown instruction %d", errBadDelta, op)
+		}
+	}
+	if uint64(len(out)) != targetSize {
+		return nil, fmt.Errorf("%w: produced %d bytes, expected %d", errBadDelta, len(out), targetSize)
+	}
+	return out, nil
+}
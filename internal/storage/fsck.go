@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+)
+
+// ObjectLink is a reference from one object to another: a commit's tree and parents, a
+// tree's entries, or the target of a tag. Type is what the referenced object must be.
+type ObjectLink struct {
+	Hash string
+	Type ObjectType
+}
+
+// ListObjects returns the names of every loose and packed object
+func ListObjects() ([]string, error) {
+	return listObjects()
+}
+
+// IsFullHash reports whether s has the form of a full object name: 40 hexadecimal digits
+func IsFullHash(s string) bool {
+	return isHexHash(s)
+}
+
+// CheckObject reads the object hash, checks that its content matches its name and that
+// it is well formed for its type, and returns its type with the objects it refers to.
+// Unlike ReadTree and FindCommit, which accept what they can make sense of, every line of
+// a tree and every field of a commit or tag is checked.
+func CheckObject(hash string) (ObjectType, []ObjectLink, error) {
+	obj, err := ReadObject(hash)
+	if err != nil {
+		return "", nil, err
+	}
+	switch obj.Type {
+	case CommitObject:
+		links, err := checkCommit(hash, obj.Data)
+		return obj.Type, links, err
+	case TreeObject:
+		links, err := checkTree(hash, obj.Data)
+		return obj.Type, links, err
+	case TagObject:
+		tag, err := ParseTag(obj.Data)
+		if err != nil {
+			return obj.Type, nil, fmt.Errorf("%w %s: %v", ErrCorruptObject, hash, err)
+		}
+		return obj.Type, []ObjectLink{{Hash: tag.Object, Type: tag.Type}}, nil
+	}
+	return obj.Type, nil, nil
+}
+
+// checkCommit validates the fields of a commit object and that they hash to its name
+func checkCommit(hash string, data []byte) ([]ObjectLink, error) {
+	var c models.Commit
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(&c); err != nil {
+		return nil, fmt.Errorf("%w %s: undecodable commit: %v", ErrCorruptObject, hash, err)
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("%w %s: trailing data after the commit", ErrCorruptObject, hash)
+	}
+	if c.ID != hash || !c.NamedBy(hash) {
+		return nil, fmt.Errorf("%w %s: commit content hashes to %s", ErrCorruptObject, hash, c.Hash())
+	}
+	if !isHexHash(c.TreeHash) {
+		return nil, fmt.Errorf("%w %s: invalid tree %q", ErrCorruptObject, hash, c.TreeHash)
+	}
+	if c.Timestamp.IsZero() {
+		return nil, fmt.Errorf("%w %s: commit has no timestamp", ErrCorruptObject, hash)
+	}
+	links := []ObjectLink{{Hash: c.TreeHash, Type: TreeObject}}
+	seen := make(map[string]bool)
+	for _, parent := range c.Parents {
+		if !isHexHash(parent) {
+			return nil, fmt.Errorf("%w %s: invalid parent %q", ErrCorruptObject, hash, parent)
+		}
+		if seen[parent] {
+			return nil, fmt.Errorf("%w %s: parent %s is listed twice", ErrCorruptObject, hash, parent)
+		}
+		seen[parent] = true
+		links = append(links, ObjectLink{Hash: parent, Type: CommitObject})
+	}
+	return links, nil
+}
+
+// checkTree validates every entry of a tree object. Names must be unique within the tree;
+// trees from before nested trees ("hash path" lines) are accepted as they are.
+func checkTree(hash string, data []byte) ([]ObjectLink, error) {
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		return nil, fmt.Errorf("%w %s: tree does not end with a newline", ErrCorruptObject, hash)
+	}
+	var links []ObjectLink
+	names := make(map[string]bool)
+	for i, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if line == "" {
+			if len(data) == 0 {
+				break
+			}
+			return nil, fmt.Errorf("%w %s: empty line %d in tree", ErrCorruptObject, hash, i+1)
+		}
+		parts := strings.SplitN(line, " ", 3)
+		if len(parts) == 3 && isMode(parts[0]) {
+			mode, target, name := parts[0], parts[1], parts[2]
+			if !isHexHash(target) {
+				return nil, fmt.Errorf("%w %s: invalid object name in tree entry %q", ErrCorruptObject, hash, line)
+			}
+			if !validEntryName(name) {
+				return nil, fmt.Errorf("%w %s: invalid name in tree entry %q", ErrCorruptObject, hash, line)
+			}
+			if names[name] {
+				return nil, fmt.Errorf("%w %s: duplicate tree entry %q", ErrCorruptObject, hash, name)
+			}
+			names[name] = true
+			t := BlobObject
+			if mode == ModeTree {
+				t = TreeObject
+			}
+			links = append(links, ObjectLink{Hash: target, Type: t})
+			continue
+		}
+		// Legacy flat format: "hash path"
+		target, path, ok := strings.Cut(line, " ")
+		if !ok || !isHexHash(target) || !validLegacyPath(path) {
+			return nil, fmt.Errorf("%w %s: malformed tree entry %q", ErrCorruptObject, hash, line)
+		}
+		links = append(links, ObjectLink{Hash: target, Type: BlobObject})
+	}
+	return links, nil
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+)
+
+func TestCheckObject_ReturnsLinks(t *testing.T) {
+	chdirTemp(t)
+
+	blob, err := WriteBlob([]byte("content\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := WriteTree(map[string]TreeEntry{"dir/file.txt": {Mode: ModeRegular, Hash: blob}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent := HashObject(BlobObject, []byte("parent"))
+	commit := models.Commit{Parents: []string{parent}, TreeHash: tree, Timestamp: time.Now()}
+	commit.ID = commit.Hash()
+	if err := AppendCommit(commit); err != nil {
+		t.Fatal(err)
+	}
+
+	typ, links, err := CheckObject(commit.ID)
+	if err != nil || typ != CommitObject {
+		t.Fatalf("CheckObject(commit) = %s, %v", typ, err)
+	}
+	want := []ObjectLink{{Hash: tree, Type: TreeObject}, {Hash: parent, Type: CommitObject}}
+	if fmt.Sprint(links) != fmt.Sprint(want) {
+		t.Errorf("commit links = %v, want %v", links, want)
+	}
+	if _, links, err := CheckObject(tree); err != nil || len(links) != 1 || links[0].Type != TreeObject {
+		t.Errorf("tree links = %v, %v; want its subtree", links, err)
+	}
+}
+
+func TestCheckObject_RejectsMalformedObjects(t *testing.T) {
+	chdirTemp(t)
+
+	hash := HashObject(BlobObject, []byte("x"))
+	for name, tree := range map[string]string{
+		"bad hash":       "100644 nothex file\n",
+		"slash in name":  fmt.Sprintf("100644 %s a/b\n", hash),
+		"backslash":      fmt.Sprintf("100644 %s a\\b\n", hash),
+		"repository dir": fmt.Sprintf("040000 %s .kitcat\n", hash),
+		"parent in path": fmt.Sprintf("%s a/../../b\n", hash),
+		"duplicate name": fmt.Sprintf("100644 %s a\n100755 %s a\n", hash, hash),
+		"empty line":     fmt.Sprintf("100644 %s a\n\n", hash),
+		"no newline":     fmt.Sprintf("100644 %s a", hash),
+	} {
+		data := []byte(tree)
+		name := name
+		if err := writeObject(TreeObject, HashObject(TreeObject, data), data); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := CheckObject(HashObject(TreeObject, data)); !errors.Is(err, ErrCorruptObject) {
+			t.Errorf("%s: CheckObject returned %v, want a corrupt object error", name, err)
+		}
+	}
+
+	for name, c := range map[string]models.Commit{
+		"bad tree":         {ID: HashObject(CommitObject, []byte("c1")), TreeHash: "tree", Timestamp: time.Now()},
+		"no timestamp":     {ID: HashObject(CommitObject, []byte("c2")), TreeHash: hash},
+		"duplicate parent": {ID: HashObject(CommitObject, []byte("c3")), TreeHash: hash, Parents: []string{hash, hash}, Timestamp: time.Now()},
+	} {
+		if err := AppendCommit(c); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := CheckObject(c.ID); !errors.Is(err, ErrCorruptObject) {
+			t.Errorf("%s: CheckObject returned %v, want a corrupt object error", name, err)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Git stores every object as a zlib stream of "<type> <size>\0<content>" under
+// objects/<first two hex digits>/<rest>, named by the SHA-1 of that whole stream, as kitcat
+// names its own. Unlike kitcat's, git trees are binary and commits are text.
+
+// Git's names for the modes kitcat writes. Git writes trees as "40000", without the
+// leading zero kitcat keeps, and records submodules as gitlinks, which kitcat cannot hold.
+const (
+	GitModeTree    = "40000"
+	GitModeGitlink = "160000"
+)
+
+// ErrGitObjectNotLoose is returned for an object that is missing from a git repository's
+// loose objects; it may be in a pack, which kitcat cannot read
+var ErrGitObjectNotLoose = errors.New("not a loose object")
+
+// GitTreeEntry is an entry of a git tree object
+type GitTreeEntry struct {
+	Mode string
+	Name string
+	Hash string
+}
+
+// gitObjectPath returns where a loose object lives inside gitDir
+func gitObjectPath(gitDir, hash string) string {
+	return filepath.Join(gitDir, "objects", hash[:2], hash[2:])
+}
+
+// WriteGitObject stores data as a loose object of type t in the git repository at gitDir
+// and returns its git name
+func WriteGitObject(gitDir string, t ObjectType, data []byte) (string, error) {
+	header := fmt.Sprintf("%s %d\x00", t, len(data))
+	h := sha1.New()
+	h.Write([]byte(header))
+	h.Write(data)
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	dest := gitObjectPath(gitDir, hash)
+	if _, err := os.Stat(dest); err == nil {
+		return hash, nil
+	}
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write([]byte(header))
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	// Git makes its objects read-only
+	if err := SafeWriteFile(dest, buf.Bytes(), 0o444); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// ReadGitObject reads the loose object hash from the git repository at gitDir and checks
+// its content against its name
+func ReadGitObject(gitDir, hash string) (ObjectType, []byte, error) {
+	if !isHexHash(hash) {
+		return "", nil, fmt.Errorf("invalid git object name %q", hash)
+	}
+	f, err := os.Open(gitObjectPath(gitDir, hash))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil, fmt.Errorf("git object %s: %w", hash, ErrGitObjectNotLoose)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	zr, err := zlib.NewReader(f)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w git %s: %v", ErrCorruptObject, hash, err)
+	}
+	defer zr.Close()
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w git %s: %v", ErrCorruptObject, hash, err)
+	}
+
+	sum := sha1.Sum(raw)
+	if hex.EncodeToString(sum[:]) != hash {
+		return "", nil, fmt.Errorf("%w git %s: content does not match its name", ErrCorruptObject, hash)
+	}
+	header, data, ok := bytes.Cut(raw, []byte{0})
+	if !ok {
+		return "", nil, fmt.Errorf("%w git %s: missing header", ErrCorruptObject, hash)
+	}
+	t, size, ok := strings.Cut(string(header), " ")
+	if n, err := strconv.Atoi(size); !ok || err != nil || n != len(data) {
+		return "", nil, fmt.Errorf("%w git %s: bad header %q", ErrCorruptObject, hash, header)
+	}
+	return ObjectType(t), data, nil
+}
+
+// EncodeGitTree returns the content of a git tree holding entries, in git's order: by
+// name, with subtrees compared as if their name ended in a slash
+func EncodeGitTree(entries []GitTreeEntry) ([]byte, error) {
+	sortKey := func(e GitTreeEntry) string {
+		if e.Mode == GitModeTree {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	entries = append([]GitTreeEntry(nil), entries...)
+	sort.Slice(entries, func(i, j int) bool { return sortKey(entries[i]) < sortKey(entries[j]) })
+
+	var buf bytes.Buffer
+	for _, e := range entries {
+		raw, err := hex.DecodeString(e.Hash)
+		if err != nil || len(raw) != sha1.Size {
+			return nil, fmt.Errorf("invalid object name %q for tree entry %s", e.Hash, e.Name)
+		}
+		fmt.Fprintf(&buf, "%s %s\x00", e.Mode, e.Name)
+		buf.Write(raw)
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeGitTree parses the content of a git tree
+func DecodeGitTree(data []byte) ([]GitTreeEntry, error) {
+	var entries []GitTreeEntry
+	for len(data) > 0 {
+		header, rest, ok := bytes.Cut(data, []byte{0})
+		if !ok || len(rest) < sha1.Size {
+			return nil, fmt.Errorf("%w: truncated git tree", ErrCorruptObject)
+		}
+		mode, name, ok := strings.Cut(string(header), " ")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%w: bad git tree entry %q", ErrCorruptObject, header)
+		}
+		entries = append(entries, GitTreeEntry{Mode: mode, Name: name, Hash: hex.EncodeToString(rest[:sha1.Size])})
+		data = rest[sha1.Size:]
+	}
+	return entries, nil
+}
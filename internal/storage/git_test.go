@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGitObjects_MatchGitNames(t *testing.T) {
+	gitDir := t.TempDir()
+
+	// The names git itself gives the empty blob and the empty tree
+	blob, err := WriteGitObject(gitDir, BlobObject, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blob != "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391" {
+		t.Errorf("empty blob is named %s", blob)
+	}
+	tree, err := WriteGitObject(gitDir, TreeObject, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree != "4b825dc642cb6eb9a060e54bf8d69288fbee4904" {
+		t.Errorf("empty tree is named %s", tree)
+	}
+
+	typ, data, err := ReadGitObject(gitDir, blob)
+	if err != nil || typ != BlobObject || len(data) != 0 {
+		t.Errorf("ReadGitObject = %q, %q, %v", typ, data, err)
+	}
+	path := filepath.Join(gitDir, "objects", blob[:2], blob[2:])
+	os.Chmod(path, 0o644)
+	if err := os.WriteFile(path, []byte("garbage"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ReadGitObject(gitDir, blob); err == nil {
+		t.Errorf("a damaged git object should be rejected")
+	}
+}
+
+func TestGitTree_SortsLikeGit(t *testing.T) {
+	blob := "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"
+	entries := []GitTreeEntry{
+		{Mode: ModeRegular, Name: "a.txt", Hash: blob},
+		{Mode: GitModeTree, Name: "a", Hash: blob},
+		{Mode: ModeRegular, Name: "a-b", Hash: blob},
+	}
+	data, err := EncodeGitTree(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeGitTree(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Git compares the subtree "a" as "a/", which sorts after "a-b" and "a.txt"
+	want := []string{"a-b", "a.txt", "a"}
+	if len(decoded) != len(want) {
+		t.Fatalf("decoded %d entries, want %d", len(decoded), len(want))
+	}
+	for i, name := range want {
+		if decoded[i].Name != name || decoded[i].Hash != blob {
+			t.Errorf("entry %d = %+v, want %s", i, decoded[i], name)
+		}
+	}
+}
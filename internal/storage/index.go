@@ -1,49 +1,382 @@
 package storage
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
 )
 
 const indexPath = ".kitcat/index"
 
-// LoadIndex reads the .kitcat/index file (in JSON format) and returns it as a map
+// The index is a binary file:
+//
+//	"KCIX" | uint32 version | uint32 entry count | entries... | SHA-1 of everything before
+//
+// Each entry, sorted by path and stage:
+//
+//	int64 mtime | int64 ctime (nanoseconds) | int64 size | uint64 inode | uint32 mode
+//	| uint8 stage | uvarint hash length, hash | uvarint path length, path
+//
+// Version 1 indexes have no stage byte and are still read, as are the JSON maps of
+// path -> hash stored by older repositories.
+const (
+	indexMagic   = "KCIX"
+	indexVersion = 2
+)
+
+// Index stages. A path normally has a single stage 0 entry; a path left unmerged by a
+// conflicted merge instead has up to three entries holding each side of the merge.
+const (
+	StageBase   = 1
+	StageOurs   = 2
+	StageTheirs = 3
+)
+
+// racyWindow is how recently a file may have been modified for its stat data to be
+// distrusted. A file changed again within the filesystem's timestamp granularity
+// could otherwise keep the mtime recorded in the index while its content differs.
+const racyWindow = 2 * time.Second
+
+// IndexEntry is a staged file: the blob it points to, its tree mode, and the stat
+// data of the working file when it was last known to match that blob
+type IndexEntry struct {
+	Hash  string
+	Mode  string
+	MTime int64
+	CTime int64
+	Size  int64
+	Inode uint64
+}
+
+// Conflict is an unmerged path: its base, ours and theirs versions, stored in index
+// stages 1, 2 and 3. A side is nil when the path does not exist on it.
+type Conflict struct {
+	Base   *IndexEntry
+	Ours   *IndexEntry
+	Theirs *IndexEntry
+}
+
+// stage returns the entry recorded for one stage of the conflict
+func (c *Conflict) stage(n uint8) **IndexEntry {
+	switch n {
+	case StageBase:
+		return &c.Base
+	case StageOurs:
+		return &c.Ours
+	default:
+		return &c.Theirs
+	}
+}
+
+// HasStat reports whether the entry carries stat data that can be compared
+func (e IndexEntry) HasStat() bool {
+	return e.MTime != 0
+}
+
+// StatMatches reports whether the working file described by info is unchanged since
+// the entry was recorded, so it does not need to be rehashed
+func (e IndexEntry) StatMatches(info os.FileInfo) bool {
+	if !e.HasStat() {
+		return false
+	}
+	ctime, inode := statExtra(info)
+	return e.MTime == info.ModTime().UnixNano() &&
+		e.CTime == ctime &&
+		e.Size == info.Size() &&
+		e.Inode == inode &&
+		e.Mode == modeFromFileInfo(info)
+}
+
+// ModeMatches reports whether the working file described by info has the mode recorded
+// in the entry. Entries from before modes were recorded match any file.
+func (e IndexEntry) ModeMatches(info os.FileInfo) bool {
+	return e.Mode == "" || e.Mode == modeFromFileInfo(info)
+}
+
+// IndexEntryFromInfo builds an entry for a file whose content hashes to hash,
+// recording info as its stat data. info must be taken before the content was read,
+// so a concurrent modification leaves a stale mtime rather than a false match.
+func IndexEntryFromInfo(hash string, info os.FileInfo) IndexEntry {
+	ctime, inode := statExtra(info)
+	return IndexEntry{
+		Hash:  hash,
+		Mode:  modeFromFileInfo(info),
+		MTime: info.ModTime().UnixNano(),
+		CTime: ctime,
+		Size:  info.Size(),
+		Inode: inode,
+	}
+}
+
+// NewIndexEntry builds an entry for the file at path, which the caller knows to hold
+// the blob hash (for example because it was just written from that blob)
+// A file that cannot be stat'ed gets an entry without stat data.
+func NewIndexEntry(path, hash, mode string) IndexEntry {
+	entry := IndexEntry{Hash: hash, Mode: mode}
+	if info, err := os.Lstat(path); err == nil {
+		entry = IndexEntryFromInfo(hash, info)
+		if mode != "" {
+			entry.Mode = mode
+		}
+	}
+	return entry
+}
+
+// LoadIndex reads the .kitcat/index file and returns it as a map of path -> hash
 // It returns an empty map if the file doesn't exist, which is normal for a new repository
+// Unmerged paths are not part of it; see LoadIndexConflicts.
 func LoadIndex() (map[string]string, error) {
-	return loadIndexInternal()
+	entries, _, err := loadIndexInternal()
+	if err != nil {
+		return nil, err
+	}
+	return indexHashes(entries), nil
+}
+
+// LoadIndexEntries reads the index with the mode and stat data of every entry
+func LoadIndexEntries() (map[string]IndexEntry, error) {
+	entries, _, err := loadIndexInternal()
+	return entries, err
+}
+
+// LoadIndexConflicts returns the unmerged paths of the index
+func LoadIndexConflicts() (map[string]Conflict, error) {
+	_, conflicts, err := loadIndexInternal()
+	return conflicts, err
 }
 
-func loadIndexInternal() (map[string]string, error) {
-	index := make(map[string]string)
+func loadIndexInternal() (map[string]IndexEntry, map[string]Conflict, error) {
+	index := make(map[string]IndexEntry)
+	conflicts := make(map[string]Conflict)
 
 	content, err := os.ReadFile(indexPath)
 	if os.IsNotExist(err) {
 		// File doesn't exist, return empty index. This is not an error ^-^
-		return index, nil
+		return index, conflicts, nil
 	}
 	if err != nil {
-		return nil, fmt.Errorf("could not read index file: %w", err)
+		return nil, nil, fmt.Errorf("could not read index file: %w", err)
 	}
 
-	// If the file is empty, avoid a JSON error.
+	// If the file is empty, there is nothing staged.
 	if len(content) == 0 {
-		return index, nil
+		return index, conflicts, nil
 	}
 
-	if err := json.Unmarshal(content, &index); err != nil {
-		return nil, fmt.Errorf("could not parse index file: %w", err)
+	if content[0] == '{' {
+		index, err = decodeLegacyIndex(content)
+		return index, conflicts, err
+	}
+	index, conflicts, err = decodeIndex(content)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse index file: %w", err)
 	}
+	return index, conflicts, nil
+}
 
+// decodeLegacyIndex reads the JSON index written by older versions. It has no stat
+// data, so every file is rehashed once until the index is rewritten.
+func decodeLegacyIndex(content []byte) (map[string]IndexEntry, error) {
+	var hashes map[string]string
+	if err := json.Unmarshal(content, &hashes); err != nil {
+		return nil, fmt.Errorf("could not parse index file: %w", err)
+	}
+	index := make(map[string]IndexEntry, len(hashes))
+	for path, hash := range hashes {
+		index[path] = IndexEntry{Hash: hash}
+	}
 	return index, nil
 }
 
+func decodeIndex(content []byte) (map[string]IndexEntry, map[string]Conflict, error) {
+	if len(content) < len(indexMagic)+8+sha1.Size || string(content[:len(indexMagic)]) != indexMagic {
+		return nil, nil, errors.New("not a kitcat index")
+	}
+	body, sum := content[:len(content)-sha1.Size], content[len(content)-sha1.Size:]
+	if actual := sha1.Sum(body); !bytes.Equal(actual[:], sum) {
+		return nil, nil, errors.New("index checksum mismatch")
+	}
+
+	r := bufio.NewReader(bytes.NewReader(body[len(indexMagic):]))
+	var version, count uint32
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return nil, nil, err
+	}
+	if version != 1 && version != indexVersion {
+		return nil, nil, fmt.Errorf("unsupported index version %d", version)
+	}
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, nil, err
+	}
+
+	index := make(map[string]IndexEntry, count)
+	conflicts := make(map[string]Conflict)
+	for i := uint32(0); i < count; i++ {
+		var fixed struct {
+			MTime, CTime, Size int64
+			Inode              uint64
+			Mode               uint32
+		}
+		if err := binary.Read(r, binary.BigEndian, &fixed); err != nil {
+			return nil, nil, fmt.Errorf("truncated entry %d: %w", i, err)
+		}
+		var stage uint8
+		if version >= 2 {
+			if err := binary.Read(r, binary.BigEndian, &stage); err != nil {
+				return nil, nil, fmt.Errorf("truncated entry %d: %w", i, err)
+			}
+			if stage > StageTheirs {
+				return nil, nil, fmt.Errorf("entry %d has invalid stage %d", i, stage)
+			}
+		}
+		hash, err := readIndexString(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("truncated entry %d: %w", i, err)
+		}
+		path, err := readIndexString(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("truncated entry %d: %w", i, err)
+		}
+		mode := ""
+		if fixed.Mode != 0 {
+			mode = fmt.Sprintf("%06o", fixed.Mode)
+		}
+		entry := IndexEntry{
+			Hash:  hash,
+			Mode:  mode,
+			MTime: fixed.MTime,
+			CTime: fixed.CTime,
+			Size:  fixed.Size,
+			Inode: fixed.Inode,
+		}
+		if stage == 0 {
+			index[path] = entry
+			continue
+		}
+		conflict := conflicts[path]
+		*conflict.stage(stage) = &entry
+		conflicts[path] = conflict
+	}
+	return index, conflicts, nil
+}
+
+func readIndexString(r *bufio.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if n > 1<<16 {
+		return "", fmt.Errorf("string of %d bytes is too long", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// encodeIndex serializes the index. Entries modified within racyWindow of now lose
+// their stat data, so they are rehashed until they are old enough to be trusted.
+func encodeIndex(index map[string]IndexEntry, conflicts map[string]Conflict) []byte {
+	type stagedEntry struct {
+		path  string
+		stage uint8
+		entry IndexEntry
+	}
+	staged := make([]stagedEntry, 0, len(index)+3*len(conflicts))
+	for path, entry := range index {
+		staged = append(staged, stagedEntry{path, 0, entry})
+	}
+	for path, conflict := range conflicts {
+		for stage := uint8(StageBase); stage <= StageTheirs; stage++ {
+			if entry := *conflict.stage(stage); entry != nil {
+				staged = append(staged, stagedEntry{path, stage, *entry})
+			}
+		}
+	}
+	sort.Slice(staged, func(i, j int) bool {
+		if staged[i].path != staged[j].path {
+			return staged[i].path < staged[j].path
+		}
+		return staged[i].stage < staged[j].stage
+	})
+
+	racyAfter := time.Now().Add(-racyWindow).UnixNano()
+
+	var buf bytes.Buffer
+	buf.WriteString(indexMagic)
+	binary.Write(&buf, binary.BigEndian, uint32(indexVersion))
+	binary.Write(&buf, binary.BigEndian, uint32(len(staged)))
+	for _, s := range staged {
+		path, entry := s.path, s.entry
+		if entry.MTime >= racyAfter {
+			entry = IndexEntry{Hash: entry.Hash, Mode: entry.Mode}
+		}
+		mode, err := strconv.ParseUint(entry.Mode, 8, 32)
+		if err != nil {
+			mode = 0
+		}
+		binary.Write(&buf, binary.BigEndian, struct {
+			MTime, CTime, Size int64
+			Inode              uint64
+			Mode               uint32
+		}{entry.MTime, entry.CTime, entry.Size, entry.Inode, uint32(mode)})
+		buf.WriteByte(s.stage)
+		buf.Write(binary.AppendUvarint(nil, uint64(len(entry.Hash))))
+		buf.WriteString(entry.Hash)
+		buf.Write(binary.AppendUvarint(nil, uint64(len(path))))
+		buf.WriteString(path)
+	}
+	sum := sha1.Sum(buf.Bytes())
+	buf.Write(sum[:])
+	return buf.Bytes()
+}
+
 // UpdateIndex safely updates the index by locking it before reading.
 // The callback function 'fn' is allowed to modify the index map.
 // If 'fn' returns nil, the modified index is written to disk.
 // If 'fn' returns an error, the operation is aborted and nothing is written.
+// Entries whose hash is left unchanged keep their mode and stat data.
 func UpdateIndex(fn func(index map[string]string) error) error {
+	return UpdateIndexEntries(func(entries map[string]IndexEntry) error {
+		index := indexHashes(entries)
+		if err := fn(index); err != nil {
+			return err
+		}
+		mergeIndexHashes(entries, index)
+		return nil
+	})
+}
+
+// UpdateIndexEntries is UpdateIndex for callers that record mode and stat data
+// Staging an unmerged path marks it resolved.
+func UpdateIndexEntries(fn func(index map[string]IndexEntry) error) error {
+	return UpdateIndexState(func(index map[string]IndexEntry, conflicts map[string]Conflict) error {
+		if err := fn(index); err != nil {
+			return err
+		}
+		for path := range conflicts {
+			if _, staged := index[path]; staged {
+				delete(conflicts, path)
+			}
+		}
+		return nil
+	})
+}
+
+// UpdateIndexState is UpdateIndexEntries for callers that also read or change the
+// unmerged paths. A path must not be both staged and unmerged when fn returns.
+func UpdateIndexState(fn func(index map[string]IndexEntry, conflicts map[string]Conflict) error) error {
 	// Ensure the parent directory (.kitcat) exists.
 	if err := os.MkdirAll(filepath.Dir(indexPath), 0o755); err != nil {
 		return err
@@ -57,23 +390,64 @@ func UpdateIndex(fn func(index map[string]string) error) error {
 	defer unlock(l)
 
 	// 2. Load the index (without locking, since we already hold the lock)
-	index, err := loadIndexInternal()
+	index, conflicts, err := loadIndexInternal()
 	if err != nil {
 		return err
 	}
 
 	// 3. Callback to modify the index
-	if err := fn(index); err != nil {
+	if err := fn(index, conflicts); err != nil {
 		return err // Abort transaction
 	}
+	for path := range conflicts {
+		if _, staged := index[path]; staged {
+			return fmt.Errorf("%s is both staged and unmerged", path)
+		}
+	}
 
 	// 4. Write the updated index (without locking, as we hold it)
-	return writeIndexInternal(index)
+	return writeIndexInternal(index, conflicts)
+}
+
+// RecordConflicts marks paths as unmerged, replacing any staged entry they had
+func RecordConflicts(recorded map[string]Conflict) error {
+	return UpdateIndexState(func(index map[string]IndexEntry, conflicts map[string]Conflict) error {
+		for path, conflict := range recorded {
+			delete(index, path)
+			conflicts[path] = conflict
+		}
+		return nil
+	})
+}
+
+// RefreshIndex records fresh stat data for files found to be unchanged after rehashing,
+// so later commands can skip them. Entries that were restaged in the meantime are left alone.
+func RefreshIndex(refreshed map[string]IndexEntry) error {
+	if len(refreshed) == 0 {
+		return nil
+	}
+	return UpdateIndexEntries(func(index map[string]IndexEntry) error {
+		for path, entry := range refreshed {
+			if current, ok := index[path]; ok && current.Hash == entry.Hash {
+				index[path] = entry
+			}
+		}
+		return nil
+	})
 }
 
-// WriteIndex writes the index map to the .kitcat/index file atomically using a JSON format
-// It uses SafeWriteFile to ensure atomic and durable writes
+// WriteIndex replaces the index with the given path -> hash map, dropping any unmerged paths
+// Paths whose hash matches the current index keep their mode and stat data
 func WriteIndex(index map[string]string) error {
+	return UpdateIndexState(func(entries map[string]IndexEntry, conflicts map[string]Conflict) error {
+		mergeIndexHashes(entries, index)
+		clear(conflicts)
+		return nil
+	})
+}
+
+// WriteIndexEntries replaces the index with the given entries, dropping any unmerged paths
+func WriteIndexEntries(index map[string]IndexEntry) error {
 	// Ensure the parent directory (.kitcat) exists.
 	if err := os.MkdirAll(filepath.Dir(indexPath), 0o755); err != nil {
 		return err
@@ -86,18 +460,37 @@ func WriteIndex(index map[string]string) error {
 	}
 	defer unlock(l)
 
-	return writeIndexInternal(index)
+	return writeIndexInternal(index, nil)
 }
 
 // writeIndexInternal writes the index without acquiring a lock.
 // Caller must ensure the lock is held.
-func writeIndexInternal(index map[string]string) error {
-	// Marshal the index to JSON with indentation
-	data, err := json.MarshalIndent(index, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal index: %w", err)
+func writeIndexInternal(index map[string]IndexEntry, conflicts map[string]Conflict) error {
+	// Use SafeWriteFile to atomically write the index
+	return SafeWriteFile(indexPath, encodeIndex(index, conflicts), 0o644)
+}
+
+// indexHashes projects index entries to a path -> hash map
+func indexHashes(entries map[string]IndexEntry) map[string]string {
+	index := make(map[string]string, len(entries))
+	for path, entry := range entries {
+		index[path] = entry.Hash
 	}
+	return index
+}
 
-	// Use SafeWriteFile to atomically write the index
-	return SafeWriteFile(indexPath, data, 0644)
+// mergeIndexHashes makes entries match the path -> hash map. Unchanged entries are kept;
+// new or restaged paths take their mode from the working file and have no stat data yet.
+func mergeIndexHashes(entries map[string]IndexEntry, index map[string]string) {
+	for path := range entries {
+		if _, ok := index[path]; !ok {
+			delete(entries, path)
+		}
+	}
+	for path, hash := range index {
+		if current, ok := entries[path]; ok && current.Hash == hash {
+			continue
+		}
+		entries[path] = IndexEntry{Hash: hash, Mode: FileMode(path)}
+	}
 }
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestIndexEntries_StatCache(t *testing.T) {
+	chdirTemp(t)
+
+	if err := os.WriteFile("a.txt", []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Backdate the file so its stat data is old enough to be trusted
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes("a.txt", old, old); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Lstat("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := HashAndStoreFile("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteIndexEntries(map[string]IndexEntry{"a.txt": IndexEntryFromInfo(hash, info)}); err != nil {
+		t.Fatalf("WriteIndexEntries failed: %v", err)
+	}
+	entries, err := LoadIndexEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := entries["a.txt"]
+	if entry.Hash != hash || entry.Mode != ModeRegular || entry.Size != 5 {
+		t.Fatalf("entry did not round trip: %+v", entry)
+	}
+	if !entry.StatMatches(info) {
+		t.Errorf("stat data should match the unchanged file")
+	}
+
+	// Restaging another path through the map API keeps a.txt's stat data
+	if err := UpdateIndex(func(index map[string]string) error {
+		index["b.txt"] = "2222222222222222222222222222222222222222"
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ = LoadIndexEntries()
+	if !entries["a.txt"].StatMatches(info) {
+		t.Errorf("UpdateIndex dropped stat data of an unchanged entry")
+	}
+	if entries["b.txt"].HasStat() {
+		t.Errorf("an entry staged without stat data must not claim any")
+	}
+
+	// Modifying the file invalidates the cache
+	if err := os.WriteFile("a.txt", []byte("hello, world"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info, _ = os.Lstat("a.txt")
+	if entries["a.txt"].StatMatches(info) {
+		t.Errorf("stat data should not match after the file changed")
+	}
+}
+
+func TestIndex_RacilyCleanEntriesAreNotTrusted(t *testing.T) {
+	chdirTemp(t)
+
+	if err := os.WriteFile("fresh.txt", []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Lstat("fresh.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteIndexEntries(map[string]IndexEntry{"fresh.txt": IndexEntryFromInfo("h", info)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file was modified just now, so a later write in the same timestamp tick
+	// could go unnoticed; the index must not vouch for it
+	entries, err := LoadIndexEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries["fresh.txt"].HasStat() {
+		t.Errorf("recently modified file kept its stat data: %+v", entries["fresh.txt"])
+	}
+	if entries["fresh.txt"].Mode != ModeRegular {
+		t.Errorf("mode should survive even without stat data, got %q", entries["fresh.txt"].Mode)
+	}
+}
+
+func TestLoadIndex_LegacyJSON(t *testing.T) {
+	chdirTemp(t)
+
+	if err := os.MkdirAll(".kitcat", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(indexPath, []byte(`{"a.txt":"1111111111111111111111111111111111111111"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := LoadIndex()
+	if err != nil {
+		t.Fatalf("LoadIndex on a JSON index failed: %v", err)
+	}
+	if index["a.txt"] != "1111111111111111111111111111111111111111" {
+		t.Errorf("legacy entry not loaded: %v", index)
+	}
+
+	// Any update rewrites the index in the binary format
+	if err := UpdateIndex(func(map[string]string) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content[:len(indexMagic)]) != indexMagic {
+		t.Errorf("index was not rewritten in the binary format")
+	}
+}
+
+func TestIndex_ConflictStages(t *testing.T) {
+	chdirTemp(t)
+
+	if err := WriteIndexEntries(map[string]IndexEntry{
+		"clean.txt": {Hash: "1111", Mode: ModeRegular},
+		"both.txt":  {Hash: "2222", Mode: ModeRegular},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	conflict := Conflict{
+		Base:   &IndexEntry{Hash: "aaaa", Mode: ModeRegular},
+		Ours:   &IndexEntry{Hash: "bbbb", Mode: ModeRegular},
+		Theirs: &IndexEntry{Hash: "cccc", Mode: ModeExecutable},
+	}
+	if err := RecordConflicts(map[string]Conflict{
+		"both.txt":    conflict,
+		"deleted.txt": {Base: conflict.Base, Theirs: conflict.Theirs},
+	}); err != nil {
+		t.Fatalf("RecordConflicts failed: %v", err)
+	}
+
+	entries, err := LoadIndexEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := entries["both.txt"]; ok {
+		t.Errorf("an unmerged path must not keep its stage 0 entry")
+	}
+	if entries["clean.txt"].Hash != "1111" {
+		t.Errorf("clean entry lost: %+v", entries)
+	}
+	conflicts, err := LoadIndexConflicts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := conflicts["both.txt"]
+	if got.Base == nil || got.Ours == nil || got.Theirs == nil ||
+		got.Base.Hash != "aaaa" || got.Ours.Hash != "bbbb" || got.Theirs.Hash != "cccc" || got.Theirs.Mode != ModeExecutable {
+		t.Fatalf("stages did not round trip: %+v", got)
+	}
+	if d := conflicts["deleted.txt"]; d.Ours != nil || d.Base == nil || d.Theirs == nil {
+		t.Errorf("missing side was not preserved: %+v", d)
+	}
+
+	// Staging a path resolves it; other conflicts survive the update
+	if err := UpdateIndexEntries(func(index map[string]IndexEntry) error {
+		index["both.txt"] = IndexEntry{Hash: "dddd", Mode: ModeRegular}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	conflicts, _ = LoadIndexConflicts()
+	if _, ok := conflicts["both.txt"]; ok {
+		t.Errorf("staging both.txt did not resolve it")
+	}
+	if _, ok := conflicts["deleted.txt"]; !ok {
+		t.Errorf("an unrelated update dropped the deleted.txt conflict")
+	}
+
+	// Replacing the whole index, as checkout does, clears every conflict
+	if err := WriteIndexEntries(map[string]IndexEntry{"clean.txt": {Hash: "1111"}}); err != nil {
+		t.Fatal(err)
+	}
+	if conflicts, _ = LoadIndexConflicts(); len(conflicts) != 0 {
+		t.Errorf("WriteIndexEntries kept conflicts: %v", conflicts)
+	}
+}
@@ -1,7 +1,6 @@
 package storage
 
 import (
-	"encoding/json"
 	"os"
 	"testing"
 )
@@ -43,10 +42,14 @@ func TestIndexWriteSanity(t *testing.T) {
 		t.Fatalf("Failed to read index file at %s: %v", targetPath, err)
 	}
 
-	// Assert Valid JSON
-	var loadedMap map[string]string
-	if err := json.Unmarshal(content, &loadedMap); err != nil {
-		t.Fatalf("Index file contains invalid JSON: %v", err)
+	// Assert the binary format decodes
+	entries, _, err := decodeIndex(content)
+	if err != nil {
+		t.Fatalf("Index file could not be decoded: %v", err)
+	}
+	loadedMap := make(map[string]string, len(entries))
+	for path, entry := range entries {
+		loadedMap[path] = entry.Hash
 	}
 
 	// Assert Content Integrity
@@ -0,0 +1,582 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+)
+
+// ObjectType identifies what kind of data an object holds
+type ObjectType string
+
+const (
+	BlobObject   ObjectType = "blob"
+	TreeObject   ObjectType = "tree"
+	CommitObject ObjectType = "commit"
+	TagObject    ObjectType = "tag"
+)
+
+// ErrCorruptObject is returned when an object cannot be decoded or its content
+// does not match its name
+var ErrCorruptObject = errors.New("corrupt object")
+
+// Object is a decoded object from the object store
+type Object struct {
+	Type ObjectType
+	Data []byte
+}
+
+// isKnownType reports whether t is an object type kitcat knows how to store
+func isKnownType(t ObjectType) bool {
+	switch t {
+	case BlobObject, TreeObject, CommitObject, TagObject:
+		return true
+	}
+	return false
+}
+
+// objectPath returns where an object is written: a subdirectory named after the first
+// two characters of the name holds the rest, so no single directory grows too large
+func objectPath(hash string) string {
+	if len(hash) <= 2 {
+		return filepath.Join(objectsDir, hash)
+	}
+	return filepath.Join(objectsDir, hash[:2], hash[2:])
+}
+
+// flatObjectPath returns the location used before objects were sharded
+func flatObjectPath(hash string) string {
+	return filepath.Join(objectsDir, hash)
+}
+
+// locateObject returns the path of a loose object, checking the sharded layout first
+// and falling back to the flat layout of older repositories
+func locateObject(hash string) (string, bool) {
+	if !isValidObjectName(hash) {
+		return "", false
+	}
+	for _, p := range []string{objectPath(hash), flatObjectPath(hash)} {
+		if info, err := os.Stat(p); err == nil && !info.IsDir() {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// isValidObjectName rejects names that could escape the objects directory
+func isValidObjectName(hash string) bool {
+	return hash != "" && !strings.ContainsAny(hash, "/\\") && !strings.Contains(hash, "..")
+}
+
+// HasObject reports whether an object with the given name is stored
+func HasObject(hash string) bool {
+	if _, ok := locateObject(hash); ok {
+		return true
+	}
+	return isValidObjectName(hash) && hasPackedObject(hash)
+}
+
+// HashObject returns the name an object of type t with this content is stored under:
+// the SHA-1 of a "type size\x00" header followed by the content, as git names objects
+func HashObject(t ObjectType, data []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s %d\x00", t, len(data))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// legacyObjectName returns the name objects were stored under before the type was
+// hashed with them: the SHA-1 of the content alone. Such names are still accepted.
+func legacyObjectName(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// objectName picks the name to store new content of type t under: its typed name, unless
+// an older kitcat already stored the same content as a t under its legacy name. That name
+// is kept, so unchanged files and trees still match what the index and old trees refer to.
+func objectName(t ObjectType, name, legacy string) string {
+	if name == legacy || HasObject(name) {
+		return name
+	}
+	if stored, err := ReadObjectType(legacy); err == nil && stored == t {
+		return legacy
+	}
+	return name
+}
+
+// freshenObject reports whether an object of type t is stored under hash, and dates its
+// loose file, or the pack holding it, to now. A command storing an object again is about
+// to refer to it, so prune must not take it for an old unreachable object. When the date
+// cannot be changed, or the stored copy is corrupt, the object is reported as missing so
+// that a fresh copy is written. An object of another type under the name is an error.
+func freshenObject(t ObjectType, hash string) (bool, error) {
+	stored, err := ReadObjectType(hash)
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, ErrCorruptObject) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if stored != t {
+		return false, fmt.Errorf("object %s is already stored as a %s, not a %s", hash, stored, t)
+	}
+	now := time.Now()
+	if p, ok := locateObject(hash); ok {
+		return os.Chtimes(p, now, now) == nil, nil
+	}
+	if pack, ok := packHolding(hash); ok {
+		return os.Chtimes(pack, now, now) == nil, nil
+	}
+	return false, nil
+}
+
+// storeObject stores data as an object of type t and returns its name
+func storeObject(t ObjectType, data []byte) (string, error) {
+	hash := objectName(t, HashObject(t, data), legacyObjectName(data))
+	if err := writeObject(t, hash, data); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// writeObject stores data as an object of type t under the given name
+// Objects are immutable, so an existing object is never rewritten, only freshened
+func writeObject(t ObjectType, hash string, data []byte) error {
+	if !isValidObjectName(hash) {
+		return fmt.Errorf("invalid object name %q", hash)
+	}
+	if ok, err := freshenObject(t, hash); err != nil || ok {
+		return err
+	}
+	_, err := writeObjectStream(t, int64(len(data)), bytes.NewReader(data), func(string) string { return hash })
+	return err
+}
+
+// writeObjectStream compresses size bytes from r into a new object of type t.
+// The object's name, as HashObject computes it, is worked out while writing and passed to
+// name, which returns the name to store it under. The final name is returned.
+func writeObjectStream(t ObjectType, size int64, r io.Reader, name func(hash string) string) (string, error) {
+	if err := os.MkdirAll(objectsDir, 0o755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(objectsDir, "obj-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	zw := zlib.NewWriter(tmp)
+	h := sha1.New()
+	if _, err := fmt.Fprintf(io.MultiWriter(zw, h), "%s %d\x00", t, size); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	n, err := io.Copy(io.MultiWriter(zw, h), r)
+	if err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if n != size {
+		tmp.Close()
+		return "", fmt.Errorf("content changed while it was being stored (expected %d bytes, read %d)", size, n)
+	}
+	if err := zw.Close(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	hash := name(hex.EncodeToString(h.Sum(nil)))
+	if !isValidObjectName(hash) {
+		return "", fmt.Errorf("invalid object name %q", hash)
+	}
+	if ok, err := freshenObject(t, hash); err != nil {
+		return "", err
+	} else if ok {
+		return hash, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(objectPath(hash)), 0o755); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmpName, objectPath(hash)); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// compressObject returns the stored form of an object: its "type size\x00" header and
+// content, zlib compressed
+func compressObject(t ObjectType, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	fmt.Fprintf(zw, "%s %d\x00", t, len(data))
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ReadObject reads an object from the objects directory or a pack, decompresses it and
+// verifies that its content still matches its name
+func ReadObject(hash string) (Object, error) {
+	if !isValidObjectName(hash) {
+		return Object{}, &os.PathError{Op: "open", Path: hash, Err: os.ErrNotExist}
+	}
+	p, ok := locateObject(hash)
+	if !ok {
+		obj, err := readPackedObject(hash)
+		if err != nil {
+			return Object{}, err
+		}
+		if err := verifyObject(hash, obj); err != nil {
+			return Object{}, err
+		}
+		return obj, nil
+	}
+	raw, err := os.ReadFile(p)
+	if err != nil {
+		return Object{}, err
+	}
+
+	obj, err := decodeObject(raw)
+	if err != nil {
+		// Objects written before compression was introduced are stored as raw bytes;
+		// accept them only if the raw content still matches the name
+		legacy := Object{Type: inferLegacyType(hash, raw), Data: raw}
+		if verifyObject(hash, legacy) == nil {
+			return legacy, nil
+		}
+		return Object{}, fmt.Errorf("%w %s: %v", ErrCorruptObject, hash, err)
+	}
+
+	if err := verifyObject(hash, obj); err != nil {
+		return Object{}, err
+	}
+	return obj, nil
+}
+
+// ReadBlob reads a blob object and returns its content
+func ReadBlob(hash string) ([]byte, error) {
+	obj, err := ReadObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	if obj.Type != BlobObject {
+		return nil, fmt.Errorf("object %s is a %s, not a blob", hash, obj.Type)
+	}
+	return obj.Data, nil
+}
+
+// ReadObjectType returns the type of an object by decoding only its header
+func ReadObjectType(hash string) (ObjectType, error) {
+	p, ok := locateObject(hash)
+	if !ok {
+		if !isValidObjectName(hash) {
+			return "", &os.PathError{Op: "open", Path: hash, Err: os.ErrNotExist}
+		}
+		return packedObjectType(hash)
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	zr, err := zlib.NewReader(f)
+	if err == nil {
+		defer zr.Close()
+		header, err := bufio.NewReader(zr).ReadString(0)
+		if err == nil {
+			if t, _, err := parseHeader(strings.TrimSuffix(header, "\x00")); err == nil {
+				return t, nil
+			}
+		}
+	}
+
+	// Fall back to a full read, which also handles legacy objects
+	obj, err := ReadObject(hash)
+	if err != nil {
+		return "", err
+	}
+	return obj.Type, nil
+}
+
+// decodeObject decompresses an object and splits off its "type size\0" header
+func decodeObject(raw []byte) (Object, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return Object{}, err
+	}
+	defer zr.Close()
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return Object{}, err
+	}
+
+	nul := bytes.IndexByte(data, 0)
+	if nul < 0 {
+		return Object{}, errors.New("missing object header")
+	}
+	t, size, err := parseHeader(string(data[:nul]))
+	if err != nil {
+		return Object{}, err
+	}
+	payload := data[nul+1:]
+	if int64(len(payload)) != size {
+		return Object{}, fmt.Errorf("size mismatch: header says %d bytes, found %d", size, len(payload))
+	}
+	return Object{Type: t, Data: payload}, nil
+}
+
+// parseHeader parses a "type size" object header
+func parseHeader(header string) (ObjectType, int64, error) {
+	typ, sizeStr, ok := strings.Cut(header, " ")
+	if !ok {
+		return "", 0, fmt.Errorf("malformed object header %q", header)
+	}
+	t := ObjectType(typ)
+	if !isKnownType(t) {
+		return "", 0, fmt.Errorf("unknown object type %q", typ)
+	}
+	size, err := strconv.ParseInt(sizeStr, 10, 64)
+	if err != nil || size < 0 {
+		return "", 0, fmt.Errorf("malformed object size %q", sizeStr)
+	}
+	return t, size, nil
+}
+
+// verifyObject checks that an object's content matches the name it is stored under.
+// Blobs, trees and tags are named as HashObject computes, or by their legacy name. Commits
+// are named by the hash of the fields they record, which must also equal their stored ID.
+func verifyObject(hash string, obj Object) error {
+	if obj.Type == CommitObject {
+		var c models.Commit
+		if err := json.Unmarshal(obj.Data, &c); err != nil {
+			return fmt.Errorf("%w %s: undecodable commit: %v", ErrCorruptObject, hash, err)
+		}
+		if c.ID != hash {
+			return fmt.Errorf("%w %s: commit records ID %s", ErrCorruptObject, hash, c.ID)
+		}
+		if !c.NamedBy(hash) {
+			return fmt.Errorf("%w %s: commit content hashes to %s", ErrCorruptObject, hash, c.Hash())
+		}
+		return nil
+	}
+	if actual := HashObject(obj.Type, obj.Data); actual != hash && legacyObjectName(obj.Data) != hash {
+		return fmt.Errorf("%w %s: content hashes to %s", ErrCorruptObject, hash, actual)
+	}
+	return nil
+}
+
+// inferLegacyType guesses the type of an uncompressed legacy object from its content
+func inferLegacyType(hash string, data []byte) ObjectType {
+	if _, ok := decodeCommit(hash, data); ok {
+		return CommitObject
+	}
+	if legacyObjectName(data) == hash && looksLikeTree(data) {
+		return TreeObject
+	}
+	return BlobObject
+}
+
+// looksLikeTree reports whether every line of data is a tree entry in either tree format
+func looksLikeTree(data []byte) bool {
+	if len(data) == 0 || data[len(data)-1] != '\n' {
+		return false
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		parts := strings.SplitN(line, " ", 3)
+		if len(parts) == 3 && isMode(parts[0]) && isHexHash(parts[1]) {
+			continue
+		}
+		if len(parts) >= 2 && isHexHash(parts[0]) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// isHexHash reports whether s is a full hexadecimal SHA-1
+func isHexHash(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// listObjects returns the names of all loose and packed objects
+func listObjects() ([]string, error) {
+	return listObjectsWithPrefix("")
+}
+
+// listObjectsWithPrefix returns the names of all loose and packed objects starting with prefix
+func listObjectsWithPrefix(prefix string) ([]string, error) {
+	names, err := listLooseObjects(prefix)
+	if err != nil {
+		return nil, err
+	}
+	packed, err := listPackedObjects(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if len(packed) == 0 {
+		return names, nil
+	}
+	// An object can be both packed and loose until the next gc
+	names = append(names, packed...)
+	slices.Sort(names)
+	return slices.Compact(names), nil
+}
+
+// listLooseObjects returns the names of loose objects starting with prefix, in either layout
+// Only the matching shard is read when the prefix covers the shard name
+func listLooseObjects(prefix string) ([]string, error) {
+	entries, err := os.ReadDir(objectsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if filepath.Ext(name) == ".tmp" {
+			continue
+		}
+		if !entry.IsDir() {
+			if strings.HasPrefix(name, prefix) {
+				names = append(names, name)
+			}
+			continue
+		}
+		if !isShardDir(name) || !shardMatches(name, prefix) {
+			continue
+		}
+		shard, err := os.ReadDir(filepath.Join(objectsDir, name))
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range shard {
+			full := name + obj.Name()
+			if obj.IsDir() || filepath.Ext(full) == ".tmp" || !strings.HasPrefix(full, prefix) {
+				continue
+			}
+			names = append(names, full)
+		}
+	}
+	return names, nil
+}
+
+// isShardDir reports whether a directory in objects/ is a fan-out directory
+func isShardDir(name string) bool {
+	return len(name) == 2 && isValidObjectName(name)
+}
+
+// shardMatches reports whether objects in the shard can start with prefix
+func shardMatches(shard, prefix string) bool {
+	if len(prefix) >= 2 {
+		return prefix[:2] == shard
+	}
+	return strings.HasPrefix(shard, prefix)
+}
+
+// MigrateObjectLayout moves objects stored directly in the objects directory into
+// their fan-out subdirectories. Returns the number of objects moved.
+func MigrateObjectLayout() (int, error) {
+	entries, err := os.ReadDir(objectsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	moved := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) == ".tmp" || len(name) <= 2 {
+			continue
+		}
+		dest := objectPath(name)
+		if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+			return moved, err
+		}
+		if _, err := os.Stat(dest); err == nil {
+			// Already present in the new layout; the flat copy is redundant
+			if err := os.Remove(flatObjectPath(name)); err != nil {
+				return moved, err
+			}
+		} else if err := os.Rename(flatObjectPath(name), dest); err != nil {
+			return moved, err
+		}
+		moved++
+	}
+	return moved, nil
+}
+
+// MigrateLegacyObjects rewrites uncompressed objects in the compressed, type-prefixed format
+// Returns the number of objects rewritten
+func MigrateLegacyObjects() (int, error) {
+	names, err := listLooseObjects("")
+	if err != nil {
+		return 0, err
+	}
+	migrated := 0
+	for _, name := range names {
+		p, ok := locateObject(name)
+		if !ok {
+			continue
+		}
+		raw, err := os.ReadFile(p)
+		if err != nil {
+			return migrated, err
+		}
+		if _, err := decodeObject(raw); err == nil {
+			continue
+		}
+		obj, err := ReadObject(name)
+		if err != nil {
+			return migrated, err
+		}
+		// Replace the raw file with its compressed form under the same name. The new copy
+		// is written beside it and renamed over it, so one of the two is always in place.
+		compressed, err := compressObject(obj.Type, obj.Data)
+		if err != nil {
+			return migrated, err
+		}
+		if err := SafeWriteFile(p, compressed, 0o644); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, nil
+}
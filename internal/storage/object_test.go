@@ -0,0 +1,259 @@
+package storage
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+)
+
+func TestWriteObject_CompressedWithHeader(t *testing.T) {
+	chdirTemp(t)
+
+	data := []byte("hello world\n")
+	hash := HashObject(BlobObject, data)
+	if err := writeObject(BlobObject, hash, data); err != nil {
+		t.Fatalf("writeObject failed: %v", err)
+	}
+
+	raw, err := os.ReadFile(objectPath(hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("object is not zlib compressed: %v", err)
+	}
+	var decompressed bytes.Buffer
+	if _, err := decompressed.ReadFrom(zr); err != nil {
+		t.Fatal(err)
+	}
+	if want := "blob 12\x00hello world\n"; decompressed.String() != want {
+		t.Errorf("stored %q, want %q", decompressed.String(), want)
+	}
+
+	obj, err := ReadObject(hash)
+	if err != nil {
+		t.Fatalf("ReadObject failed: %v", err)
+	}
+	if obj.Type != BlobObject || string(obj.Data) != string(data) {
+		t.Errorf("ReadObject = %s %q", obj.Type, obj.Data)
+	}
+	if typ, err := ReadObjectType(hash); err != nil || typ != BlobObject {
+		t.Errorf("ReadObjectType = %s, %v", typ, err)
+	}
+}
+
+func TestWriteObject_NamesByType(t *testing.T) {
+	chdirTemp(t)
+
+	// The empty blob and the empty tree get git's names, not one shared name
+	blob, err := WriteBlob(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := WriteTreeEntries(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blob != "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391" || tree != "4b825dc642cb6eb9a060e54bf8d69288fbee4904" {
+		t.Errorf("the empty blob is %s and the empty tree %s", blob, tree)
+	}
+	if _, err := ReadTree(tree); err != nil {
+		t.Errorf("ReadTree of the empty tree failed: %v", err)
+	}
+
+	// An object of another type stored under a name is never taken for the one being written
+	if err := writeObject(TreeObject, blob, nil); err == nil {
+		t.Error("writeObject reused a blob as a tree")
+	}
+
+	// Content an older version stored under its legacy name keeps that name
+	data := []byte("stored by an older version\n")
+	legacy := legacyObjectName(data)
+	if err := writeObject(BlobObject, legacy, data); err != nil {
+		t.Fatal(err)
+	}
+	if hash, err := WriteBlob(data); err != nil || hash != legacy {
+		t.Errorf("WriteBlob = %s, %v; want the legacy name %s", hash, err, legacy)
+	}
+	if err := os.WriteFile("old.txt", data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if hash, err := HashFile("old.txt"); err != nil || hash != legacy {
+		t.Errorf("HashFile = %s, %v; want the legacy name %s", hash, err, legacy)
+	}
+	if _, err := ReadObject(legacy); err != nil {
+		t.Errorf("ReadObject of a legacy name failed: %v", err)
+	}
+}
+
+func TestReadObject_DetectsCorruption(t *testing.T) {
+	chdirTemp(t)
+
+	if err := os.WriteFile("file.txt", []byte("original"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := HashAndStoreFile("file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replace the object with a well-formed object holding different content
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write([]byte("blob 8\x00tampered"))
+	zw.Close()
+	objPath := objectPath(hash)
+	if err := os.WriteFile(objPath, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadObject(hash); !errors.Is(err, ErrCorruptObject) {
+		t.Errorf("ReadObject on tampered content = %v, want ErrCorruptObject", err)
+	}
+
+	// Truncated compressed data is reported as corrupt too
+	if err := os.WriteFile(objPath, buf.Bytes()[:len(buf.Bytes())/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadObject(hash); !errors.Is(err, ErrCorruptObject) {
+		t.Errorf("ReadObject on truncated object = %v, want ErrCorruptObject", err)
+	}
+}
+
+// forgeCommit overwrites the stored commit c with a well-formed object holding c with
+// another message but the same ID
+func forgeCommit(t *testing.T, c models.Commit) {
+	t.Helper()
+	c.Message = "forged"
+	data, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	fmt.Fprintf(zw, "commit %d\x00%s", len(data), data)
+	zw.Close()
+	if err := os.WriteFile(objectPath(c.ID), buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadObject_RehashesCommits(t *testing.T) {
+	chdirTemp(t)
+
+	commit := models.Commit{Message: "original", TreeHash: HashObject(TreeObject, nil), Timestamp: time.Now()}
+	commit.ID = commit.Hash()
+	if err := AppendCommit(commit); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadObject(commit.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	forgeCommit(t, commit)
+	if _, err := ReadObject(commit.ID); !errors.Is(err, ErrCorruptObject) {
+		t.Errorf("ReadObject on a commit rewritten in place = %v, want ErrCorruptObject", err)
+	}
+	if _, err := FindCommit(commit.ID); err == nil {
+		t.Error("FindCommit returned the forged commit")
+	}
+}
+
+func TestMigrateLegacyObjects(t *testing.T) {
+	chdirTemp(t)
+
+	data := []byte("legacy content")
+	hash := legacyObjectName(data)
+	if err := os.MkdirAll(objectsDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(objectsDir, hash), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Legacy objects stay readable before migration
+	blob, err := ReadBlob(hash)
+	if err != nil || string(blob) != string(data) {
+		t.Fatalf("ReadBlob on legacy object = %q, %v", blob, err)
+	}
+
+	migrated, err := MigrateLegacyObjects()
+	if err != nil {
+		t.Fatalf("MigrateLegacyObjects failed: %v", err)
+	}
+	if migrated != 1 {
+		t.Errorf("migrated %d objects, want 1", migrated)
+	}
+	// The compressed copy replaces the raw file where it was, leaving nothing else behind
+	raw, err := os.ReadFile(filepath.Join(objectsDir, hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeObject(raw); err != nil {
+		t.Errorf("object was not rewritten in the compressed format: %v", err)
+	}
+	if entries, _ := os.ReadDir(objectsDir); len(entries) != 1 {
+		t.Errorf("the objects directory holds %d entries after migrating one object", len(entries))
+	}
+
+	if migrated, err := MigrateLegacyObjects(); err != nil || migrated != 0 {
+		t.Errorf("second migration = %d, %v", migrated, err)
+	}
+}
+
+func TestObjectLayout_ShardedAndFlat(t *testing.T) {
+	chdirTemp(t)
+
+	data := []byte("sharded")
+	hash := HashObject(BlobObject, data)
+	if err := writeObject(BlobObject, hash, data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(objectsDir, hash[:2], hash[2:])); err != nil {
+		t.Fatalf("object not written to its fan-out directory: %v", err)
+	}
+
+	// An object left in the flat layout by an older version
+	flatData := []byte("flat")
+	flatHash := HashObject(BlobObject, flatData)
+	if err := writeObject(BlobObject, flatHash, flatData); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(objectPath(flatHash), filepath.Join(objectsDir, flatHash)); err != nil {
+		t.Fatal(err)
+	}
+
+	if blob, err := ReadBlob(flatHash); err != nil || string(blob) != "flat" {
+		t.Fatalf("ReadBlob on flat object = %q, %v", blob, err)
+	}
+	names, err := listObjectsWithPrefix(flatHash[:4])
+	if err != nil || len(names) != 1 || names[0] != flatHash {
+		t.Errorf("listObjectsWithPrefix = %v, %v", names, err)
+	}
+
+	moved, err := MigrateObjectLayout()
+	if err != nil {
+		t.Fatalf("MigrateObjectLayout failed: %v", err)
+	}
+	if moved != 1 {
+		t.Errorf("moved %d objects, want 1", moved)
+	}
+	if _, err := os.Stat(filepath.Join(objectsDir, flatHash)); !os.IsNotExist(err) {
+		t.Errorf("flat object should be gone after migration")
+	}
+	if blob, err := ReadBlob(flatHash); err != nil || string(blob) != "flat" {
+		t.Errorf("ReadBlob after migration = %q, %v", blob, err)
+	}
+	all, err := listObjects()
+	if err != nil || len(all) != 2 {
+		t.Errorf("listObjects = %v, %v", all, err)
+	}
+}
@@ -0,0 +1,647 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Pack files hold many objects in a single file, optionally stored as deltas against
+// another object in the same pack. Each pack has an index mapping names to offsets.
+//
+// Pack layout:
+//
+//	"KPAK" | uint32 version | uint32 object count | entries... | SHA-1 of everything before
+//
+// Entry layout:
+//
+//	type byte | kind byte | [uvarint base offset, for deltas] | uvarint compressed length | zlib data
+//
+// Index layout:
+//
+//	"KIDX" | uint32 version | uint32 count | (uvarint name length, name, uint64 offset)... sorted by name
+//	| pack checksum | SHA-1 of everything before
+const (
+	packDir     = ".kitcat/objects/pack"
+	packMagic   = "KPAK"
+	idxMagic    = "KIDX"
+	packVersion = 1
+
+	packFull  byte = 0
+	packDelta byte = 1
+
+	// maxDeltaDepth bounds delta chains so reading an object stays cheap
+	maxDeltaDepth = 10
+	// deltaWindow is how many similar objects are tried as a delta base
+	deltaWindow = 10
+)
+
+var packTypeCodes = map[ObjectType]byte{
+	BlobObject:   1,
+	TreeObject:   2,
+	CommitObject: 3,
+	TagObject:    4,
+}
+
+// packIndex is the parsed index of one pack file
+type packIndex struct {
+	packPath string
+	offsets  map[string]int64
+}
+
+// packCache keeps parsed pack indexes between lookups, keyed by absolute index path
+var packCache = struct {
+	sync.Mutex
+	entries map[string]cachedPack
+}{entries: make(map[string]cachedPack)}
+
+type cachedPack struct {
+	size    int64
+	modTime int64
+	index   *packIndex
+}
+
+// loadPacks returns the indexes of every pack in the repository
+func loadPacks() ([]*packIndex, error) {
+	entries, err := os.ReadDir(packDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var packs []*packIndex
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".idx" {
+			continue
+		}
+		idxPath, err := filepath.Abs(filepath.Join(packDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(idxPath)
+		if err != nil {
+			return nil, err
+		}
+
+		packCache.Lock()
+		cached, ok := packCache.entries[idxPath]
+		packCache.Unlock()
+		if ok && cached.size == info.Size() && cached.modTime == info.ModTime().UnixNano() {
+			packs = append(packs, cached.index)
+			continue
+		}
+
+		idx, err := readPackIndex(idxPath)
+		if err != nil {
+			return nil, err
+		}
+		packCache.Lock()
+		packCache.entries[idxPath] = cachedPack{size: info.Size(), modTime: info.ModTime().UnixNano(), index: idx}
+		packCache.Unlock()
+		packs = append(packs, idx)
+	}
+	return packs, nil
+}
+
+// readPackIndex parses a pack index file
+func readPackIndex(idxPath string) (*packIndex, error) {
+	data, err := os.ReadFile(idxPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(idxMagic)+8+2*sha1.Size || string(data[:len(idxMagic)]) != idxMagic {
+		return nil, fmt.Errorf("%w: %s is not a pack index", ErrCorruptObject, idxPath)
+	}
+	body, sum := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	if actual := sha1.Sum(body); !bytes.Equal(actual[:], sum) {
+		return nil, fmt.Errorf("%w: pack index %s fails its checksum", ErrCorruptObject, idxPath)
+	}
+
+	r := bytes.NewReader(body[len(idxMagic):])
+	var version, count uint32
+	binary.Read(r, binary.BigEndian, &version)
+	binary.Read(r, binary.BigEndian, &count)
+	if version != packVersion {
+		return nil, fmt.Errorf("unsupported pack index version %d in %s", version, idxPath)
+	}
+
+	idx := &packIndex{
+		packPath: strings.TrimSuffix(idxPath, ".idx") + ".pack",
+		offsets:  make(map[string]int64, count),
+	}
+	for i := uint32(0); i < count; i++ {
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n > uint64(r.Len()) {
+			return nil, fmt.Errorf("%w: truncated pack index %s", ErrCorruptObject, idxPath)
+		}
+		name := make([]byte, n)
+		io.ReadFull(r, name)
+		var off uint64
+		if err := binary.Read(r, binary.BigEndian, &off); err != nil {
+			return nil, fmt.Errorf("%w: truncated pack index %s", ErrCorruptObject, idxPath)
+		}
+		idx.offsets[string(name)] = int64(off)
+	}
+	return idx, nil
+}
+
+// listPackedObjects returns the names of all packed objects starting with prefix
+func listPackedObjects(prefix string) ([]string, error) {
+	packs, err := loadPacks()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, p := range packs {
+		for name := range p.offsets {
+			if strings.HasPrefix(name, prefix) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names, nil
+}
+
+// hasPackedObject reports whether any pack contains the named object
+func hasPackedObject(hash string) bool {
+	_, ok := packHolding(hash)
+	return ok
+}
+
+// packHolding returns the path of a pack containing the named object
+func packHolding(hash string) (string, bool) {
+	packs, err := loadPacks()
+	if err != nil {
+		return "", false
+	}
+	for _, p := range packs {
+		if _, ok := p.offsets[hash]; ok {
+			return p.packPath, true
+		}
+	}
+	return "", false
+}
+
+// readPackedObject looks the object up in every pack and decodes it
+// A missing object is reported as a not-exist error, like a missing loose object
+func readPackedObject(hash string) (Object, error) {
+	packs, err := loadPacks()
+	if err != nil {
+		return Object{}, err
+	}
+	for _, p := range packs {
+		off, ok := p.offsets[hash]
+		if !ok {
+			continue
+		}
+		f, err := os.Open(p.packPath)
+		if err != nil {
+			return Object{}, err
+		}
+		obj, err := readPackEntry(f, off, 0)
+		f.Close()
+		if err != nil {
+			return Object{}, fmt.Errorf("%w %s: %v", ErrCorruptObject, hash, err)
+		}
+		return obj, nil
+	}
+	return Object{}, &os.PathError{Op: "open", Path: hash, Err: os.ErrNotExist}
+}
+
+// packedObjectType returns the type of a packed object from its entry, without decoding it
+func packedObjectType(hash string) (ObjectType, error) {
+	packs, err := loadPacks()
+	if err != nil {
+		return "", err
+	}
+	for _, p := range packs {
+		off, ok := p.offsets[hash]
+		if !ok {
+			continue
+		}
+		f, err := os.Open(p.packPath)
+		if err != nil {
+			return "", err
+		}
+		var code [1]byte
+		_, err = f.ReadAt(code[:], off)
+		f.Close()
+		if err != nil {
+			return "", fmt.Errorf("%w %s: %v", ErrCorruptObject, hash, err)
+		}
+		t, ok := packTypeFromCode(code[0])
+		if !ok {
+			return "", fmt.Errorf("%w %s: unknown type code %d in pack", ErrCorruptObject, hash, code[0])
+		}
+		return t, nil
+	}
+	return "", &os.PathError{Op: "open", Path: hash, Err: os.ErrNotExist}
+}
+
+// readPackEntry decodes the entry at off, resolving delta chains against their bases
+func readPackEntry(f *os.File, off int64, depth int) (Object, error) {
+	if depth > maxDeltaDepth {
+		return Object{}, errors.New("delta chain too deep")
+	}
+	r := bufio.NewReader(io.NewSectionReader(f, off, 1<<62))
+
+	code, err := r.ReadByte()
+	if err != nil {
+		return Object{}, err
+	}
+	t, ok := packTypeFromCode(code)
+	if !ok {
+		return Object{}, fmt.Errorf("unknown type code %d in pack", code)
+	}
+	kind, err := r.ReadByte()
+	if err != nil {
+		return Object{}, err
+	}
+	var baseOff uint64
+	if kind == packDelta {
+		if baseOff, err = binary.ReadUvarint(r); err != nil {
+			return Object{}, err
+		}
+		if int64(baseOff) >= off {
+			return Object{}, errors.New("delta base must precede its delta")
+		}
+	} else if kind != packFull {
+		return Object{}, fmt.Errorf("unknown entry kind %d in pack", kind)
+	}
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return Object{}, err
+	}
+
+	zr, err := zlib.NewReader(io.LimitReader(r, int64(length)))
+	if err != nil {
+		return Object{}, err
+	}
+	data, err := io.ReadAll(zr)
+	zr.Close()
+	if err != nil {
+		return Object{}, err
+	}
+
+	if kind == packFull {
+		return Object{Type: t, Data: data}, nil
+	}
+	base, err := readPackEntry(f, int64(baseOff), depth+1)
+	if err != nil {
+		return Object{}, err
+	}
+	target, err := applyDelta(base.Data, data)
+	if err != nil {
+		return Object{}, err
+	}
+	return Object{Type: t, Data: target}, nil
+}
+
+func packTypeFromCode(code byte) (ObjectType, bool) {
+	for t, c := range packTypeCodes {
+		if c == code {
+			return t, true
+		}
+	}
+	return "", false
+}
+
+// PackStats summarizes what PackObjects did
+type PackStats struct {
+	Objects      int
+	Deltas       int
+	LooseRemoved int
+	PacksMerged  int
+	PackName     string
+	SizeBefore   int64
+	SizeAfter    int64
+}
+
+// PackObjects writes every loose and packed object into a single new pack, using
+// delta compression between similar objects, then removes the loose objects and old packs
+func PackObjects() (PackStats, error) {
+	return PackObjectsExcept(nil)
+}
+
+// PackObjectsExcept works like PackObjects but does not pack the objects for which
+// leaveOut returns true. Those that are loose stay as they are; those in a pack are
+// written out as loose objects dated like that pack, so their age is kept.
+func PackObjectsExcept(leaveOut func(name string) bool) (PackStats, error) {
+	var stats PackStats
+	if err := os.MkdirAll(packDir, 0o755); err != nil {
+		return stats, err
+	}
+	l, err := lock(filepath.Join(packDir, "gc"))
+	if err != nil {
+		return stats, err
+	}
+	defer unlock(l)
+
+	loose, err := listLooseObjects("")
+	if err != nil {
+		return stats, err
+	}
+	packed, err := listPackedObjects("")
+	if err != nil {
+		return stats, err
+	}
+	oldPacks, err := loadPacks()
+	if err != nil {
+		return stats, err
+	}
+
+	var unpack []string
+	if leaveOut != nil {
+		loose = slices.DeleteFunc(loose, leaveOut)
+		packed = slices.DeleteFunc(packed, func(name string) bool {
+			if !leaveOut(name) {
+				return false
+			}
+			if _, ok := locateObject(name); !ok {
+				unpack = append(unpack, name)
+			}
+			return true
+		})
+	}
+
+	// Nothing to gain from rewriting a single pack when there are no loose objects
+	if len(loose) == 0 && len(oldPacks) <= 1 && len(unpack) == 0 {
+		return stats, nil
+	}
+
+	names := append(append([]string(nil), loose...), packed...)
+	slices.Sort(names)
+	names = slices.Compact(names)
+	if len(names) == 0 && len(unpack) == 0 {
+		return stats, nil
+	}
+
+	// Only the type and size of each object are kept here; contents are read again one at
+	// a time while the pack is written
+	objects := make([]packEntry, 0, len(names))
+	for _, name := range names {
+		obj, err := ReadObject(name)
+		if err != nil {
+			return stats, err
+		}
+		objects = append(objects, packEntry{name: name, typ: obj.Type, size: len(obj.Data)})
+	}
+
+	for _, name := range loose {
+		if p, ok := locateObject(name); ok {
+			if info, err := os.Stat(p); err == nil {
+				stats.SizeBefore += info.Size()
+			}
+		}
+	}
+	for _, p := range oldPacks {
+		for _, path := range []string{p.packPath, strings.TrimSuffix(p.packPath, ".pack") + ".idx"} {
+			if info, err := os.Stat(path); err == nil {
+				stats.SizeBefore += info.Size()
+			}
+		}
+	}
+
+	// Order by type and then by size, largest first, so similar objects end up next to
+	// each other and deltas mostly remove data from a bigger base
+	sort.SliceStable(objects, func(i, j int) bool {
+		if objects[i].typ != objects[j].typ {
+			return objects[i].typ < objects[j].typ
+		}
+		return objects[i].size > objects[j].size
+	})
+
+	// The pack is named after the objects it holds
+	if len(objects) > 0 {
+		nameHash := sha1.New()
+		for _, name := range names {
+			nameHash.Write([]byte(name))
+		}
+		stats.PackName = fmt.Sprintf("pack-%x", nameHash.Sum(nil))
+		newPack := filepath.Join(packDir, stats.PackName+".pack")
+		newIdx := filepath.Join(packDir, stats.PackName+".idx")
+
+		tmpPack, packSum, packSize, err := writePackFile(objects)
+		if tmpPack != "" {
+			defer os.Remove(tmpPack)
+		}
+		if err != nil {
+			return stats, err
+		}
+
+		var idx bytes.Buffer
+		idx.WriteString(idxMagic)
+		binary.Write(&idx, binary.BigEndian, uint32(packVersion))
+		binary.Write(&idx, binary.BigEndian, uint32(len(names)))
+		offsets := make(map[string]int64, len(objects))
+		for _, po := range objects {
+			offsets[po.name] = po.offset
+			if po.base >= 0 {
+				stats.Deltas++
+			}
+		}
+		for _, name := range names {
+			idx.Write(binary.AppendUvarint(nil, uint64(len(name))))
+			idx.WriteString(name)
+			binary.Write(&idx, binary.BigEndian, uint64(offsets[name]))
+		}
+		idx.Write(packSum)
+		idxSum := sha1.Sum(idx.Bytes())
+		idx.Write(idxSum[:])
+
+		// Move the pack into place before its index: readers only look at packs that have an index
+		if err := os.Rename(tmpPack, newPack); err != nil {
+			return stats, err
+		}
+		if err := SafeWriteFile(newIdx, idx.Bytes(), 0o644); err != nil {
+			return stats, err
+		}
+		stats.Objects = len(objects)
+		stats.SizeAfter = packSize + int64(idx.Len())
+	}
+
+	// Objects left out of the new pack must survive the removal of the old ones
+	for _, name := range unpack {
+		if err := unpackObject(name, oldPacks); err != nil {
+			return stats, err
+		}
+	}
+
+	for _, p := range oldPacks {
+		if filepath.Base(p.packPath) == stats.PackName+".pack" {
+			continue
+		}
+		os.Remove(strings.TrimSuffix(p.packPath, ".pack") + ".idx")
+		os.Remove(p.packPath)
+		stats.PacksMerged++
+	}
+	for _, name := range loose {
+		if err := removeLooseObject(name); err != nil {
+			return stats, err
+		}
+		stats.LooseRemoved++
+	}
+	return stats, nil
+}
+
+// packEntry is an object on its way into a new pack
+type packEntry struct {
+	name   string
+	typ    ObjectType
+	size   int
+	offset int64
+	// base is the index in objects of the entry this one is a delta against, or -1
+	base  int
+	depth int
+}
+
+// writePackFile streams objects, in order, into a temporary file in packDir, storing each
+// as a delta against one of the deltaWindow entries before it when that saves enough.
+// Only the contents of that window are held in memory. The offset and base of every
+// entry are filled in, and the temporary file's path, checksum and size are returned;
+// the caller removes the file if it does not rename it.
+func writePackFile(objects []packEntry) (string, []byte, int64, error) {
+	f, err := os.CreateTemp(packDir, "pack-*.tmp")
+	if err != nil {
+		return "", nil, 0, err
+	}
+	tmpName := f.Name()
+	fail := func(err error) (string, []byte, int64, error) {
+		f.Close()
+		return tmpName, nil, 0, err
+	}
+
+	h := sha1.New()
+	w := bufio.NewWriter(io.MultiWriter(f, h))
+	var off int64
+	write := func(b []byte) {
+		w.Write(b) // errors are kept by w and reported by Flush
+		off += int64(len(b))
+	}
+
+	var header bytes.Buffer
+	header.WriteString(packMagic)
+	binary.Write(&header, binary.BigEndian, uint32(packVersion))
+	binary.Write(&header, binary.BigEndian, uint32(len(objects)))
+	write(header.Bytes())
+
+	// window[i%deltaWindow] holds the content of entry i while it may still be a base
+	window := make([][]byte, deltaWindow)
+	var compressed bytes.Buffer
+	for i := range objects {
+		po := &objects[i]
+		obj, err := ReadObject(po.name)
+		if err != nil {
+			return fail(err)
+		}
+		if obj.Type != po.typ || len(obj.Data) != po.size {
+			return fail(fmt.Errorf("object %s changed while it was being packed", po.name))
+		}
+		po.offset, po.base = off, -1
+
+		payload := obj.Data
+		if po.typ != CommitObject {
+			for j := i - 1; j >= 0 && j >= i-deltaWindow; j-- {
+				cand := objects[j]
+				if cand.typ != po.typ || cand.depth >= maxDeltaDepth {
+					continue
+				}
+				delta := computeDelta(window[j%deltaWindow], obj.Data)
+				if len(delta) < len(payload)/2 {
+					payload, po.base = delta, j
+				}
+			}
+		}
+		window[i%deltaWindow] = obj.Data
+
+		write([]byte{packTypeCodes[po.typ]})
+		if po.base >= 0 {
+			write([]byte{packDelta})
+			write(binary.AppendUvarint(nil, uint64(objects[po.base].offset)))
+			po.depth = objects[po.base].depth + 1
+		} else {
+			write([]byte{packFull})
+		}
+
+		compressed.Reset()
+		zw := zlib.NewWriter(&compressed)
+		zw.Write(payload)
+		if err := zw.Close(); err != nil {
+			return fail(err)
+		}
+		write(binary.AppendUvarint(nil, uint64(compressed.Len())))
+		write(compressed.Bytes())
+	}
+	if err := w.Flush(); err != nil {
+		return fail(err)
+	}
+	sum := h.Sum(nil)
+	if _, err := f.Write(sum); err != nil {
+		return fail(err)
+	}
+	if err := f.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := f.Chmod(0o644); err != nil {
+		return fail(err)
+	}
+	if err := f.Close(); err != nil {
+		return tmpName, nil, 0, err
+	}
+	return tmpName, sum, off + int64(len(sum)), nil
+}
+
+// unpackObject writes the packed object name as a loose object, dated like its pack
+func unpackObject(name string, packs []*packIndex) error {
+	obj, err := ReadObject(name)
+	if err != nil {
+		return err
+	}
+	// writeObject would find the packed copy and skip the write
+	compressed, err := compressObject(obj.Type, obj.Data)
+	if err != nil {
+		return err
+	}
+	if err := SafeWriteFile(objectPath(name), compressed, 0o644); err != nil {
+		return err
+	}
+	for _, p := range packs {
+		if _, ok := p.offsets[name]; !ok {
+			continue
+		}
+		info, err := os.Stat(p.packPath)
+		if err != nil {
+			return err
+		}
+		return os.Chtimes(objectPath(name), info.ModTime(), info.ModTime())
+	}
+	return nil
+}
+
+// removeLooseObject deletes a loose object and its fan-out directory once it is empty
+func removeLooseObject(hash string) error {
+	p, ok := locateObject(hash)
+	if !ok {
+		return nil
+	}
+	if err := os.Remove(p); err != nil {
+		return err
+	}
+	if dir := filepath.Dir(p); dir != filepath.Clean(objectsDir) {
+		os.Remove(dir) // fails harmlessly while other objects remain
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+)
+
+func TestDelta_RoundTrip(t *testing.T) {
+	base := []byte(strings.Repeat("the quick brown fox jumps over the lazy dog\n", 50))
+	target := append([]byte("header line\n"), base[:1000]...)
+	target = append(target, []byte("inserted in the middle\n")...)
+	target = append(target, base[1000:]...)
+
+	delta := computeDelta(base, target)
+	if len(delta) >= len(target)/2 {
+		t.Errorf("delta is %d bytes for a %d byte target, expected it to be much smaller", len(delta), len(target))
+	}
+	got, err := applyDelta(base, delta)
+	if err != nil {
+		t.Fatalf("applyDelta failed: %v", err)
+	}
+	if !bytes.Equal(got, target) {
+		t.Errorf("applyDelta did not reproduce the target")
+	}
+
+	if _, err := applyDelta(base[:10], delta); err == nil {
+		t.Errorf("applyDelta should reject a base of the wrong size")
+	}
+}
+
+func TestPackObjects(t *testing.T) {
+	chdirTemp(t)
+
+	// Several revisions of a large file, each differing by one line
+	var blobs []string
+	lines := make([]string, 400)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("generated asset line %d with some padding text", i)
+	}
+	for rev := 0; rev < 5; rev++ {
+		lines[rev*50] = fmt.Sprintf("revision %d", rev)
+		data := []byte(strings.Join(lines, "\n"))
+		hash := HashObject(BlobObject, data)
+		if err := writeObject(BlobObject, hash, data); err != nil {
+			t.Fatal(err)
+		}
+		blobs = append(blobs, hash)
+	}
+	treeHash, err := WriteTree(map[string]TreeEntry{"asset.txt": {Mode: ModeRegular, Hash: blobs[4]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := models.Commit{TreeHash: treeHash, Message: "assets", Timestamp: time.Now()}
+	commit.ID = commit.Hash()
+	if err := AppendCommit(commit); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := PackObjects()
+	if err != nil {
+		t.Fatalf("PackObjects failed: %v", err)
+	}
+	if stats.Objects != 7 {
+		t.Errorf("packed %d objects, want 7", stats.Objects)
+	}
+	if stats.Deltas < 4 {
+		t.Errorf("expected the file revisions to be stored as deltas, got %d deltas", stats.Deltas)
+	}
+	if stats.SizeAfter >= stats.SizeBefore {
+		t.Errorf("packing did not shrink the store: %d -> %d bytes", stats.SizeBefore, stats.SizeAfter)
+	}
+
+	loose, err := listLooseObjects("")
+	if err != nil || len(loose) != 0 {
+		t.Errorf("loose objects left after packing: %v, %v", loose, err)
+	}
+
+	// Everything must still be readable from the pack
+	for _, hash := range blobs {
+		if _, err := ReadBlob(hash); err != nil {
+			t.Errorf("ReadBlob(%s) from pack failed: %v", hash, err)
+		}
+	}
+	if _, err := ParseTree(treeHash); err != nil {
+		t.Errorf("ParseTree from pack failed: %v", err)
+	}
+	found, err := FindCommit(commit.ID[:8])
+	if err != nil || found.ID != commit.ID {
+		t.Errorf("FindCommit by prefix from pack = %v, %v", found.ID, err)
+	}
+	commits, err := ReadCommits([]string{commit.ID})
+	if err != nil || len(commits) != 1 {
+		t.Errorf("ReadCommits from pack = %d commits, %v", len(commits), err)
+	}
+
+	if stats, err := PackObjects(); err != nil || stats.Objects != 0 {
+		t.Errorf("repacking a fully packed store = %+v, %v", stats, err)
+	}
+
+	// A new loose object and a second gc fold everything into one pack again
+	extra := []byte("new loose object")
+	if err := writeObject(BlobObject, HashObject(BlobObject, extra), extra); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = PackObjects()
+	if err != nil {
+		t.Fatalf("second PackObjects failed: %v", err)
+	}
+	if stats.Objects != 8 || stats.PacksMerged != 1 {
+		t.Errorf("second pack: %+v", stats)
+	}
+	idx, _ := filepath.Glob(filepath.Join(packDir, "*.idx"))
+	if len(idx) != 1 {
+		t.Errorf("expected a single pack index, found %v", idx)
+	}
+}
+
+func TestPackObjects_MoreObjectsThanDeltaWindow(t *testing.T) {
+	chdirTemp(t)
+
+	// Enough revisions that bases fall out of the window and chains reach maxDeltaDepth
+	var blobs []string
+	lines := make([]string, 300)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line %d of a file that keeps changing", i)
+	}
+	for rev := range 3 * deltaWindow {
+		lines[rev*7%len(lines)] = fmt.Sprintf("revision %d", rev)
+		data := []byte(strings.Join(lines, "\n"))
+		hash := HashObject(BlobObject, data)
+		if err := writeObject(BlobObject, hash, data); err != nil {
+			t.Fatal(err)
+		}
+		blobs = append(blobs, hash)
+	}
+
+	stats, err := PackObjects()
+	if err != nil {
+		t.Fatalf("PackObjects failed: %v", err)
+	}
+	if stats.Objects != len(blobs) || stats.Deltas == 0 {
+		t.Errorf("packing gave %+v", stats)
+	}
+	for _, hash := range blobs {
+		if _, err := ReadBlob(hash); err != nil {
+			t.Errorf("ReadBlob(%s) from pack failed: %v", hash, err)
+		}
+	}
+
+	// No temporary file is left behind, and the pack and index are as big as reported
+	if tmp, _ := filepath.Glob(filepath.Join(packDir, "*.tmp")); len(tmp) != 0 {
+		t.Errorf("temporary files left in the pack directory: %v", tmp)
+	}
+	var size int64
+	for _, ext := range []string{".pack", ".idx"} {
+		info, err := os.Stat(filepath.Join(packDir, stats.PackName+ext))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += info.Size()
+	}
+	if size != stats.SizeAfter {
+		t.Errorf("SizeAfter = %d, the files hold %d bytes", stats.SizeAfter, size)
+	}
+}
+
+func TestReadPackedObject_DetectsCorruption(t *testing.T) {
+	chdirTemp(t)
+
+	data := []byte(strings.Repeat("packed content ", 100))
+	hash := HashObject(BlobObject, data)
+	if err := writeObject(BlobObject, hash, data); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := PackObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packPath := filepath.Join(packDir, stats.PackName+".pack")
+	raw, err := os.ReadFile(packPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Damage the compressed data of the only entry
+	raw[len(raw)-30] ^= 0xff
+	if err := os.WriteFile(packPath, raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadObject(hash); err == nil {
+		t.Errorf("ReadObject should fail on a damaged pack")
+	}
+}
+
+func TestPackObjectsExcept_UnpacksWithPackTime(t *testing.T) {
+	chdirTemp(t)
+
+	keep, drop := []byte("kept in the pack"), []byte("left out of the pack")
+	for _, data := range [][]byte{keep, drop} {
+		if err := writeObject(BlobObject, HashObject(BlobObject, data), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := PackObjects(); err != nil {
+		t.Fatal(err)
+	}
+	packs, _ := filepath.Glob(filepath.Join(packDir, "*.pack"))
+	old := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(packs[0], old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := PackObjectsExcept(func(name string) bool { return name == HashObject(BlobObject, drop) })
+	if err != nil {
+		t.Fatalf("PackObjectsExcept failed: %v", err)
+	}
+	if stats.Objects != 1 || stats.PacksMerged != 1 {
+		t.Errorf("repack: %+v", stats)
+	}
+	written, ok := LooseObjectTime(HashObject(BlobObject, drop))
+	if !ok || !written.Equal(old) {
+		t.Errorf("left-out object is loose = %v, written %v; want the pack's time %v", ok, written, old)
+	}
+	if _, ok := LooseObjectTime(HashObject(BlobObject, keep)); ok {
+		t.Error("packed object was also left loose")
+	}
+	if data, err := ReadBlob(HashObject(BlobObject, drop)); err != nil || !bytes.Equal(data, drop) {
+		t.Errorf("ReadBlob of the unpacked object = %q, %v", data, err)
+	}
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// repositoryLock is taken by commands that delete objects, so that two of them do not
+// decide what is unreachable at the same time
+const repositoryLock = ".kitcat/repository"
+
+// LockRepository takes the repository lock and returns the function that releases it
+func LockRepository() (func(), error) {
+	l, err := lock(filepath.FromSlash(repositoryLock))
+	if err != nil {
+		return nil, err
+	}
+	return func() { unlock(l) }, nil
+}
+
+// LooseObjectTime returns when the loose object hash was last written, and false when
+// the object is not stored loose
+func LooseObjectTime(hash string) (time.Time, bool) {
+	p, ok := locateObject(hash)
+	if !ok {
+		return time.Time{}, false
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return info.ModTime(), true
+}
+
+// RemoveLooseObject deletes the loose copy of the object hash
+func RemoveLooseObject(hash string) error {
+	return removeLooseObject(hash)
+}
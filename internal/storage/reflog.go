@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// logsDir holds one reflog per ref: logs/HEAD and logs/refs/heads/<branch>.
+// Each line records one update of the ref:
+//
+//	<old hash> <new hash> <name> <<email>> <unix seconds> <zone>\t<message>
+//
+// A ref that did not exist before the update has an all-zero old hash.
+const logsDir = ".kitcat/logs"
+
+// ZeroHash stands for "no commit" in reflog entries
+const ZeroHash = "0000000000000000000000000000000000000000"
+
+// ReflogEntry is one recorded update of a ref
+type ReflogEntry struct {
+	Old     string
+	New     string
+	Name    string
+	Email   string
+	Time    time.Time
+	Message string
+}
+
+// reflogPath returns the log file of a ref such as "HEAD" or "refs/heads/main"
+func reflogPath(ref string) string {
+	return filepath.Join(logsDir, filepath.FromSlash(ref))
+}
+
+// AppendReflog records an update of ref
+func AppendReflog(ref string, entry ReflogEntry) error {
+	path := reflogPath(ref)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	old := entry.Old
+	if old == "" {
+		old = ZeroHash
+	}
+	message := strings.ReplaceAll(firstLine(entry.Message), "\t", " ")
+	line := fmt.Sprintf("%s %s %s <%s> %d %s\t%s\n",
+		old, entry.New, entry.Name, entry.Email, entry.Time.Unix(), entry.Time.Format("-0700"), message)
+	if _, err := f.WriteString(line); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// ReadReflog returns the recorded updates of ref, newest first
+// A ref without a log has no entries.
+func ReadReflog(ref string) ([]ReflogEntry, error) {
+	f, err := os.Open(reflogPath(ref))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []ReflogEntry
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if scanner.Text() == "" {
+			continue
+		}
+		entry, err := parseReflogLine(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("reflog of %s, line %d: %w", ref, lineNo, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+func parseReflogLine(line string) (ReflogEntry, error) {
+	header, message, _ := strings.Cut(line, "\t")
+	fields := strings.Fields(header)
+	if len(fields) < 5 {
+		return ReflogEntry{}, fmt.Errorf("malformed entry %q", line)
+	}
+	seconds, err := strconv.ParseInt(fields[len(fields)-2], 10, 64)
+	if err != nil {
+		return ReflogEntry{}, fmt.Errorf("malformed time in %q", line)
+	}
+	when := time.Unix(seconds, 0)
+	if zone, err := time.Parse("-0700", fields[len(fields)-1]); err == nil {
+		_, offset := zone.Zone()
+		when = when.In(time.FixedZone("", offset))
+	}
+
+	// The identity is everything between the hashes and the time: "Name <email>"
+	identity := strings.Join(fields[2:len(fields)-2], " ")
+	name, email := identity, ""
+	if open := strings.LastIndex(identity, "<"); open >= 0 && strings.HasSuffix(identity, ">") {
+		name = strings.TrimSpace(identity[:open])
+		email = identity[open+1 : len(identity)-1]
+	}
+
+	return ReflogEntry{
+		Old:     fields[0],
+		New:     fields[1],
+		Name:    name,
+		Email:   email,
+		Time:    when,
+		Message: message,
+	}, nil
+}
+
+// RenameReflog moves the log of a renamed ref
+func RenameReflog(oldRef, newRef string) error {
+	newPath := reflogPath(newRef)
+	if err := os.MkdirAll(filepath.Dir(newPath), 0o755); err != nil {
+		return err
+	}
+	err := os.Rename(reflogPath(oldRef), newPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// DeleteReflog removes the log of a deleted ref, or every log below a prefix
+// such as refs/remotes/origin
+func DeleteReflog(ref string) error {
+	return os.RemoveAll(reflogPath(ref))
+}
+
+// firstLine returns the first line of s
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReflog_RoundTrip(t *testing.T) {
+	chdirTemp(t)
+
+	when := time.Date(2024, 3, 1, 12, 0, 0, 0, time.FixedZone("", 2*60*60))
+	first := ReflogEntry{New: "aaa", Name: "Jane Q. Doe", Email: "jane@example.com", Time: when, Message: "commit (initial): start"}
+	second := ReflogEntry{Old: "aaa", New: "bbb", Name: "Jane Q. Doe", Email: "jane@example.com", Time: when.Add(time.Minute), Message: "commit: next\n\nbody"}
+	for _, e := range []ReflogEntry{first, second} {
+		if err := AppendReflog("refs/heads/main", e); err != nil {
+			t.Fatalf("AppendReflog failed: %v", err)
+		}
+	}
+
+	entries, err := ReadReflog("refs/heads/main")
+	if err != nil {
+		t.Fatalf("ReadReflog failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	newest, oldest := entries[0], entries[1]
+	if newest.Old != "aaa" || newest.New != "bbb" || newest.Message != "commit: next" {
+		t.Errorf("newest entry = %+v, want the second update with its subject only", newest)
+	}
+	if oldest.Old != ZeroHash || oldest.Name != "Jane Q. Doe" || oldest.Email != "jane@example.com" {
+		t.Errorf("oldest entry = %+v", oldest)
+	}
+	if !oldest.Time.Equal(when) {
+		t.Errorf("time = %v, want %v", oldest.Time, when)
+	}
+	if _, offset := oldest.Time.Zone(); offset != 2*60*60 {
+		t.Errorf("zone offset = %d, want the recorded +0200", offset)
+	}
+
+	if err := RenameReflog("refs/heads/main", "refs/heads/trunk"); err != nil {
+		t.Fatal(err)
+	}
+	if moved, _ := ReadReflog("refs/heads/trunk"); len(moved) != 2 {
+		t.Errorf("renamed log has %d entries, want 2", len(moved))
+	}
+	if err := DeleteReflog("refs/heads/trunk"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(".kitcat/logs/refs/heads/trunk"); !os.IsNotExist(err) {
+		t.Errorf("log was not deleted")
+	}
+	if missing, err := ReadReflog("refs/heads/trunk"); err != nil || len(missing) != 0 {
+		t.Errorf("a missing log should read as empty, got %v, %v", missing, err)
+	}
+}
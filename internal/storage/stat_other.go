@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd
+
+package storage
+
+import "os"
+
+// statExtra reports no change time or inode where the platform does not provide them;
+// mtime, size and mode still detect modifications
+func statExtra(info os.FileInfo) (int64, uint64) {
+	return 0, 0
+}
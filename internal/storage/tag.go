@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tag is an annotated tag: a named message about another object, usually a commit,
+// recording who made it and when
+type Tag struct {
+	Object      string
+	Type        ObjectType
+	Name        string
+	TaggerName  string
+	TaggerEmail string
+	Time        time.Time
+	Message     string
+	// Signature is the armored SSH signature of a signed tag, made over the content of
+	// the tag object without it
+	Signature string
+}
+
+// tagSignatureBegin starts the signature a signed tag object carries after its message
+const tagSignatureBegin = "-----BEGIN SSH SIGNATURE-----"
+
+// maxTagDepth bounds how many tags pointing at tags are followed
+const maxTagDepth = 10
+
+// EncodeTag returns the content of the tag object for tag. The layout is git's: object,
+// type, tag and tagger header lines, an empty line, the message ending in a newline, and
+// the signature of a signed tag. The time is kept in whole seconds.
+func EncodeTag(tag Tag) []byte {
+	clean := func(s string) string {
+		return strings.TrimSpace(strings.Map(func(r rune) rune {
+			if r == '<' || r == '>' || r == '\n' {
+				return -1
+			}
+			return r
+		}, s))
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "object %s\ntype %s\ntag %s\n", tag.Object, tag.Type, tag.Name)
+	fmt.Fprintf(&buf, "tagger %s <%s> %d %s\n\n", clean(tag.TaggerName), clean(tag.TaggerEmail), tag.Time.Unix(), tag.Time.Format("-0700"))
+	if tag.Message != "" {
+		buf.WriteString(strings.TrimSuffix(tag.Message, "\n") + "\n")
+	}
+	buf.WriteString(tag.Signature)
+	return buf.Bytes()
+}
+
+// ParseTag decodes the content of a tag object, which may also come from git
+func ParseTag(data []byte) (Tag, error) {
+	headers, message, ok := strings.Cut(string(data), "\n\n")
+	if !ok {
+		headers = strings.TrimSuffix(string(data), "\n")
+	}
+	var tag Tag
+	lines := strings.Split(headers, "\n")
+	for i, key := range []string{"object", "type", "tag", "tagger"} {
+		if i >= len(lines) {
+			return Tag{}, fmt.Errorf("tag has no %s line", key)
+		}
+		value, ok := strings.CutPrefix(lines[i], key+" ")
+		if !ok {
+			return Tag{}, fmt.Errorf("expected a %s line, found %q", key, lines[i])
+		}
+		switch key {
+		case "object":
+			if !isHexHash(value) {
+				return Tag{}, fmt.Errorf("invalid object %q", value)
+			}
+			tag.Object = value
+		case "type":
+			tag.Type = ObjectType(value)
+			if !isKnownType(tag.Type) {
+				return Tag{}, fmt.Errorf("unknown target type %q", value)
+			}
+		case "tag":
+			if value == "" {
+				return Tag{}, fmt.Errorf("tag has an empty name")
+			}
+			tag.Name = value
+		case "tagger":
+			var err error
+			if tag.TaggerName, tag.TaggerEmail, tag.Time, err = parseTagger(value); err != nil {
+				return Tag{}, err
+			}
+		}
+	}
+	if at := strings.Index(message, tagSignatureBegin); at >= 0 && (at == 0 || message[at-1] == '\n') {
+		message, tag.Signature = message[:at], message[at:]
+	}
+	tag.Message = strings.TrimSuffix(message, "\n")
+	return tag, nil
+}
+
+// parseTagger splits "Name <email> seconds zone" into its parts
+func parseTagger(value string) (string, string, time.Time, error) {
+	open := strings.Index(value, "<")
+	end := strings.LastIndex(value, ">")
+	fields := strings.Fields(value[end+1:])
+	if open < 0 || end < open || len(fields) != 2 {
+		return "", "", time.Time{}, fmt.Errorf("malformed tagger %q", value)
+	}
+	seconds, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return "", "", time.Time{}, fmt.Errorf("malformed tagger %q", value)
+	}
+	zone, err := time.Parse("-0700", fields[1])
+	if err != nil {
+		return "", "", time.Time{}, fmt.Errorf("malformed tagger %q", value)
+	}
+	_, offset := zone.Zone()
+	return strings.TrimSpace(value[:open]), value[open+1 : end], time.Unix(seconds, 0).In(time.FixedZone("", offset)), nil
+}
+
+// WriteTag stores tag as a tag object, named by its content, and returns
+// its hash. The object it points at must already be stored.
+func WriteTag(tag Tag) (string, error) {
+	t, err := ReadObjectType(tag.Object)
+	if err != nil {
+		return "", fmt.Errorf("tag %s: %w", tag.Name, err)
+	}
+	if t != tag.Type {
+		return "", fmt.Errorf("tag %s: object %s is a %s, not a %s", tag.Name, tag.Object, t, tag.Type)
+	}
+	return storeObject(TagObject, EncodeTag(tag))
+}
+
+// ReadTag loads the tag object stored under hash
+func ReadTag(hash string) (Tag, error) {
+	obj, err := ReadObject(hash)
+	if err != nil {
+		return Tag{}, err
+	}
+	if obj.Type != TagObject {
+		return Tag{}, fmt.Errorf("object %s is a %s, not a tag", hash, obj.Type)
+	}
+	tag, err := ParseTag(obj.Data)
+	if err != nil {
+		return Tag{}, fmt.Errorf("%w %s: %v", ErrCorruptObject, hash, err)
+	}
+	return tag, nil
+}
+
+// PeelTag follows tag objects from hash and returns the first object that is not a tag.
+// Any other object is returned as it is.
+func PeelTag(hash string) (string, error) {
+	for range maxTagDepth {
+		t, err := ReadObjectType(hash)
+		if err != nil || t != TagObject {
+			return hash, err
+		}
+		tag, err := ReadTag(hash)
+		if err != nil {
+			return "", err
+		}
+		hash = tag.Object
+	}
+	return "", fmt.Errorf("tag chain at %s is too long", hash)
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+)
+
+func TestWriteTag_ReadsBackAndPeels(t *testing.T) {
+	chdirTemp(t)
+
+	tree, err := WriteTreeEntries(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := models.Commit{TreeHash: tree, Timestamp: time.Now()}
+	commit.ID = commit.Hash()
+	if err := AppendCommit(commit); err != nil {
+		t.Fatal(err)
+	}
+	tag := Tag{
+		Object:      commit.ID,
+		Type:        CommitObject,
+		Name:        "v1",
+		TaggerName:  "A U Thor",
+		TaggerEmail: "author@example.com",
+		Time:        time.Unix(1700000000, 0).In(time.FixedZone("", 3600)),
+		Message:     "Release\n\nNotes",
+	}
+	hash, err := WriteTag(tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "object " + commit.ID + "\ntype commit\ntag v1\ntagger A U Thor <author@example.com> 1700000000 +0100\n\nRelease\n\nNotes\n"
+	if got := string(EncodeTag(tag)); got != want {
+		t.Errorf("tag object is\n%s\nwant\n%s", got, want)
+	}
+	if _, err := PackObjects(); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadTag(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Object != commit.ID || read.Message != tag.Message || !read.Time.Equal(tag.Time) || read.TaggerName != tag.TaggerName {
+		t.Errorf("ReadTag = %+v, want %+v", read, tag)
+	}
+	if peeled, err := PeelTag(hash); err != nil || peeled != commit.ID {
+		t.Errorf("PeelTag = %s, %v; want %s", peeled, err, commit.ID)
+	}
+	if c, err := FindCommit(hash); err != nil || c.ID != commit.ID {
+		t.Errorf("FindCommit(tag) = %s, %v; want the tagged commit", c.ID, err)
+	}
+	if typ, links, err := CheckObject(hash); err != nil || typ != TagObject || len(links) != 1 || links[0] != (ObjectLink{Hash: commit.ID, Type: CommitObject}) {
+		t.Errorf("CheckObject(tag) = %s, %v, %v", typ, links, err)
+	}
+
+	if _, err := WriteTag(Tag{Object: tree, Type: CommitObject, Name: "bad", Time: time.Now()}); err == nil {
+		t.Error("WriteTag accepted a tree recorded as a commit")
+	}
+}
@@ -0,0 +1,104 @@
+package storage
+
+import "fmt"
+
+// StoreObject writes an object received from another repository under hash,
+// after checking it with checkReceivedObject
+func StoreObject(hash string, obj Object) error {
+	if err := checkReceivedObject(hash, obj); err != nil {
+		return err
+	}
+	return writeObject(obj.Type, hash, obj.Data)
+}
+
+// checkReceivedObject checks an object from another repository: its content must match
+// its name, and a tree may only hold entry names ReadTree accepts, so a tree that would
+// write outside the working directory is refused before it is stored
+func checkReceivedObject(hash string, obj Object) error {
+	if !isKnownType(obj.Type) {
+		return fmt.Errorf("%w %s: unknown type %q", ErrCorruptObject, hash, obj.Type)
+	}
+	if err := verifyObject(hash, obj); err != nil {
+		return err
+	}
+	if obj.Type == TreeObject {
+		if _, err := parseTree(hash, obj.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReachableObjects returns the names of every object reachable from the commits and tags
+// in tips: the tags, the commits, their trees, and the subtrees and blobs within, each once.
+// Commits for which stop returns true are skipped together with their ancestors, so a
+// caller can leave out history the receiving side already has. stop may be nil.
+func ReachableObjects(tips []string, stop func(commit string) bool) ([]string, error) {
+	seen := make(map[string]bool)
+	var names []string
+	var walkTree func(hash string) error
+	walkTree = func(hash string) error {
+		if seen[hash] {
+			return nil
+		}
+		seen[hash] = true
+		names = append(names, hash)
+		entries, err := ReadTree(hash)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.IsTree() {
+				if err := walkTree(e.Hash); err != nil {
+					return err
+				}
+			} else if !seen[e.Hash] {
+				seen[e.Hash] = true
+				names = append(names, e.Hash)
+			}
+		}
+		return nil
+	}
+
+	queue := append([]string(nil), tips...)
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if hash == "" || seen[hash] || (stop != nil && stop(hash)) {
+			continue
+		}
+		seen[hash] = true
+		if t, err := ReadObjectType(hash); err == nil && t == TagObject {
+			tag, err := ReadTag(hash)
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, hash)
+			switch tag.Type {
+			case TreeObject:
+				err = walkTree(tag.Object)
+			case BlobObject:
+				if !seen[tag.Object] {
+					seen[tag.Object] = true
+					names = append(names, tag.Object)
+				}
+			default:
+				queue = append(queue, tag.Object)
+			}
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		commit, err := FindCommit(hash)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, commit.ID)
+		if err := walkTree(commit.TreeHash); err != nil {
+			return nil, err
+		}
+		queue = append(queue, commit.Parents...)
+	}
+	return names, nil
+}
@@ -3,71 +3,264 @@ package storage
 import (
 	"bufio"
 	"bytes"
-	"crypto/sha1"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
 )
 
-// CreateTree creates a tree object from the current index and stores it
-// It ensures the process is deterministic by sorting the file paths
+// File modes recorded for tree entries, using git's octal notation
+const (
+	ModeRegular    = "100644"
+	ModeExecutable = "100755"
+	ModeSymlink    = "120000"
+	ModeTree       = "040000"
+)
+
+// TreeEntry is a single entry of a tree object
+// Name is the entry's name inside its directory; flattened trees leave it empty
+// and key the entries by their full path instead
+type TreeEntry struct {
+	Mode string
+	Hash string
+	Name string
+}
+
+// IsTree reports whether the entry points to a subtree
+func (e TreeEntry) IsTree() bool {
+	return e.Mode == ModeTree
+}
+
+// isMode reports whether s is one of the modes kitcat writes into trees
+func isMode(s string) bool {
+	switch s {
+	case ModeRegular, ModeExecutable, ModeSymlink, ModeTree:
+		return true
+	}
+	return false
+}
+
+// validEntryName reports whether name can be written as one entry of a directory: it
+// must not be empty, "." or "..", hold a path separator or NUL, or name the repository
+// directory, so a tree can never place a file outside its directory or inside .kitcat
+func validEntryName(name string) bool {
+	switch name {
+	case "", ".", "..":
+		return false
+	}
+	return !strings.ContainsAny(name, "/\\\x00") && !strings.EqualFold(name, ".kitcat")
+}
+
+// validLegacyPath reports whether every component of a path from a flat legacy tree
+// entry is a valid entry name
+func validLegacyPath(p string) bool {
+	for _, part := range strings.Split(p, "/") {
+		if !validEntryName(part) {
+			return false
+		}
+	}
+	return true
+}
+
+// FileMode returns the tree mode for the file at path in the working directory
+// Symlinks are not followed; a missing file is reported as a regular file
+func FileMode(path string) string {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return ModeRegular
+	}
+	return modeFromFileInfo(info)
+}
+
+// modeFromFileInfo maps file metadata to a tree mode
+func modeFromFileInfo(info os.FileInfo) string {
+	if info.Mode()&os.ModeSymlink != 0 {
+		return ModeSymlink
+	}
+	if info.Mode().Perm()&0o111 != 0 {
+		return ModeExecutable
+	}
+	return ModeRegular
+}
+
+// CreateTree creates the tree objects for the current index and stores them
+// File modes are taken from the index, as recorded when each file was staged
+// One tree is written per directory, so unchanged directories keep their hash between commits
 func CreateTree() (string, error) {
-	index, err := LoadIndex()
+	index, err := LoadIndexEntries()
 	if err != nil {
 		return "", err
 	}
 
-	var treeContent bytes.Buffer
+	entries := make(map[string]TreeEntry, len(index))
+	for p, entry := range index {
+		mode := entry.Mode
+		if mode == "" {
+			// Entries from the legacy JSON index carry no mode
+			mode = FileMode(p)
+		}
+		entries[p] = TreeEntry{Mode: mode, Hash: entry.Hash}
+	}
+	return WriteTree(entries)
+}
+
+// WriteTree stores a nested set of tree objects for the given flat path -> entry map
+// and returns the hash of the root tree
+func WriteTree(entries map[string]TreeEntry) (string, error) {
+	// Group files by the directory they live in and record the directory hierarchy,
+	// since directories are only implied by file paths
+	files := make(map[string][]TreeEntry)
+	subdirs := make(map[string]map[string]bool)
+	for p, entry := range entries {
+		dir, name := path.Split(filepath.ToSlash(p))
+		dir = strings.TrimSuffix(dir, "/")
+		if entry.Mode == "" {
+			entry.Mode = ModeRegular
+		}
+		entry.Name = name
+		files[dir] = append(files[dir], entry)
 
-	// Sort keys to ensure the tree content is always in the same order
-	keys := make([]string, 0, len(index))
-	for p := range index {
-		keys = append(keys, p)
+		for dir != "" {
+			parent, dirName := path.Split(dir)
+			parent = strings.TrimSuffix(parent, "/")
+			if subdirs[parent] == nil {
+				subdirs[parent] = make(map[string]bool)
+			}
+			if subdirs[parent][dirName] {
+				break // the rest of the chain is already registered
+			}
+			subdirs[parent][dirName] = true
+			dir = parent
+		}
 	}
-	sort.Strings(keys)
 
-	// Iterate over the sorted keys to build the tree content
-	for _, path := range keys {
-		hash := index[path]
-		treeContent.WriteString(fmt.Sprintf("%s %s\n", hash, path))
+	return writeTreeDir("", files, subdirs)
+}
+
+// writeTreeDir writes the tree for dir after writing all of its subdirectories
+func writeTreeDir(dir string, files map[string][]TreeEntry, subdirs map[string]map[string]bool) (string, error) {
+	entries := append([]TreeEntry(nil), files[dir]...)
+
+	for name := range subdirs[dir] {
+		sub := name
+		if dir != "" {
+			sub = dir + "/" + name
+		}
+		hash, err := writeTreeDir(sub, files, subdirs)
+		if err != nil {
+			return "", err
+		}
+		entries = append(entries, TreeEntry{Mode: ModeTree, Hash: hash, Name: name})
 	}
 
-	// Hash the deterministic tree content to get the tree's hash
-	h := sha1.New()
-	h.Write(treeContent.Bytes())
-	treeHash := fmt.Sprintf("%x", h.Sum(nil))
+	return WriteTreeEntries(entries)
+}
+
+// WriteTreeEntries stores a single tree object holding entries, which must already point
+// to stored blobs and subtrees, and returns its hash
+func WriteTreeEntries(entries []TreeEntry) (string, error) {
+	// Sort by name to ensure the tree content is always in the same order
+	entries = append([]TreeEntry(nil), entries...)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
 
-	// Store the tree object in the objects directory
-	objectPath := filepath.Join(objectsDir, treeHash)
-	if err := os.WriteFile(objectPath, treeContent.Bytes(), 0644); err != nil {
-		return "", err
+	var treeContent bytes.Buffer
+	for _, entry := range entries {
+		treeContent.WriteString(fmt.Sprintf("%s %s %s\n", entry.Mode, entry.Hash, entry.Name))
 	}
 
-	return treeHash, nil
+	// The deterministic tree content names the tree
+	return storeObject(TreeObject, treeContent.Bytes())
 }
 
-// ParseTree reads a tree object from storage and returns it as a map of path -> hash
-// This is the function that was missing
-func ParseTree(hash string) (map[string]string, error) {
-	tree := make(map[string]string)
-	objectPath := filepath.Join(objectsDir, hash)
-	data, err := os.ReadFile(objectPath)
+// ReadTree returns the entries of a single tree object without descending into subtrees
+// Trees written before nested trees existed ("hash path" lines) are read as regular files
+// A tree with an entry name that could not be checked out safely is reported as corrupt
+func ReadTree(hash string) ([]TreeEntry, error) {
+	obj, err := ReadObject(hash)
 	if err != nil {
 		return nil, err
 	}
+	if obj.Type != TreeObject {
+		return nil, fmt.Errorf("object %s is a %s, not a tree", hash, obj.Type)
+	}
+	return parseTree(hash, obj.Data)
+}
 
+// parseTree decodes the content of the tree hash for ReadTree
+func parseTree(hash string, data []byte) ([]TreeEntry, error) {
+	var entries []TreeEntry
 	scanner := bufio.NewScanner(bytes.NewReader(data))
 	for scanner.Scan() {
 		line := scanner.Text()
-		// The format is "hash path", so we split on the first space
-		parts := strings.SplitN(line, " ", 2)
-		if len(parts) == 2 {
-			// The hash is parts[0], the path is parts[1]
-			tree[parts[1]] = parts[0]
+		if line == "" {
+			continue
+		}
+		// The format is "mode hash name"; the name may itself contain spaces
+		parts := strings.SplitN(line, " ", 3)
+		if len(parts) == 3 && isMode(parts[0]) {
+			if !validEntryName(parts[2]) {
+				return nil, fmt.Errorf("%w %s: unsafe name in tree entry %q", ErrCorruptObject, hash, line)
+			}
+			entries = append(entries, TreeEntry{Mode: parts[0], Hash: parts[1], Name: parts[2]})
+			continue
+		}
+		// Legacy flat format: "hash path"
+		parts = strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("malformed entry in tree %s: %q", hash, line)
+		}
+		if !validLegacyPath(parts[1]) {
+			return nil, fmt.Errorf("%w %s: unsafe path in tree entry %q", ErrCorruptObject, hash, line)
+		}
+		entries = append(entries, TreeEntry{Mode: ModeRegular, Hash: parts[0], Name: parts[1]})
+	}
+	return entries, scanner.Err()
+}
+
+// FlattenTree walks a tree and its subtrees and returns every file keyed by its path
+func FlattenTree(hash string) (map[string]TreeEntry, error) {
+	files := make(map[string]TreeEntry)
+	if err := flattenInto(hash, "", files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func flattenInto(hash, prefix string, files map[string]TreeEntry) error {
+	entries, err := ReadTree(hash)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		p := entry.Name
+		if prefix != "" {
+			p = prefix + "/" + entry.Name
 		}
+		if entry.IsTree() {
+			if err := flattenInto(entry.Hash, p, files); err != nil {
+				return err
+			}
+			continue
+		}
+		files[filepath.FromSlash(p)] = TreeEntry{Mode: entry.Mode, Hash: entry.Hash}
 	}
+	return nil
+}
 
-	return tree, scanner.Err()
+// ParseTree reads a tree object from storage and returns it as a map of path -> hash
+// Nested trees are flattened, so callers see every file regardless of directory depth
+func ParseTree(hash string) (map[string]string, error) {
+	files, err := FlattenTree(hash)
+	if err != nil {
+		return nil, err
+	}
+	tree := make(map[string]string, len(files))
+	for p, entry := range files {
+		tree[p] = entry.Hash
+	}
+	return tree, nil
 }
@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestWriteTree_NestedDirectories(t *testing.T) {
+	chdirTemp(t)
+
+	first := map[string]TreeEntry{
+		"README.md":                         {Mode: ModeRegular, Hash: "1111111111111111111111111111111111111111"},
+		filepath.Join("lib", "util.go"):     {Mode: ModeRegular, Hash: "2222222222222222222222222222222222222222"},
+		filepath.Join("lib", "io", "io.go"): {Mode: ModeRegular, Hash: "3333333333333333333333333333333333333333"},
+		filepath.Join("bin", "run.sh"):      {Mode: ModeExecutable, Hash: "4444444444444444444444444444444444444444"},
+		"link":                              {Mode: ModeSymlink, Hash: "5555555555555555555555555555555555555555"},
+	}
+	rootHash, err := WriteTree(first)
+	if err != nil {
+		t.Fatalf("WriteTree failed: %v", err)
+	}
+
+	root, err := ReadTree(rootHash)
+	if err != nil {
+		t.Fatalf("ReadTree failed: %v", err)
+	}
+	// README.md, bin/, lib/, link
+	if len(root) != 4 {
+		t.Fatalf("root tree has %d entries, want 4: %+v", len(root), root)
+	}
+	var libHash string
+	for _, e := range root {
+		if e.Name == "lib" {
+			if !e.IsTree() {
+				t.Errorf("lib should be a subtree, got mode %s", e.Mode)
+			}
+			libHash = e.Hash
+		}
+	}
+
+	files, err := FlattenTree(rootHash)
+	if err != nil {
+		t.Fatalf("FlattenTree failed: %v", err)
+	}
+	if len(files) != len(first) {
+		t.Fatalf("flattened %d files, want %d", len(files), len(first))
+	}
+	for p, want := range first {
+		got, ok := files[p]
+		if !ok {
+			t.Errorf("missing %s after flattening", p)
+			continue
+		}
+		if got.Hash != want.Hash || got.Mode != want.Mode {
+			t.Errorf("%s = %+v, want mode %s hash %s", p, got, want.Mode, want.Hash)
+		}
+	}
+
+	// Changing a file outside lib/ must leave lib's tree hash untouched
+	second := make(map[string]TreeEntry, len(first))
+	for p, e := range first {
+		second[p] = e
+	}
+	second["README.md"] = TreeEntry{Mode: ModeRegular, Hash: "6666666666666666666666666666666666666666"}
+	secondRoot, err := WriteTree(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secondRoot == rootHash {
+		t.Fatal("root tree hash should change when a file changes")
+	}
+	entries, err := ReadTree(secondRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name == "lib" && e.Hash != libHash {
+			t.Errorf("unchanged subdirectory got a new tree hash: %s != %s", e.Hash, libHash)
+		}
+	}
+}
+
+func TestParseTree_LegacyFlatFormat(t *testing.T) {
+	chdirTemp(t)
+
+	legacy := "1111111111111111111111111111111111111111 a.txt\n" +
+		"2222222222222222222222222222222222222222 dir/b c.txt\n"
+	// Written the way older versions did: uncompressed, without a type header
+	hash := legacyObjectName([]byte(legacy))
+	if err := os.MkdirAll(objectsDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(objectsDir, hash), []byte(legacy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := ParseTree(hash)
+	if err != nil {
+		t.Fatalf("ParseTree failed: %v", err)
+	}
+	if tree["a.txt"] != "1111111111111111111111111111111111111111" {
+		t.Errorf("a.txt = %q", tree["a.txt"])
+	}
+	if tree[filepath.Join("dir", "b c.txt")] != "2222222222222222222222222222222222222222" {
+		t.Errorf("dir/b c.txt = %q", tree[filepath.Join("dir", "b c.txt")])
+	}
+}
+
+func TestHashAndStoreFile_Symlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require elevated privileges on Windows")
+	}
+	chdirTemp(t)
+
+	if err := os.WriteFile("target.txt", []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("target.txt", "link"); err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := HashAndStoreFile("link")
+	if err != nil {
+		t.Fatalf("HashAndStoreFile failed: %v", err)
+	}
+	data, err := ReadBlob(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "target.txt" {
+		t.Errorf("symlink blob = %q, want the link target", data)
+	}
+	if mode := FileMode("link"); mode != ModeSymlink {
+		t.Errorf("FileMode(link) = %s, want %s", mode, ModeSymlink)
+	}
+}
+
+func TestReadTree_RejectsUnsafeNames(t *testing.T) {
+	chdirTemp(t)
+
+	blob, err := WriteBlob([]byte("escaped\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"100644 " + blob + " ..",
+		"100644 " + blob + " .",
+		"100644 " + blob + " ../../escaped.txt",
+		"100644 " + blob + " a\\b",
+		"100644 " + blob + " a\x00b",
+		"040000 " + blob + " .kitcat",
+		"100644 " + blob + " .KITCAT",
+		blob + " dir/../../escaped.txt",
+		blob + " /etc/passwd",
+	} {
+		data := []byte(line + "\n")
+		hash := HashObject(TreeObject, data)
+		if err := writeObject(TreeObject, hash, data); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadTree(hash); !errors.Is(err, ErrCorruptObject) {
+			t.Errorf("ReadTree of %q = %v, want a corrupt object error", line, err)
+		}
+		if _, err := FlattenTree(hash); err == nil {
+			t.Errorf("FlattenTree accepted %q", line)
+		}
+	}
+
+	// A subtree with an unsafe name fails the whole walk
+	bad := []byte(fmt.Sprintf("100644 %s ..\n", blob))
+	badHash := HashObject(TreeObject, bad)
+	if err := writeObject(TreeObject, badHash, bad); err != nil {
+		t.Fatal(err)
+	}
+	root, err := WriteTreeEntries([]TreeEntry{{Mode: ModeTree, Hash: badHash, Name: "dir"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FlattenTree(root); !errors.Is(err, ErrCorruptObject) {
+		t.Errorf("FlattenTree of a tree holding an unsafe subtree = %v", err)
+	}
+}
+
+func TestStoreObject_RejectsUnsafeTrees(t *testing.T) {
+	chdirTemp(t)
+
+	blob := HashObject(BlobObject, []byte("escaped\n"))
+	data := []byte("100644 " + blob + " ../escaped.txt\n")
+	hash := HashObject(TreeObject, data)
+	if err := StoreObject(hash, Object{Type: TreeObject, Data: data}); !errors.Is(err, ErrCorruptObject) {
+		t.Errorf("StoreObject of an unsafe tree = %v, want a corrupt object error", err)
+	}
+	if HasObject(hash) {
+		t.Error("the unsafe tree was stored")
+	}
+}
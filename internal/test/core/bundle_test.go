@@ -0,0 +1,146 @@
+package core_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/LeeFred3042U/kitcat/internal/core"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+func TestBundle_CloneAndIncrementalFetch(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "file.txt", "one\n", "first")
+	if err := core.CreateTag("v1", "HEAD"); err != nil {
+		t.Fatal(err)
+	}
+	full := filepath.Join(t.TempDir(), "full.bundle")
+	if err := core.CreateBundle(full, nil, true); err != nil {
+		t.Fatalf("CreateBundle --all failed: %v", err)
+	}
+	if err := core.VerifyBundle(full); err != nil {
+		t.Errorf("VerifyBundle of a complete bundle failed: %v", err)
+	}
+
+	commitFile(t, "file.txt", "two\n", "second")
+	second, _ := core.GetHeadCommit()
+	incremental := filepath.Join(t.TempDir(), "incr.bundle")
+	if err := core.CreateBundle(incremental, []string{"v1..main"}, false); err != nil {
+		t.Fatalf("CreateBundle of a range failed: %v", err)
+	}
+
+	// A repository without the prerequisite cannot use the incremental bundle
+	empty := t.TempDir()
+	inDir(t, empty, func() {
+		if err := core.InitRepo(); err != nil {
+			t.Fatal(err)
+		}
+		if err := core.VerifyBundle(incremental); err == nil {
+			t.Errorf("VerifyBundle should report the missing prerequisite")
+		}
+	})
+
+	clone := filepath.Join(t.TempDir(), "clone")
+	if err := core.Clone(full, clone); err != nil {
+		t.Fatalf("Clone from a bundle failed: %v", err)
+	}
+	inDir(t, clone, func() {
+		if data, _ := os.ReadFile("file.txt"); string(data) != "one\n" {
+			t.Errorf("clone did not check out file.txt, got %q", data)
+		}
+		if _, err := core.ResolveRevision("v1"); err != nil {
+			t.Errorf("clone did not copy tag v1: %v", err)
+		}
+
+		if err := core.VerifyBundle(incremental); err != nil {
+			t.Fatalf("VerifyBundle with the prerequisite present failed: %v", err)
+		}
+		if err := core.Unbundle(incremental); err != nil {
+			t.Fatalf("Unbundle failed: %v", err)
+		}
+		if _, err := core.ResolveRevision(second.ID); err != nil {
+			t.Errorf("unbundle did not store the commit: %v", err)
+		}
+
+		if err := core.AddRemote("usb", incremental); err != nil {
+			t.Fatalf("AddRemote of a bundle failed: %v", err)
+		}
+		if err := core.Pull("usb", "main"); err != nil {
+			t.Fatalf("Pull from a bundle failed: %v", err)
+		}
+		if data, _ := os.ReadFile("file.txt"); string(data) != "two\n" {
+			t.Errorf("pull from the bundle did not fast-forward, file.txt = %q", data)
+		}
+		commitFile(t, "file.txt", "three\n", "third")
+		if err := core.Push("usb", "main", false); err == nil {
+			t.Errorf("pushing to a bundle should fail")
+		}
+	})
+}
+
+func TestBundle_RejectsBadRanges(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "file.txt", "one\n", "first")
+	if err := core.CreateTag("v1", "HEAD"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, "file.txt", "two\n", "second")
+	for _, rev := range []string{"mian..main", "main..mian", "mian...main", "main...mian"} {
+		file := filepath.Join(t.TempDir(), "bad.bundle")
+		if err := core.CreateBundle(file, []string{rev}, false); err == nil {
+			t.Errorf("CreateBundle accepted the range %s", rev)
+		}
+		if _, err := os.Stat(file); err == nil {
+			t.Errorf("CreateBundle wrote a bundle for the range %s", rev)
+		}
+	}
+	if err := core.CreateBundle(filepath.Join(t.TempDir(), "ok.bundle"), []string{"v1...main"}, false); err != nil {
+		t.Errorf("CreateBundle of a symmetric range failed: %v", err)
+	}
+}
+
+func TestBundle_RefusesHostileTrees(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	blob := writeRawObject(t, storage.BlobObject, []byte("escaped\n"))
+	commitRawTree(t, "100644 "+blob+" ../../escaped.txt\n")
+	head, err := core.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Written object by object, since kitcat cannot walk the tree to bundle it
+	file := filepath.Join(t.TempDir(), "hostile.bundle")
+	header := storage.BundleHeader{Refs: []storage.BundleRef{{Name: "refs/heads/main", Hash: head.ID}, {Name: "HEAD", Hash: head.ID}}}
+	if err := storage.WriteBundle(file, header, []string{head.ID, head.TreeHash, blob}); err != nil {
+		t.Fatal(err)
+	}
+
+	victim := t.TempDir()
+	if err := core.Clone(file, filepath.Join(victim, "sub", "clone")); err == nil {
+		t.Error("cloning a bundle with a hostile tree succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(victim, "escaped.txt")); err == nil {
+		t.Fatal("the clone from the bundle wrote outside the clone")
+	}
+
+	inDir(t, t.TempDir(), func() {
+		if err := core.InitRepo(); err != nil {
+			t.Fatal(err)
+		}
+		if err := core.VerifyBundle(file); err == nil {
+			t.Error("VerifyBundle accepted a hostile tree")
+		}
+		if err := core.Unbundle(file); err == nil {
+			t.Error("Unbundle stored a hostile tree")
+		}
+		if storage.HasObject(head.TreeHash) {
+			t.Error("the hostile tree was stored")
+		}
+	})
+}
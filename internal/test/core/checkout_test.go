@@ -0,0 +1,48 @@
+package core_test
+
+import (
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/LeeFred3042U/kitcat/internal/core"
+)
+
+func TestCheckoutFile_TrackedSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require elevated privileges on Windows")
+	}
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "target.txt", "content\n", "target")
+	if err := os.Symlink("target.txt", "link"); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.AddFile("link"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := core.Commit("link"); err != nil {
+		t.Fatal(err)
+	}
+
+	// An unchanged link is restored even though its target's content differs from the
+	// link's own blob
+	if err := core.CheckoutFile("link"); err != nil {
+		t.Fatalf("checking out an unchanged symlink failed: %v", err)
+	}
+	if target, err := os.Readlink("link"); err != nil || target != "target.txt" {
+		t.Errorf("link points to %q, %v", target, err)
+	}
+
+	// A link pointing elsewhere is a local change
+	if err := os.Remove("link"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("elsewhere", "link"); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.CheckoutFile("link"); err == nil {
+		t.Error("a retargeted symlink was overwritten")
+	}
+}
@@ -0,0 +1,164 @@
+package core_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/LeeFred3042U/kitcat/internal/core"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// setupPickBranches commits base.txt on main, then two commits on a "fix" branch,
+// and returns to main. It returns the IDs of the two fix commits.
+func setupPickBranches(t *testing.T) (string, string) {
+	t.Helper()
+	commitFile(t, "base.txt", "one\ntwo\nthree\n", "base")
+	if err := core.CreateBranch("fix"); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.CheckoutBranch("fix"); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.SetConfig("user.name", "Fix Author", false); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, "fix1.txt", "first fix\n", "first fix")
+	first, _ := core.GetHeadCommit()
+	commitFile(t, "base.txt", "one\ntwo\nTHREE\n", "second fix")
+	second, _ := core.GetHeadCommit()
+
+	if err := core.SetConfig("user.name", "Maintainer", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.CheckoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	return first.ID, second.ID
+}
+
+func TestCherryPick_AppliesCommitsInOrder(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	first, second := setupPickBranches(t)
+	commitFile(t, "base.txt", "ONE\ntwo\nthree\n", "main edit")
+	mainHead, _ := core.GetHeadCommit()
+
+	if err := core.CherryPick([]string{first, second[:7]}, false); err != nil {
+		t.Fatalf("CherryPick failed: %v", err)
+	}
+
+	head, _ := core.GetHeadCommit()
+	if head.Message != "second fix" || head.AuthorName != "Fix Author" {
+		t.Errorf("HEAD = %q by %q, want the second fix by its original author", head.Message, head.AuthorName)
+	}
+	parent, err := storage.FindCommit(head.FirstParent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent.Message != "first fix" || parent.FirstParent() != mainHead.ID {
+		t.Errorf("picked commits are not stacked on main: %q on %s", parent.Message, parent.FirstParent())
+	}
+	if data, _ := os.ReadFile("base.txt"); string(data) != "ONE\ntwo\nTHREE\n" {
+		t.Errorf("base.txt = %q, want both edits merged", data)
+	}
+	if core.IsSequencerInProgress() {
+		t.Errorf("sequencer state left behind after a clean cherry-pick")
+	}
+}
+
+func TestCherryPick_NoCommit(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	first, second := setupPickBranches(t)
+	mainHead, _ := core.GetHeadCommit()
+
+	if err := core.CherryPick([]string{first, second}, true); err != nil {
+		t.Fatalf("CherryPick --no-commit failed: %v", err)
+	}
+	if head, _ := core.GetHeadCommit(); head.ID != mainHead.ID {
+		t.Errorf("--no-commit must not create commits")
+	}
+	index, _ := storage.LoadIndex()
+	if _, ok := index["fix1.txt"]; !ok {
+		t.Errorf("fix1.txt was not staged")
+	}
+	if data, _ := os.ReadFile("base.txt"); string(data) != "one\ntwo\nTHREE\n" {
+		t.Errorf("base.txt = %q", data)
+	}
+}
+
+func TestCherryPick_ConflictContinueAndAbort(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	first, second := setupPickBranches(t)
+	commitFile(t, "base.txt", "one\ntwo\nmain\n", "main edit")
+	mainHead, _ := core.GetHeadCommit()
+
+	if err := core.CherryPick([]string{second, first}, false); err == nil {
+		t.Fatal("expected the cherry-pick to stop on a conflict")
+	}
+	if !core.IsSequencerInProgress() {
+		t.Fatal("sequencer state should be kept while a conflict is unresolved")
+	}
+	if err := core.CherryPickContinue(); err == nil {
+		t.Errorf("continue must be refused while paths are unmerged")
+	}
+
+	// Abort restores the starting point
+	if err := core.CherryPickAbort(); err != nil {
+		t.Fatalf("CherryPickAbort failed: %v", err)
+	}
+	if head, _ := core.GetHeadCommit(); head.ID != mainHead.ID {
+		t.Errorf("abort did not restore HEAD")
+	}
+	if data, _ := os.ReadFile("base.txt"); string(data) != "one\ntwo\nmain\n" {
+		t.Errorf("abort did not restore base.txt, got %q", data)
+	}
+
+	// Resolving and continuing commits the picked change and applies the rest
+	if err := core.CherryPick([]string{second, first}, false); err == nil {
+		t.Fatal("expected the cherry-pick to stop on a conflict")
+	}
+	if err := os.WriteFile("base.txt", []byte("one\ntwo\nresolved\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.AddFile("base.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.CherryPickContinue(); err != nil {
+		t.Fatalf("CherryPickContinue failed: %v", err)
+	}
+	head, _ := core.GetHeadCommit()
+	resolved, _ := storage.FindCommit(head.FirstParent())
+	if head.Message != "first fix" || resolved.Message != "second fix" || resolved.AuthorName != "Fix Author" {
+		t.Errorf("unexpected history after continue: %q <- %q by %q", resolved.Message, head.Message, resolved.AuthorName)
+	}
+	if core.IsSequencerInProgress() {
+		t.Errorf("sequencer state left behind after continue")
+	}
+}
+
+func TestCherryPick_Skip(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	first, second := setupPickBranches(t)
+	commitFile(t, "base.txt", "one\ntwo\nmain\n", "main edit")
+
+	if err := core.CherryPick([]string{second, first}, false); err == nil {
+		t.Fatal("expected the cherry-pick to stop on a conflict")
+	}
+	if err := core.CherryPickSkip(); err != nil {
+		t.Fatalf("CherryPickSkip failed: %v", err)
+	}
+	head, _ := core.GetHeadCommit()
+	if head.Message != "first fix" {
+		t.Errorf("HEAD = %q, want only the first fix applied", head.Message)
+	}
+	if data, _ := os.ReadFile("base.txt"); string(data) != "one\ntwo\nmain\n" {
+		t.Errorf("skipped changes were not discarded, base.txt = %q", data)
+	}
+}
@@ -0,0 +1,124 @@
+package core_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/LeeFred3042U/kitcat/internal/core"
+)
+
+// useEditor makes EDITOR a script that saves the file it is given to seen and then puts
+// message in front of it, returning the path of seen
+func useEditor(t *testing.T, message string) string {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not installed")
+	}
+	dir := t.TempDir()
+	seen := filepath.Join(dir, "seen")
+	if err := os.WriteFile(filepath.Join(dir, "message"), []byte(message), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(dir, "editor.sh")
+	body := "#!/bin/sh\ncp \"$1\" '" + seen + "'\ncat '" + filepath.Join(dir, "message") + "' \"$1\" > \"$1.new\" && mv \"$1.new\" \"$1\"\n"
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("EDITOR", script)
+	return seen
+}
+
+func TestCommit_EditorWithTemplateAndStatus(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "a.txt", "1\n", "first")
+	if err := os.WriteFile("template", []byte("Area: \n\n# Explain why\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.SetConfig("commit.template", "template", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("a.txt", []byte("2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.AddFile("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := useEditor(t, "Fix the parser   \n\n\n# not part of it\nIt failed on empty input.\n\n")
+	if _, _, err := core.CommitWith("", core.CommitOptions{Edit: true}); err != nil {
+		t.Fatalf("committing with the editor failed: %v", err)
+	}
+	shown, _ := os.ReadFile(seen)
+	for _, want := range []string{"Area: \n\n# Explain why\n", "# Please enter the commit message", "# On branch main", "#\tmodified:  a.txt"} {
+		if !strings.Contains(string(shown), want) {
+			t.Errorf("the editor was not given %q:\n%s", want, shown)
+		}
+	}
+	head, _ := core.GetHeadCommit()
+	if want := "Fix the parser\n\nIt failed on empty input.\n\nArea:"; head.Message != want {
+		t.Errorf("message is %q, want %q", head.Message, want)
+	}
+
+	// Leaving the template as it is, or emptying the message, aborts
+	useEditor(t, "")
+	if _, _, err := core.CommitWith("", core.CommitOptions{Edit: true, AllowEmpty: true}); err == nil {
+		t.Error("an unedited template was committed")
+	}
+	if err := core.SetConfig("commit.template", "", false); err != nil {
+		t.Fatal(err)
+	}
+	useEditor(t, "# only a comment\n")
+	if _, _, err := core.CommitWith("", core.CommitOptions{Edit: true, AllowEmpty: true}); err == nil {
+		t.Error("an empty message was committed")
+	}
+
+	// Amending offers the current message
+	seen = useEditor(t, "Reworded\n\n")
+	amended, err := core.AmendCommitWith("", core.CommitOptions{Edit: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shown, _ := os.ReadFile(seen); !strings.HasPrefix(string(shown), head.Message+"\n") {
+		t.Errorf("amending offered\n%s", shown)
+	}
+	if amended.Message != "Reworded\n\n"+head.Message {
+		t.Errorf("amended message is %q", amended.Message)
+	}
+}
+
+func TestCommit_AllowEmptyAndMultiParagraphLog(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "a.txt", "1\n", "first")
+	if _, _, err := core.Commit("nothing"); err == nil {
+		t.Error("an empty commit was made without AllowEmpty")
+	}
+	if _, _, err := core.CommitWith("  \n# kept\n", core.CommitOptions{AllowEmpty: true}); err != nil {
+		t.Errorf("a message given directly keeps its # lines: %v", err)
+	}
+	if _, _, err := core.CommitWith(" \n\n", core.CommitOptions{AllowEmpty: true}); err == nil {
+		t.Error("a blank message was committed")
+	}
+	if _, _, err := core.CommitWith("Subject line\n\n\nBody one\nBody two  \n", core.CommitOptions{AllowEmpty: true}); err != nil {
+		t.Fatalf("--allow-empty failed: %v", err)
+	}
+	head, _ := core.GetHeadCommit()
+	if head.Message != "Subject line\n\nBody one\nBody two" || head.Subject() != "Subject line" {
+		t.Errorf("message is %q", head.Message)
+	}
+
+	logged := captureOutput(t, func() error { return core.ShowLog(false, 1, "", false) })
+	if want := "\n    Subject line\n    \n    Body one\n    Body two\n\n"; !strings.HasSuffix(logged, want) {
+		t.Errorf("log shows\n%q\nwant it to end with\n%q", logged, want)
+	}
+	oneline := captureOutput(t, func() error { return core.ShowLog(true, 1, "", false) })
+	if want := head.ID[:7] + " Subject line\n"; oneline != want {
+		t.Errorf("log --oneline shows %q, want %q", oneline, want)
+	}
+}
@@ -0,0 +1,244 @@
+package core_test
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/LeeFred3042U/kitcat/internal/core"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// fastImportInto imports stream into a new repository and returns its path
+func fastImportInto(t *testing.T, stream []byte) string {
+	t.Helper()
+	dir := t.TempDir()
+	inDir(t, dir, func() {
+		if err := core.InitRepo(); err != nil {
+			t.Fatal(err)
+		}
+		if err := core.FastImport(bytes.NewReader(stream), false); err != nil {
+			t.Fatalf("FastImport failed: %v", err)
+		}
+	})
+	return dir
+}
+
+// resolveIn resolves rev in the repository at dir
+func resolveIn(t *testing.T, dir, rev string) string {
+	t.Helper()
+	var hash string
+	inDir(t, dir, func() {
+		var err error
+		if hash, err = core.ResolveRevision(rev); err != nil {
+			t.Fatalf("resolving %s: %v", rev, err)
+		}
+	})
+	return hash
+}
+
+func TestFastExport_RoundTrip(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "README", "readme\n", "first")
+	if err := core.CreateBranch("side"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, "odd \"name\".txt", "quoted\n", "second")
+	if err := core.CheckoutBranch("side"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, "side.txt", "side\n", "side work")
+	if err := core.CheckoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.Merge("side"); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.CreateTag("v1", "HEAD~1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.CreateAnnotatedTag("v2", "side", "Side release", false); err != nil {
+		t.Fatal(err)
+	}
+
+	var stream bytes.Buffer
+	if err := core.FastExport(&stream, nil, true); err != nil {
+		t.Fatalf("FastExport failed: %v", err)
+	}
+	imported := fastImportInto(t, stream.Bytes())
+	inDir(t, imported, func() {
+		data, err := os.ReadFile("odd \"name\".txt")
+		if err != nil || string(data) != "quoted\n" {
+			t.Errorf("checked out file holds %q, %v", data, err)
+		}
+		head, err := core.GetHeadCommit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(head.Parents) != 2 || head.Message != "Merge branch 'side'" {
+			t.Errorf("imported tip is %+v, want the merge", head)
+		}
+	})
+	// The stream keeps whole seconds only, so the imported commits get new IDs; from then
+	// on, the same stream always gives the same commits
+	var again bytes.Buffer
+	inDir(t, imported, func() {
+		if err := core.FastExport(&again, nil, true); err != nil {
+			t.Fatalf("FastExport failed: %v", err)
+		}
+	})
+	copied := fastImportInto(t, again.Bytes())
+	for _, ref := range []string{"main", "side", "v1", "v2"} {
+		if a, b := resolveIn(t, imported, ref), resolveIn(t, copied, ref); a != b {
+			t.Errorf("%s is %s after one round trip and %s after two", ref, a, b)
+		}
+	}
+	inDir(t, copied, func() {
+		ref, _ := os.ReadFile(filepath.Join(".kitcat", "refs", "tags", "v2"))
+		if tag, err := storage.ReadTag(string(ref)); err != nil || tag.Message != "Side release" {
+			t.Errorf("v2 was imported as %+v (%v), want the annotated tag", tag, err)
+		}
+	})
+}
+
+func TestFastExport_RangeBuildsOnExistingCommits(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "a.txt", "one\n", "first")
+	var full bytes.Buffer
+	if err := core.FastExport(&full, []string{"main"}, false); err != nil {
+		t.Fatal(err)
+	}
+	repo := fastImportInto(t, full.Bytes())
+	mirror := fastImportInto(t, full.Bytes())
+
+	var incremental bytes.Buffer
+	inDir(t, repo, func() {
+		commitFile(t, "b.txt", "two\n", "second")
+		if err := core.FastExport(&incremental, []string{"HEAD~1..main"}, false); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if strings.Contains(incremental.String(), "\ndata 6\nfirst\n") {
+		t.Errorf("range export repeats the excluded commit:\n%s", incremental.String())
+	}
+	inDir(t, mirror, func() {
+		if err := core.FastImport(&incremental, false); err != nil {
+			t.Fatalf("FastImport of the range failed: %v", err)
+		}
+		if _, err := os.Stat("b.txt"); err != nil {
+			t.Errorf("fast-forwarded branch was not checked out: %v", err)
+		}
+	})
+	// The new commit builds on the one the mirror already had
+	if a, b := resolveIn(t, repo, "main~1"), resolveIn(t, mirror, "main~1"); a != b {
+		t.Errorf("main~1 is %s in the source and %s in the mirror", a, b)
+	}
+}
+
+func TestFastImport_GitStream(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	stream := `feature done
+blob
+mark :1
+data 6
+hello
+
+reset refs/heads/main
+commit refs/heads/main
+mark :2
+author A U Thor <author@example.com> 1700000000 +0100
+committer C O Mitter <committer@example.com> 1700000100 +0000
+data <<EOF
+Add files
+EOF
+M 100644 :1 docs/hello.txt
+M 755 inline bin/run
+data 10
+#!/bin/sh
+
+commit refs/heads/main
+mark :3
+author A U Thor <author@example.com> 1700000200 +0100
+committer A U Thor <author@example.com> 1700000200 +0100
+data 7
+Rename
+from :2
+R docs/hello.txt "docs/h\303\251llo.txt"
+D bin
+
+tag v1
+from :2
+tagger A U Thor <author@example.com> 1700000300 +0100
+data 8
+Release
+done
+`
+	if err := core.FastImport(strings.NewReader(stream), false); err != nil {
+		t.Fatalf("FastImport failed: %v", err)
+	}
+	head, err := core.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Message != "Rename" || head.AuthorName != "A U Thor" || head.Timestamp.Unix() != 1700000200 {
+		t.Errorf("unexpected tip %+v", head)
+	}
+	if data, err := os.ReadFile("docs/héllo.txt"); err != nil || string(data) != "hello\n" {
+		t.Errorf("renamed file holds %q, %v", data, err)
+	}
+	if _, err := os.Stat("bin"); !os.IsNotExist(err) {
+		t.Errorf("deleted directory still exists: %v", err)
+	}
+	if tagged, first := resolveIn(t, ".", "v1"), resolveIn(t, ".", "HEAD~1"); tagged != first {
+		t.Errorf("v1 is %s, want %s", tagged, first)
+	}
+	ref, _ := os.ReadFile(filepath.Join(".kitcat", "refs", "tags", "v1"))
+	if tag, err := storage.ReadTag(string(ref)); err != nil || tag.Message != "Release" || tag.Time.Unix() != 1700000300 {
+		t.Errorf("v1 was imported as %+v (%v), want the annotated tag", tag, err)
+	}
+
+	bad := "commit refs/heads/main\ncommitter X <x@y> 1 +0000\ndata 2\nx\nM 100644 inline .kitcat/HEAD\ndata 1\nx\n"
+	if err := core.FastImport(strings.NewReader(bad), false); err == nil {
+		t.Error("FastImport wrote into .kitcat")
+	}
+}
+
+func TestFastExport_GitAcceptsStream(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "README", "readme\n", "first")
+	commitFile(t, "README", "changed\n", "second")
+	if err := core.CreateAnnotatedTag("v1", "HEAD~1", "First release", false); err != nil {
+		t.Fatal(err)
+	}
+
+	var stream bytes.Buffer
+	if err := core.FastExport(&stream, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	gitRepo := t.TempDir()
+	runGit(t, gitRepo, "init", "-q")
+	cmd := exec.Command("git", "fast-import", "--quiet")
+	cmd.Dir = gitRepo
+	cmd.Stdin = &stream
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git fast-import failed: %v\n%s", err, out)
+	}
+	runGit(t, gitRepo, "fsck", "--strict")
+	if got := runGit(t, gitRepo, "log", "--format=%s", "main"); got != "second\nfirst" {
+		t.Errorf("git log of the imported stream is %q", got)
+	}
+	if got := runGit(t, gitRepo, "cat-file", "-t", "v1"); got != "tag" {
+		t.Errorf("v1 is a %s in git, want an annotated tag", got)
+	}
+}
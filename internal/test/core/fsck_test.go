@@ -0,0 +1,165 @@
+package core_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/LeeFred3042U/kitcat/internal/core"
+)
+
+// fsckKinds runs Fsck and returns how many problems of each kind it found
+func fsckKinds(t *testing.T, showUnreachable bool) (map[string]int, error) {
+	t.Helper()
+	report, err := core.Fsck(showUnreachable)
+	kinds := make(map[string]int)
+	for _, p := range report.Problems {
+		kinds[p.Kind]++
+	}
+	return kinds, err
+}
+
+func TestFsck_CleanRepository(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "a.txt", "one\n", "first")
+	if err := os.Mkdir("dir", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, filepath.Join("dir", "b.txt"), "two\n", "second")
+	if err := core.CreateTag("v1", "HEAD~1"); err != nil {
+		t.Fatal(err)
+	}
+	kinds, err := fsckKinds(t, false)
+	if err != nil || len(kinds) != 0 {
+		t.Errorf("Fsck of a clean repository = %v, %v", kinds, err)
+	}
+}
+
+func TestFsck_EmptyTreeAfterEmptyBlob(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	// The empty blob and the empty tree have the same content, but must not share a name
+	commitFile(t, "empty", "", "one")
+	if err := core.RemoveFile("empty", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := core.CommitWith("two", core.CommitOptions{AllowEmpty: true}); err != nil {
+		t.Fatalf("committing the empty tree failed: %v", err)
+	}
+	if err := core.Status(); err != nil {
+		t.Errorf("status after removing the last file failed: %v", err)
+	}
+	if kinds, err := fsckKinds(t, false); err != nil || len(kinds) != 0 {
+		t.Errorf("Fsck after removing the last file = %v, %v", kinds, err)
+	}
+}
+
+func TestFsck_ReportsDanglingAndUnreachable(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "a.txt", "one\n", "first")
+	commitFile(t, "a.txt", "two\n", "second")
+	if err := core.Reset("HEAD~1", core.ResetHard); err != nil {
+		t.Fatal(err)
+	}
+	// The reflog still reaches the second commit
+	if kinds, err := fsckKinds(t, false); err != nil || len(kinds) != 0 {
+		t.Fatalf("Fsck = %v, %v; the reflog should keep the commit reachable", kinds, err)
+	}
+	if err := os.RemoveAll(filepath.Join(".kitcat", "logs")); err != nil {
+		t.Fatal(err)
+	}
+	kinds, err := fsckKinds(t, false)
+	if err != nil {
+		t.Errorf("dangling objects must not fail Fsck: %v", err)
+	}
+	if kinds[core.FsckDangling] != 1 {
+		t.Errorf("Fsck found %v, want only the commit to be dangling", kinds)
+	}
+	// The commit, its tree and its blob are unreachable
+	if kinds, _ := fsckKinds(t, true); kinds[core.FsckUnreachable] != 3 {
+		t.Errorf("Fsck --unreachable found %v, want 3 unreachable objects", kinds)
+	}
+}
+
+func TestFsck_DetectsDamage(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := os.Mkdir("dir", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, filepath.Join("dir", "a.txt"), "one\n", "first")
+	head, _ := core.GetHeadCommit()
+	if err := os.WriteFile(filepath.Join(".kitcat", "refs", "heads", "broken"), []byte("not a hash"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Removing the root tree breaks the commit's link to it
+	tree := filepath.Join(".kitcat", "objects", head.TreeHash[:2], head.TreeHash[2:])
+	if err := os.Remove(tree); err != nil {
+		t.Fatal(err)
+	}
+
+	kinds, err := fsckKinds(t, false)
+	if err == nil {
+		t.Fatal("Fsck should fail on a damaged repository")
+	}
+	if kinds[core.FsckMissing] != 1 || kinds[core.FsckBadRef] != 1 {
+		t.Errorf("Fsck found %v, want a missing tree and a bad ref", kinds)
+	}
+	// The subtree is now unreachable
+	if kinds[core.FsckDangling] != 1 {
+		t.Errorf("Fsck found %v, want the orphaned subtree to be dangling", kinds)
+	}
+}
+
+func TestFsck_DetectsCommitRewrittenInPlace(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "a.txt", "one\n", "first")
+	commitFile(t, "a.txt", "two\n", "second")
+	head, _ := core.GetHeadCommit()
+
+	// A well-formed commit object with another message under the same name and ID
+	forged := head
+	forged.Message = "EVIL!"
+	data, err := json.Marshal(forged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	fmt.Fprintf(zw, "commit %d\x00%s", len(data), data)
+	zw.Close()
+	object := filepath.Join(".kitcat", "objects", head.ID[:2], head.ID[2:])
+	if err := os.WriteFile(object, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := core.GetHeadCommit(); err == nil {
+		t.Error("the rewritten commit was read")
+	}
+	report, err := core.Fsck(false)
+	if err == nil {
+		t.Fatal("Fsck should fail on a rewritten commit")
+	}
+	found := false
+	for _, p := range report.Problems {
+		if p.Kind == core.FsckCorrupt && p.Name == "object "+head.ID && strings.Contains(p.Detail, "hashes to") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Fsck did not report %s as corrupt: %v", head.ID, report.Problems)
+	}
+}
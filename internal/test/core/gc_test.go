@@ -0,0 +1,184 @@
+package core_test
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/core"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// ageLooseObjects makes every loose object look as if it was written age ago
+func ageLooseObjects(t *testing.T, age time.Duration) {
+	t.Helper()
+	when := time.Now().Add(-age)
+	err := filepath.WalkDir(filepath.Join(".kitcat", "objects"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.Contains(path, "pack") {
+			return err
+		}
+		return os.Chtimes(path, when, when)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrune_DeletesExpiredUnreachableObjects(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "a.txt", "one\n", "first")
+	commitFile(t, "a.txt", "two\n", "second")
+	original, _ := core.GetHeadCommit()
+	if _, err := core.AmendCommit("second, reworded"); err != nil {
+		t.Fatal(err)
+	}
+	// The reflog keeps the amended commit alive
+	ageLooseObjects(t, 3*7*24*time.Hour)
+	if err := core.Prune(core.DefaultPruneAge); err != nil {
+		t.Fatal(err)
+	}
+	if !storage.HasObject(original.ID) {
+		t.Fatal("prune deleted a commit the reflog still reaches")
+	}
+
+	if err := os.RemoveAll(filepath.Join(".kitcat", "logs")); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.Prune(core.DefaultPruneAge); err != nil {
+		t.Fatal(err)
+	}
+	if storage.HasObject(original.ID) {
+		t.Error("prune kept the expired amended commit")
+	}
+	// New unreachable objects are kept until they expire
+	stray, err := storage.WriteBlob([]byte("stray\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := core.Prune(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if !storage.HasObject(stray) {
+		t.Error("prune deleted an object younger than the grace period")
+	}
+	if err := core.Prune(0); err != nil {
+		t.Fatal(err)
+	}
+	if storage.HasObject(stray) {
+		t.Error("prune with an age of now kept an unreachable object")
+	}
+
+	report, err := core.Fsck(true)
+	if err != nil || len(report.Problems) != 0 {
+		t.Errorf("Fsck after prune = %v, %v", report.Problems, err)
+	}
+}
+
+func TestGarbageCollect_PruneKeepsYoungObjectsLoose(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "a.txt", "one\n", "first")
+	stray, err := storage.WriteBlob([]byte("stray\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := core.GarbageCollect(true, core.DefaultPruneAge); err != nil {
+		t.Fatal(err)
+	}
+	if _, loose := storage.LooseObjectTime(stray); !loose {
+		t.Error("gc --prune packed an unreachable object, resetting its age")
+	}
+	head, _ := core.GetHeadCommit()
+	if _, loose := storage.LooseObjectTime(head.ID); loose {
+		t.Error("gc --prune did not pack a reachable commit")
+	}
+	if err := core.GarbageCollect(true, 0); err != nil {
+		t.Fatal(err)
+	}
+	if storage.HasObject(stray) {
+		t.Error("gc --prune=now kept an unreachable object")
+	}
+}
+
+func TestPrune_KeepsOldObjectsStoredAgain(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "a.txt", "one\n", "first")
+	strays := []string{"packed\n", "loose\n"}
+	if _, err := storage.WriteBlob([]byte(strays[0])); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.GarbageCollect(false, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.WriteBlob([]byte(strays[1])); err != nil {
+		t.Fatal(err)
+	}
+	// Both strays are old and unreachable, one packed and one loose
+	packs, _ := filepath.Glob(filepath.Join(".kitcat", "objects", "pack", "*"))
+	old := time.Now().Add(-3 * 7 * 24 * time.Hour)
+	for _, p := range packs {
+		if err := os.Chtimes(p, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ageLooseObjects(t, 3*7*24*time.Hour)
+
+	// A command about to refer to them again stores them first
+	var hashes []string
+	for _, content := range strays {
+		hash, err := storage.WriteBlob([]byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, hash)
+	}
+	if err := core.Prune(core.DefaultPruneAge); err != nil {
+		t.Fatal(err)
+	}
+	for i, hash := range hashes {
+		if !storage.HasObject(hash) {
+			t.Errorf("prune deleted the %s blob, which was just stored again", strings.TrimSpace(strays[i]))
+		}
+	}
+}
+
+func TestPrune_RefusesDamagedRepository(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "a.txt", "one\n", "first")
+	if err := os.WriteFile(filepath.Join(".kitcat", "refs", "heads", "broken"), []byte("0123"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.Prune(0); err == nil {
+		t.Error("prune should refuse a repository with a bad ref")
+	}
+}
+
+func TestParsePruneAge(t *testing.T) {
+	for spec, want := range map[string]time.Duration{
+		"now":            0,
+		"2.weeks.ago":    14 * 24 * time.Hour,
+		"30.minutes.ago": 30 * time.Minute,
+		"3 days":         72 * time.Hour,
+		"12h":            12 * time.Hour,
+		"1.month.ago":    30 * 24 * time.Hour,
+	} {
+		if got, err := core.ParsePruneAge(spec); err != nil || got != want {
+			t.Errorf("ParsePruneAge(%q) = %v, %v; want %v", spec, got, err, want)
+		}
+	}
+	for _, spec := range []string{"", "soon", "weeks.ago", "5.fortnights.ago"} {
+		if _, err := core.ParsePruneAge(spec); err == nil {
+			t.Errorf("ParsePruneAge(%q) should fail", spec)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package core_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/LeeFred3042U/kitcat/internal/core"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// runGit runs git in dir and returns its trimmed output, skipping the test when git is
+// not installed
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	cmd := exec.Command("git", append([]string{"-c", "user.name=Git User", "-c", "user.email=git@example.com"}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_CONFIG_NOSYSTEM=1", "HOME="+dir)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s failed: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestExportGit_RoundTrip(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "README", "readme\n", "first")
+	if err := core.CreateBranch("side"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll("src/pkg", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("run.sh", []byte("#!/bin/sh\necho hi\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.AddFile("run.sh"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, filepath.Join("src", "pkg", "code.go"), "package pkg\n", "add code")
+	if err := core.CheckoutBranch("side"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, "side.txt", "side\n", "side work")
+	if err := core.CheckoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.Merge("side"); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.CreateTag("v1", "HEAD~1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.CreateAnnotatedTag("v2", "HEAD", "Second release", false); err != nil {
+		t.Fatal(err)
+	}
+	original, _ := core.GetHeadCommit()
+	annotated, _ := os.ReadFile(filepath.Join(".kitcat", "refs", "tags", "v2"))
+
+	gitRepo := t.TempDir()
+	if err := core.ExportGit(gitRepo); err != nil {
+		t.Fatalf("ExportGit failed: %v", err)
+	}
+	runGit(t, gitRepo, "fsck", "--strict")
+	if got := runGit(t, gitRepo, "rev-list", "--count", "main"); got != "4" {
+		t.Errorf("git sees %s commits on main, want 4", got)
+	}
+	if got := runGit(t, gitRepo, "show", "main:src/pkg/code.go"); got != "package pkg" {
+		t.Errorf("git shows src/pkg/code.go as %q", got)
+	}
+	if got := runGit(t, gitRepo, "ls-tree", "main", "run.sh"); !strings.HasPrefix(got, "100755 blob") {
+		t.Errorf("run.sh should be executable in git, got %q", got)
+	}
+	if got := runGit(t, gitRepo, "log", "-1", "--format=%s", "v1"); got != "add code" {
+		t.Errorf("tag v1 points at %q in git", got)
+	}
+	if got := runGit(t, gitRepo, "cat-file", "-t", "v2"); got != "tag" {
+		t.Errorf("v2 is a %s in git, want an annotated tag", got)
+	}
+	if got := runGit(t, gitRepo, "tag", "-l", "-n1", "v2"); !strings.HasSuffix(got, "Second release") {
+		t.Errorf("git lists v2 as %q", got)
+	}
+
+	// Importing the export again reproduces the kitcat commits exactly
+	inDir(t, t.TempDir(), func() {
+		if err := core.InitRepo(); err != nil {
+			t.Fatal(err)
+		}
+		if err := core.ImportGit(gitRepo); err != nil {
+			t.Fatalf("ImportGit failed: %v", err)
+		}
+		head, err := core.GetHeadCommit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if head.ID != original.ID {
+			t.Errorf("round trip changed main from %s to %s", original.ID, head.ID)
+		}
+		if tag, _ := os.ReadFile(filepath.Join(".kitcat", "refs", "tags", "v2")); string(tag) != string(annotated) {
+			t.Errorf("round trip changed the tag object of v2 from %s to %s", annotated, tag)
+		}
+		if data, _ := os.ReadFile("side.txt"); string(data) != "side\n" {
+			t.Errorf("import did not check out side.txt, got %q", data)
+		}
+	})
+}
+
+func TestImportGit_NativeRepository(t *testing.T) {
+	gitRepo := t.TempDir()
+	runGit(t, gitRepo, "init", "-q", "-b", "trunk")
+	if err := os.MkdirAll(filepath.Join(gitRepo, "docs"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(gitRepo, "docs", "guide.md"), []byte("# Guide\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, gitRepo, "add", ".")
+	runGit(t, gitRepo, "commit", "-q", "-m", "Add the guide")
+	runGit(t, gitRepo, "tag", "-a", "v1.0", "-m", "first release")
+
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+	if err := core.ImportGit(gitRepo); err != nil {
+		t.Fatalf("ImportGit failed: %v", err)
+	}
+	head, err := core.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Message != "Add the guide" || head.AuthorName != "Git User" {
+		t.Errorf("imported commit = %q by %q", head.Message, head.AuthorName)
+	}
+	if data, _ := os.ReadFile(filepath.Join("docs", "guide.md")); string(data) != "# Guide\n" {
+		t.Errorf("import did not check out docs/guide.md, got %q", data)
+	}
+	if tagged, err := core.ResolveRevision("v1.0"); err != nil || tagged != head.ID {
+		t.Errorf("annotated tag v1.0 resolves to %q (%v), want %s", tagged, err, head.ID)
+	}
+	ref, _ := os.ReadFile(filepath.Join(".kitcat", "refs", "tags", "v1.0"))
+	if tag, err := storage.ReadTag(string(ref)); err != nil || tag.Message != "first release" || tag.TaggerName != "Git User" {
+		t.Errorf("v1.0 was imported as %+v (%v), want the annotated tag", tag, err)
+	}
+
+	// A second import fast-forwards the checked out branch
+	if err := os.WriteFile(filepath.Join(gitRepo, "docs", "guide.md"), []byte("# Guide\nmore\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, gitRepo, "commit", "-q", "-am", "Expand the guide")
+	if err := core.ImportGit(gitRepo); err != nil {
+		t.Fatalf("second ImportGit failed: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join("docs", "guide.md")); string(data) != "# Guide\nmore\n" {
+		t.Errorf("the fast-forward did not update docs/guide.md, got %q", data)
+	}
+}
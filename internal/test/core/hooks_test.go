@@ -0,0 +1,221 @@
+package core_test
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/LeeFred3042U/kitcat/internal/core"
+)
+
+// writeHook installs a shell script as the hook name of the repository in the current
+// directory
+func writeHook(t *testing.T, name, body string) {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not installed")
+	}
+	if err := os.WriteFile(filepath.Join(core.HooksDir, name), []byte("#!/bin/sh\n"+body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readLog returns what the hooks appended to log, and empties it
+func readLog(t *testing.T, log string) string {
+	t.Helper()
+	data, _ := os.ReadFile(log)
+	if err := os.WriteFile(log, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestHooks_Commit(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	log := filepath.Join(t.TempDir(), "log")
+	commitFile(t, "a.txt", "1\n", "first")
+	writeHook(t, "pre-commit", "echo \"pre-commit $KITCAT_AUTHOR_NAME\" >> '"+log+"'\nexit 1\n")
+	writeHook(t, "post-commit", "echo post-commit >> '"+log+"'\n")
+	if err := os.WriteFile("a.txt", []byte("2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.AddFile("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := core.Commit("blocked"); err == nil || !strings.Contains(err.Error(), "pre-commit") {
+		t.Errorf("a failing pre-commit hook gave %v", err)
+	}
+	if got := readLog(t, log); got != "pre-commit Test User\n" {
+		t.Errorf("the hooks logged %q", got)
+	}
+	if head, _ := core.GetHeadCommit(); head.Message != "first" {
+		t.Errorf("the blocked commit was made: %q", head.Message)
+	}
+
+	writeHook(t, "commit-msg", "echo \"commit-msg $1\" >> '"+log+"'\nprintf '\\nSigned-off-by: Test User\\n' >> \"$1\"\n")
+	if _, _, err := core.CommitWith("skipped", core.CommitOptions{NoVerify: true}); err != nil {
+		t.Fatalf("--no-verify did not skip the hooks: %v", err)
+	}
+	if got := readLog(t, log); got != "post-commit\n" {
+		t.Errorf("with --no-verify the hooks logged %q", got)
+	}
+
+	writeHook(t, "pre-commit", "exit 0\n")
+	if _, _, err := core.CommitWith("checked", core.CommitOptions{AllowEmpty: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readLog(t, log); got != "commit-msg "+core.CommitEditMsgPath+"\npost-commit\n" {
+		t.Errorf("the hooks logged %q", got)
+	}
+	if head, _ := core.GetHeadCommit(); head.Message != "checked\n\nSigned-off-by: Test User" {
+		t.Errorf("the commit-msg hook's edit was not kept: %q", head.Message)
+	}
+
+	writeHook(t, "commit-msg", "echo 'no ticket' >&2\nexit 3\n")
+	if _, _, err := core.CommitWith("rejected", core.CommitOptions{AllowEmpty: true}); err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("a failing commit-msg hook gave %v", err)
+	}
+
+	// A hook that is not executable is skipped, with a hint printed once
+	if err := os.Chmod(filepath.Join(core.HooksDir, "commit-msg"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	r, w, _ := os.Pipe()
+	os.Stderr = w
+	_, _, err := core.CommitWith("accepted", core.CommitOptions{AllowEmpty: true})
+	w.Close()
+	os.Stderr = stderr
+	if err != nil {
+		t.Errorf("a hook that is not executable was run: %v", err)
+	}
+	hints, _ := io.ReadAll(r)
+	if n := strings.Count(string(hints), "commit-msg' hook was ignored"); n != 1 {
+		t.Errorf("the hint for the commit-msg hook was printed %d times:\n%s", n, hints)
+	}
+}
+
+func TestHooks_CheckoutStashMergeAndRebase(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	log := filepath.Join(t.TempDir(), "log")
+	commitFile(t, "a.txt", "1\n", "first")
+	first, _ := core.GetHeadCommit()
+	if err := core.CreateBranch("side"); err != nil {
+		t.Fatal(err)
+	}
+	writeHook(t, "post-checkout", "echo \"post-checkout $1 $2 $3\" >> '"+log+"'\n")
+
+	if err := core.CheckoutBranch("side"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, "b.txt", "b\n", "side work")
+	side, _ := core.GetHeadCommit()
+	if err := core.CheckoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	want := "post-checkout " + first.ID + " " + first.ID + " 1\npost-checkout " + side.ID + " " + first.ID + " 1\n"
+	if got := readLog(t, log); got != want {
+		t.Errorf("checking out logged\n%q\nwant\n%q", got, want)
+	}
+
+	if err := os.WriteFile("a.txt", []byte("dirty\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeHook(t, "pre-stash", "echo pre-stash >> '"+log+"'\nexit 1\n")
+	if err := core.StashPush(""); err == nil || !strings.Contains(err.Error(), "pre-stash") {
+		t.Errorf("a failing pre-stash hook gave %v", err)
+	}
+	if got := readLog(t, log); got != "pre-stash\n" {
+		t.Errorf("the blocked stash logged %q", got)
+	}
+	if data, _ := os.ReadFile("a.txt"); string(data) != "dirty\n" {
+		t.Errorf("the blocked stash changed a.txt to %q", data)
+	}
+	if err := core.StashPushWith("", true); err != nil {
+		t.Fatalf("--no-verify did not skip pre-stash: %v", err)
+	}
+	if got, want := readLog(t, log), "post-checkout "+first.ID+" "+first.ID+" 0\n"; got != want {
+		t.Errorf("stashing logged %q, want %q", got, want)
+	}
+	if err := core.CheckoutFile("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readLog(t, log), "post-checkout "+first.ID+" "+first.ID+" 0\n"; got != want {
+		t.Errorf("checking out a file logged %q, want %q", got, want)
+	}
+
+	commitFile(t, "c.txt", "c\n", "main work")
+	writeHook(t, "pre-merge-commit", "exit 1\n")
+	writeHook(t, "post-merge", "echo \"post-merge $1\" >> '"+log+"'\n")
+	if err := core.MergeWith("side", false); err == nil || !strings.Contains(err.Error(), "pre-merge-commit") {
+		t.Errorf("a failing pre-merge-commit hook gave %v", err)
+	}
+	if head, _ := core.GetHeadCommit(); head.Message != "main work" {
+		t.Errorf("the blocked merge was committed: %q", head.Message)
+	}
+	if err := core.MergeWith("side", true); err != nil {
+		t.Fatalf("--no-verify did not skip pre-merge-commit: %v", err)
+	}
+	if got := readLog(t, log); got != "post-merge 0\n" {
+		t.Errorf("merging logged %q", got)
+	}
+
+	writeHook(t, "pre-rebase", "echo \"pre-rebase $1\" >> '"+log+"'\nexit 1\n")
+	if err := core.RebaseInteractiveWith(first.ID, false); err == nil || !strings.Contains(err.Error(), "not started") {
+		t.Errorf("a failing pre-rebase hook gave %v", err)
+	}
+	if got := readLog(t, log); got != "pre-rebase "+first.ID+"\n" {
+		t.Errorf("rebasing logged %q", got)
+	}
+}
+
+func TestHooks_CherryPickAndRevertRunOnlyPostCommit(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	log := filepath.Join(t.TempDir(), "log")
+	commitFile(t, "a.txt", "1\n", "first")
+	if err := core.CreateBranch("side"); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.CheckoutBranch("side"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, "b.txt", "b\n", "side work")
+	if err := core.CheckoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	writeHook(t, "pre-commit", "echo pre-commit >> '"+log+"'\nexit 1\n")
+	writeHook(t, "commit-msg", "echo commit-msg >> '"+log+"'\nexit 1\n")
+	writeHook(t, "post-commit", "echo post-commit >> '"+log+"'\n")
+
+	if err := core.CherryPick([]string{"side"}, false); err != nil {
+		t.Fatalf("CherryPick ran a failing hook: %v", err)
+	}
+	if err := core.Revert([]string{"HEAD"}, false); err != nil {
+		t.Fatalf("Revert ran a failing hook: %v", err)
+	}
+	if got := readLog(t, log); got != "post-commit\npost-commit\n" {
+		t.Errorf("cherry-pick and revert ran the hooks %q", got)
+	}
+
+	// Picking a commit whose changes are already here is reported, not silently dropped
+	writeHook(t, "pre-commit", "exit 0\n")
+	writeHook(t, "commit-msg", "exit 0\n")
+	commitFile(t, "b.txt", "b\n", "same change on main")
+	out := captureOutput(t, func() error { return core.CherryPick([]string{"side"}, false) })
+	if !strings.Contains(out, "Skipped") {
+		t.Errorf("an empty cherry-pick printed\n%s", out)
+	}
+	if head, _ := core.GetHeadCommit(); head.Message != "same change on main" {
+		t.Errorf("an empty cherry-pick made the commit %q", head.Message)
+	}
+}
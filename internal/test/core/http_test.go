@@ -0,0 +1,199 @@
+package core_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/LeeFred3042U/kitcat/internal/core"
+)
+
+func TestHTTP_CloneFetchAndPush(t *testing.T) {
+	origin, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "file.txt", "one\n", "first")
+	if err := core.CreateTag("v1", "HEAD"); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(core.NewServer(origin))
+	defer server.Close()
+
+	clone := filepath.Join(t.TempDir(), "clone")
+	if err := core.Clone(server.URL, clone); err != nil {
+		t.Fatalf("Clone over HTTP failed: %v", err)
+	}
+
+	inDir(t, clone, func() {
+		if data, _ := os.ReadFile("file.txt"); string(data) != "one\n" {
+			t.Errorf("clone did not check out file.txt, got %q", data)
+		}
+		if _, err := core.ResolveRevision("v1"); err != nil {
+			t.Errorf("clone did not copy tag v1: %v", err)
+		}
+		commitFile(t, "file.txt", "two\n", "second")
+		if err := core.Push("origin", "", false); err == nil || !strings.Contains(err.Error(), "checked out") {
+			t.Fatalf("a push to the server's checked out branch gave %v", err)
+		}
+	})
+	if data, _ := os.ReadFile("file.txt"); string(data) != "one\n" {
+		t.Errorf("a push changed the server's working directory, file.txt = %q", data)
+	}
+
+	// With another branch checked out on the server, the push is accepted
+	if err := core.CreateBranch("serving"); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.CheckoutBranch("serving"); err != nil {
+		t.Fatal(err)
+	}
+	inDir(t, clone, func() {
+		if err := core.Push("origin", "", false); err != nil {
+			t.Fatalf("Push over HTTP failed: %v", err)
+		}
+	})
+	if err := core.CheckoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	if head, _ := core.GetHeadCommit(); head.Message != "second" {
+		t.Errorf("origin main = %q, want the pushed commit", head.Message)
+	}
+
+	commitFile(t, "other.txt", "from origin\n", "third")
+	third, _ := core.GetHeadCommit()
+	inDir(t, clone, func() {
+		if err := core.Fetch("origin"); err != nil {
+			t.Fatalf("Fetch over HTTP failed: %v", err)
+		}
+		if tracking, _ := core.ResolveRevision("origin/main"); tracking != third.ID {
+			t.Errorf("origin/main = %s, want %s", tracking, third.ID)
+		}
+		if _, err := core.ResolveRevision(third.ID); err != nil {
+			t.Errorf("fetched commit is not readable: %v", err)
+		}
+	})
+}
+
+func TestHTTP_PushRejectsNonFastForward(t *testing.T) {
+	origin, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "file.txt", "one\n", "first")
+	server := httptest.NewServer(core.NewServer(origin))
+	defer server.Close()
+
+	clone := filepath.Join(t.TempDir(), "clone")
+	if err := core.Clone(server.URL, clone); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, "origin.txt", "origin\n", "origin change")
+	originHead, _ := core.GetHeadCommit()
+	if err := core.CreateBranch("serving"); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.CheckoutBranch("serving"); err != nil {
+		t.Fatal(err)
+	}
+
+	inDir(t, clone, func() {
+		commitFile(t, "clone.txt", "clone\n", "clone change")
+		err := core.Push("origin", "main", false)
+		if err == nil || !strings.Contains(err.Error(), "non-fast-forward") {
+			t.Fatalf("expected a non-fast-forward rejection, got %v", err)
+		}
+	})
+	if main, _ := core.ResolveRevision("main"); main != originHead.ID {
+		t.Errorf("a rejected push must leave the remote branch alone")
+	}
+
+	inDir(t, clone, func() {
+		if err := core.Pull("origin", ""); err != nil {
+			t.Fatalf("Pull over HTTP failed: %v", err)
+		}
+		if err := core.Push("origin", "main", false); err != nil {
+			t.Fatalf("Push after pulling failed: %v", err)
+		}
+	})
+	if err := core.CheckoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat("clone.txt"); err != nil {
+		t.Errorf("the merged push did not bring clone.txt to origin: %v", err)
+	}
+}
+
+func TestHTTP_ServerRejectsForgedObjectsWithStatus(t *testing.T) {
+	origin, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "file.txt", "one\n", "first")
+	head, _ := core.GetHeadCommit()
+	server := httptest.NewServer(core.NewServer(origin))
+	defer server.Close()
+
+	post := func(body any) (int, string) {
+		t.Helper()
+		data, ok := body.([]byte)
+		if !ok {
+			var err error
+			if data, err = json.Marshal(body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		resp, err := http.Post(server.URL+"/receive-objects", "application/json", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(msg)
+	}
+	type object struct {
+		Hash string `json:"hash"`
+		Type string `json:"type"`
+		Data []byte `json:"data"`
+	}
+	update := func(ref, old, new string) map[string]string {
+		return map[string]string{"ref": ref, "old": old, "new": new}
+	}
+
+	// A commit whose content does not hash to the name it is sent under
+	forged := head
+	forged.Message = "EVIL!"
+	data, _ := json.Marshal(forged)
+	status, msg := post(map[string]any{
+		"update":  update("refs/heads/evil", "", forged.ID),
+		"objects": []object{{Hash: forged.ID, Type: "commit", Data: data}},
+	})
+	if status != http.StatusBadRequest || !strings.Contains(msg, "corrupt object") {
+		t.Errorf("pushing a forged commit gave %d %q, want 400", status, msg)
+	}
+	if _, err := core.ResolveRevision("evil"); err == nil {
+		t.Error("the forged push created a branch")
+	}
+	if again, err := core.GetHeadCommit(); err != nil || again.Message != "first" {
+		t.Errorf("HEAD reads as %q, %v after the forged push", again.Message, err)
+	}
+
+	if status, msg := post(update("refs/heads/main", strings.Repeat("0", 40), head.ID)); status != http.StatusBadRequest {
+		t.Errorf("a request without an update gave %d %q, want 400", status, msg)
+	}
+	if status, msg := post(map[string]any{"update": update("refs/heads/side", strings.Repeat("0", 40), head.ID)}); status != http.StatusConflict {
+		t.Errorf("a push to a branch that moved gave %d %q, want 409", status, msg)
+	}
+	if status, msg := post(map[string]any{"update": update("refs/heads/main", head.ID, head.ID)}); status != http.StatusConflict {
+		t.Errorf("a push to the checked out branch gave %d %q, want 409", status, msg)
+	}
+	if status, msg := post([]byte("{not json")); status != http.StatusBadRequest {
+		t.Errorf("a malformed request gave %d %q, want 400", status, msg)
+	}
+	if status, _ := post(bytes.Repeat([]byte(" "), 64<<20+1)); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("a request over the size limit gave %d, want 413", status)
+	}
+}
@@ -0,0 +1,154 @@
+package core_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/core"
+)
+
+func TestCommit_AuthorAndCommitterFromEnvironmentAndFlags(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "a.txt", "1\n", "configured")
+	head, _ := core.GetHeadCommit()
+	if head.AuthorName != "Test User" || head.CommitterName != "Test User" || head.CommitterEmail != "test@example.com" {
+		t.Errorf("a plain commit has author %q and committer %q <%s>", head.AuthorName, head.CommitterName, head.CommitterEmail)
+	}
+
+	t.Setenv("KITCAT_AUTHOR_NAME", "Ann Author")
+	t.Setenv("KITCAT_AUTHOR_DATE", "2020-02-03T04:05:06+01:00")
+	t.Setenv("KITCAT_COMMITTER_EMAIL", "bot@example.com")
+	commitFile(t, "a.txt", "2\n", "from the environment")
+	head, _ = core.GetHeadCommit()
+	if head.AuthorName != "Ann Author" || head.AuthorEmail != "test@example.com" {
+		t.Errorf("author is %s <%s>", head.AuthorName, head.AuthorEmail)
+	}
+	if want := time.Date(2020, 2, 3, 3, 5, 6, 0, time.UTC); !head.Timestamp.Equal(want) {
+		t.Errorf("author date is %v, want %v", head.Timestamp, want)
+	}
+	if head.CommitterName != "Test User" || head.CommitterEmail != "bot@example.com" || time.Since(head.CommitTime) > time.Minute {
+		t.Errorf("committer is %s <%s> at %v", head.CommitterName, head.CommitterEmail, head.CommitTime)
+	}
+
+	if err := os.WriteFile("a.txt", []byte("3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.AddFile("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	opts := core.CommitOptions{Author: "Flag Author <flag@example.com>", Date: "@1700000000"}
+	if _, _, err := core.CommitWith("from flags", opts); err != nil {
+		t.Fatalf("CommitWith failed: %v", err)
+	}
+	head, _ = core.GetHeadCommit()
+	if head.AuthorName != "Flag Author" || head.AuthorEmail != "flag@example.com" || head.Timestamp.Unix() != 1700000000 {
+		t.Errorf("--author and --date recorded %s <%s> at %v", head.AuthorName, head.AuthorEmail, head.Timestamp)
+	}
+
+	amended, err := core.AmendCommitWith("reworded", core.CommitOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if amended.AuthorName != "Flag Author" || !amended.Timestamp.Equal(head.Timestamp) {
+		t.Errorf("amending changed the author to %s at %v", amended.AuthorName, amended.Timestamp)
+	}
+
+	for _, bad := range []core.CommitOptions{{Author: "nobody"}, {Date: "next tuesday"}} {
+		if _, _, err := core.CommitWith("bad", bad); err == nil {
+			t.Errorf("CommitWith accepted %+v", bad)
+		}
+	}
+	t.Setenv("KITCAT_COMMITTER_DATE", "yesterday")
+	if _, _, err := core.CommitWith("bad", core.CommitOptions{All: true}); err == nil {
+		t.Error("an invalid KITCAT_COMMITTER_DATE was accepted")
+	}
+}
+
+func TestCommit_IDCoversAuthorAndCommitter(t *testing.T) {
+	t.Setenv("KITCAT_AUTHOR_DATE", "@1700000000 +0000")
+	t.Setenv("KITCAT_COMMITTER_DATE", "@1700000100 +0000")
+	commitIn := func(authorName, committerName string) string {
+		t.Helper()
+		t.Setenv("KITCAT_AUTHOR_NAME", authorName)
+		t.Setenv("KITCAT_COMMITTER_NAME", committerName)
+		var id string
+		inDir(t, t.TempDir(), func() {
+			if err := core.InitRepo(); err != nil {
+				t.Fatal(err)
+			}
+			commitFile(t, "a.txt", "same\n", "same")
+			head, _ := core.GetHeadCommit()
+			id = head.ID
+		})
+		return id
+	}
+
+	base := commitIn("Ann", "Cat")
+	if again := commitIn("Ann", "Cat"); again != base {
+		t.Errorf("identical commits got the IDs %s and %s", base, again)
+	}
+	if other := commitIn("Bob", "Cat"); other == base {
+		t.Error("the commit ID does not cover the author")
+	}
+	if other := commitIn("Ann", "Dan"); other == base {
+		t.Error("the commit ID does not cover the committer")
+	}
+}
+
+func TestCherryPick_KeepsAuthorAndChangesCommitter(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "a.txt", "1\n", "first")
+	if err := core.CreateBranch("side"); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.CheckoutBranch("side"); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("KITCAT_AUTHOR_NAME", "Original Author")
+	t.Setenv("KITCAT_AUTHOR_DATE", "2021-06-01 10:00:00 +0000")
+	commitFile(t, "b.txt", "b\n", "side work")
+	original, _ := core.GetHeadCommit()
+	if err := core.CheckoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("KITCAT_AUTHOR_NAME", "")
+	t.Setenv("KITCAT_AUTHOR_DATE", "")
+	t.Setenv("KITCAT_COMMITTER_NAME", "Picker")
+	if err := core.CherryPick([]string{"side"}, false); err != nil {
+		t.Fatalf("CherryPick failed: %v", err)
+	}
+	picked, _ := core.GetHeadCommit()
+	if picked.AuthorName != "Original Author" || !picked.Timestamp.Equal(original.Timestamp) {
+		t.Errorf("the picked commit has author %s at %v, want %s at %v", picked.AuthorName, picked.Timestamp, original.AuthorName, original.Timestamp)
+	}
+	if picked.CommitterName != "Picker" {
+		t.Errorf("the picked commit has committer %s, want Picker", picked.CommitterName)
+	}
+
+	// git sees both identities
+	gitRepo := t.TempDir()
+	if err := core.ExportGit(gitRepo); err != nil {
+		t.Fatal(err)
+	}
+	if got := runGit(t, gitRepo, "log", "-1", "--format=%an|%cn|%ad", "--date=iso", "main"); got != "Original Author|Picker|2021-06-01 10:00:00 +0000" {
+		t.Errorf("git shows the picked commit as %q", got)
+	}
+	inDir(t, filepath.Join(t.TempDir()), func() {
+		if err := core.InitRepo(); err != nil {
+			t.Fatal(err)
+		}
+		if err := core.ImportGit(gitRepo); err != nil {
+			t.Fatal(err)
+		}
+		if head, _ := core.GetHeadCommit(); head.ID != picked.ID {
+			t.Errorf("export and import changed %s into %s", picked.ID, head.ID)
+		}
+	})
+}
@@ -0,0 +1,321 @@
+package core_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/LeeFred3042U/kitcat/internal/core"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// commitFile writes content to path, stages it and commits it
+func commitFile(t *testing.T, path, content, message string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.AddFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := core.Commit(message); err != nil {
+		t.Fatalf("commit %q failed: %v", message, err)
+	}
+}
+
+func TestMerge_DivergedBranchesCreateMergeCommit(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "shared.txt", "base\n", "base")
+	if err := core.CreateBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+
+	commitFile(t, "main.txt", "main side\n", "main work")
+	mainHead, _ := core.GetHeadCommit()
+
+	if err := core.CheckoutBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, "feature.txt", "feature side\n", "feature work")
+	featureHead, _ := core.GetHeadCommit()
+
+	if err := core.CheckoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.Merge("feature"); err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+
+	merge, err := core.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merge.Parents) != 2 || merge.Parents[0] != mainHead.ID || merge.Parents[1] != featureHead.ID {
+		t.Fatalf("merge commit parents = %v, want [%s %s]", merge.Parents, mainHead.ID, featureHead.ID)
+	}
+	for _, f := range []string{"shared.txt", "main.txt", "feature.txt"} {
+		if _, err := os.Stat(f); err != nil {
+			t.Errorf("%s missing from the working tree after merge: %v", f, err)
+		}
+	}
+
+	for _, ancestor := range []string{mainHead.ID, featureHead.ID} {
+		ok, err := storage.IsAncestor(ancestor, merge.ID)
+		if err != nil || !ok {
+			t.Errorf("IsAncestor(%s, merge) = %v, %v", ancestor[:7], ok, err)
+		}
+	}
+
+	// Merging again is a no-op
+	if err := core.Merge("feature"); err != nil {
+		t.Errorf("second merge failed: %v", err)
+	}
+	if head, _ := core.GetHeadCommit(); head.ID != merge.ID {
+		t.Errorf("second merge moved HEAD")
+	}
+}
+
+func TestMerge_NonOverlappingEditsToOneFile(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "file.txt", "one\ntwo\nthree\nfour\nfive\n", "base")
+	if err := core.CreateBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, "file.txt", "ONE\ntwo\nthree\nfour\nfive\n", "main edit")
+
+	if err := core.CheckoutBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, "file.txt", "one\ntwo\nthree\nfour\nFIVE\n", "feature edit")
+	if err := core.CheckoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := core.Merge("feature"); err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if data, _ := os.ReadFile("file.txt"); string(data) != "ONE\ntwo\nthree\nfour\nFIVE\n" {
+		t.Errorf("file.txt was not merged line by line, got %q", data)
+	}
+	if head, _ := core.GetHeadCommit(); !head.IsMerge() {
+		t.Errorf("expected a merge commit, got parents %v", head.Parents)
+	}
+}
+
+func TestMerge_ConflictingChangesLeaveMarkers(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "file.txt", "base\n", "base")
+	if err := core.CreateBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, "file.txt", "main\n", "main edit")
+	mainHead, _ := core.GetHeadCommit()
+
+	if err := core.CheckoutBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, "file.txt", "feature\n", "feature edit")
+	featureHead, _ := core.GetHeadCommit()
+	if err := core.CheckoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := core.Merge("feature"); err == nil {
+		t.Fatal("expected the merge to stop on conflicting edits")
+	}
+	if head, _ := core.GetHeadCommit(); head.ID != mainHead.ID {
+		t.Errorf("a conflicted merge must not move HEAD")
+	}
+	want := "<<<<<<< HEAD\nmain\n=======\nfeature\n>>>>>>> feature\n"
+	if data, _ := os.ReadFile("file.txt"); string(data) != want {
+		t.Errorf("file.txt = %q, want conflict markers %q", data, want)
+	}
+	if err := core.Merge("feature"); err == nil {
+		t.Errorf("a second merge must be refused while the first is unresolved")
+	}
+
+	// Resolving and committing concludes the merge
+	commitFile(t, "file.txt", "resolved\n", "")
+	merge, _ := core.GetHeadCommit()
+	if len(merge.Parents) != 2 || merge.Parents[0] != mainHead.ID || merge.Parents[1] != featureHead.ID {
+		t.Fatalf("merge commit parents = %v, want [%s %s]", merge.Parents, mainHead.ID, featureHead.ID)
+	}
+	if merge.Message != "Merge branch 'feature'" {
+		t.Errorf("merge commit message = %q", merge.Message)
+	}
+	if core.IsMergeInProgress() {
+		t.Errorf("merge state left behind after the merge commit")
+	}
+}
+
+func TestMerge_Abort(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "file.txt", "base\n", "base")
+	if err := core.CreateBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, "file.txt", "main\n", "main edit")
+	if err := core.CheckoutBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, "file.txt", "feature\n", "feature edit")
+	if err := core.CheckoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := core.Merge("feature"); err == nil {
+		t.Fatal("expected the merge to stop on conflicting edits")
+	}
+	if err := core.MergeAbort(); err != nil {
+		t.Fatalf("MergeAbort failed: %v", err)
+	}
+	if data, _ := os.ReadFile("file.txt"); string(data) != "main\n" {
+		t.Errorf("abort did not restore file.txt, got %q", data)
+	}
+	if core.IsMergeInProgress() {
+		t.Errorf("abort left the merge in progress")
+	}
+	if err := core.MergeAbort(); err == nil {
+		t.Errorf("aborting without a merge in progress should fail")
+	}
+}
+
+func TestMerge_AbortRemovesConflictOnlyFiles(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "file.txt", "base\n", "base")
+	if err := core.CreateBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.RemoveFile("file.txt", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := core.Commit("main deletes file.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.CheckoutBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, "file.txt", "feature\n", "feature edit")
+	if err := core.CheckoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := core.Merge("feature"); err == nil {
+		t.Fatal("expected the merge to stop on a modify/delete conflict")
+	}
+	if _, err := os.Stat("file.txt"); err != nil {
+		t.Fatalf("the conflict did not bring file.txt back: %v", err)
+	}
+	if err := core.MergeAbort(); err != nil {
+		t.Fatalf("MergeAbort failed: %v", err)
+	}
+	if _, err := os.Stat("file.txt"); !os.IsNotExist(err) {
+		t.Errorf("abort left file.txt, which is not in HEAD: %v", err)
+	}
+	if conflicts, _ := storage.LoadIndexConflicts(); len(conflicts) != 0 {
+		t.Errorf("abort left unmerged paths %v", conflicts)
+	}
+}
+
+func TestMerge_IndexRewriteDropsConflicts(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "file.txt", "base\n", "base")
+	if err := core.CreateBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, "file.txt", "main\n", "main edit")
+	if err := core.CheckoutBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, "file.txt", "feature\n", "feature edit")
+	if err := core.CheckoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.Merge("feature"); err == nil {
+		t.Fatal("expected the merge to stop on conflicting edits")
+	}
+
+	index, err := storage.LoadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.WriteIndex(index); err != nil {
+		t.Fatal(err)
+	}
+	if conflicts, _ := storage.LoadIndexConflicts(); len(conflicts) != 0 {
+		t.Errorf("a full index rewrite kept the unmerged paths %v", conflicts)
+	}
+}
+
+func TestMerge_UnmergedPathsBlockCommit(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "file.txt", "base\n", "base")
+	if err := core.CreateBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, "file.txt", "main\n", "main edit")
+	if err := core.CheckoutBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, "file.txt", "feature\n", "feature edit")
+	if err := core.CheckoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.Merge("feature"); err == nil {
+		t.Fatal("expected the merge to stop on conflicting edits")
+	}
+
+	conflicts, err := storage.LoadIndexConflicts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, ok := conflicts["file.txt"]
+	if !ok || c.Base == nil || c.Ours == nil || c.Theirs == nil {
+		t.Fatalf("file.txt should be unmerged with all three stages, got %+v", conflicts)
+	}
+
+	if _, _, err := core.Commit("too early"); err == nil || !strings.Contains(err.Error(), "unmerged") {
+		t.Fatalf("commit with unmerged paths should be refused, got %v", err)
+	}
+
+	if err := core.CheckoutConflictSide("file.txt", true); err != nil {
+		t.Fatalf("checkout --theirs failed: %v", err)
+	}
+	if data, _ := os.ReadFile("file.txt"); string(data) != "feature\n" {
+		t.Errorf("checkout --theirs wrote %q", data)
+	}
+	if err := core.CheckoutConflictSide("file.txt", false); err != nil {
+		t.Fatalf("checkout --ours failed: %v", err)
+	}
+	if data, _ := os.ReadFile("file.txt"); string(data) != "main\n" {
+		t.Errorf("checkout --ours wrote %q", data)
+	}
+
+	if err := core.AddFile("file.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if conflicts, _ := storage.LoadIndexConflicts(); len(conflicts) != 0 {
+		t.Fatalf("add did not mark file.txt resolved: %v", conflicts)
+	}
+	// Taking our side leaves the tree unchanged, but still concludes the merge
+	if _, _, err := core.Commit(""); err != nil {
+		t.Fatalf("commit after resolving failed: %v", err)
+	}
+	if head, _ := core.GetHeadCommit(); !head.IsMerge() {
+		t.Errorf("expected a merge commit, got parents %v", head.Parents)
+	}
+}
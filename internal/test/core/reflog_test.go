@@ -0,0 +1,111 @@
+package core_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/LeeFred3042U/kitcat/internal/core"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+func TestReflog_RecordsCommitsAndCheckouts(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "file.txt", "one\n", "first")
+	first, _ := core.GetHeadCommit()
+	commitFile(t, "file.txt", "two\n", "second")
+	second, _ := core.GetHeadCommit()
+	if err := core.CreateBranch("topic"); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.CheckoutBranch("topic"); err != nil {
+		t.Fatal(err)
+	}
+
+	head, err := storage.ReadReflog("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ old, new, message string }{
+		{second.ID, second.ID, "checkout: moving from main to topic"},
+		{first.ID, second.ID, "commit: second"},
+		{storage.ZeroHash, first.ID, "commit (initial): first"},
+	}
+	if len(head) != len(want) {
+		t.Fatalf("HEAD reflog has %d entries, want %d: %+v", len(head), len(want), head)
+	}
+	for i, w := range want {
+		if head[i].Old != w.old || head[i].New != w.new || head[i].Message != w.message {
+			t.Errorf("HEAD@{%d} = %s -> %s %q, want %s -> %s %q", i, head[i].Old, head[i].New, head[i].Message, w.old, w.new, w.message)
+		}
+	}
+
+	topic, _ := storage.ReadReflog("refs/heads/topic")
+	if len(topic) != 1 || topic[0].Message != "branch: Created from HEAD" {
+		t.Errorf("topic reflog = %+v, want only its creation", topic)
+	}
+	main, _ := storage.ReadReflog("refs/heads/main")
+	if len(main) != 2 {
+		t.Errorf("main reflog has %d entries, want its two commits", len(main))
+	}
+}
+
+func TestReflog_ResetToPreviousHead(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "file.txt", "one\n", "first")
+	first, _ := core.GetHeadCommit()
+	commitFile(t, "file.txt", "two\n", "second")
+	second, _ := core.GetHeadCommit()
+
+	if err := core.Reset("HEAD@{1}", core.ResetHard); err != nil {
+		t.Fatalf("Reset to HEAD@{1} failed: %v", err)
+	}
+	if head, _ := core.GetHeadCommit(); head.ID != first.ID {
+		t.Fatalf("HEAD = %s, want the first commit", head.ID)
+	}
+	if state, _ := core.GetHeadState(); state != "main" {
+		t.Errorf("reset must move the branch, HEAD is now %q", state)
+	}
+
+	// The reset itself is an entry, so HEAD@{1} is now the commit before it
+	if err := core.Reset("main@{1}", core.ResetHard); err != nil {
+		t.Fatalf("Reset to main@{1} failed: %v", err)
+	}
+	if head, _ := core.GetHeadCommit(); head.ID != second.ID {
+		t.Errorf("HEAD = %s, want the second commit back", head.ID)
+	}
+	if data, _ := os.ReadFile("file.txt"); string(data) != "two\n" {
+		t.Errorf("file.txt = %q", data)
+	}
+
+	if _, err := core.ResolveRevision("HEAD@{10}"); err == nil {
+		t.Errorf("expected an error for an entry past the end of the log")
+	}
+}
+
+func TestReflog_FollowsBranchRenameAndDelete(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "file.txt", "one\n", "first")
+	if err := core.RenameCurrentBranch("trunk"); err != nil {
+		t.Fatal(err)
+	}
+	trunk, _ := storage.ReadReflog("refs/heads/trunk")
+	if len(trunk) != 2 || trunk[1].Message != "commit (initial): first" {
+		t.Errorf("renamed branch lost its reflog: %+v", trunk)
+	}
+
+	if err := core.CreateBranch("gone"); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.DeleteBranch("gone"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(".kitcat/logs/refs/heads/gone"); !os.IsNotExist(err) {
+		t.Errorf("deleting a branch should delete its reflog")
+	}
+}
@@ -0,0 +1,276 @@
+package core_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/core"
+	"github.com/LeeFred3042U/kitcat/internal/models"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// cloneInto clones the current repository into a new temporary directory and returns
+// its path. The working directory is left unchanged.
+func cloneInto(t *testing.T) string {
+	t.Helper()
+	origin, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(t.TempDir(), "clone")
+	if err := core.Clone(origin, dir); err != nil {
+		t.Fatalf("Clone failed: %v", err)
+	}
+	return dir
+}
+
+// inDir runs fn with dir as the working directory
+func inDir(t *testing.T, dir string, fn func()) {
+	t.Helper()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	fn()
+}
+
+func TestClone_PushAndPull(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "file.txt", "one\n", "first")
+	if err := core.CreateTag("v1", "HEAD"); err != nil {
+		t.Fatal(err)
+	}
+	clone := cloneInto(t)
+
+	inDir(t, clone, func() {
+		if data, _ := os.ReadFile("file.txt"); string(data) != "one\n" {
+			t.Errorf("clone did not check out file.txt, got %q", data)
+		}
+		if _, err := core.ResolveRevision("v1"); err != nil {
+			t.Errorf("clone did not copy tag v1: %v", err)
+		}
+		commitFile(t, "file.txt", "two\n", "second")
+		if err := core.Push("origin", "", false); err != nil {
+			t.Fatalf("Push failed: %v", err)
+		}
+	})
+
+	// The pushed branch is checked out here, so the working directory follows it
+	head, _ := core.GetHeadCommit()
+	if head.Message != "second" {
+		t.Errorf("origin HEAD = %q, want the pushed commit", head.Message)
+	}
+	if data, _ := os.ReadFile("file.txt"); string(data) != "two\n" {
+		t.Errorf("push did not update origin's working directory, file.txt = %q", data)
+	}
+
+	commitFile(t, "other.txt", "from origin\n", "third")
+	third, _ := core.GetHeadCommit()
+	inDir(t, clone, func() {
+		if err := core.Pull("origin", ""); err != nil {
+			t.Fatalf("Pull failed: %v", err)
+		}
+		if head, _ := core.GetHeadCommit(); head.ID != third.ID {
+			t.Errorf("pull did not fast-forward to %s", third.ID)
+		}
+		if tracking, _ := core.ResolveRevision("origin/main"); tracking != third.ID {
+			t.Errorf("origin/main = %s, want %s", tracking, third.ID)
+		}
+	})
+}
+
+func TestPush_RequiresFastForward(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "file.txt", "one\n", "first")
+	clone := cloneInto(t)
+	commitFile(t, "origin.txt", "origin\n", "origin change")
+
+	inDir(t, clone, func() {
+		commitFile(t, "clone.txt", "clone\n", "clone change")
+		mine, _ := core.GetHeadCommit()
+
+		err := core.Push("origin", "main", false)
+		if err == nil || !strings.Contains(err.Error(), "non-fast-forward") {
+			t.Fatalf("expected a non-fast-forward rejection, got %v", err)
+		}
+		if err := core.Push("origin", "main", true); err != nil {
+			t.Fatalf("forced Push failed: %v", err)
+		}
+		if tracking, _ := core.ResolveRevision("origin/main"); tracking != mine.ID {
+			t.Errorf("origin/main was not updated by the push")
+		}
+	})
+
+	if head, _ := core.GetHeadCommit(); head.Message != "clone change" {
+		t.Errorf("origin HEAD = %q, want the force-pushed commit", head.Message)
+	}
+	if _, err := os.Stat("origin.txt"); !os.IsNotExist(err) {
+		t.Errorf("origin.txt should be gone after the forced push")
+	}
+}
+
+func TestPush_RefusesDirtyCheckedOutBranch(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "file.txt", "one\n", "first")
+	clone := cloneInto(t)
+	if err := os.WriteFile("file.txt", []byte("uncommitted\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	inDir(t, clone, func() {
+		commitFile(t, "file.txt", "two\n", "second")
+		if err := core.Push("origin", "", false); err == nil {
+			t.Fatal("expected the push to be refused")
+		}
+	})
+	if data, _ := os.ReadFile("file.txt"); string(data) != "uncommitted\n" {
+		t.Errorf("a refused push must not touch the remote working directory")
+	}
+}
+
+func TestRemote_AddAndRemove(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "file.txt", "one\n", "first")
+	other := t.TempDir()
+	inDir(t, other, func() {
+		if err := core.InitRepo(); err != nil {
+			t.Fatal(err)
+		}
+		commitFile(t, "other.txt", "other\n", "other")
+	})
+
+	if err := core.AddRemote("upstream", other); err != nil {
+		t.Fatalf("AddRemote failed: %v", err)
+	}
+	if err := core.AddRemote("upstream", other); err == nil {
+		t.Errorf("adding a remote twice should fail")
+	}
+	if err := core.AddRemote("bogus", t.TempDir()); err == nil {
+		t.Errorf("a directory without a repository should be rejected")
+	}
+	if err := core.Fetch("upstream"); err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if _, err := core.ResolveRevision("upstream/main"); err != nil {
+		t.Errorf("fetch did not create upstream/main: %v", err)
+	}
+
+	if err := core.RemoveRemote("upstream"); err != nil {
+		t.Fatalf("RemoveRemote failed: %v", err)
+	}
+	if _, err := core.ResolveRevision("upstream/main"); err == nil {
+		t.Errorf("removing the remote should delete its remote-tracking branches")
+	}
+	if err := core.Fetch("upstream"); err == nil {
+		t.Errorf("fetching from a removed remote should fail")
+	}
+}
+
+// writeRawObject stores data as a loose object of type typ in the repository in the
+// current directory, bypassing every check kitcat makes, and returns its name
+func writeRawObject(t *testing.T, typ storage.ObjectType, data []byte) string {
+	t.Helper()
+	header := fmt.Sprintf("%s %d\x00", typ, len(data))
+	sum := sha1.Sum(append([]byte(header), data...))
+	hash := hex.EncodeToString(sum[:])
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write([]byte(header))
+	zw.Write(data)
+	zw.Close()
+	object := filepath.Join(".kitcat", "objects", hash[:2], hash[2:])
+	if err := os.MkdirAll(filepath.Dir(object), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(object, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+// commitRawTree points the current branch at a new commit of the raw tree content
+func commitRawTree(t *testing.T, tree string) {
+	t.Helper()
+	commit := models.Commit{
+		TreeHash:  writeRawObject(t, storage.TreeObject, []byte(tree)),
+		Message:   "hostile",
+		Timestamp: time.Now(),
+	}
+	if head, err := core.GetHeadCommit(); err == nil {
+		commit.Parents = []string{head.ID}
+	}
+	commit.ID = commit.Hash()
+	if err := storage.AppendCommit(commit); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.UpdateBranchPointer(commit.ID, "hostile"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClone_RefusesHostileTrees(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	blob := writeRawObject(t, storage.BlobObject, []byte("escaped\n"))
+	victim := t.TempDir()
+	for name, tree := range map[string]string{
+		"parent path":    "100644 " + blob + " ../../escaped.txt\n",
+		"repository dir": "040000 " + writeRawObject(t, storage.TreeObject, []byte("100644 "+blob+" HEAD\n")) + " .kitcat\n",
+		"legacy path":    blob + " dir/../../../escaped.txt\n",
+	} {
+		commitRawTree(t, tree)
+		dest := filepath.Join(victim, "sub", strings.ReplaceAll(name, " ", "-"))
+		if err := core.Clone(".", dest); err == nil {
+			t.Errorf("%s: cloning a hostile tree succeeded", name)
+		}
+		for _, p := range []string{filepath.Join(victim, "escaped.txt"), filepath.Join(victim, "sub", "escaped.txt")} {
+			if _, err := os.Stat(p); err == nil {
+				t.Fatalf("%s: the clone wrote %s", name, p)
+			}
+		}
+	}
+}
+
+func TestClone_RefusesWritingThroughSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require elevated privileges on Windows")
+	}
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	// A link to a directory outside the clone, and a file inside that link
+	victim := t.TempDir()
+	link := writeRawObject(t, storage.BlobObject, []byte(victim))
+	blob := writeRawObject(t, storage.BlobObject, []byte("escaped\n"))
+	sub := writeRawObject(t, storage.TreeObject, []byte("100644 "+blob+" escaped.txt\n"))
+	commitRawTree(t, "120000 "+link+" link\n040000 "+sub+" link\n")
+
+	if err := core.Clone(".", filepath.Join(t.TempDir(), "clone")); err == nil {
+		t.Error("cloning a tree that writes through a symlink succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(victim, "escaped.txt")); err == nil {
+		t.Fatal("the clone wrote through the symlink")
+	}
+}
@@ -0,0 +1,84 @@
+package core_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/LeeFred3042U/kitcat/internal/core"
+)
+
+func TestRevert_UndoesCommitKeepingLaterEdits(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "file.txt", "one\ntwo\nthree\n", "base")
+	commitFile(t, "file.txt", "one\nTWO\nthree\n", "shout two")
+	bad, _ := core.GetHeadCommit()
+	commitFile(t, "file.txt", "one\nTWO\nthree\nfour\n", "add four")
+
+	if err := core.Revert([]string{bad.ID}, false); err != nil {
+		t.Fatalf("Revert failed: %v", err)
+	}
+	if data, _ := os.ReadFile("file.txt"); string(data) != "one\ntwo\nthree\nfour\n" {
+		t.Errorf("file.txt = %q, want the reverted line with the later addition kept", data)
+	}
+	head, _ := core.GetHeadCommit()
+	if !strings.HasPrefix(head.Message, `Revert "shout two"`) || !strings.Contains(head.Message, bad.ID) {
+		t.Errorf("unexpected revert message %q", head.Message)
+	}
+}
+
+func TestRevert_RangeNoCommit(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "base.txt", "base\n", "base")
+	base, _ := core.GetHeadCommit()
+	commitFile(t, "a.txt", "a\n", "add a")
+	commitFile(t, "b.txt", "b\n", "add b")
+	tip, _ := core.GetHeadCommit()
+
+	if err := core.Revert([]string{base.ID + ".." + tip.ID}, true); err != nil {
+		t.Fatalf("Revert of a range failed: %v", err)
+	}
+	if head, _ := core.GetHeadCommit(); head.ID != tip.ID {
+		t.Errorf("--no-commit must not create commits")
+	}
+	for _, f := range []string{"a.txt", "b.txt"} {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Errorf("%s should be removed by reverting the range", f)
+		}
+	}
+
+	if _, _, err := core.Commit("revert a and b"); err != nil {
+		t.Fatalf("committing the staged revert failed: %v", err)
+	}
+}
+
+func TestRevert_ConflictStopsSequence(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "file.txt", "base\n", "base")
+	commitFile(t, "file.txt", "changed\n", "change")
+	change, _ := core.GetHeadCommit()
+	commitFile(t, "file.txt", "changed again\n", "change again")
+	last, _ := core.GetHeadCommit()
+
+	if err := core.Revert([]string{change.ID}, false); err == nil {
+		t.Fatal("expected the revert to conflict with the later change")
+	}
+	if err := core.CherryPickContinue(); err == nil {
+		t.Errorf("cherry-pick --continue must not resume a revert")
+	}
+	if err := core.RevertAbort(); err != nil {
+		t.Fatalf("RevertAbort failed: %v", err)
+	}
+	if head, _ := core.GetHeadCommit(); head.ID != last.ID {
+		t.Errorf("abort did not restore HEAD")
+	}
+	if data, _ := os.ReadFile("file.txt"); string(data) != "changed again\n" {
+		t.Errorf("abort did not restore file.txt, got %q", data)
+	}
+}
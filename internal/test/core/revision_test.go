@@ -0,0 +1,95 @@
+package core_test
+
+import (
+	"testing"
+
+	"github.com/LeeFred3042U/kitcat/internal/core"
+)
+
+func TestResolveRevision(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "a.txt", "1\n", "c1")
+	c1, _ := core.GetHeadCommit()
+	commitFile(t, "a.txt", "2\n", "c2")
+	c2, _ := core.GetHeadCommit()
+	if err := core.CreateTag("v1", "HEAD"); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.CreateBranch("topic"); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.CheckoutBranch("topic"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, "b.txt", "topic\n", "c3")
+	c3, _ := core.GetHeadCommit()
+	if err := core.CheckoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, "c.txt", "main\n", "c4")
+	c4, _ := core.GetHeadCommit()
+	if err := core.Merge("topic"); err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	merge, _ := core.GetHeadCommit()
+
+	tests := []struct {
+		rev  string
+		want string
+	}{
+		{"HEAD", merge.ID},
+		{"@", merge.ID},
+		{"main", merge.ID},
+		{"refs/heads/topic", c3.ID},
+		{"topic", c3.ID},
+		{"v1", c2.ID},
+		{"v1~1", c1.ID},
+		{"v1^", c1.ID},
+		{c4.ID[:7], c4.ID},
+		{"HEAD^0", merge.ID},
+		{"HEAD^", c4.ID},
+		{"HEAD^2", c3.ID},
+		{"HEAD~2", c2.ID},
+		{"HEAD^2~1", c2.ID},
+		{"HEAD~~", c2.ID},
+		{"main@{1}", c4.ID},
+		{"HEAD@{1}~1", c2.ID},
+	}
+	for _, tt := range tests {
+		got, err := core.ResolveRevision(tt.rev)
+		if err != nil {
+			t.Errorf("ResolveRevision(%q) failed: %v", tt.rev, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ResolveRevision(%q) = %s, want %s", tt.rev, got, tt.want)
+		}
+	}
+
+	for _, rev := range []string{"HEAD~10", "HEAD^3", "no-such-branch", "HEAD~x", "../HEAD"} {
+		if got, err := core.ResolveRevision(rev); err == nil {
+			t.Errorf("ResolveRevision(%q) = %s, want an error", rev, got)
+		}
+	}
+}
+
+func TestCherryPick_Range(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	setupPickBranches(t)
+	mainHead, _ := core.GetHeadCommit()
+	if err := core.CherryPick([]string{"main..fix"}, false); err != nil {
+		t.Fatalf("CherryPick of a range failed: %v", err)
+	}
+
+	head, _ := core.GetHeadCommit()
+	if head.Message != "second fix" {
+		t.Errorf("HEAD = %q, want the range applied oldest first", head.Message)
+	}
+	if base, _ := core.ResolveRevision("HEAD~2"); base != mainHead.ID {
+		t.Errorf("HEAD~2 = %s, want both commits of the range stacked on %s", base, mainHead.ID)
+	}
+}
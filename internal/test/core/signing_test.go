@@ -0,0 +1,156 @@
+package core_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/LeeFred3042U/kitcat/internal/core"
+)
+
+// configureSigning generates an ed25519 key, makes it the signing key of the current
+// repository and lists it for principal in a new allowed signers file
+func configureSigning(t *testing.T, principal string) string {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var wire []byte
+	for _, field := range [][]byte{[]byte("ssh-ed25519"), pub} {
+		wire = binary.BigEndian.AppendUint32(wire, uint32(len(field)))
+		wire = append(wire, field...)
+	}
+	signersFile := filepath.Join(dir, "allowed_signers")
+	line := principal + " ssh-ed25519 " + base64.StdEncoding.EncodeToString(wire) + "\n"
+	if err := os.WriteFile(signersFile, []byte(line), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range map[string]string{"user.signingkey": keyFile, "gpg.ssh.allowedsignersfile": signersFile} {
+		if err := core.SetConfig(key, value, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signersFile
+}
+
+func TestVerifyCommit_SignedWithConfiguredKey(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "a.txt", "1\n", "unsigned")
+	signersFile := configureSigning(t, "test@example.com")
+	if err := core.SetConfig("commit.gpgsign", "true", false); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, "a.txt", "2\n", "signed")
+
+	head, _ := core.GetHeadCommit()
+	if !strings.HasPrefix(head.Signature, "-----BEGIN SSH SIGNATURE-----") {
+		t.Fatalf("commit.gpgsign did not sign the commit: %q", head.Signature)
+	}
+	out := captureOutput(t, func() error { return core.VerifyCommit("HEAD") })
+	if !strings.HasPrefix(out, `Good "kitcat" signature for test@example.com with ED25519 key SHA256:`) {
+		t.Errorf("verify-commit printed %q", out)
+	}
+	if err := core.VerifyCommit("HEAD~1"); err == nil {
+		t.Error("verify-commit accepted an unsigned commit")
+	}
+	logged := captureOutput(t, func() error { return core.ShowLog(false, -1, "", true) })
+	if strings.Count(logged, "Good \"kitcat\" signature") != 1 {
+		t.Errorf("log --show-signature should check the one signed commit:\n%s", logged)
+	}
+
+	// Once the key is no longer trusted the signature does not verify
+	if err := os.WriteFile(signersFile, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.VerifyCommit("HEAD"); err == nil {
+		t.Error("verify-commit accepted a key missing from the allowed signers file")
+	}
+}
+
+func TestVerifyTag_SignedTag(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "a.txt", "1\n", "first")
+	configureSigning(t, "test@example.com")
+	if err := core.CreateAnnotatedTag("v1", "HEAD", "Signed release", true); err != nil {
+		t.Fatalf("signed tag failed: %v", err)
+	}
+	if err := core.CreateAnnotatedTag("v2", "HEAD", "Unsigned release", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.CreateTag("v3", "HEAD"); err != nil {
+		t.Fatal(err)
+	}
+
+	out := captureOutput(t, func() error { return core.VerifyTag("v1") })
+	if !strings.HasPrefix(out, `Good "kitcat" signature for test@example.com`) {
+		t.Errorf("verify-tag printed %q", out)
+	}
+	for _, name := range []string{"v2", "v3", "missing"} {
+		if err := core.VerifyTag(name); err == nil {
+			t.Errorf("verify-tag accepted %s", name)
+		}
+	}
+	if got, err := core.ResolveRevision("v1"); err != nil || got == "" {
+		t.Errorf("the signed tag does not resolve: %v", err)
+	}
+}
+
+func TestExportGit_KeepsCommitSignatures(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	configureSigning(t, "test@example.com")
+	if err := core.SetConfig("commit.gpgsign", "true", false); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, "a.txt", "1\n", "signed")
+	original, _ := core.GetHeadCommit()
+	signers, _, _ := core.GetConfig("gpg.ssh.allowedsignersfile")
+
+	gitRepo := t.TempDir()
+	if err := core.ExportGit(gitRepo); err != nil {
+		t.Fatalf("ExportGit failed: %v", err)
+	}
+	runGit(t, gitRepo, "fsck", "--strict")
+
+	inDir(t, t.TempDir(), func() {
+		if err := core.InitRepo(); err != nil {
+			t.Fatal(err)
+		}
+		if err := core.ImportGit(gitRepo); err != nil {
+			t.Fatalf("ImportGit failed: %v", err)
+		}
+		head, _ := core.GetHeadCommit()
+		if head.ID != original.ID || head.Signature != original.Signature {
+			t.Fatalf("round trip changed the signed commit %s into %s", original.ID, head.ID)
+		}
+		if err := core.SetConfig("gpg.ssh.allowedsignersfile", signers, false); err != nil {
+			t.Fatal(err)
+		}
+		if err := core.VerifyCommit("HEAD"); err != nil {
+			t.Errorf("the imported commit does not verify: %v", err)
+		}
+	})
+}
@@ -660,3 +660,51 @@ func TestStash_List(t *testing.T) {
 		t.Error("message 2 (newer) should appear before message 1 (older)")
 	}
 }
+
+func TestStashPop_MergesWithNewCommits(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "test.txt", "one\ntwo\nthree\n", "initial commit")
+
+	if err := os.WriteFile("test.txt", []byte("one\ntwo\nTHREE\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.Stash(); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, "test.txt", "ONE\ntwo\nthree\n", "upstream edit")
+
+	if err := core.StashPop(); err != nil {
+		t.Fatalf("StashPop failed: %v", err)
+	}
+	if data, _ := os.ReadFile("test.txt"); string(data) != "ONE\ntwo\nTHREE\n" {
+		t.Errorf("stash was not merged with HEAD, test.txt = %q", data)
+	}
+}
+
+func TestStashPop_ConflictKeepsStash(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "test.txt", "original\n", "initial commit")
+
+	if err := os.WriteFile("test.txt", []byte("stashed\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.Stash(); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, "test.txt", "upstream\n", "upstream edit")
+
+	if err := core.StashPop(); err == nil {
+		t.Fatal("expected StashPop to report a conflict")
+	}
+	want := "<<<<<<< Updated upstream\nupstream\n=======\nstashed\n>>>>>>> Stashed changes\n"
+	if data, _ := os.ReadFile("test.txt"); string(data) != want {
+		t.Errorf("test.txt = %q, want %q", data, want)
+	}
+	if stashes, _ := storage.ListStashes(); len(stashes) != 1 {
+		t.Errorf("a conflicting stash must be kept, got %d entries", len(stashes))
+	}
+}
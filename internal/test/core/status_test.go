@@ -0,0 +1,39 @@
+package core_test
+
+import (
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/LeeFred3042U/kitcat/internal/core"
+)
+
+func TestStatus_ReportsModeChanges(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the executable bit is not kept on Windows")
+	}
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "run.sh", "echo hi\n", "script")
+	if err := os.Chmod("run.sh", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// Twice, so the second status runs against whatever the first one refreshed
+	for range 2 {
+		if out := captureOutput(t, core.Status); !strings.Contains(out, "modified:  run.sh") {
+			t.Errorf("status does not report the mode change:\n%s", out)
+		}
+	}
+	if dirty, err := core.IsWorkDirDirty(); err != nil || !dirty {
+		t.Errorf("IsWorkDirDirty = %v, %v after a mode change", dirty, err)
+	}
+
+	if err := core.AddFile("run.sh"); err != nil {
+		t.Fatal(err)
+	}
+	if out := captureOutput(t, core.Status); strings.Contains(out, "Changes not staged") {
+		t.Errorf("the staged mode change is still reported as unstaged:\n%s", out)
+	}
+}
@@ -0,0 +1,133 @@
+package core_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/LeeFred3042U/kitcat/internal/core"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// captureOutput returns what fn prints to standard output
+func captureOutput(t *testing.T, fn func() error) string {
+	t.Helper()
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	err := fn()
+	w.Close()
+	os.Stdout = oldStdout
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := io.ReadAll(r)
+	return string(out)
+}
+
+func TestAnnotatedTag_CreateShowAndDelete(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "a.txt", "1\n", "first")
+	first, _ := core.GetHeadCommit()
+	commitFile(t, "a.txt", "2\n", "second")
+	if err := core.CreateAnnotatedTag("v1.0", "HEAD~1", "First release\n\nWith notes", false); err != nil {
+		t.Fatalf("CreateAnnotatedTag failed: %v", err)
+	}
+	if err := core.CreateTag("v1.1", "HEAD"); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.CreateAnnotatedTag("v1.0", "HEAD", "again", false); err == nil {
+		t.Error("an existing tag was overwritten")
+	}
+	if err := core.CreateAnnotatedTag("empty", "HEAD", "  \n", false); err == nil {
+		t.Error("a tag with an empty message was created")
+	}
+
+	ref, _ := os.ReadFile(filepath.Join(".kitcat", "refs", "tags", "v1.0"))
+	tag, err := storage.ReadTag(string(ref))
+	if err != nil {
+		t.Fatalf("v1.0 does not point at a tag object: %v", err)
+	}
+	if tag.Object != first.ID || tag.Name != "v1.0" || tag.TaggerName != "Test User" {
+		t.Errorf("unexpected tag object %+v", tag)
+	}
+	if got, err := core.ResolveRevision("v1.0"); err != nil || got != first.ID {
+		t.Errorf("v1.0 resolves to %s (%v), want %s", got, err, first.ID)
+	}
+	if got, err := core.ResolveRevision("v1.0~0"); err != nil || got != first.ID {
+		t.Errorf("v1.0~0 resolves to %s (%v), want %s", got, err, first.ID)
+	}
+
+	shown := captureOutput(t, func() error { return core.ShowObject("v1.0") })
+	for _, want := range []string{"tag v1.0\n", "Tagger: Test User <test@example.com>\n", "First release\n\nWith notes\n", first.ID} {
+		if !strings.Contains(shown, want) {
+			t.Errorf("show v1.0 lacks %q:\n%s", want, shown)
+		}
+	}
+
+	listed := captureOutput(t, func() error { return core.PrintTags([]string{"v1.*"}, 1) })
+	if want := "v1.0            First release\nv1.1            second\n"; listed != want {
+		t.Errorf("tag -n lists\n%q\nwant\n%q", listed, want)
+	}
+	listed = captureOutput(t, func() error { return core.PrintTags([]string{"*.0"}, 3) })
+	if want := "v1.0            First release\n\n    With notes\n"; listed != want {
+		t.Errorf("tag -n3 lists\n%q\nwant\n%q", listed, want)
+	}
+
+	if err := core.DeleteTag("v1.0"); err != nil {
+		t.Fatalf("DeleteTag failed: %v", err)
+	}
+	if _, err := core.ResolveRevision("v1.0"); err == nil {
+		t.Error("v1.0 still resolves after being deleted")
+	}
+	if err := core.DeleteTag("v1.0"); err == nil {
+		t.Error("deleting a missing tag succeeded")
+	}
+	kinds, err := fsckKinds(t, false)
+	if err != nil || kinds[core.FsckDangling] != 1 {
+		t.Errorf("fsck after deleting the tag found %v (%v), want the tag object dangling", kinds, err)
+	}
+}
+
+func TestAnnotatedTag_TravelsWithHistory(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	commitFile(t, "a.txt", "1\n", "first")
+	if err := core.CreateAnnotatedTag("v1", "HEAD", "Release", false); err != nil {
+		t.Fatal(err)
+	}
+	ref, _ := os.ReadFile(filepath.Join(".kitcat", "refs", "tags", "v1"))
+	if kinds, err := fsckKinds(t, true); err != nil || len(kinds) != 0 {
+		t.Errorf("fsck found %v (%v) in a repository with an annotated tag", kinds, err)
+	}
+
+	bundle := filepath.Join(t.TempDir(), "repo.bundle")
+	if err := core.CreateBundle(bundle, nil, true); err != nil {
+		t.Fatalf("CreateBundle failed: %v", err)
+	}
+	for _, source := range []string{"", bundle} {
+		var dir string
+		if source == "" {
+			dir = cloneInto(t)
+		} else {
+			dir = filepath.Join(t.TempDir(), "from-bundle")
+			if err := core.Clone(source, dir); err != nil {
+				t.Fatalf("Clone of the bundle failed: %v", err)
+			}
+		}
+		inDir(t, dir, func() {
+			got, _ := os.ReadFile(filepath.Join(".kitcat", "refs", "tags", "v1"))
+			if string(got) != string(ref) {
+				t.Errorf("clone from %q has v1 at %q, want the tag object %s", source, got, ref)
+			}
+			if _, err := storage.ReadTag(string(got)); err != nil {
+				t.Errorf("clone from %q lacks the tag object: %v", source, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package diff_test
+
+import (
+	"testing"
+
+	"github.com/LeeFred3042U/kitcat/internal/diff"
+)
+
+func TestMergeText(t *testing.T) {
+	tests := []struct {
+		name      string
+		base      string
+		ours      string
+		theirs    string
+		want      string
+		conflicts int
+	}{
+		{
+			name:   "Unchanged",
+			base:   "a\nb\n",
+			ours:   "a\nb\n",
+			theirs: "a\nb\n",
+			want:   "a\nb\n",
+		},
+		{
+			name:   "Only Ours Changed",
+			base:   "a\nb\nc\n",
+			ours:   "a\nB\nc\n",
+			theirs: "a\nb\nc\n",
+			want:   "a\nB\nc\n",
+		},
+		{
+			name:   "Separate Hunks",
+			base:   "a\nb\nc\nd\ne\n",
+			ours:   "A\nb\nc\nd\ne\n",
+			theirs: "a\nb\nc\nd\nE\n",
+			want:   "A\nb\nc\nd\nE\n",
+		},
+		{
+			name:   "Insertions On Both Sides",
+			base:   "a\nb\nc\n",
+			ours:   "start\na\nb\nc\n",
+			theirs: "a\nb\nc\nend\n",
+			want:   "start\na\nb\nc\nend\n",
+		},
+		{
+			name:   "Same Change On Both Sides",
+			base:   "a\nb\nc\n",
+			ours:   "a\nX\nc\n",
+			theirs: "a\nX\nc\n",
+			want:   "a\nX\nc\n",
+		},
+		{
+			name:      "Overlapping Change",
+			base:      "a\nb\nc\n",
+			ours:      "a\nours\nc\n",
+			theirs:    "a\ntheirs\nc\n",
+			want:      "a\n<<<<<<< HEAD\nours\n=======\ntheirs\n>>>>>>> other\nc\n",
+			conflicts: 1,
+		},
+		{
+			name:      "Missing Final Newline",
+			base:      "a",
+			ours:      "b",
+			theirs:    "c",
+			want:      "<<<<<<< HEAD\nb\n=======\nc\n>>>>>>> other\n",
+			conflicts: 1,
+		},
+		{
+			name:      "Add Add",
+			base:      "",
+			ours:      "one\n",
+			theirs:    "two\n",
+			want:      "<<<<<<< HEAD\none\n=======\ntwo\n>>>>>>> other\n",
+			conflicts: 1,
+		},
+		{
+			name:      "Conflict Next To Clean Hunk",
+			base:      "a\nb\nc\nd\ne\n",
+			ours:      "A\nb\nc\nours\ne\n",
+			theirs:    "a\nb\nc\ntheirs\ne\n",
+			want:      "A\nb\nc\n<<<<<<< HEAD\nours\n=======\ntheirs\n>>>>>>> other\ne\n",
+			conflicts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diff.MergeText(tt.base, tt.ours, tt.theirs, "HEAD", "other")
+			if got.Text != tt.want {
+				t.Errorf("MergeText text = %q, want %q", got.Text, tt.want)
+			}
+			if got.Conflicts != tt.conflicts {
+				t.Errorf("MergeText conflicts = %d, want %d", got.Conflicts, tt.conflicts)
+			}
+		})
+	}
+}
+
+func TestMerge3_ChunksCoverAllInput(t *testing.T) {
+	base := []int{1, 2, 3, 4, 5, 6}
+	ours := []int{1, 9, 3, 4, 6}
+	theirs := []int{0, 1, 2, 3, 4, 5, 6, 7}
+
+	var b, o, th int
+	for _, chunk := range diff.Merge3(base, ours, theirs) {
+		if chunk.Stable && (len(chunk.Base) != len(chunk.Ours) || len(chunk.Base) != len(chunk.Theirs)) {
+			t.Errorf("stable chunk with unequal sides: %+v", chunk)
+		}
+		b += len(chunk.Base)
+		o += len(chunk.Ours)
+		th += len(chunk.Theirs)
+	}
+	if b != len(base) || o != len(ours) || th != len(theirs) {
+		t.Errorf("chunks cover %d/%d/%d elements, want %d/%d/%d", b, o, th, len(base), len(ours), len(theirs))
+	}
+}